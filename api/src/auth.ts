@@ -34,6 +34,18 @@ const Jwt = t.Object({
 type Jwt = typeof Jwt.static;
 const validator = TypeCompiler.Compile(Jwt);
 
+/**
+ * Mirrors keibi's matching: `*` grants everything and `core.*` grants every `core.` permission.
+ */
+export function hasPermission(granted: string[], perm: string) {
+	return granted.some(
+		(x) =>
+			x === perm ||
+			x === "*" ||
+			(x.endsWith(".*") && perm.startsWith(x.slice(0, -1))),
+	);
+}
+
 export async function verifyJwt(bearer: string) {
 	// @ts-expect-error ts can't understand that there's two overload idk why
 	const { payload } = await jwtVerify(bearer, jwtSecret ?? jwks, {
@@ -78,7 +90,7 @@ export const auth = new Elysia({ name: "auth" })
 			return {
 				beforeHandle: function permissionCheck({ jwt, status }) {
 					for (const perm of perms) {
-						if (!jwt!.permissions.includes(perm)) {
+						if (!hasPermission(jwt!.permissions, perm)) {
 							return status(403, {
 								status: 403,
 								message: `Missing permission: '${perm}'.`,
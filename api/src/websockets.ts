@@ -2,7 +2,7 @@ import { getLogger } from "@logtape/logtape";
 import type { TObject, TString } from "@sinclair/typebox";
 import { eq } from "drizzle-orm";
 import Elysia, { type TSchema, t } from "elysia";
-import { auth, verifyJwt } from "./auth";
+import { auth, hasPermission, verifyJwt } from "./auth";
 import { updateProgress } from "./controllers/profiles/history";
 import { getOrCreateProfile } from "./controllers/profiles/profile";
 import { prepareVideo } from "./controllers/video-metadata";
@@ -131,7 +131,7 @@ export const appWs = baseWs.ws("/ws", {
 		}
 
 		for (const perm of handler.permissions ?? []) {
-			if (!ws.data.jwt.permissions.includes(perm)) {
+			if (!hasPermission(ws.data.jwt.permissions, perm)) {
 				ws.send({
 					action: action,
 					status: 403,
@@ -4,9 +4,11 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"fmt"
 	"maps"
 	"net/http"
 	"slices"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -15,26 +17,9 @@ import (
 	"github.com/jackc/pgx/v5"
 	"github.com/labstack/echo/v5"
 	"github.com/zoriya/kyoo/keibi/dbc"
+	. "github.com/zoriya/kyoo/keibi/models"
 )
 
-type ApiKey struct {
-	Id        uuid.UUID     `json:"id" example:"e05089d6-9179-4b5b-a63e-94dd5fc2a397"`
-	Name      string        `json:"name" example:"myapp"`
-	CreatedAt time.Time     `json:"createAt" example:"2025-03-29T18:20:05.267Z"`
-	LastUsed  time.Time     `json:"lastUsed" example:"2025-03-29T18:20:05.267Z"`
-	Claims    jwt.MapClaims `json:"claims" example:"isAdmin: true"`
-}
-
-type ApiKeyWToken struct {
-	ApiKey
-	Token string `json:"token" example:"myapp-lyHzTYm9yi+pkEv3m2tamAeeK7Dj7N3QRP7xv7dPU5q9MAe8tU4ySwYczE0RaMr4fijsA=="`
-}
-
-type ApiKeyDto struct {
-	Name   string        `json:"name" example:"myapp" validate:"alpha"`
-	Claims jwt.MapClaims `json:"claims" example:"isAdmin: true"`
-}
-
 func MapDbKey(key *dbc.Apikey) ApiKeyWToken {
 	return ApiKeyWToken{
 		ApiKey: ApiKey{
@@ -57,7 +42,7 @@ func MapDbKey(key *dbc.Apikey) ApiKeyWToken {
 // @Param        key  body      ApiKeyDto  false  "Api key info"
 // @Success      201  {object}  ApiKeyWToken
 // @Failure      409  {object}  KError "Duplicated api key"
-// @Failure      422  {object}  KError "Invalid create body"
+// @Failure      422  {object}  KError "Invalid create body or unknown permissions"
 // @Router       /keys [post]
 func (h *Handler) CreateApiKey(c *echo.Context) error {
 	ctx := c.Request().Context()
@@ -69,17 +54,20 @@ func (h *Handler) CreateApiKey(c *echo.Context) error {
 	var req ApiKeyDto
 	err = c.Bind(&req)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+		return NewError(http.StatusUnprocessableEntity, ErrInvalidBody, err.Error())
 	}
 	if err = c.Validate(&req); err != nil {
 		return err
 	}
+	if err = h.validatePermissions(req.Claims); err != nil {
+		return err
+	}
 
 	conflict := slices.ContainsFunc(h.config.EnvApiKeys, func(k ApiKeyWToken) bool {
 		return k.Name == req.Name
 	})
 	if conflict {
-		return echo.NewHTTPError(409, "An env apikey is already defined with the same name")
+		return NewError(409, ErrApiKeyExists, "An env apikey is already defined with the same name")
 	}
 
 	id := make([]byte, 64)
@@ -90,13 +78,18 @@ func (h *Handler) CreateApiKey(c *echo.Context) error {
 
 	var user *int32
 	uid, err := GetCurrentUserId(c)
-	// if err, we probably are using an api key (so no user)
 	if err != nil {
-		u, _ := h.db.GetUser(ctx, dbc.GetUserParams{
-			UseId: true,
-			Id:    uid,
-		})
+		return err
+	}
+	u, err := h.db.GetUser(ctx, dbc.GetUserParams{
+		UseId: true,
+		Id:    uid,
+	})
+	// if no user is found, we are using an api key (so no creator)
+	if err == nil {
 		user = &u.User.Pk
+	} else if err != pgx.ErrNoRows {
+		return err
 	}
 
 	dbkey, err := h.db.CreateApiKey(ctx, dbc.CreateApiKeyParams{
@@ -106,11 +99,13 @@ func (h *Handler) CreateApiKey(c *echo.Context) error {
 		CreatedBy: user,
 	})
 	if ErrIs(err, pgerrcode.UniqueViolation) {
-		return echo.NewHTTPError(409, "An apikey with the same name already exists.")
+		return NewError(409, ErrApiKeyExists, "An apikey with the same name already exists.")
 	} else if err != nil {
 		return err
 	}
-	return c.JSON(201, MapDbKey(&dbkey))
+	key := MapDbKey(&dbkey)
+	h.emit(ctx, EventApiKeyCreated, key.ApiKey)
+	return c.JSON(201, key)
 }
 
 // @Summary      Delete API key
@@ -132,12 +127,12 @@ func (h *Handler) DeleteApiKey(c *echo.Context) error {
 
 	id, err := uuid.Parse(c.Param("id"))
 	if err != nil {
-		return echo.NewHTTPError(422, "Invalid id given: not an uuid")
+		return NewError(422, ErrInvalidId, "Invalid id given: not an uuid")
 	}
 
 	dbkey, err := h.db.DeleteApiKey(ctx, id)
 	if err == pgx.ErrNoRows {
-		return echo.NewHTTPError(404, "No apikey found")
+		return NewError(404, ErrApiKeyNotFound, "No apikey found")
 	} else if err != nil {
 		return err
 	}
@@ -178,8 +173,195 @@ func (h *Handler) ListApiKey(c *echo.Context) error {
 	})
 }
 
+// currentUser returns the user making the request. It fails for api keys and guests.
+func (h *Handler) currentUser(c *echo.Context) (dbc.GetUserRow, error) {
+	uid, err := GetCurrentUserId(c)
+	if err != nil {
+		return dbc.GetUserRow{}, err
+	}
+	user, err := h.db.GetUser(c.Request().Context(), dbc.GetUserParams{
+		UseId: true,
+		Id:    uid,
+	})
+	if err == pgx.ErrNoRows {
+		return dbc.GetUserRow{}, NewError(http.StatusForbidden, ErrUsersOnly, "Only users can manage personal tokens (not api keys or guests).")
+	}
+	return user, err
+}
+
+// @Summary      Create personal token
+// @Description  Create an api key bound to your user (for scripts or plugins). It acts as you and can't
+// @Description  have more permissions than you (now or after your permissions are changed).
+// @Tags         apikeys
+// @Accept       json
+// @Produce      json
+// @Security     Jwt
+// @Param        token  body      PersonalTokenDto  false  "Token info"
+// @Success      201  {object}  ApiKeyWToken
+// @Failure      403  {object}  KError "Trying to grant permissions you don't have"
+// @Failure      409  {object}  KError "Duplicated token name"
+// @Failure      422  {object}  KError "Invalid create body or unknown permissions"
+// @Router       /users/me/tokens [post]
+func (h *Handler) CreatePersonalToken(c *echo.Context) error {
+	ctx := c.Request().Context()
+	user, err := h.currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	var req PersonalTokenDto
+	err = c.Bind(&req)
+	if err != nil {
+		return NewError(http.StatusUnprocessableEntity, ErrInvalidBody, err.Error())
+	}
+	if err = c.Validate(&req); err != nil {
+		return err
+	}
+
+	// the current jwt can have less permissions than the user (if it comes from another personal token).
+	granted := make([]string, 0)
+	if token, ok := c.Get("user").(*jwt.Token); ok {
+		if claims, ok := token.Claims.(jwt.MapClaims); ok {
+			for _, perm := range claimPermissions(claims) {
+				if HasPermission(claimPermissions(user.User.Claims), perm) {
+					granted = append(granted, perm)
+				}
+			}
+		}
+	}
+
+	perms := req.Permissions
+	if perms == nil {
+		perms = granted
+	}
+	claims := jwt.MapClaims{"permissions": perms}
+	if err = h.validatePermissions(claims); err != nil {
+		return err
+	}
+	missing := slices.DeleteFunc(slices.Clone(perms), func(perm string) bool {
+		return HasPermission(granted, perm)
+	})
+	if len(missing) > 0 {
+		return NewError(
+			http.StatusForbidden,
+			ErrPermissionEscalation,
+			fmt.Sprintf("You can't grant permissions you don't have: %s.", strings.Join(missing, ", ")),
+		)
+	}
+
+	id := make([]byte, 64)
+	_, err = rand.Read(id)
+	if err != nil {
+		return err
+	}
+
+	dbkey, err := h.db.CreateApiKey(ctx, dbc.CreateApiKeyParams{
+		Name:      req.Name,
+		Token:     base64.RawURLEncoding.EncodeToString(id),
+		Claims:    claims,
+		CreatedBy: &user.User.Pk,
+		Personal:  true,
+	})
+	if ErrIs(err, pgerrcode.UniqueViolation) {
+		return NewError(409, ErrApiKeyExists, "You already have a token with the same name.")
+	} else if err != nil {
+		return err
+	}
+	key := MapDbKey(&dbkey)
+	h.audit(ctx, user.User.Pk, "token.created", map[string]any{
+		"id":          key.Id,
+		"name":        key.Name,
+		"permissions": perms,
+	})
+	h.emit(ctx, EventApiKeyCreated, key.ApiKey)
+	return c.JSON(201, key)
+}
+
+// @Summary      List personal tokens
+// @Description  List the api keys bound to your user
+// @Tags         apikeys
+// @Produce      json
+// @Security     Jwt
+// @Success      200  {object}  Page[ApiKey]
+// @Router       /users/me/tokens [get]
+func (h *Handler) ListPersonalTokens(c *echo.Context) error {
+	ctx := c.Request().Context()
+	user, err := h.currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	dbkeys, err := h.db.ListPersonalTokens(ctx, &user.User.Pk)
+	if err != nil {
+		return err
+	}
+	ret := make([]ApiKey, 0, len(dbkeys))
+	for _, key := range dbkeys {
+		ret = append(ret, MapDbKey(&key).ApiKey)
+	}
+
+	return c.JSON(200, Page[ApiKey]{
+		Items: ret,
+		This:  c.Request().URL.String(),
+	})
+}
+
+// @Summary      Delete personal token
+// @Description  Delete one of your personal tokens
+// @Tags         apikeys
+// @Produce      json
+// @Security     Jwt
+// @Param        id   path      string    true  "The id of the token to delete" Format(uuid)
+// @Success      200  {object}  ApiKey
+// @Failure      404  {object}  KError "Invalid id"
+// @Failure      422  {object}  KError "Invalid id format"
+// @Router       /users/me/tokens/{id} [delete]
+func (h *Handler) DeletePersonalToken(c *echo.Context) error {
+	ctx := c.Request().Context()
+	user, err := h.currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return NewError(422, ErrInvalidId, "Invalid id given: not an uuid")
+	}
+
+	dbkey, err := h.db.DeletePersonalToken(ctx, dbc.DeletePersonalTokenParams{
+		Id:        id,
+		CreatedBy: &user.User.Pk,
+	})
+	if err == pgx.ErrNoRows {
+		return NewError(404, ErrApiKeyNotFound, "No token found")
+	} else if err != nil {
+		return err
+	}
+	h.audit(ctx, user.User.Pk, "token.deleted", map[string]any{
+		"id":   dbkey.Id,
+		"name": dbkey.Name,
+	})
+	return c.JSON(200, MapDbKey(&dbkey).ApiKey)
+}
+
+// personalTokenClaims returns the claims of a personal token: it acts as its owner but
+// can't grant more than the owner's current permissions.
+func personalTokenClaims(owner *dbc.User, keyClaims jwt.MapClaims) jwt.MapClaims {
+	claims := maps.Clone(owner.Claims)
+	ownerPerms := claimPermissions(owner.Claims)
+	perms := make([]string, 0)
+	for _, perm := range claimPermissions(keyClaims) {
+		if HasPermission(ownerPerms, perm) {
+			perms = append(perms, perm)
+		}
+	}
+	claims["permissions"] = perms
+	return claims
+}
+
 func (h *Handler) createApiJwt(ctx context.Context, apikey string) (string, error) {
 	var key *ApiKeyWToken
+	var owner *dbc.User
 	for _, k := range h.config.EnvApiKeys {
 		if k.Token == apikey {
 			key = &k
@@ -189,7 +371,7 @@ func (h *Handler) createApiJwt(ctx context.Context, apikey string) (string, erro
 	if key == nil {
 		dbKey, err := h.db.GetApiKey(ctx, apikey)
 		if err == pgx.ErrNoRows {
-			return "", echo.NewHTTPError(http.StatusForbidden, "Invalid api key")
+			return "", NewError(http.StatusForbidden, ErrInvalidApiKey, "Invalid api key")
 		} else if err != nil {
 			return "", err
 		}
@@ -200,11 +382,26 @@ func (h *Handler) createApiJwt(ctx context.Context, apikey string) (string, erro
 
 		found := MapDbKey(&dbKey)
 		key = &found
+
+		if dbKey.Personal {
+			o, err := h.db.GetApiKeyOwner(ctx, dbKey.Pk)
+			if err == pgx.ErrNoRows {
+				return "", NewError(http.StatusForbidden, ErrInvalidApiKey, "Invalid api key")
+			} else if err != nil {
+				return "", err
+			}
+			owner = &o.User
+		}
 	}
 
 	claims := maps.Clone(key.Claims)
 	claims["username"] = key.Name
 	claims["sub"] = key.Id
+	if owner != nil {
+		claims = personalTokenClaims(owner, key.Claims)
+		claims["username"] = owner.Username
+		claims["sub"] = owner.Id.String()
+	}
 	claims["sid"] = key.Id
 	claims["jti"] = uuid.New().String()
 	claims["iss"] = h.config.PublicUrl
@@ -214,7 +411,7 @@ func (h *Handler) createApiJwt(ctx context.Context, apikey string) (string, erro
 	claims["exp"] = &jwt.NumericDate{
 		Time: time.Now().UTC().Add(time.Hour),
 	}
-	jwt := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	jwt := jwt.NewWithClaims(h.config.JwtSigningMethod, claims)
 	jwt.Header["kid"] = h.config.JwtKid
 	return jwt.SignedString(h.config.JwtPrivateKey)
 }
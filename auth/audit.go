@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/zoriya/kyoo/keibi/dbc"
+)
+
+type AuditLog struct {
+	// Unique id of this entry.
+	Id uuid.UUID `json:"id" example:"e05089d6-9179-4b5b-a63e-94dd5fc2a397"`
+	// What happened on the account.
+	Action string `json:"action" example:"session.created"`
+	// Extra information about the action (device, session id...).
+	Details any `json:"details"`
+	// When did it happen?
+	CreatedAt time.Time `json:"createdAt" example:"2025-03-29T18:20:05.267Z"`
+}
+
+func MapAuditLog(log *dbc.AuditLog) AuditLog {
+	return AuditLog{
+		Id:        log.Id,
+		Action:    log.Action,
+		Details:   log.Details,
+		CreatedAt: log.CreatedAt,
+	}
+}
+
+// audit records an event on a user's account. Failures are only logged since
+// the audit trail should never prevent the action itself.
+func (h *Handler) audit(ctx context.Context, userPk int32, action string, details map[string]any) {
+	if details == nil {
+		details = map[string]any{}
+	}
+	err := h.db.CreateAuditLog(ctx, dbc.CreateAuditLogParams{
+		UserPk:  userPk,
+		Action:  action,
+		Details: details,
+	})
+	if err != nil {
+		slog.Warn("Could not write audit log", "action", action, "user", userPk, "err", err)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"log/slog"
+	"maps"
+	"net"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v5"
+)
+
+// Service account authenticated by a client certificate (see KEIBI_CERT_<NAME>_*).
+type CertIdentity struct {
+	Id   uuid.UUID
+	Name string
+	// RFC 2253 subject the certificate must have (for example `CN=scanner,O=kyoo`).
+	Subject string
+	// SAN (dns name, uri, email or ip) the certificate must have.
+	San    string
+	Claims jwt.MapClaims
+}
+
+type clientCert struct {
+	subject string
+	sans    []string
+}
+
+func clientCertFromX509(cert *x509.Certificate) clientCert {
+	ret := clientCert{subject: cert.Subject.String()}
+	ret.sans = append(ret.sans, cert.DNSNames...)
+	ret.sans = append(ret.sans, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		ret.sans = append(ret.sans, uri.String())
+	}
+	for _, ip := range cert.IPAddresses {
+		ret.sans = append(ret.sans, ip.String())
+	}
+	return ret
+}
+
+// splitQuoted splits s on sep, ignoring separators inside double quotes.
+func splitQuoted(s string, sep byte) []string {
+	var ret []string
+	quoted := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quoted:
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			ret = append(ret, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(ret, s[start:])
+}
+
+func unquote(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+	return strings.ReplaceAll(s[1:len(s)-1], `\"`, `"`)
+}
+
+// parseXfcc reads the client certificate of an X-Forwarded-Client-Cert header (envoy's format).
+// Only the last element is used since it was added by the proxy in front of us.
+func parseXfcc(header string) (clientCert, bool) {
+	elements := splitQuoted(header, ',')
+	var ret clientCert
+	found := false
+	for _, pair := range splitQuoted(elements[len(elements)-1], ';') {
+		key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			continue
+		}
+		value = unquote(value)
+		switch strings.ToLower(key) {
+		case "cert":
+			data, err := url.QueryUnescape(value)
+			if err != nil {
+				return clientCert{}, false
+			}
+			block, _ := pem.Decode([]byte(data))
+			if block == nil {
+				return clientCert{}, false
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return clientCert{}, false
+			}
+			// the full certificate is the most reliable source, use it instead of the other fields.
+			return clientCertFromX509(cert), true
+		case "subject":
+			ret.subject = value
+			found = true
+		case "uri", "dns":
+			ret.sans = append(ret.sans, value)
+			found = true
+		}
+	}
+	return ret, found
+}
+
+func (h *Handler) isTrustedCertProxy(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	return slices.ContainsFunc(h.config.ClientCertProxies, func(p netip.Prefix) bool {
+		return p.Contains(addr)
+	})
+}
+
+// getCertIdentity returns the service identity of the request's client certificate, either verified by
+// our tls listener or forwarded by a trusted proxy. Returns nil if there is none.
+func (h *Handler) getCertIdentity(c *echo.Context) *CertIdentity {
+	if len(h.config.CertIdentities) == 0 {
+		return nil
+	}
+	req := c.Request()
+
+	var cert clientCert
+	if req.TLS != nil && len(req.TLS.VerifiedChains) > 0 {
+		cert = clientCertFromX509(req.TLS.VerifiedChains[0][0])
+	} else if header := req.Header.Get("X-Forwarded-Client-Cert"); header != "" {
+		if !h.isTrustedCertProxy(req.RemoteAddr) {
+			slog.Warn("Ignoring client certificate header from an untrusted proxy", "addr", req.RemoteAddr)
+			return nil
+		}
+		var ok bool
+		cert, ok = parseXfcc(header)
+		if !ok {
+			return nil
+		}
+	} else {
+		return nil
+	}
+
+	for i, identity := range h.config.CertIdentities {
+		if identity.Subject != "" && identity.Subject != cert.subject {
+			continue
+		}
+		if identity.San != "" && !slices.Contains(cert.sans, identity.San) {
+			continue
+		}
+		return &h.config.CertIdentities[i]
+	}
+	return nil
+}
+
+func (h *Handler) createCertJwt(identity *CertIdentity) (string, error) {
+	claims := maps.Clone(identity.Claims)
+	if claims == nil {
+		claims = make(jwt.MapClaims)
+	}
+	claims["username"] = identity.Name
+	claims["sub"] = identity.Id.String()
+	claims["sid"] = identity.Id.String()
+	claims["jti"] = uuid.New().String()
+	claims["iss"] = h.config.PublicUrl
+	claims["iat"] = &jwt.NumericDate{
+		Time: time.Now().UTC(),
+	}
+	claims["exp"] = &jwt.NumericDate{
+		Time: time.Now().UTC().Add(time.Hour),
+	}
+	jwt := jwt.NewWithClaims(h.config.JwtSigningMethod, claims)
+	jwt.Header["kid"] = h.config.JwtKid
+	return jwt.SignedString(h.config.JwtPrivateKey)
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/bits"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v5"
+	"github.com/zoriya/kyoo/keibi/dbc"
+	. "github.com/zoriya/kyoo/keibi/models"
+)
+
+type ChallengeProvider string
+
+const (
+	ChallengePow       ChallengeProvider = "pow"
+	ChallengeHCaptcha  ChallengeProvider = "hcaptcha"
+	ChallengeTurnstile ChallengeProvider = "turnstile"
+)
+
+// Siteverify endpoints of captcha providers. They all take a form with `secret`, `response` and `remoteip`
+// and return `{"success": bool}`, adding a provider is a matter of adding its url here.
+var captchaVerifyUrls = map[ChallengeProvider]string{
+	ChallengeHCaptcha:  "https://api.hcaptcha.com/siteverify",
+	ChallengeTurnstile: "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+}
+
+const powChallengeTtl = 5 * time.Minute
+
+type ChallengeConfig struct {
+	// Kind of challenge the frontend must solve.
+	Provider ChallengeProvider `json:"provider" enums:"pow,hcaptcha,turnstile" example:"pow"`
+	// Public site key to use in the captcha widget (hcaptcha and turnstile only).
+	SiteKey string `json:"siteKey,omitempty" example:"10000000-ffff-ffff-ffff-000000000001"`
+	// If true, registering requires solving a challenge.
+	OnRegister bool `json:"onRegister" example:"true"`
+	// Number of consecutive failed logins on an account after which logging in to it requires
+	// solving a challenge. 0 if login never requires a challenge.
+	AfterFailedLogins int32 `json:"afterFailedLogins" example:"3"`
+	// Number of leading zero bits required for proof-of-work solutions.
+	Difficulty int `json:"-"`
+	// Secret key used to verify captcha responses.
+	Secret string `json:"-"`
+}
+
+func (c *ChallengeConfig) Enabled() bool {
+	return c.OnRegister || c.AfterFailedLogins > 0
+}
+
+type PowChallenge struct {
+	// Id to send back with the solution.
+	Id uuid.UUID `json:"id" example:"e05089d6-9179-4b5b-a63e-94dd5fc2a397"`
+	// Random prefix of the string to hash.
+	Nonce string `json:"nonce" example:"9f86d081884c7d659a2feaa0c55ad015"`
+	// Number of leading zero bits the hash must have.
+	Difficulty int32 `json:"difficulty" example:"20"`
+	// Hash function to use.
+	Algorithm string `json:"algorithm" example:"sha256"`
+	// Date after which the challenge can't be solved anymore.
+	ExpireAt time.Time `json:"expireAt" example:"2025-03-29T18:20:05.267Z"`
+}
+
+var captchaClient = &http.Client{Timeout: 10 * time.Second}
+
+// @Summary      Create challenge
+// @Description  Create a proof-of-work challenge, only available if the challenge provider (see /info) is `pow`.
+// @Description  To solve it, find a `response` such that sha256(nonce + response) starts with `difficulty` zero bits.
+// @Description  Send `{ id, response }` as the `challenge` field of the register or login body.
+// @Description  A challenge can only be used once (even if the solution was invalid).
+// @Tags         users
+// @Produce      json
+// @Success      201  {object}  PowChallenge
+// @Failure      404  {object}  KError "Proof-of-work challenges are disabled"
+// @Router /challenges [post]
+func (h *Handler) CreateChallenge(c *echo.Context) error {
+	ctx := c.Request().Context()
+	if !h.config.Challenge.Enabled() || h.config.Challenge.Provider != ChallengePow {
+		return NewError(http.StatusNotFound, ErrChallengesDisabled, "Proof-of-work challenges are disabled.")
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	challenge, err := h.db.CreateChallenge(ctx, dbc.CreateChallengeParams{
+		Nonce:      hex.EncodeToString(nonce),
+		Difficulty: int32(h.config.Challenge.Difficulty),
+		ExpireAt:   time.Now().UTC().Add(powChallengeTtl),
+	})
+	if err != nil {
+		return err
+	}
+	if err = h.db.DeleteExpiredChallenges(ctx); err != nil {
+		slog.Warn("Could not delete expired challenges", "err", err)
+	}
+
+	return c.JSON(http.StatusCreated, PowChallenge{
+		Id:         challenge.Id,
+		Nonce:      challenge.Nonce,
+		Difficulty: challenge.Difficulty,
+		Algorithm:  "sha256",
+		ExpireAt:   challenge.ExpireAt,
+	})
+}
+
+// verifyChallenge checks the solution of the configured challenge.
+func (h *Handler) verifyChallenge(c *echo.Context, solution *ChallengeDto) error {
+	if solution == nil {
+		return NewError(
+			http.StatusPreconditionRequired,
+			ErrChallengeRequired,
+			fmt.Sprintf("A %s challenge must be solved, see /info.", h.config.Challenge.Provider),
+		)
+	}
+
+	var ok bool
+	var err error
+	if h.config.Challenge.Provider == ChallengePow {
+		ok, err = h.verifyPow(c.Request().Context(), solution)
+	} else {
+		ok, err = h.verifyCaptcha(c.Request().Context(), solution.Response, c.RealIP())
+	}
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return NewError(http.StatusForbidden, ErrChallengeInvalid, "Invalid challenge solution.")
+	}
+	return nil
+}
+
+func (h *Handler) verifyPow(ctx context.Context, solution *ChallengeDto) (bool, error) {
+	id, err := uuid.Parse(solution.Id)
+	if err != nil {
+		return false, nil
+	}
+	// challenges are spent even if the solution is wrong so they can't be brute-forced online.
+	challenge, err := h.db.SpendChallenge(ctx, id)
+	if err == pgx.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	hash := sha256.Sum256([]byte(challenge.Nonce + solution.Response))
+	return leadingZeroBits(hash[:]) >= int(challenge.Difficulty), nil
+}
+
+func leadingZeroBits(data []byte) int {
+	ret := 0
+	for _, b := range data {
+		ret += bits.LeadingZeros8(b)
+		if b != 0 {
+			break
+		}
+	}
+	return ret
+}
+
+func (h *Handler) verifyCaptcha(ctx context.Context, response string, remoteIp string) (bool, error) {
+	form := url.Values{
+		"secret":   {h.config.Challenge.Secret},
+		"response": {response},
+		"remoteip": {remoteIp},
+	}
+	if h.config.Challenge.SiteKey != "" {
+		form.Set("sitekey", h.config.Challenge.SiteKey)
+	}
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		captchaVerifyUrls[h.config.Challenge.Provider],
+		strings.NewReader(form.Encode()),
+	)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := captchaClient.Do(req)
+	if err != nil {
+		slog.Warn("Could not verify captcha", "provider", h.config.Challenge.Provider, "err", err)
+		return false, NewError(http.StatusBadGateway, ErrChallengeUnavailable, "Could not verify the challenge.")
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Success bool `json:"success"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		slog.Warn("Invalid captcha verification response", "provider", h.config.Challenge.Provider, "err", err)
+		return false, NewError(http.StatusBadGateway, ErrChallengeUnavailable, "Could not verify the challenge.")
+	}
+	return result.Success, nil
+}
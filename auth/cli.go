@@ -0,0 +1,424 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/alexedwards/argon2id"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/zoriya/kyoo/keibi/dbc"
+	"github.com/zoriya/kyoo/keibi/jwks"
+	. "github.com/zoriya/kyoo/keibi/models"
+)
+
+const cliUsage = `Usage: keibi [command]
+
+Without command, start the http server.
+
+Commands:
+  user create <username> <email> [password] [--admin]
+      Create a new user. With --admin, the user receives FIRST_USER_CLAIMS.
+  user set-password <login> [password]
+      Change the password of an user and revoke all its sessions.
+  user import <file>
+      Import users from a json or csv export (see POST /users/import). Use - to read json from stdin.
+  user grant <login> <permission>...
+      Add permissions to an user.
+  user revoke <login> <permission>...
+      Remove permissions from an user. Fails if a wildcard (like * or users.*) still grants one of them.
+  sessions revoke <login>
+      Logout an user from every devices.
+  apikey create <name> [permission]...
+      Create a new api key and print its token.
+  migrate up
+      Apply all pending migrations.
+  migrate down [steps]
+      Rollback the last migration (or the given number of migrations).
+
+When a password is not given as an argument, it is read from stdin.
+`
+
+// RunCli runs an admin subcommand (without starting the http server) and returns the exit code.
+func RunCli(ctx context.Context, args []string) int {
+	if args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		fmt.Print(cliUsage)
+		return 0
+	}
+
+	err := runCli(ctx, args)
+	if errors.Is(err, errUsage) {
+		fmt.Fprint(os.Stderr, cliUsage)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+var errUsage = errors.New("invalid usage")
+
+func runCli(ctx context.Context, args []string) error {
+	if len(args) < 2 {
+		return errUsage
+	}
+
+	if args[0] == "migrate" {
+		return cliMigrate(ctx, args[1:])
+	}
+
+	db, err := OpenDatabase(ctx)
+	if err != nil {
+		return fmt.Errorf("could not open database: %w", err)
+	}
+	defer db.Close()
+
+	h := Handler{
+		db:           dbc.New(db),
+		rawDb:        db,
+		oidcCache:    &OidcProviderCache{},
+		samlCache:    &SamlMetadataCache{metadata: make(map[string]*samlMetadata)},
+		jwksCache:    &jwks.Cache{},
+		sessionCache: NewSessionCache(0, 0),
+	}
+	h.config, err = LoadConfiguration(ctx, h.db)
+	if err != nil {
+		return fmt.Errorf("could not load configuration: %w", err)
+	}
+
+	switch args[0] + " " + args[1] {
+	case "user create":
+		return h.cliCreateUser(ctx, args[2:])
+	case "user set-password":
+		return h.cliSetPassword(ctx, args[2:])
+	case "user import":
+		return h.cliImportUsers(ctx, args[2:])
+	case "user grant":
+		return h.cliEditPermissions(ctx, args[2:], true)
+	case "user revoke":
+		return h.cliEditPermissions(ctx, args[2:], false)
+	case "sessions revoke":
+		return h.cliRevokeSessions(ctx, args[2:])
+	case "apikey create":
+		return h.cliCreateApiKey(ctx, args[2:])
+	default:
+		return errUsage
+	}
+}
+
+func cliMigrate(ctx context.Context, args []string) error {
+	db, err := ConnectDatabase(ctx)
+	if err != nil {
+		return fmt.Errorf("could not open database: %w", err)
+	}
+	defer db.Close()
+
+	m, closeMigrator, err := NewMigrator(db)
+	if err != nil {
+		return err
+	}
+	defer closeMigrator()
+
+	switch args[0] {
+	case "up":
+		err = m.Up()
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps: %s", args[1])
+			}
+		}
+		err = m.Steps(-steps)
+	default:
+		return errUsage
+	}
+	if errors.Is(err, migrate.ErrNoChange) {
+		fmt.Println("No migration to apply.")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	version, dirty, err := m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		fmt.Println("All migrations have been rolled back.")
+		return nil
+	} else if err != nil {
+		return err
+	}
+	fmt.Printf("Database is now at version %d (dirty: %t).\n", version, dirty)
+	return nil
+}
+
+func readPassword(args []string, idx int) (string, error) {
+	if len(args) > idx {
+		return args[idx], nil
+	}
+	fmt.Fprint(os.Stderr, "Password: ")
+	pass, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && pass == "" {
+		return "", fmt.Errorf("could not read password: %w", err)
+	}
+	pass = strings.TrimRight(pass, "\r\n")
+	if pass == "" {
+		return "", errors.New("empty password")
+	}
+	return pass, nil
+}
+
+func cliPasswordError(err error) error {
+	var policy *PasswordPolicyError
+	if !errors.As(err, &policy) {
+		return err
+	}
+	msgs := make([]string, 0, len(policy.Violations))
+	for _, v := range policy.Violations {
+		msgs = append(msgs, v.Message)
+	}
+	return fmt.Errorf("%s\n  %s", policy.Error(), strings.Join(msgs, "\n  "))
+}
+
+func (h *Handler) cliGetUser(ctx context.Context, login string) (dbc.User, error) {
+	user, err := h.db.GetUserByLogin(ctx, login)
+	if err == pgx.ErrNoRows {
+		return user, fmt.Errorf("no user found with the username or email %q", login)
+	}
+	return user, err
+}
+
+func (h *Handler) cliCreateUser(ctx context.Context, args []string) error {
+	admin := slices.Contains(args, "--admin")
+	args = slices.DeleteFunc(args, func(arg string) bool { return arg == "--admin" })
+	if len(args) < 2 {
+		return errUsage
+	}
+
+	password, err := readPassword(args, 2)
+	if err != nil {
+		return err
+	}
+	if err = h.checkPassword(ctx, password, args[0], args[1]); err != nil {
+		return cliPasswordError(err)
+	}
+	pass, err := argon2id.CreateHash(password, argon2id.DefaultParams)
+	if err != nil {
+		return err
+	}
+
+	claims := h.config.DefaultClaims
+	if admin {
+		claims = h.config.FirstUserClaims
+	}
+	duser, err := h.db.CreateUser(ctx, dbc.CreateUserParams{
+		Username:    args[0],
+		Email:       args[1],
+		Password:    &pass,
+		Claims:      claims,
+		FirstClaims: h.config.FirstUserClaims,
+	})
+	if ErrIs(err, pgerrcode.UniqueViolation) {
+		return errors.New("email or username already taken")
+	} else if err != nil {
+		return err
+	}
+	user := MapDbUser(&duser)
+	h.emit(ctx, EventUserCreated, user)
+	fmt.Printf("Created user %s (%s).\n", user.Username, user.Id)
+	return nil
+}
+
+func (h *Handler) cliSetPassword(ctx context.Context, args []string) error {
+	if len(args) < 1 {
+		return errUsage
+	}
+	user, err := h.cliGetUser(ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	password, err := readPassword(args, 1)
+	if err != nil {
+		return err
+	}
+	if err = h.checkPassword(ctx, password, user.Username, user.Email); err != nil {
+		return cliPasswordError(err)
+	}
+	pass, err := argon2id.CreateHash(password, argon2id.DefaultParams)
+	if err != nil {
+		return err
+	}
+	ret, err := h.db.UpdateUser(ctx, dbc.UpdateUserParams{
+		Id:       user.Id,
+		Password: &pass,
+	})
+	if err != nil {
+		return err
+	}
+	sessions, err := h.db.ClearUserSessions(ctx, user.Pk)
+	if err != nil {
+		return err
+	}
+	h.audit(ctx, user.Pk, "user.password_changed", map[string]any{
+		"source": "cli",
+	})
+	h.emit(ctx, EventUserUpdated, MapDbUser(&ret))
+	h.emitSessionsDeleted(ctx, user.Id, sessions)
+	fmt.Printf("Password of %s changed, all its sessions have been revoked.\n", user.Username)
+	return nil
+}
+
+func (h *Handler) cliImportUsers(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	input := os.Stdin
+	if args[0] != "-" {
+		file, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+	}
+	users, err := parseImport(input, strings.HasSuffix(strings.ToLower(args[0]), ".csv"))
+	if err != nil {
+		return err
+	}
+
+	ret, err := h.importUsers(ctx, newValidator(), users, "cli")
+	if err != nil {
+		return err
+	}
+	for _, e := range ret.Errors {
+		fmt.Fprintf(os.Stderr, "line %d (%s): %s\n", e.Line, e.Username, e.Message)
+	}
+	fmt.Printf("Imported %d user(s), %d skipped.\n", len(ret.Created), len(ret.Errors))
+	return nil
+}
+
+func (h *Handler) cliEditPermissions(ctx context.Context, args []string, grant bool) error {
+	if len(args) < 2 {
+		return errUsage
+	}
+	user, err := h.cliGetUser(ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	var permissions []string
+	if perms, ok := user.Claims["permissions"].([]any); ok {
+		for _, perm := range perms {
+			if p, ok := perm.(string); ok {
+				permissions = append(permissions, p)
+			}
+		}
+	}
+	for _, perm := range args[1:] {
+		if grant && !h.isKnownPermission(perm) {
+			return fmt.Errorf("unknown permission %q", perm)
+		}
+		if grant && !slices.Contains(permissions, perm) {
+			permissions = append(permissions, perm)
+		} else if !grant {
+			permissions = slices.DeleteFunc(permissions, func(p string) bool { return p == perm })
+		}
+	}
+	if !grant {
+		for _, perm := range args[1:] {
+			// wildcards (`*`, `users.*`) would still grant the permission, refuse instead of silently keeping it.
+			wildcards := slices.DeleteFunc(slices.Clone(permissions), func(p string) bool {
+				return !HasPermission([]string{p}, perm)
+			})
+			if len(wildcards) > 0 {
+				return fmt.Errorf(
+					"%q is still granted by %s, revoke it instead (and grant the permissions to keep)",
+					perm,
+					strings.Join(wildcards, ", "),
+				)
+			}
+		}
+	}
+	if permissions == nil {
+		permissions = make([]string, 0)
+	}
+
+	ret, err := h.db.UpdateUser(ctx, dbc.UpdateUserParams{
+		Id:     user.Id,
+		Claims: jwt.MapClaims{"permissions": permissions},
+	})
+	if err != nil {
+		return err
+	}
+	h.emit(ctx, EventUserUpdated, MapDbUser(&ret))
+	fmt.Printf("Permissions of %s: %s\n", user.Username, strings.Join(permissions, ", "))
+	return nil
+}
+
+func (h *Handler) cliRevokeSessions(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	user, err := h.cliGetUser(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	sessions, err := h.db.ClearUserSessions(ctx, user.Pk)
+	if err != nil {
+		return err
+	}
+	h.emitSessionsDeleted(ctx, user.Id, sessions)
+	fmt.Printf("Revoked %d session(s) of %s.\n", len(sessions), user.Username)
+	return nil
+}
+
+func (h *Handler) cliCreateApiKey(ctx context.Context, args []string) error {
+	if len(args) < 1 {
+		return errUsage
+	}
+	conflict := slices.ContainsFunc(h.config.EnvApiKeys, func(k ApiKeyWToken) bool {
+		return k.Name == args[0]
+	})
+	if conflict {
+		return errors.New("an env apikey is already defined with the same name")
+	}
+	for _, perm := range args[1:] {
+		if !h.isKnownPermission(perm) {
+			return fmt.Errorf("unknown permission %q", perm)
+		}
+	}
+
+	id := make([]byte, 64)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	dbkey, err := h.db.CreateApiKey(ctx, dbc.CreateApiKeyParams{
+		Name:   args[0],
+		Token:  base64.RawURLEncoding.EncodeToString(id),
+		Claims: jwt.MapClaims{"permissions": append(make([]string, 0), args[1:]...)},
+	})
+	if ErrIs(err, pgerrcode.UniqueViolation) {
+		return errors.New("an apikey with the same name already exists")
+	} else if err != nil {
+		return err
+	}
+	key := MapDbKey(&dbkey)
+	h.emit(ctx, EventApiKeyCreated, key.ApiKey)
+	fmt.Println(key.Token)
+	return nil
+}
@@ -0,0 +1,37 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/zoriya/kyoo/keibi/models"
+)
+
+// ListApiKeys lists the api keys stored in the database (requires `apikeys.read`).
+func (c *Client) ListApiKeys(ctx context.Context) (models.Page[models.ApiKey], error) {
+	var ret models.Page[models.ApiKey]
+	err := c.do(ctx, request{method: http.MethodGet, path: "/keys"}, &ret)
+	return ret, err
+}
+
+// CreateApiKey creates an api key (requires `apikeys.write`). Its token is only returned here.
+func (c *Client) CreateApiKey(ctx context.Context, key models.ApiKeyDto) (models.ApiKeyWToken, error) {
+	var ret models.ApiKeyWToken
+	err := c.do(ctx, request{method: http.MethodPost, path: "/keys", body: key}, &ret)
+	return ret, err
+}
+
+// DeleteApiKey deletes an api key (requires `apikeys.write`).
+func (c *Client) DeleteApiKey(ctx context.Context, id string) (models.ApiKey, error) {
+	var ret models.ApiKey
+	err := c.do(ctx, request{method: http.MethodDelete, path: "/keys/" + url.PathEscape(id)}, &ret)
+	return ret, err
+}
+
+// CreatePersonalToken creates an api key tied to the authenticated user, with a subset of its permissions.
+func (c *Client) CreatePersonalToken(ctx context.Context, token models.PersonalTokenDto) (models.ApiKeyWToken, error) {
+	var ret models.ApiKeyWToken
+	err := c.do(ctx, request{method: http.MethodPost, path: "/users/me/tokens", body: token}, &ret)
+	return ret, err
+}
@@ -0,0 +1,151 @@
+// Package client is the go sdk of keibi, kyoo's auth service.
+//
+// A Client authenticates with an api key or a session token, exchanges it for a jwt (cached until it
+// expires) and exposes typed methods for keibi's routes. Services that only need to check the jwts
+// they receive should use a Verifier instead.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Config struct {
+	// Url of keibi, including its prefix (for example `http://auth:4568/auth`).
+	Url string
+	// Api key used to authenticate, see KEIBI_APIKEY_* or POST /keys.
+	ApiKey string
+	// Session token used to authenticate (ignored if ApiKey is set).
+	SessionToken string
+	// Called when a rotating session returns a new session token, the previous one is then invalid.
+	OnSessionTokenRotated func(token string)
+	// Defaults to a client with a 30s timeout.
+	HttpClient *http.Client
+}
+
+type Client struct {
+	url        string
+	apiKey     string
+	httpClient *http.Client
+	onRotate   func(token string)
+
+	lock         sync.Mutex
+	sessionToken string
+	jwt          string
+	jwtExpireAt  time.Time
+}
+
+// Error is returned for any non 2xx response of keibi.
+type Error struct {
+	Status int `json:"status"`
+	// Stable code of the error (for example `auth.invalid_password`), see the ErrorCode enum of the swagger.
+	Code    string          `json:"code"`
+	Message string          `json:"message"`
+	Details json.RawMessage `json:"details"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("keibi: %s (%d %s)", e.Message, e.Status, e.Code)
+}
+
+func New(config Config) *Client {
+	httpClient := config.HttpClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &Client{
+		url:          strings.TrimSuffix(config.Url, "/"),
+		apiKey:       config.ApiKey,
+		sessionToken: config.SessionToken,
+		onRotate:     config.OnSessionTokenRotated,
+		httpClient:   httpClient,
+	}
+}
+
+type request struct {
+	method string
+	path   string
+	query  url.Values
+	body   any
+	// Send the request without a jwt (public routes or routes using the session token/api key directly).
+	anonymous bool
+	header    http.Header
+}
+
+func (c *Client) do(ctx context.Context, req request, ret any) error {
+	var body []byte
+	if req.body != nil {
+		var err error
+		body, err = json.Marshal(req.body)
+		if err != nil {
+			return err
+		}
+	}
+
+	resp, jwt, err := c.send(ctx, req, body)
+	if err != nil {
+		return err
+	}
+	// the cached jwt can be rejected before it expires (key rotation, revoked session...), get a new one & retry once.
+	if jwt != "" && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) {
+		resp.Body.Close()
+		c.dropJwt(jwt)
+		resp, _, err = c.send(ctx, req, body)
+		if err != nil {
+			return err
+		}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		kerr := &Error{Status: resp.StatusCode}
+		if err := json.NewDecoder(resp.Body).Decode(kerr); err != nil {
+			kerr.Message = http.StatusText(resp.StatusCode)
+		}
+		return kerr
+	}
+	if ret == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(ret)
+}
+
+// send sends a request to keibi, returning the jwt it used (if any).
+func (c *Client) send(ctx context.Context, req request, body []byte) (*http.Response, string, error) {
+	u := c.url + req.path
+	if len(req.query) > 0 {
+		u += "?" + req.query.Encode()
+	}
+	r, err := http.NewRequestWithContext(ctx, req.method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, "", err
+	}
+	for k, v := range req.header {
+		r.Header[k] = v
+	}
+	r.Header.Set("Accept", "application/json")
+	if req.body != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	var jwt string
+	if !req.anonymous && r.Header.Get("Authorization") == "" && r.Header.Get("X-Api-Key") == "" {
+		jwt, err = c.Jwt(ctx)
+		if err != nil {
+			return nil, "", err
+		}
+		r.Header.Set("Authorization", "Bearer "+jwt)
+	}
+
+	resp, err := c.httpClient.Do(r)
+	if err != nil {
+		return nil, "", err
+	}
+	return resp, jwt, nil
+}
@@ -0,0 +1,412 @@
+package client
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/zoriya/kyoo/keibi/models"
+)
+
+const testApiKey = "test-api-key"
+
+type signingKey struct {
+	kid  string
+	priv ed25519.PrivateKey
+	pub  ed25519.PublicKey
+}
+
+func newSigningKey(t *testing.T, kid string) signingKey {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signingKey{kid: kid, priv: priv, pub: pub}
+}
+
+func (k signingKey) sign(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	token.Header["kid"] = k.kid
+	ret, err := token.SignedString(k.priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ret
+}
+
+// fakeKeibi mimics the routes of keibi used by the client: /jwt, /users/me and the jwks.
+type fakeKeibi struct {
+	t      *testing.T
+	server *httptest.Server
+
+	lock sync.Mutex
+	// Keys served by the jwks, the first one signs new jwts.
+	keys []signingKey
+	// Lifetime of the jwts returned by /jwt.
+	jwtTtl time.Duration
+	// If set, /jwt only accepts this session token and rotates it.
+	sessionToken string
+	rotations    int
+	// If set, /users/me rejects every jwt.
+	rejectJwts bool
+
+	jwtHits  atomic.Int32
+	jwksHits atomic.Int32
+}
+
+func newFakeKeibi(t *testing.T) *fakeKeibi {
+	f := &fakeKeibi{
+		t:      t,
+		keys:   []signingKey{newSigningKey(t, "key-1")},
+		jwtTtl: time.Hour,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /auth/jwt", f.handleJwt)
+	mux.HandleFunc("GET /auth/users/me", f.handleMe)
+	mux.HandleFunc("GET /.well-known/jwks.json", f.handleJwks)
+	f.server = httptest.NewServer(mux)
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func (f *fakeKeibi) url() string {
+	return f.server.URL + "/auth"
+}
+
+func (f *fakeKeibi) jwksUrl() string {
+	return f.server.URL + "/.well-known/jwks.json"
+}
+
+func (f *fakeKeibi) signer() signingKey {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.keys[0]
+}
+
+func (f *fakeKeibi) writeError(w http.ResponseWriter, status int, code string, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(Error{Status: status, Code: code, Message: message})
+}
+
+func (f *fakeKeibi) handleJwt(w http.ResponseWriter, r *http.Request) {
+	f.jwtHits.Add(1)
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	var ret models.Jwt
+	switch {
+	case r.Header.Get("X-Api-Key") == testApiKey:
+	case f.sessionToken != "" && r.Header.Get("Authorization") == "Bearer "+f.sessionToken:
+		f.rotations++
+		f.sessionToken = fmt.Sprintf("session-token-%d", f.rotations)
+		ret.RefreshToken = &f.sessionToken
+	default:
+		f.writeError(w, http.StatusForbidden, "auth.invalid_token", "Invalid token")
+		return
+	}
+
+	token := f.keys[0].sign(f.t, jwt.MapClaims{
+		"sub":         "0195f3ae-4a54-7b9d-9d58-9c3c2bd0d7f2",
+		"sid":         "0195f3ae-4a54-7b9d-9d58-9c3c2bd0d7f3",
+		"permissions": []string{"users.read"},
+		"exp":         time.Now().Add(f.jwtTtl).Unix(),
+	})
+	ret.Token = &token
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ret)
+}
+
+func (f *fakeKeibi) handleMe(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		f.writeError(w, http.StatusUnauthorized, "auth.not_logged_in", "Not logged in")
+		return
+	}
+	f.lock.Lock()
+	reject := f.rejectJwts
+	f.lock.Unlock()
+	if reject {
+		f.writeError(w, http.StatusForbidden, "auth.invalid_token", "Invalid token")
+		return
+	}
+	claims, err := NewVerifier(f.jwksUrl(), "").Verify(r.Context(), token)
+	if err != nil {
+		f.writeError(w, http.StatusForbidden, "auth.invalid_token", err.Error())
+		return
+	}
+	sub, _ := claims.GetSubject()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"id": sub, "username": "test"})
+}
+
+func (f *fakeKeibi) handleJwks(w http.ResponseWriter, r *http.Request) {
+	f.jwksHits.Add(1)
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	set := jwk.NewSet()
+	for _, k := range f.keys {
+		key, err := jwk.Import(k.pub)
+		if err != nil {
+			f.t.Error(err)
+			return
+		}
+		key.Set(jwk.KeyIDKey, k.kid)
+		key.Set(jwk.AlgorithmKey, "EdDSA")
+		set.AddKey(key)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(set)
+}
+
+func TestJwtIsCached(t *testing.T) {
+	keibi := newFakeKeibi(t)
+	c := New(Config{Url: keibi.url(), ApiKey: testApiKey})
+	ctx := context.Background()
+
+	for range 3 {
+		me, err := c.GetMe(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if me.Username != "test" {
+			t.Fatalf("unexpected user %q", me.Username)
+		}
+	}
+	if hits := keibi.jwtHits.Load(); hits != 1 {
+		t.Fatalf("expected a single /jwt call, got %d", hits)
+	}
+}
+
+func TestJwtIsRefreshedBeforeExpiring(t *testing.T) {
+	keibi := newFakeKeibi(t)
+	// jwts expiring within the refresh margin are never reused.
+	keibi.jwtTtl = jwtRefreshMargin / 2
+	c := New(Config{Url: keibi.url(), ApiKey: testApiKey})
+	ctx := context.Background()
+
+	first, err := c.Jwt(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Jwt(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if hits := keibi.jwtHits.Load(); hits != 2 {
+		t.Fatalf("expected the jwt to be refreshed, got %d /jwt calls", hits)
+	}
+
+	keibi.jwtTtl = time.Hour
+	third, err := c.Jwt(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third == first {
+		t.Fatal("expected a new jwt")
+	}
+	if again, _ := c.Jwt(ctx); again != third {
+		t.Fatal("expected the long lived jwt to be cached")
+	}
+	if hits := keibi.jwtHits.Load(); hits != 3 {
+		t.Fatalf("expected 3 /jwt calls, got %d", hits)
+	}
+}
+
+func TestSessionTokenRotation(t *testing.T) {
+	keibi := newFakeKeibi(t)
+	keibi.sessionToken = "session-token-0"
+	keibi.jwtTtl = jwtRefreshMargin / 2
+
+	var rotated []string
+	c := New(Config{
+		Url:          keibi.url(),
+		SessionToken: "session-token-0",
+		OnSessionTokenRotated: func(token string) {
+			rotated = append(rotated, token)
+		},
+	})
+	ctx := context.Background()
+
+	// each exchange must use the token returned by the previous one.
+	for range 3 {
+		if _, err := c.GetMe(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := []string{"session-token-1", "session-token-2", "session-token-3"}
+	if fmt.Sprint(rotated) != fmt.Sprint(expected) {
+		t.Fatalf("expected rotations %v, got %v", expected, rotated)
+	}
+
+	c.SetSessionToken("session-token-0")
+	_, err := c.GetMe(ctx)
+	var kerr *Error
+	if !errors.As(err, &kerr) || kerr.Status != http.StatusForbidden || kerr.Code != "auth.invalid_token" {
+		t.Fatalf("expected a 403 auth.invalid_token for a spent token, got %v", err)
+	}
+}
+
+func TestRejectedJwtIsRefreshed(t *testing.T) {
+	keibi := newFakeKeibi(t)
+	c := New(Config{Url: keibi.url(), ApiKey: testApiKey})
+	ctx := context.Background()
+
+	if _, err := c.GetMe(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// the cached jwt is still valid for an hour but its signing key was removed.
+	keibi.lock.Lock()
+	keibi.keys = []signingKey{newSigningKey(t, "key-2")}
+	keibi.lock.Unlock()
+
+	if _, err := c.GetMe(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if hits := keibi.jwtHits.Load(); hits != 2 {
+		t.Fatalf("expected the rejected jwt to be replaced, got %d /jwt calls", hits)
+	}
+	if _, err := c.GetMe(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if hits := keibi.jwtHits.Load(); hits != 2 {
+		t.Fatalf("expected the new jwt to be cached, got %d /jwt calls", hits)
+	}
+
+	// requests are only retried once.
+	keibi.lock.Lock()
+	keibi.rejectJwts = true
+	keibi.lock.Unlock()
+	_, err := c.GetMe(ctx)
+	var kerr *Error
+	if !errors.As(err, &kerr) || kerr.Status != http.StatusForbidden {
+		t.Fatalf("expected a 403, got %v", err)
+	}
+	if hits := keibi.jwtHits.Load(); hits != 3 {
+		t.Fatalf("expected a single retry, got %d /jwt calls", hits)
+	}
+}
+
+func TestVerifierKeyRotation(t *testing.T) {
+	keibi := newFakeKeibi(t)
+	v := NewVerifier(keibi.jwksUrl(), "")
+	ctx := context.Background()
+	exp := time.Now().Add(time.Hour).Unix()
+
+	if _, err := v.Verify(ctx, keibi.signer().sign(t, jwt.MapClaims{"sub": "a", "exp": exp})); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Verify(ctx, keibi.signer().sign(t, jwt.MapClaims{"sub": "b", "exp": exp})); err != nil {
+		t.Fatal(err)
+	}
+	if hits := keibi.jwksHits.Load(); hits != 1 {
+		t.Fatalf("expected the jwks to be cached, got %d fetches", hits)
+	}
+
+	// keibi rotated its key: the new kid is not in the cached jwks.
+	rotated := newSigningKey(t, "key-2")
+	keibi.lock.Lock()
+	keibi.keys = append([]signingKey{rotated}, keibi.keys...)
+	keibi.lock.Unlock()
+
+	other := NewVerifier(keibi.jwksUrl(), "")
+	if _, err := other.Verify(ctx, keibi.signer().sign(t, jwt.MapClaims{"sub": "c", "exp": exp})); err != nil {
+		t.Fatal(err)
+	}
+	// v's cache is recent: unknown kids don't trigger a fetch on every request.
+	_, err := v.Verify(ctx, rotated.sign(t, jwt.MapClaims{"sub": "d", "exp": exp}))
+	var kerr *Error
+	if !errors.As(err, &kerr) || kerr.Code != "auth.invalid_token" {
+		t.Fatalf("expected an auth.invalid_token error, got %v", err)
+	}
+	if hits := keibi.jwksHits.Load(); hits != 2 {
+		t.Fatalf("expected 2 jwks fetches, got %d", hits)
+	}
+}
+
+func TestVerifierRejectsInvalidTokens(t *testing.T) {
+	keibi := newFakeKeibi(t)
+	v := NewVerifier(keibi.jwksUrl(), "https://kyoo.example")
+	ctx := context.Background()
+	exp := time.Now().Add(time.Hour).Unix()
+
+	hmac, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"exp": exp, "iss": "https://kyoo.example"}).
+		SignedString([]byte("secret"))
+	tokens := map[string]string{
+		"expired":     keibi.signer().sign(t, jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix(), "iss": "https://kyoo.example"}),
+		"no exp":      keibi.signer().sign(t, jwt.MapClaims{"iss": "https://kyoo.example"}),
+		"wrong iss":   keibi.signer().sign(t, jwt.MapClaims{"exp": exp, "iss": "https://other.example"}),
+		"hmac signed": hmac,
+	}
+	for name, token := range tokens {
+		if _, err := v.Verify(ctx, token); err == nil {
+			t.Errorf("%s: expected the jwt to be rejected", name)
+		}
+	}
+	if _, err := v.Verify(ctx, keibi.signer().sign(t, jwt.MapClaims{"exp": exp, "iss": "https://kyoo.example"})); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckPermissions(t *testing.T) {
+	claims := func(sub string, perms ...string) *Claims {
+		p := make([]any, 0, len(perms))
+		for _, perm := range perms {
+			p = append(p, perm)
+		}
+		return &Claims{MapClaims: jwt.MapClaims{"sub": sub, "permissions": p}}
+	}
+	const user = "0195f3ae-4a54-7b9d-9d58-9c3c2bd0d7f2"
+
+	tests := []struct {
+		name   string
+		claims *Claims
+		perms  []string
+		status int
+		code   string
+	}{
+		{"granted", claims(user, "users.read", "users.write"), []string{"users.read"}, 0, ""},
+		{"no permission required", claims(user), nil, 0, ""},
+		{"wildcard", claims(user, "users.*"), []string{"users.read", "users.write"}, 0, ""},
+		{"global wildcard", claims(user, "*"), []string{"apikeys.write"}, 0, ""},
+		{"missing", claims(user, "users.read"), []string{"users.read", "users.write"}, http.StatusForbidden, "auth.missing_permissions"},
+		{"other namespace", claims(user, "users.*"), []string{"apikeys.read"}, http.StatusForbidden, "auth.missing_permissions"},
+		{"guest", claims(guestId, "*"), []string{"users.read"}, http.StatusUnauthorized, "auth.not_logged_in"},
+		{"no subject", claims("", "users.read"), nil, http.StatusUnauthorized, "auth.not_logged_in"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.claims.CheckPermissions(tt.perms...)
+			if tt.status == 0 {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				return
+			}
+			var kerr *Error
+			if !errors.As(err, &kerr) || kerr.Status != tt.status || kerr.Code != tt.code {
+				t.Fatalf("expected %d %s, got %v", tt.status, tt.code, err)
+			}
+		})
+	}
+
+	err := claims(user, "users.read").CheckPermissions("users.write", "apikeys.read")
+	if err == nil || err.(*Error).Message != "Missing permissions: users.write, apikeys.read." {
+		t.Fatalf("expected the missing permissions to be listed, got %v", err)
+	}
+}
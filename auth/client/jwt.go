@@ -0,0 +1,77 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/zoriya/kyoo/keibi/models"
+)
+
+// Jwts are refreshed this long before they expire to account for clock skews & slow requests.
+const jwtRefreshMargin = 30 * time.Second
+
+// Jwt returns a jwt for the configured api key or session token. It is cached until it's about to expire.
+func (c *Client) Jwt(ctx context.Context) (string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.jwt != "" && time.Now().Add(jwtRefreshMargin).Before(c.jwtExpireAt) {
+		return c.jwt, nil
+	}
+
+	header := make(http.Header)
+	if c.apiKey != "" {
+		header.Set("X-Api-Key", c.apiKey)
+	} else if c.sessionToken != "" {
+		header.Set("Authorization", "Bearer "+c.sessionToken)
+	} else {
+		return "", errors.New("keibi: an api key or a session token is required")
+	}
+
+	var ret models.Jwt
+	err := c.do(ctx, request{method: http.MethodGet, path: "/jwt", header: header}, &ret)
+	if err != nil {
+		return "", err
+	}
+	if ret.Token == nil {
+		return "", errors.New("keibi: no jwt returned")
+	}
+	if ret.RefreshToken != nil {
+		c.sessionToken = *ret.RefreshToken
+		if c.onRotate != nil {
+			c.onRotate(*ret.RefreshToken)
+		}
+	}
+
+	// the jwt comes from keibi itself, no need to verify it to read its expiration.
+	claims := jwt.RegisteredClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(*ret.Token, &claims); err != nil {
+		return "", err
+	}
+	c.jwt = *ret.Token
+	c.jwtExpireAt = time.Now()
+	if claims.ExpiresAt != nil {
+		c.jwtExpireAt = claims.ExpiresAt.Time
+	}
+	return c.jwt, nil
+}
+
+// dropJwt removes jwt from the cache (if it wasn't already replaced) so the next call gets a new one.
+func (c *Client) dropJwt(jwt string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.jwt == jwt {
+		c.jwt = ""
+	}
+}
+
+// SetSessionToken replaces the session token used to authenticate (for example after a Login call).
+func (c *Client) SetSessionToken(token string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.sessionToken = token
+	c.jwt = ""
+}
@@ -0,0 +1,30 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/zoriya/kyoo/keibi/models"
+)
+
+// Presign creates a signature allowing the requests of `presign.For` without a jwt (via `?x-presign=`).
+func (c *Client) Presign(ctx context.Context, presign models.PresignRequest) (models.Presign, error) {
+	var ret models.Presign
+	err := c.do(ctx, request{method: http.MethodPost, path: "/presign", body: presign}, &ret)
+	return ret, err
+}
+
+// ListPresigns lists the active presigns of the authenticated session.
+func (c *Client) ListPresigns(ctx context.Context) ([]models.ActivePresign, error) {
+	var ret []models.ActivePresign
+	err := c.do(ctx, request{method: http.MethodGet, path: "/presign"}, &ret)
+	return ret, err
+}
+
+// RevokePresign revokes a presign before it expires.
+func (c *Client) RevokePresign(ctx context.Context, id string) (models.ActivePresign, error) {
+	var ret models.ActivePresign
+	err := c.do(ctx, request{method: http.MethodDelete, path: "/presign/" + url.PathEscape(id)}, &ret)
+	return ret, err
+}
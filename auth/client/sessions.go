@@ -0,0 +1,56 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/zoriya/kyoo/keibi/models"
+)
+
+// Login opens a new session. The client then uses it to authenticate, unless it was created with an api key.
+func (c *Client) Login(ctx context.Context, login models.LoginDto, device string) (models.SessionWToken, error) {
+	var ret models.SessionWToken
+	query := url.Values{}
+	if device != "" {
+		query.Set("device", device)
+	}
+	err := c.do(ctx, request{method: http.MethodPost, path: "/sessions", query: query, body: login, anonymous: true}, &ret)
+	if err != nil {
+		return ret, err
+	}
+	c.SetSessionToken(ret.Token)
+	return ret, nil
+}
+
+// ListSessions lists the sessions of the authenticated user.
+func (c *Client) ListSessions(ctx context.Context) ([]models.SessionWCurrent, error) {
+	var ret []models.SessionWCurrent
+	err := c.do(ctx, request{method: http.MethodGet, path: "/sessions"}, &ret)
+	return ret, err
+}
+
+// ListUserSessions lists the sessions of another user (requires `users.read`).
+func (c *Client) ListUserSessions(ctx context.Context, id string) ([]models.Session, error) {
+	var ret []models.Session
+	err := c.do(ctx, request{method: http.MethodGet, path: "/users/" + url.PathEscape(id) + "/sessions"}, &ret)
+	return ret, err
+}
+
+// Logout deletes the client's current session.
+func (c *Client) Logout(ctx context.Context) (models.SessionWLogout, error) {
+	var ret models.SessionWLogout
+	err := c.do(ctx, request{method: http.MethodDelete, path: "/sessions"}, &ret)
+	if err != nil {
+		return ret, err
+	}
+	c.SetSessionToken("")
+	return ret, nil
+}
+
+// DeleteSession deletes another session of the authenticated user.
+func (c *Client) DeleteSession(ctx context.Context, id string) (models.SessionWLogout, error) {
+	var ret models.SessionWLogout
+	err := c.do(ctx, request{method: http.MethodDelete, path: "/sessions/" + url.PathEscape(id)}, &ret)
+	return ret, err
+}
@@ -0,0 +1,81 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/zoriya/kyoo/keibi/models"
+)
+
+// Register creates a new account and returns its first session. It doesn't change the client's credentials.
+func (c *Client) Register(ctx context.Context, user models.RegisterDto, device string) (models.SessionWToken, error) {
+	var ret models.SessionWToken
+	query := url.Values{}
+	if device != "" {
+		query.Set("device", device)
+	}
+	err := c.do(ctx, request{method: http.MethodPost, path: "/users", query: query, body: user, anonymous: true}, &ret)
+	return ret, err
+}
+
+// GetMe returns the user authenticated by the client's credentials.
+func (c *Client) GetMe(ctx context.Context) (models.Me, error) {
+	var ret models.Me
+	err := c.do(ctx, request{method: http.MethodGet, path: "/users/me"}, &ret)
+	return ret, err
+}
+
+// GetUser returns a user by id or username (requires `users.read`).
+func (c *Client) GetUser(ctx context.Context, id string) (models.User, error) {
+	var ret models.User
+	err := c.do(ctx, request{method: http.MethodGet, path: "/users/" + url.PathEscape(id)}, &ret)
+	return ret, err
+}
+
+// ListUsers returns a page of users (requires `users.read`). Pass the previous page's last id as `after`.
+func (c *Client) ListUsers(ctx context.Context, after string) (models.Page[models.User], error) {
+	var ret models.Page[models.User]
+	query := url.Values{}
+	if after != "" {
+		query.Set("after", after)
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: "/users", query: query}, &ret)
+	return ret, err
+}
+
+// EditMe edits the authenticated user.
+func (c *Client) EditMe(ctx context.Context, edit models.EditUserDto) (models.User, error) {
+	var ret models.User
+	err := c.do(ctx, request{method: http.MethodPatch, path: "/users/me", body: edit}, &ret)
+	return ret, err
+}
+
+// EditUser edits another user (requires `users.write`).
+func (c *Client) EditUser(ctx context.Context, id string, edit models.EditUserDto) (models.User, error) {
+	var ret models.User
+	err := c.do(ctx, request{method: http.MethodPatch, path: "/users/" + url.PathEscape(id), body: edit}, &ret)
+	return ret, err
+}
+
+// ChangePassword changes the authenticated user's password, invalidating its other sessions if asked.
+func (c *Client) ChangePassword(ctx context.Context, edit models.EditPasswordDto, invalidate bool) error {
+	query := url.Values{}
+	query.Set("invalidate", strconv.FormatBool(invalidate))
+	return c.do(ctx, request{method: http.MethodPatch, path: "/users/me/password", query: query, body: edit}, nil)
+}
+
+// DeleteMe deletes (or schedules the deletion of) the authenticated user.
+func (c *Client) DeleteMe(ctx context.Context) (models.User, error) {
+	var ret models.User
+	err := c.do(ctx, request{method: http.MethodDelete, path: "/users/me"}, &ret)
+	return ret, err
+}
+
+// DeleteUser deletes another user (requires `users.delete`).
+func (c *Client) DeleteUser(ctx context.Context, id string) (models.User, error) {
+	var ret models.User
+	err := c.do(ctx, request{method: http.MethodDelete, path: "/users/" + url.PathEscape(id)}, &ret)
+	return ret, err
+}
@@ -0,0 +1,150 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/zoriya/kyoo/keibi/jwks"
+	"github.com/zoriya/kyoo/keibi/models"
+)
+
+// Subject of guest jwts.
+const guestId = "00000000-0000-0000-0000-000000000000"
+
+// Verifier validates jwts issued by keibi using its jwks (`/.well-known/jwks.json`).
+type Verifier struct {
+	jwksUrl string
+	issuer  string
+	keys    jwks.Cache
+}
+
+// NewVerifier creates a verifier using keibi's jwks url (for example `http://auth:4568/.well-known/jwks.json`,
+// it's served outside of the `/auth` prefix). If issuer is not empty, jwts with another `iss` claim
+// (keibi's PUBLIC_URL) are rejected.
+func NewVerifier(jwksUrl string, issuer string) *Verifier {
+	return &Verifier{
+		jwksUrl: jwksUrl,
+		issuer:  issuer,
+	}
+}
+
+// Claims of a verified keibi jwt.
+type Claims struct {
+	jwt.MapClaims
+}
+
+// Verify checks the signature & expiration of a jwt and returns its claims.
+func (v *Verifier) Verify(ctx context.Context, token string) (*Claims, error) {
+	opts := []jwt.ParserOption{
+		// keibi only signs with asymmetric keys, never accept a shared secret (or none).
+		jwt.WithValidMethods([]string{"RS256", "ES256", "EdDSA"}),
+		jwt.WithExpirationRequired(),
+	}
+	if v.issuer != "" {
+		opts = append(opts, jwt.WithIssuer(v.issuer))
+	}
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(
+		token,
+		claims,
+		func(token *jwt.Token) (any, error) {
+			kid, _ := token.Header["kid"].(string)
+			return v.keys.Key(ctx, v.jwksUrl, kid)
+		},
+		opts...,
+	)
+	if err != nil {
+		return nil, &Error{
+			Status:  http.StatusForbidden,
+			Code:    "auth.invalid_token",
+			Message: err.Error(),
+		}
+	}
+	return &Claims{MapClaims: claims}, nil
+}
+
+// VerifyRequest verifies the jwt of the request's `Authorization: Bearer` header (or `X-Bearer` cookie).
+func (v *Verifier) VerifyRequest(r *http.Request) (*Claims, error) {
+	var token string
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		var ok bool
+		token, ok = strings.CutPrefix(auth, "Bearer ")
+		if !ok {
+			return nil, &Error{Status: http.StatusUnauthorized, Code: "auth.invalid_bearer", Message: "Invalid bearer format."}
+		}
+	} else if cookie, _ := r.Cookie("X-Bearer"); cookie != nil {
+		token = cookie.Value
+	}
+	if token == "" {
+		return nil, &Error{Status: http.StatusUnauthorized, Code: "auth.not_logged_in", Message: "Not logged in"}
+	}
+	return v.Verify(r.Context(), token)
+}
+
+// UserId returns the id of the user (or api key/service) the jwt was created for.
+func (c *Claims) UserId() (uuid.UUID, error) {
+	sub, err := c.GetSubject()
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+	return uuid.Parse(sub)
+}
+
+// SessionId returns the id of the session the jwt was created from.
+func (c *Claims) SessionId() (uuid.UUID, error) {
+	sid, ok := c.MapClaims["sid"].(string)
+	if !ok {
+		return uuid.UUID{}, errors.New("missing sid claim")
+	}
+	return uuid.Parse(sid)
+}
+
+// IsGuest returns true for jwts created without credentials (if guests are enabled).
+func (c *Claims) IsGuest() bool {
+	sub, _ := c.GetSubject()
+	return sub == "" || sub == guestId
+}
+
+// Permissions returns the `permissions` claim.
+func (c *Claims) Permissions() []string {
+	ret := make([]string, 0)
+	perms, _ := c.MapClaims["permissions"].([]any)
+	for _, perm := range perms {
+		if p, ok := perm.(string); ok {
+			ret = append(ret, p)
+		}
+	}
+	return ret
+}
+
+// HasPermission checks if the jwt grants `perm`, wildcards (`*`, `users.*`) included.
+func (c *Claims) HasPermission(perm string) bool {
+	return models.HasPermission(c.Permissions(), perm)
+}
+
+// CheckPermissions returns an error like keibi's if the jwt is a guest or misses one of the permissions.
+func (c *Claims) CheckPermissions(perms ...string) error {
+	if c.IsGuest() {
+		return &Error{Status: http.StatusUnauthorized, Code: "auth.not_logged_in", Message: "Not logged in"}
+	}
+	granted := c.Permissions()
+	missing := make([]string, 0)
+	for _, perm := range perms {
+		if !models.HasPermission(granted, perm) {
+			missing = append(missing, perm)
+		}
+	}
+	if len(missing) != 0 {
+		return &Error{
+			Status:  http.StatusForbidden,
+			Code:    "auth.missing_permissions",
+			Message: fmt.Sprintf("Missing permissions: %s.", strings.Join(missing, ", ")),
+		}
+	}
+	return nil
+}
@@ -4,14 +4,22 @@ import (
 	"cmp"
 	"context"
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"maps"
+	"net/mail"
+	"net/netip"
 	"net/url"
 	"os"
 	"slices"
@@ -22,24 +30,47 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/oschwald/maxminddb-golang"
 	"github.com/zoriya/kyoo/keibi/dbc"
+	. "github.com/zoriya/kyoo/keibi/models"
 )
 
 type Configuration struct {
-	JwtPrivateKey       *rsa.PrivateKey
-	JwtPublicKey        *rsa.PublicKey
+	JwtSigningMethod    jwt.SigningMethod
+	JwtPrivateKey       crypto.Signer
+	JwtPublicKey        crypto.PublicKey
 	JwtKid              string
 	PublicUrl           string
 	OidcProviders       map[string]OidcProviderConfig
 	OidcRedirectUrls    []OidcRedirectRule
+	SamlProviders       map[string]SamlProviderConfig
+	SamlKey             crypto.Signer
+	SamlCertificate     *x509.Certificate
 	DefaultClaims       jwt.MapClaims
 	FirstUserClaims     jwt.MapClaims
 	GuestClaims         jwt.MapClaims
 	ProtectedClaims     []string
+	ExtraPermissions    []string
 	ExpirationDelay     time.Duration
 	EnvApiKeys          []ApiKeyWToken
 	ProfilePicturePath  string
+	GravatarUrl         string
 	DisableRegistration bool
+	DeletionDelay       time.Duration
+	SecretKey           []byte
+	PasswordPolicy      PasswordPolicy
+	Challenge           ChallengeConfig
+	SessionCacheSize    int
+	SessionCacheTtl     time.Duration
+	TouchFlushInterval  time.Duration
+	CertIdentities      []CertIdentity
+	TlsCertificate      *tls.Certificate
+	TlsClientCAs        *x509.CertPool
+	ClientCertProxies   []netip.Prefix
+	SettingsSchema      *SettingsSchema
+	Smtp                SmtpConfig
+	MagicLink           MagicLinkConfig
+	LoginAlerts         LoginAlertConfig
 }
 
 type OidcAuthMethod string
@@ -65,6 +96,18 @@ type OidcProviderConfig struct {
 	Profile       string
 	Scope         string
 	AuthMethod    OidcAuthMethod
+	Enabled       bool
+	// Link to existing users with the same (verified) email instead of failing.
+	LinkByEmail bool
+	// Issuer & jwks used to verify back-channel logout tokens. Back-channel logout is disabled if unset.
+	Issuer string
+	Jwks   string
+	// Provider's end_session_endpoint, used for RP-initiated logouts.
+	EndSession string
+	// Providers defined via env vars can't be edited at runtime.
+	ReadOnly bool
+	// The provider's secret could not be decrypted (SECRET_ENCRYPTION_KEY changed), it can't be used to login.
+	Broken bool
 }
 
 var DefaultConfig = Configuration{
@@ -72,9 +115,40 @@ var DefaultConfig = Configuration{
 	FirstUserClaims:  make(jwt.MapClaims),
 	OidcProviders:    make(map[string]OidcProviderConfig),
 	OidcRedirectUrls: make([]OidcRedirectRule, 0),
+	SamlProviders:    make(map[string]SamlProviderConfig),
 	ProtectedClaims:  []string{"permissions"},
 	ExpirationDelay:  30 * 24 * time.Hour,
 	EnvApiKeys:       make([]ApiKeyWToken, 0),
+	PasswordPolicy: PasswordPolicy{
+		MinLength:       8,
+		RequiredClasses: make([]PasswordClass, 0),
+	},
+	Challenge: ChallengeConfig{
+		Provider:   ChallengePow,
+		Difficulty: 20,
+	},
+	SessionCacheSize:   10000,
+	SessionCacheTtl:    30 * time.Second,
+	TouchFlushInterval: 30 * time.Second,
+	Smtp: SmtpConfig{
+		Port: 587,
+	},
+	MagicLink: MagicLinkConfig{
+		Enabled:     true,
+		Ttl:         15 * time.Minute,
+		HourlyLimit: 3,
+	},
+	LoginAlerts: LoginAlertConfig{
+		Enabled: true,
+		Ttl:     7 * 24 * time.Hour,
+	},
+}
+
+// Algorithms that can be used to sign jwts (via JWT_SIGNING_ALGORITHM).
+var JwtSigningAlgorithms = []jwt.SigningMethod{
+	jwt.SigningMethodRS256,
+	jwt.SigningMethodES256,
+	jwt.SigningMethodEdDSA,
 }
 
 func LoadConfiguration(ctx context.Context, db *dbc.Queries) (*Configuration, error) {
@@ -85,6 +159,10 @@ func LoadConfiguration(ctx context.Context, db *dbc.Queries) (*Configuration, er
 		os.Getenv("PROFILE_PICTURE_PATH"),
 		"/profile_pictures",
 	)
+	ret.GravatarUrl = strings.TrimSuffix(cmp.Or(
+		os.Getenv("GRAVATAR_URL"),
+		"https://www.gravatar.com/avatar",
+	), "/")
 
 	pub, err := url.Parse(ret.PublicUrl)
 	if err != nil {
@@ -123,6 +201,145 @@ func LoadConfiguration(ctx context.Context, db *dbc.Queries) (*Configuration, er
 	}
 	ret.DisableRegistration = disableRegistration
 
+	deletionDelay, err := time.ParseDuration(cmp.Or(os.Getenv("ACCOUNT_DELETION_DELAY"), "0"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ACCOUNT_DELETION_DELAY value: %w", err)
+	}
+	ret.DeletionDelay = deletionDelay
+
+	if minLength := os.Getenv("PASSWORD_MIN_LENGTH"); minLength != "" {
+		ret.PasswordPolicy.MinLength, err = strconv.Atoi(minLength)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PASSWORD_MIN_LENGTH value: %w", err)
+		}
+	}
+	for class := range strings.SplitSeq(os.Getenv("PASSWORD_REQUIRED_CLASSES"), ",") {
+		class = strings.TrimSpace(class)
+		if class == "" {
+			continue
+		}
+		if !slices.Contains(PasswordClasses, PasswordClass(class)) {
+			return nil, fmt.Errorf("invalid PASSWORD_REQUIRED_CLASSES entry %q, expected one of lower, upper, digit or symbol", class)
+		}
+		ret.PasswordPolicy.RequiredClasses = append(ret.PasswordPolicy.RequiredClasses, PasswordClass(class))
+	}
+	ret.PasswordPolicy.ForbidPersonalInfo, err = strconv.ParseBool(cmp.Or(os.Getenv("PASSWORD_FORBID_PERSONAL_INFO"), "false"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid PASSWORD_FORBID_PERSONAL_INFO value: %w", err)
+	}
+	ret.PasswordPolicy.BreachCheck = os.Getenv("PASSWORD_BREACH_CHECK")
+
+	ret.Challenge.Provider = ChallengeProvider(cmp.Or(os.Getenv("CHALLENGE_PROVIDER"), string(ret.Challenge.Provider)))
+	if _, ok := captchaVerifyUrls[ret.Challenge.Provider]; !ok && ret.Challenge.Provider != ChallengePow {
+		return nil, fmt.Errorf("invalid CHALLENGE_PROVIDER value %q, expected one of pow, hcaptcha or turnstile", ret.Challenge.Provider)
+	}
+	ret.Challenge.OnRegister, err = strconv.ParseBool(cmp.Or(os.Getenv("CHALLENGE_ON_REGISTER"), "false"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid CHALLENGE_ON_REGISTER value: %w", err)
+	}
+	afterFailedLogins, err := strconv.ParseInt(cmp.Or(os.Getenv("CHALLENGE_AFTER_FAILED_LOGINS"), "0"), 10, 32)
+	if err != nil || afterFailedLogins < 0 {
+		return nil, fmt.Errorf("invalid CHALLENGE_AFTER_FAILED_LOGINS value, expected a positive number")
+	}
+	ret.Challenge.AfterFailedLogins = int32(afterFailedLogins)
+	if difficulty := os.Getenv("CHALLENGE_POW_DIFFICULTY"); difficulty != "" {
+		ret.Challenge.Difficulty, err = strconv.Atoi(difficulty)
+		if err != nil || ret.Challenge.Difficulty < 1 || ret.Challenge.Difficulty > 64 {
+			return nil, fmt.Errorf("invalid CHALLENGE_POW_DIFFICULTY value, expected a number between 1 and 64")
+		}
+	}
+	ret.Challenge.SiteKey = os.Getenv("CHALLENGE_SITE_KEY")
+	ret.Challenge.Secret = os.Getenv("CHALLENGE_SECRET")
+	if ret.Challenge.Enabled() && ret.Challenge.Provider != ChallengePow && ret.Challenge.Secret == "" {
+		return nil, fmt.Errorf("CHALLENGE_SECRET is required when using the %s challenge provider", ret.Challenge.Provider)
+	}
+
+	ret.Smtp.Host = os.Getenv("SMTP_HOST")
+	if port := os.Getenv("SMTP_PORT"); port != "" {
+		ret.Smtp.Port, err = strconv.Atoi(port)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SMTP_PORT value: %w", err)
+		}
+	}
+	ret.Smtp.Username = os.Getenv("SMTP_USERNAME")
+	ret.Smtp.Password = os.Getenv("SMTP_PASSWORD")
+	ret.Smtp.From = os.Getenv("SMTP_FROM")
+	if ret.Smtp.Enabled() {
+		if _, err := mail.ParseAddress(ret.Smtp.From); err != nil {
+			return nil, fmt.Errorf("invalid SMTP_FROM value, expected an address like `Kyoo <kyoo@example.com>`: %w", err)
+		}
+	}
+
+	if enabled := os.Getenv("MAGIC_LINK_ENABLED"); enabled != "" {
+		ret.MagicLink.Enabled, err = strconv.ParseBool(enabled)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MAGIC_LINK_ENABLED value: %w", err)
+		}
+	}
+	// links are sent by email, they can't work without smtp.
+	ret.MagicLink.Enabled = ret.MagicLink.Enabled && ret.Smtp.Enabled()
+	if ttl := os.Getenv("MAGIC_LINK_TTL"); ttl != "" {
+		ret.MagicLink.Ttl, err = time.ParseDuration(ttl)
+		if err != nil || ret.MagicLink.Ttl <= 0 {
+			return nil, fmt.Errorf("invalid MAGIC_LINK_TTL value, expected a positive duration")
+		}
+	}
+	if limit := os.Getenv("MAGIC_LINK_HOURLY_LIMIT"); limit != "" {
+		hourly, err := strconv.ParseInt(limit, 10, 32)
+		if err != nil || hourly < 1 {
+			return nil, fmt.Errorf("invalid MAGIC_LINK_HOURLY_LIMIT value, expected a positive number")
+		}
+		ret.MagicLink.HourlyLimit = int32(hourly)
+	}
+
+	if enabled := os.Getenv("LOGIN_ALERTS_ENABLED"); enabled != "" {
+		ret.LoginAlerts.Enabled, err = strconv.ParseBool(enabled)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LOGIN_ALERTS_ENABLED value: %w", err)
+		}
+	}
+	if ttl := os.Getenv("LOGIN_ALERTS_TTL"); ttl != "" {
+		ret.LoginAlerts.Ttl, err = time.ParseDuration(ttl)
+		if err != nil || ret.LoginAlerts.Ttl <= 0 {
+			return nil, fmt.Errorf("invalid LOGIN_ALERTS_TTL value, expected a positive duration")
+		}
+	}
+	if path := os.Getenv("GEOIP_DATABASE_PATH"); path != "" {
+		ret.LoginAlerts.GeoIp, err = maxminddb.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("could not open GEOIP_DATABASE_PATH: %w", err)
+		}
+	}
+
+	if size := os.Getenv("SESSION_CACHE_SIZE"); size != "" {
+		ret.SessionCacheSize, err = strconv.Atoi(size)
+		if err != nil || ret.SessionCacheSize < 0 {
+			return nil, fmt.Errorf("invalid SESSION_CACHE_SIZE value, expected a positive number")
+		}
+	}
+	if ttl := os.Getenv("SESSION_CACHE_TTL"); ttl != "" {
+		ret.SessionCacheTtl, err = time.ParseDuration(ttl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SESSION_CACHE_TTL value: %w", err)
+		}
+	}
+	if interval := os.Getenv("LAST_USED_FLUSH_INTERVAL"); interval != "" {
+		ret.TouchFlushInterval, err = time.ParseDuration(interval)
+		if err != nil || ret.TouchFlushInterval <= 0 {
+			return nil, fmt.Errorf("invalid LAST_USED_FLUSH_INTERVAL value, expected a positive duration")
+		}
+	}
+
+	ret.SettingsSchema, err = loadSettingsSchema(os.Getenv("USER_SETTINGS_SCHEMA_PATH"))
+	if err != nil {
+		return nil, err
+	}
+
+	if secret := os.Getenv("SECRET_ENCRYPTION_KEY"); secret != "" {
+		key := sha256.Sum256([]byte(secret))
+		ret.SecretKey = key[:]
+	}
+
 	claims := os.Getenv("EXTRA_CLAIMS")
 	if claims != "" {
 		err := json.Unmarshal([]byte(claims), &ret.DefaultClaims)
@@ -152,34 +369,28 @@ func LoadConfiguration(ctx context.Context, db *dbc.Queries) (*Configuration, er
 	protected := strings.Split(os.Getenv("PROTECTED_CLAIMS"), ",")
 	ret.ProtectedClaims = append(ret.ProtectedClaims, protected...)
 
-	rsa_pk_path := os.Getenv("RSA_PRIVATE_KEY_PATH")
-	if rsa_pk_path != "" {
-		privateKeyData, err := os.ReadFile(rsa_pk_path)
-		if err != nil {
-			return nil, err
-		}
-
-		block, _ := pem.Decode(privateKeyData)
-		if block == nil || block.Type != "RSA PRIVATE KEY" {
-			return nil, err
+	for perm := range strings.SplitSeq(os.Getenv("EXTRA_PERMISSIONS"), ",") {
+		perm = strings.TrimSpace(perm)
+		if perm != "" {
+			ret.ExtraPermissions = append(ret.ExtraPermissions, perm)
 		}
+	}
 
-		ret.JwtPrivateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
-		if err != nil {
-			pkcs8Key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
-			if err != nil {
-				return nil, err
-			}
-			ret.JwtPrivateKey = pkcs8Key.(*rsa.PrivateKey)
-		}
-	} else {
-		var err error
-		ret.JwtPrivateKey, err = rsa.GenerateKey(rand.Reader, 4096)
-		if err != nil {
-			return nil, err
-		}
+	ret.JwtSigningMethod = jwt.GetSigningMethod(cmp.Or(os.Getenv("JWT_SIGNING_ALGORITHM"), "RS256"))
+	if !slices.Contains(JwtSigningAlgorithms, ret.JwtSigningMethod) {
+		return nil, fmt.Errorf(
+			"invalid JWT_SIGNING_ALGORITHM %q, expected RS256, ES256 or EdDSA",
+			os.Getenv("JWT_SIGNING_ALGORITHM"),
+		)
 	}
-	ret.JwtPublicKey = &ret.JwtPrivateKey.PublicKey
+	ret.JwtPrivateKey, err = loadJwtPrivateKey(
+		ret.JwtSigningMethod,
+		cmp.Or(os.Getenv("JWT_PRIVATE_KEY_PATH"), os.Getenv("RSA_PRIVATE_KEY_PATH")),
+	)
+	if err != nil {
+		return nil, err
+	}
+	ret.JwtPublicKey = ret.JwtPrivateKey.Public()
 	key, err := jwk.Import(ret.JwtPublicKey)
 	if err != nil {
 		return nil, err
@@ -194,7 +405,8 @@ func LoadConfiguration(ctx context.Context, db *dbc.Queries) (*Configuration, er
 		if !strings.HasPrefix(env, "KEIBI_APIKEY_") {
 			continue
 		}
-		v := strings.Split(env, "=")
+		// api keys can contain `=` (base64 padding).
+		v := strings.SplitN(env, "=", 2)
 		if strings.HasSuffix(v[0], "_CLAIMS") {
 			continue
 		}
@@ -268,7 +480,12 @@ func LoadConfiguration(ctx context.Context, db *dbc.Queries) (*Configuration, er
 			Token:         os.Getenv(fmt.Sprintf("OIDC_%s_TOKEN", name)),
 			Profile:       os.Getenv(fmt.Sprintf("OIDC_%s_PROFILE", name)),
 			Scope:         os.Getenv(fmt.Sprintf("OIDC_%s_SCOPE", name)),
+			Issuer:        os.Getenv(fmt.Sprintf("OIDC_%s_ISSUER", name)),
+			Jwks:          os.Getenv(fmt.Sprintf("OIDC_%s_JWKS", name)),
+			EndSession:    os.Getenv(fmt.Sprintf("OIDC_%s_ENDSESSION", name)),
 			AuthMethod:    OidcClientSecretBasic,
+			Enabled:       true,
+			ReadOnly:      true,
 		}
 
 		authMethod := os.Getenv(fmt.Sprintf("OIDC_%s_AUTHMETHOD", name))
@@ -281,6 +498,14 @@ func LoadConfiguration(ctx context.Context, db *dbc.Queries) (*Configuration, er
 			}
 		}
 
+		linkByEmail := os.Getenv(fmt.Sprintf("OIDC_%s_LINKBYEMAIL", name))
+		if linkByEmail != "" {
+			provider.LinkByEmail, err = strconv.ParseBool(linkByEmail)
+			if err != nil {
+				return nil, fmt.Errorf("invalid OIDC_%s_LINKBYEMAIL: %w", name, err)
+			}
+		}
+
 		if provider.Name == "" {
 			provider.Name = name
 		}
@@ -300,6 +525,9 @@ func LoadConfiguration(ctx context.Context, db *dbc.Queries) (*Configuration, er
 		if provider.Profile == "" {
 			missing = append(missing, fmt.Sprintf("OIDC_%s_PROFILE", name))
 		}
+		if (provider.Issuer == "") != (provider.Jwks == "") {
+			missing = append(missing, fmt.Sprintf("OIDC_%s_ISSUER", name), fmt.Sprintf("OIDC_%s_JWKS", name))
+		}
 		if len(missing) > 0 {
 			return nil, fmt.Errorf("invalid oidc configuration for provider %s, missing required values: %s", providerId, strings.Join(missing, ", "))
 		}
@@ -310,5 +538,185 @@ func LoadConfiguration(ctx context.Context, db *dbc.Queries) (*Configuration, er
 		ret.OidcProviders[providerId] = provider
 	}
 
+	for _, env := range os.Environ() {
+		k, _, _ := strings.Cut(env, "=")
+		if !strings.HasPrefix(k, "SAML_") || !strings.HasSuffix(k, "_METADATA") {
+			continue
+		}
+		name := strings.TrimSuffix(strings.TrimPrefix(k, "SAML_"), "_METADATA")
+		if name == "" {
+			continue
+		}
+		providerId := strings.ToLower(name)
+		if _, ok := ret.OidcProviders[providerId]; ok {
+			return nil, fmt.Errorf("saml provider %s has the same id as an oidc provider", providerId)
+		}
+		provider := SamlProviderConfig{
+			Id:                providerId,
+			Name:              cmp.Or(os.Getenv(fmt.Sprintf("SAML_%s_NAME", name)), name),
+			Logo:              os.Getenv(fmt.Sprintf("SAML_%s_LOGO", name)),
+			Metadata:          os.Getenv(k),
+			IdAttribute:       os.Getenv(fmt.Sprintf("SAML_%s_ATTRIBUTE_ID", name)),
+			UsernameAttribute: os.Getenv(fmt.Sprintf("SAML_%s_ATTRIBUTE_USERNAME", name)),
+			EmailAttribute:    os.Getenv(fmt.Sprintf("SAML_%s_ATTRIBUTE_EMAIL", name)),
+			ClaimAttributes:   make(map[string]string),
+		}
+		if claims := os.Getenv(fmt.Sprintf("SAML_%s_ATTRIBUTE_CLAIMS", name)); claims != "" {
+			if err := json.Unmarshal([]byte(claims), &provider.ClaimAttributes); err != nil {
+				return nil, fmt.Errorf("invalid SAML_%s_ATTRIBUTE_CLAIMS, expected a json object of claim to attribute names: %w", name, err)
+			}
+		}
+		linkByEmail := os.Getenv(fmt.Sprintf("SAML_%s_LINKBYEMAIL", name))
+		if linkByEmail != "" {
+			provider.LinkByEmail, err = strconv.ParseBool(linkByEmail)
+			if err != nil {
+				return nil, fmt.Errorf("invalid SAML_%s_LINKBYEMAIL: %w", name, err)
+			}
+		}
+		ret.SamlProviders[providerId] = provider
+	}
+	certPath, keyPath := os.Getenv("SAML_SP_CERTIFICATE_PATH"), os.Getenv("SAML_SP_KEY_PATH")
+	if (certPath == "") != (keyPath == "") {
+		return nil, errors.New("SAML_SP_CERTIFICATE_PATH and SAML_SP_KEY_PATH must be set together")
+	}
+	if certPath != "" {
+		ret.SamlCertificate, ret.SamlKey, err = loadSamlKeyPair(certPath, keyPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, env := range os.Environ() {
+		k, _, _ := strings.Cut(env, "=")
+		if !strings.HasPrefix(k, "KEIBI_CERT_") || !strings.HasSuffix(k, "_CLAIMS") {
+			continue
+		}
+		name := strings.TrimSuffix(strings.TrimPrefix(k, "KEIBI_CERT_"), "_CLAIMS")
+		identity := CertIdentity{
+			Name:    strings.ToLower(name),
+			Subject: os.Getenv(fmt.Sprintf("KEIBI_CERT_%s_SUBJECT", name)),
+			San:     os.Getenv(fmt.Sprintf("KEIBI_CERT_%s_SAN", name)),
+		}
+		// keep the same id across restarts so jwts & logs can be correlated.
+		identity.Id = uuid.NewSHA1(uuid.NameSpaceURL, []byte("keibi:cert:"+identity.Name))
+		if identity.Subject == "" && identity.San == "" {
+			return nil, fmt.Errorf("missing KEIBI_CERT_%s_SUBJECT or KEIBI_CERT_%s_SAN", name, name)
+		}
+		err := json.Unmarshal([]byte(os.Getenv(k)), &identity.Claims)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", k, err)
+		}
+		ret.CertIdentities = append(ret.CertIdentities, identity)
+	}
+
+	tlsCert, tlsKey := os.Getenv("TLS_CERT_PATH"), os.Getenv("TLS_KEY_PATH")
+	if (tlsCert == "") != (tlsKey == "") {
+		return nil, errors.New("TLS_CERT_PATH and TLS_KEY_PATH must be set together")
+	}
+	if tlsCert != "" {
+		pair, err := tls.LoadX509KeyPair(tlsCert, tlsKey)
+		if err != nil {
+			return nil, fmt.Errorf("could not load tls key pair: %w", err)
+		}
+		ret.TlsCertificate = &pair
+	}
+	if caPath := os.Getenv("TLS_CLIENT_CA_PATH"); caPath != "" {
+		if ret.TlsCertificate == nil {
+			return nil, errors.New("TLS_CLIENT_CA_PATH requires TLS_CERT_PATH and TLS_KEY_PATH")
+		}
+		data, err := os.ReadFile(caPath)
+		if err != nil {
+			return nil, err
+		}
+		ret.TlsClientCAs = x509.NewCertPool()
+		if !ret.TlsClientCAs.AppendCertsFromPEM(data) {
+			return nil, errors.New("invalid TLS_CLIENT_CA_PATH, expected pem encoded certificates")
+		}
+	}
+	for proxy := range strings.SplitSeq(os.Getenv("CLIENT_CERT_TRUSTED_PROXIES"), ",") {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			addr, aerr := netip.ParseAddr(proxy)
+			if aerr != nil {
+				return nil, fmt.Errorf("invalid CLIENT_CERT_TRUSTED_PROXIES entry %s: %w", proxy, err)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		ret.ClientCertProxies = append(ret.ClientCertProxies, prefix)
+	}
+
 	return &ret, nil
 }
+
+// loadSamlKeyPair reads the pem encoded certificate & rsa key used to sign saml requests and decrypt assertions.
+func loadSamlKeyPair(certPath string, keyPath string) (*x509.Certificate, crypto.Signer, error) {
+	pair, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not load saml key pair: %w", err)
+	}
+	key, ok := pair.PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, nil, errors.New("invalid SAML_SP_KEY_PATH: only rsa keys are supported")
+	}
+	return pair.Leaf, key, nil
+}
+
+// loadJwtPrivateKey reads a pem encoded key (pkcs1, sec1 or pkcs8) matching the signing method.
+// If path is empty, a new key is generated.
+func loadJwtPrivateKey(method jwt.SigningMethod, path string) (crypto.Signer, error) {
+	if path == "" {
+		switch method {
+		case jwt.SigningMethodES256:
+			return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		case jwt.SigningMethodEdDSA:
+			_, key, err := ed25519.GenerateKey(rand.Reader)
+			return key, err
+		default:
+			return rsa.GenerateKey(rand.Reader, 4096)
+		}
+	}
+
+	privateKeyData, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(privateKeyData)
+	if block == nil {
+		return nil, fmt.Errorf("invalid private key %s: no pem block found", path)
+	}
+
+	var key any
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("invalid private key %s: unsupported pem type %q", path, block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if method == jwt.SigningMethodRS256 {
+			return k, nil
+		}
+	case *ecdsa.PrivateKey:
+		if method == jwt.SigningMethodES256 && k.Curve == elliptic.P256() {
+			return k, nil
+		}
+	case ed25519.PrivateKey:
+		if method == jwt.SigningMethodEdDSA {
+			return k, nil
+		}
+	}
+	return nil, fmt.Errorf("private key %s can't be used with the %s algorithm", path, method.Alg())
+}
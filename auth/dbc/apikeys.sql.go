@@ -13,10 +13,10 @@ import (
 )
 
 const createApiKey = `-- name: CreateApiKey :one
-insert into keibi.apikeys(name, token, claims, created_by)
-	values ($1, $2, $3, $4)
+insert into keibi.apikeys(name, token, claims, created_by, personal)
+	values ($1, $2, $3, $4, $5)
 returning
-	pk, id, name, token, claims, created_by, created_at, last_used
+	pk, id, name, token, claims, created_by, created_at, last_used, personal
 `
 
 type CreateApiKeyParams struct {
@@ -24,6 +24,7 @@ type CreateApiKeyParams struct {
 	Token     string        `json:"token"`
 	Claims    jwt.MapClaims `json:"claims"`
 	CreatedBy *int32        `json:"createdBy"`
+	Personal  bool          `json:"personal"`
 }
 
 func (q *Queries) CreateApiKey(ctx context.Context, arg CreateApiKeyParams) (Apikey, error) {
@@ -32,6 +33,7 @@ func (q *Queries) CreateApiKey(ctx context.Context, arg CreateApiKeyParams) (Api
 		arg.Token,
 		arg.Claims,
 		arg.CreatedBy,
+		arg.Personal,
 	)
 	var i Apikey
 	err := row.Scan(
@@ -43,6 +45,7 @@ func (q *Queries) CreateApiKey(ctx context.Context, arg CreateApiKeyParams) (Api
 		&i.CreatedBy,
 		&i.CreatedAt,
 		&i.LastUsed,
+		&i.Personal,
 	)
 	return i, err
 }
@@ -51,7 +54,7 @@ const deleteApiKey = `-- name: DeleteApiKey :one
 delete from keibi.apikeys
 where id = $1
 returning
-	pk, id, name, token, claims, created_by, created_at, last_used
+	pk, id, name, token, claims, created_by, created_at, last_used, personal
 `
 
 func (q *Queries) DeleteApiKey(ctx context.Context, id uuid.UUID) (Apikey, error) {
@@ -66,13 +69,69 @@ func (q *Queries) DeleteApiKey(ctx context.Context, id uuid.UUID) (Apikey, error
 		&i.CreatedBy,
 		&i.CreatedAt,
 		&i.LastUsed,
+		&i.Personal,
+	)
+	return i, err
+}
+
+const deleteApiKeyByToken = `-- name: DeleteApiKeyByToken :one
+delete from keibi.apikeys
+where token = $1
+returning
+	pk, id, name, token, claims, created_by, created_at, last_used, personal
+`
+
+func (q *Queries) DeleteApiKeyByToken(ctx context.Context, token string) (Apikey, error) {
+	row := q.db.QueryRow(ctx, deleteApiKeyByToken, token)
+	var i Apikey
+	err := row.Scan(
+		&i.Pk,
+		&i.Id,
+		&i.Name,
+		&i.Token,
+		&i.Claims,
+		&i.CreatedBy,
+		&i.CreatedAt,
+		&i.LastUsed,
+		&i.Personal,
+	)
+	return i, err
+}
+
+const deletePersonalToken = `-- name: DeletePersonalToken :one
+delete from keibi.apikeys
+where id = $1
+	and created_by = $2
+	and personal
+returning
+	pk, id, name, token, claims, created_by, created_at, last_used, personal
+`
+
+type DeletePersonalTokenParams struct {
+	Id        uuid.UUID `json:"id"`
+	CreatedBy *int32    `json:"createdBy"`
+}
+
+func (q *Queries) DeletePersonalToken(ctx context.Context, arg DeletePersonalTokenParams) (Apikey, error) {
+	row := q.db.QueryRow(ctx, deletePersonalToken, arg.Id, arg.CreatedBy)
+	var i Apikey
+	err := row.Scan(
+		&i.Pk,
+		&i.Id,
+		&i.Name,
+		&i.Token,
+		&i.Claims,
+		&i.CreatedBy,
+		&i.CreatedAt,
+		&i.LastUsed,
+		&i.Personal,
 	)
 	return i, err
 }
 
 const getApiKey = `-- name: GetApiKey :one
 select
-	pk, id, name, token, claims, created_by, created_at, last_used
+	pk, id, name, token, claims, created_by, created_at, last_used, personal
 from
 	keibi.apikeys
 where
@@ -91,15 +150,77 @@ func (q *Queries) GetApiKey(ctx context.Context, token string) (Apikey, error) {
 		&i.CreatedBy,
 		&i.CreatedAt,
 		&i.LastUsed,
+		&i.Personal,
+	)
+	return i, err
+}
+
+const getApiKeyById = `-- name: GetApiKeyById :one
+select
+	pk, id, name, token, claims, created_by, created_at, last_used, personal
+from
+	keibi.apikeys
+where
+	id = $1
+`
+
+func (q *Queries) GetApiKeyById(ctx context.Context, id uuid.UUID) (Apikey, error) {
+	row := q.db.QueryRow(ctx, getApiKeyById, id)
+	var i Apikey
+	err := row.Scan(
+		&i.Pk,
+		&i.Id,
+		&i.Name,
+		&i.Token,
+		&i.Claims,
+		&i.CreatedBy,
+		&i.CreatedAt,
+		&i.LastUsed,
+		&i.Personal,
+	)
+	return i, err
+}
+
+const getApiKeyOwner = `-- name: GetApiKeyOwner :one
+select
+	u.pk, u.id, u.username, u.email, u.password, u.claims, u.created_date, u.last_seen, u.delete_at, u.failed_logins, u.password_reset_required
+from
+	keibi.apikeys as k
+	inner join keibi.users as u on u.pk = k.created_by
+where
+	k.pk = $1
+`
+
+type GetApiKeyOwnerRow struct {
+	User User `json:"user"`
+}
+
+func (q *Queries) GetApiKeyOwner(ctx context.Context, pk int32) (GetApiKeyOwnerRow, error) {
+	row := q.db.QueryRow(ctx, getApiKeyOwner, pk)
+	var i GetApiKeyOwnerRow
+	err := row.Scan(
+		&i.User.Pk,
+		&i.User.Id,
+		&i.User.Username,
+		&i.User.Email,
+		&i.User.Password,
+		&i.User.Claims,
+		&i.User.CreatedDate,
+		&i.User.LastSeen,
+		&i.User.DeleteAt,
+		&i.User.FailedLogins,
+		&i.User.PasswordResetRequired,
 	)
 	return i, err
 }
 
 const listApiKeys = `-- name: ListApiKeys :many
 select
-	pk, id, name, token, claims, created_by, created_at, last_used
+	pk, id, name, token, claims, created_by, created_at, last_used, personal
 from
 	keibi.apikeys
+where
+	not personal
 order by
 	last_used
 `
@@ -122,6 +243,90 @@ func (q *Queries) ListApiKeys(ctx context.Context) ([]Apikey, error) {
 			&i.CreatedBy,
 			&i.CreatedAt,
 			&i.LastUsed,
+			&i.Personal,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listApiKeysCreatedBy = `-- name: ListApiKeysCreatedBy :many
+select
+	pk, id, name, token, claims, created_by, created_at, last_used, personal
+from
+	keibi.apikeys
+where
+	created_by = $1
+order by
+	created_at
+`
+
+func (q *Queries) ListApiKeysCreatedBy(ctx context.Context, createdBy *int32) ([]Apikey, error) {
+	rows, err := q.db.Query(ctx, listApiKeysCreatedBy, createdBy)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Apikey
+	for rows.Next() {
+		var i Apikey
+		if err := rows.Scan(
+			&i.Pk,
+			&i.Id,
+			&i.Name,
+			&i.Token,
+			&i.Claims,
+			&i.CreatedBy,
+			&i.CreatedAt,
+			&i.LastUsed,
+			&i.Personal,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listPersonalTokens = `-- name: ListPersonalTokens :many
+select
+	pk, id, name, token, claims, created_by, created_at, last_used, personal
+from
+	keibi.apikeys
+where
+	created_by = $1
+	and personal
+order by
+	created_at
+`
+
+func (q *Queries) ListPersonalTokens(ctx context.Context, createdBy *int32) ([]Apikey, error) {
+	rows, err := q.db.Query(ctx, listPersonalTokens, createdBy)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Apikey
+	for rows.Next() {
+		var i Apikey
+		if err := rows.Scan(
+			&i.Pk,
+			&i.Id,
+			&i.Name,
+			&i.Token,
+			&i.Claims,
+			&i.CreatedBy,
+			&i.CreatedAt,
+			&i.LastUsed,
+			&i.Personal,
 		); err != nil {
 			return nil, err
 		}
@@ -0,0 +1,64 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.31.1
+// source: audit.sql
+
+package dbc
+
+import (
+	"context"
+)
+
+const createAuditLog = `-- name: CreateAuditLog :exec
+insert into keibi.audit_logs(user_pk, action, details)
+	values ($1, $2, $3)
+`
+
+type CreateAuditLogParams struct {
+	UserPk  int32       `json:"userPk"`
+	Action  string      `json:"action"`
+	Details interface{} `json:"details"`
+}
+
+func (q *Queries) CreateAuditLog(ctx context.Context, arg CreateAuditLogParams) error {
+	_, err := q.db.Exec(ctx, createAuditLog, arg.UserPk, arg.Action, arg.Details)
+	return err
+}
+
+const getUserAuditLogs = `-- name: GetUserAuditLogs :many
+select
+	pk, id, user_pk, action, details, created_at
+from
+	keibi.audit_logs
+where
+	user_pk = $1
+order by
+	created_at desc
+`
+
+func (q *Queries) GetUserAuditLogs(ctx context.Context, userPk int32) ([]AuditLog, error) {
+	rows, err := q.db.Query(ctx, getUserAuditLogs, userPk)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []AuditLog
+	for rows.Next() {
+		var i AuditLog
+		if err := rows.Scan(
+			&i.Pk,
+			&i.Id,
+			&i.UserPk,
+			&i.Action,
+			&i.Details,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
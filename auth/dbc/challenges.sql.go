@@ -0,0 +1,70 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.31.1
+// source: challenges.sql
+
+package dbc
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const createChallenge = `-- name: CreateChallenge :one
+insert into keibi.challenges(nonce, difficulty, expire_at)
+	values ($1, $2, $3)
+returning
+	pk, id, nonce, difficulty, expire_at
+`
+
+type CreateChallengeParams struct {
+	Nonce      string    `json:"nonce"`
+	Difficulty int32     `json:"difficulty"`
+	ExpireAt   time.Time `json:"expireAt"`
+}
+
+func (q *Queries) CreateChallenge(ctx context.Context, arg CreateChallengeParams) (KeibiChallenge, error) {
+	row := q.db.QueryRow(ctx, createChallenge, arg.Nonce, arg.Difficulty, arg.ExpireAt)
+	var i KeibiChallenge
+	err := row.Scan(
+		&i.Pk,
+		&i.Id,
+		&i.Nonce,
+		&i.Difficulty,
+		&i.ExpireAt,
+	)
+	return i, err
+}
+
+const deleteExpiredChallenges = `-- name: DeleteExpiredChallenges :exec
+delete from keibi.challenges
+where expire_at < now()::timestamptz
+`
+
+func (q *Queries) DeleteExpiredChallenges(ctx context.Context) error {
+	_, err := q.db.Exec(ctx, deleteExpiredChallenges)
+	return err
+}
+
+const spendChallenge = `-- name: SpendChallenge :one
+delete from keibi.challenges
+where id = $1
+	and expire_at > now()::timestamptz
+returning
+	pk, id, nonce, difficulty, expire_at
+`
+
+func (q *Queries) SpendChallenge(ctx context.Context, id uuid.UUID) (KeibiChallenge, error) {
+	row := q.db.QueryRow(ctx, spendChallenge, id)
+	var i KeibiChallenge
+	err := row.Scan(
+		&i.Pk,
+		&i.Id,
+		&i.Nonce,
+		&i.Difficulty,
+		&i.ExpireAt,
+	)
+	return i, err
+}
@@ -0,0 +1,294 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.31.1
+// source: login_alerts.sql
+
+package dbc
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const createLoginAlert = `-- name: CreateLoginAlert :one
+insert into keibi.login_alerts(token, user_pk, session_id, device, ip, location, expire_at)
+	values ($1, $2, $3, $4, $5, $6, $7)
+returning
+	pk, id, token, user_pk, session_id, device, ip, location, reported_at, password_reset_at, expire_at, created_at
+`
+
+type CreateLoginAlertParams struct {
+	Token     string    `json:"token"`
+	UserPk    int32     `json:"userPk"`
+	SessionId uuid.UUID `json:"sessionId"`
+	Device    *string   `json:"device"`
+	Ip        *string   `json:"ip"`
+	Location  *string   `json:"location"`
+	ExpireAt  time.Time `json:"expireAt"`
+}
+
+func (q *Queries) CreateLoginAlert(ctx context.Context, arg CreateLoginAlertParams) (KeibiLoginAlert, error) {
+	row := q.db.QueryRow(ctx, createLoginAlert,
+		arg.Token,
+		arg.UserPk,
+		arg.SessionId,
+		arg.Device,
+		arg.Ip,
+		arg.Location,
+		arg.ExpireAt,
+	)
+	var i KeibiLoginAlert
+	err := row.Scan(
+		&i.Pk,
+		&i.Id,
+		&i.Token,
+		&i.UserPk,
+		&i.SessionId,
+		&i.Device,
+		&i.Ip,
+		&i.Location,
+		&i.ReportedAt,
+		&i.PasswordResetAt,
+		&i.ExpireAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const deleteExpiredLoginAlerts = `-- name: DeleteExpiredLoginAlerts :exec
+delete from keibi.login_alerts
+where expire_at < now()::timestamptz - interval '1 day'
+`
+
+func (q *Queries) DeleteExpiredLoginAlerts(ctx context.Context) error {
+	_, err := q.db.Exec(ctx, deleteExpiredLoginAlerts)
+	return err
+}
+
+const deleteStaleKnownDevices = `-- name: DeleteStaleKnownDevices :exec
+delete from keibi.known_devices
+where last_seen < now()::timestamptz - interval '1 year'
+`
+
+func (q *Queries) DeleteStaleKnownDevices(ctx context.Context) error {
+	_, err := q.db.Exec(ctx, deleteStaleKnownDevices)
+	return err
+}
+
+const getActiveLoginAlert = `-- name: GetActiveLoginAlert :one
+select
+	pk, id, token, user_pk, session_id, device, ip, location, reported_at, password_reset_at, expire_at, created_at
+from
+	keibi.login_alerts
+where
+	token = $1
+	and password_reset_at is null
+	and expire_at > now()::timestamptz
+limit 1
+`
+
+func (q *Queries) GetActiveLoginAlert(ctx context.Context, token string) (KeibiLoginAlert, error) {
+	row := q.db.QueryRow(ctx, getActiveLoginAlert, token)
+	var i KeibiLoginAlert
+	err := row.Scan(
+		&i.Pk,
+		&i.Id,
+		&i.Token,
+		&i.UserPk,
+		&i.SessionId,
+		&i.Device,
+		&i.Ip,
+		&i.Location,
+		&i.ReportedAt,
+		&i.PasswordResetAt,
+		&i.ExpireAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getKnownDevice = `-- name: GetKnownDevice :one
+select
+	exists (
+		select
+			1
+		from
+			keibi.known_devices as kd
+		where
+			kd.user_pk = $1) as has_history,
+	exists (
+		select
+			1
+		from
+			keibi.known_devices as kd
+		where
+			kd.user_pk = $1
+			and kd.device = $2) as known_device,
+	exists (
+		select
+			1
+		from
+			keibi.known_devices as kd
+		where
+			kd.user_pk = $1
+			and kd.network = $3) as known_network
+`
+
+type GetKnownDeviceParams struct {
+	UserPk  int32  `json:"userPk"`
+	Device  string `json:"device"`
+	Network string `json:"network"`
+}
+
+type GetKnownDeviceRow struct {
+	HasHistory   bool `json:"hasHistory"`
+	KnownDevice  bool `json:"knownDevice"`
+	KnownNetwork bool `json:"knownNetwork"`
+}
+
+func (q *Queries) GetKnownDevice(ctx context.Context, arg GetKnownDeviceParams) (GetKnownDeviceRow, error) {
+	row := q.db.QueryRow(ctx, getKnownDevice, arg.UserPk, arg.Device, arg.Network)
+	var i GetKnownDeviceRow
+	err := row.Scan(&i.HasHistory, &i.KnownDevice, &i.KnownNetwork)
+	return i, err
+}
+
+const getLoginAlert = `-- name: GetLoginAlert :one
+select
+	pk, id, token, user_pk, session_id, device, ip, location, reported_at, password_reset_at, expire_at, created_at
+from
+	keibi.login_alerts
+where
+	token = $1
+	and reported_at is not null
+	and password_reset_at is null
+	and expire_at > now()::timestamptz
+limit 1
+`
+
+func (q *Queries) GetLoginAlert(ctx context.Context, token string) (KeibiLoginAlert, error) {
+	row := q.db.QueryRow(ctx, getLoginAlert, token)
+	var i KeibiLoginAlert
+	err := row.Scan(
+		&i.Pk,
+		&i.Id,
+		&i.Token,
+		&i.UserPk,
+		&i.SessionId,
+		&i.Device,
+		&i.Ip,
+		&i.Location,
+		&i.ReportedAt,
+		&i.PasswordResetAt,
+		&i.ExpireAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const reportLoginAlert = `-- name: ReportLoginAlert :one
+update
+	keibi.login_alerts
+set
+	reported_at = coalesce(reported_at, now()::timestamptz)
+where
+	token = $1
+	and password_reset_at is null
+	and expire_at > now()::timestamptz
+returning
+	pk, id, token, user_pk, session_id, device, ip, location, reported_at, password_reset_at, expire_at, created_at
+`
+
+func (q *Queries) ReportLoginAlert(ctx context.Context, token string) (KeibiLoginAlert, error) {
+	row := q.db.QueryRow(ctx, reportLoginAlert, token)
+	var i KeibiLoginAlert
+	err := row.Scan(
+		&i.Pk,
+		&i.Id,
+		&i.Token,
+		&i.UserPk,
+		&i.SessionId,
+		&i.Device,
+		&i.Ip,
+		&i.Location,
+		&i.ReportedAt,
+		&i.PasswordResetAt,
+		&i.ExpireAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const resetPasswordFromLoginAlert = `-- name: ResetPasswordFromLoginAlert :one
+update
+	keibi.login_alerts
+set
+	password_reset_at = now()::timestamptz
+where
+	token = $1
+	and reported_at is not null
+	and password_reset_at is null
+	and expire_at > now()::timestamptz
+returning
+	pk, id, token, user_pk, session_id, device, ip, location, reported_at, password_reset_at, expire_at, created_at
+`
+
+func (q *Queries) ResetPasswordFromLoginAlert(ctx context.Context, token string) (KeibiLoginAlert, error) {
+	row := q.db.QueryRow(ctx, resetPasswordFromLoginAlert, token)
+	var i KeibiLoginAlert
+	err := row.Scan(
+		&i.Pk,
+		&i.Id,
+		&i.Token,
+		&i.UserPk,
+		&i.SessionId,
+		&i.Device,
+		&i.Ip,
+		&i.Location,
+		&i.ReportedAt,
+		&i.PasswordResetAt,
+		&i.ExpireAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const setPasswordResetRequired = `-- name: SetPasswordResetRequired :exec
+update
+	keibi.users
+set
+	password_reset_required = $2
+where
+	pk = $1
+`
+
+type SetPasswordResetRequiredParams struct {
+	Pk                    int32 `json:"pk"`
+	PasswordResetRequired bool  `json:"passwordResetRequired"`
+}
+
+func (q *Queries) SetPasswordResetRequired(ctx context.Context, arg SetPasswordResetRequiredParams) error {
+	_, err := q.db.Exec(ctx, setPasswordResetRequired, arg.Pk, arg.PasswordResetRequired)
+	return err
+}
+
+const touchKnownDevice = `-- name: TouchKnownDevice :exec
+insert into keibi.known_devices(user_pk, device, network)
+	values ($1, $2, $3)
+on conflict (user_pk, device, network)
+	do update set
+		last_seen = now()::timestamptz
+`
+
+type TouchKnownDeviceParams struct {
+	UserPk  int32  `json:"userPk"`
+	Device  string `json:"device"`
+	Network string `json:"network"`
+}
+
+func (q *Queries) TouchKnownDevice(ctx context.Context, arg TouchKnownDeviceParams) error {
+	_, err := q.db.Exec(ctx, touchKnownDevice, arg.UserPk, arg.Device, arg.Network)
+	return err
+}
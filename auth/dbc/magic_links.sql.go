@@ -0,0 +1,272 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.31.1
+// source: magic_links.sql
+
+package dbc
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const consumeMagicLinkCallback = `-- name: ConsumeMagicLinkCallback :one
+update
+	keibi.magic_links
+set
+	callback_token = null
+where
+	callback_token = $1
+	-- the app exchanges it right after the redirect.
+	and used_at > now()::timestamptz - interval '5 min'
+returning
+	pk, id, token, email, user_pk, redirect_url, code, code_attempts, used_at, expire_at, created_at, callback_token
+`
+
+func (q *Queries) ConsumeMagicLinkCallback(ctx context.Context, callbackToken *string) (MagicLink, error) {
+	row := q.db.QueryRow(ctx, consumeMagicLinkCallback, callbackToken)
+	var i MagicLink
+	err := row.Scan(
+		&i.Pk,
+		&i.Id,
+		&i.Token,
+		&i.Email,
+		&i.UserPk,
+		&i.RedirectUrl,
+		&i.Code,
+		&i.CodeAttempts,
+		&i.UsedAt,
+		&i.ExpireAt,
+		&i.CreatedAt,
+		&i.CallbackToken,
+	)
+	return i, err
+}
+
+const consumeMagicLinkCode = `-- name: ConsumeMagicLinkCode :one
+update
+	keibi.magic_links
+set
+	code = null
+where
+	id = $1
+	and code = $2
+	and code_attempts < $3
+	and expire_at > now()::timestamptz
+returning
+	pk, id, token, email, user_pk, redirect_url, code, code_attempts, used_at, expire_at, created_at, callback_token
+`
+
+type ConsumeMagicLinkCodeParams struct {
+	Id          uuid.UUID `json:"id"`
+	Code        *string   `json:"code"`
+	MaxAttempts int32     `json:"maxAttempts"`
+}
+
+func (q *Queries) ConsumeMagicLinkCode(ctx context.Context, arg ConsumeMagicLinkCodeParams) (MagicLink, error) {
+	row := q.db.QueryRow(ctx, consumeMagicLinkCode, arg.Id, arg.Code, arg.MaxAttempts)
+	var i MagicLink
+	err := row.Scan(
+		&i.Pk,
+		&i.Id,
+		&i.Token,
+		&i.Email,
+		&i.UserPk,
+		&i.RedirectUrl,
+		&i.Code,
+		&i.CodeAttempts,
+		&i.UsedAt,
+		&i.ExpireAt,
+		&i.CreatedAt,
+		&i.CallbackToken,
+	)
+	return i, err
+}
+
+const countRecentMagicLinks = `-- name: CountRecentMagicLinks :one
+select
+	count(*)
+from
+	keibi.magic_links
+where
+	lower(email) = lower($1)
+	and created_at > now()::timestamptz - interval '1 hour'
+`
+
+func (q *Queries) CountRecentMagicLinks(ctx context.Context, lower string) (int64, error) {
+	row := q.db.QueryRow(ctx, countRecentMagicLinks, lower)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const createMagicLink = `-- name: CreateMagicLink :one
+insert into keibi.magic_links(token, email, user_pk, redirect_url, expire_at)
+	values ($1, $2, $3, $4, $5)
+returning
+	pk, id, token, email, user_pk, redirect_url, code, code_attempts, used_at, expire_at, created_at, callback_token
+`
+
+type CreateMagicLinkParams struct {
+	Token       string    `json:"token"`
+	Email       string    `json:"email"`
+	UserPk      *int32    `json:"userPk"`
+	RedirectUrl *string   `json:"redirectUrl"`
+	ExpireAt    time.Time `json:"expireAt"`
+}
+
+func (q *Queries) CreateMagicLink(ctx context.Context, arg CreateMagicLinkParams) (MagicLink, error) {
+	row := q.db.QueryRow(ctx, createMagicLink,
+		arg.Token,
+		arg.Email,
+		arg.UserPk,
+		arg.RedirectUrl,
+		arg.ExpireAt,
+	)
+	var i MagicLink
+	err := row.Scan(
+		&i.Pk,
+		&i.Id,
+		&i.Token,
+		&i.Email,
+		&i.UserPk,
+		&i.RedirectUrl,
+		&i.Code,
+		&i.CodeAttempts,
+		&i.UsedAt,
+		&i.ExpireAt,
+		&i.CreatedAt,
+		&i.CallbackToken,
+	)
+	return i, err
+}
+
+const deleteExpiredMagicLinks = `-- name: DeleteExpiredMagicLinks :exec
+delete from keibi.magic_links
+where created_at < now()::timestamptz - interval '1 day'
+`
+
+func (q *Queries) DeleteExpiredMagicLinks(ctx context.Context) error {
+	_, err := q.db.Exec(ctx, deleteExpiredMagicLinks)
+	return err
+}
+
+const failMagicLinkCode = `-- name: FailMagicLinkCode :exec
+update
+	keibi.magic_links
+set
+	code_attempts = code_attempts + 1
+where
+	id = $1
+`
+
+func (q *Queries) FailMagicLinkCode(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.Exec(ctx, failMagicLinkCode, id)
+	return err
+}
+
+const getActiveMagicLink = `-- name: GetActiveMagicLink :one
+select
+	pk, id, token, email, user_pk, redirect_url, code, code_attempts, used_at, expire_at, created_at, callback_token
+from
+	keibi.magic_links
+where
+	token = $1
+	and used_at is null
+	and user_pk is not null
+	and expire_at > now()::timestamptz
+`
+
+func (q *Queries) GetActiveMagicLink(ctx context.Context, token string) (MagicLink, error) {
+	row := q.db.QueryRow(ctx, getActiveMagicLink, token)
+	var i MagicLink
+	err := row.Scan(
+		&i.Pk,
+		&i.Id,
+		&i.Token,
+		&i.Email,
+		&i.UserPk,
+		&i.RedirectUrl,
+		&i.Code,
+		&i.CodeAttempts,
+		&i.UsedAt,
+		&i.ExpireAt,
+		&i.CreatedAt,
+		&i.CallbackToken,
+	)
+	return i, err
+}
+
+const setMagicLinkCallbackToken = `-- name: SetMagicLinkCallbackToken :exec
+update
+	keibi.magic_links
+set
+	callback_token = $2
+where
+	pk = $1
+`
+
+type SetMagicLinkCallbackTokenParams struct {
+	Pk            int32   `json:"pk"`
+	CallbackToken *string `json:"callbackToken"`
+}
+
+func (q *Queries) SetMagicLinkCallbackToken(ctx context.Context, arg SetMagicLinkCallbackTokenParams) error {
+	_, err := q.db.Exec(ctx, setMagicLinkCallbackToken, arg.Pk, arg.CallbackToken)
+	return err
+}
+
+const setMagicLinkCode = `-- name: SetMagicLinkCode :exec
+update
+	keibi.magic_links
+set
+	code = $2
+where
+	pk = $1
+`
+
+type SetMagicLinkCodeParams struct {
+	Pk   int32   `json:"pk"`
+	Code *string `json:"code"`
+}
+
+func (q *Queries) SetMagicLinkCode(ctx context.Context, arg SetMagicLinkCodeParams) error {
+	_, err := q.db.Exec(ctx, setMagicLinkCode, arg.Pk, arg.Code)
+	return err
+}
+
+const useMagicLink = `-- name: UseMagicLink :one
+update
+	keibi.magic_links
+set
+	used_at = now()::timestamptz
+where
+	token = $1
+	and used_at is null
+	and user_pk is not null
+	and expire_at > now()::timestamptz
+returning
+	pk, id, token, email, user_pk, redirect_url, code, code_attempts, used_at, expire_at, created_at, callback_token
+`
+
+func (q *Queries) UseMagicLink(ctx context.Context, token string) (MagicLink, error) {
+	row := q.db.QueryRow(ctx, useMagicLink, token)
+	var i MagicLink
+	err := row.Scan(
+		&i.Pk,
+		&i.Id,
+		&i.Token,
+		&i.Email,
+		&i.UserPk,
+		&i.RedirectUrl,
+		&i.Code,
+		&i.CodeAttempts,
+		&i.UsedAt,
+		&i.ExpireAt,
+		&i.CreatedAt,
+		&i.CallbackToken,
+	)
+	return i, err
+}
@@ -5,12 +5,58 @@
 package dbc
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
 	"time"
 
 	jwt "github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
 
+type WebhookStatus string
+
+const (
+	KeibiWebhookStatusPending   WebhookStatus = "pending"
+	KeibiWebhookStatusDelivered WebhookStatus = "delivered"
+	KeibiWebhookStatusFailed    WebhookStatus = "failed"
+)
+
+func (e *WebhookStatus) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = WebhookStatus(s)
+	case string:
+		*e = WebhookStatus(s)
+	default:
+		return fmt.Errorf("unsupported scan type for WebhookStatus: %T", src)
+	}
+	return nil
+}
+
+type NullWebhookStatus struct {
+	WebhookStatus WebhookStatus `json:"keibiWebhookStatus"`
+	Valid         bool          `json:"valid"` // Valid is true if WebhookStatus is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullWebhookStatus) Scan(value interface{}) error {
+	if value == nil {
+		ns.WebhookStatus, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.WebhookStatus.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullWebhookStatus) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.WebhookStatus), nil
+}
+
 type Apikey struct {
 	Pk        int32         `json:"pk"`
 	Id        uuid.UUID     `json:"id"`
@@ -20,6 +66,69 @@ type Apikey struct {
 	CreatedBy *int32        `json:"createdBy"`
 	CreatedAt time.Time     `json:"createdAt"`
 	LastUsed  time.Time     `json:"lastUsed"`
+	Personal  bool          `json:"personal"`
+}
+
+type AuditLog struct {
+	Pk        int32       `json:"pk"`
+	Id        uuid.UUID   `json:"id"`
+	UserPk    int32       `json:"userPk"`
+	Action    string      `json:"action"`
+	Details   interface{} `json:"details"`
+	CreatedAt time.Time   `json:"createdAt"`
+}
+
+type KeibiChallenge struct {
+	Pk         int32     `json:"pk"`
+	Id         uuid.UUID `json:"id"`
+	Nonce      string    `json:"nonce"`
+	Difficulty int32     `json:"difficulty"`
+	ExpireAt   time.Time `json:"expireAt"`
+}
+
+type KeibiKnownDevice struct {
+	Pk        int32     `json:"pk"`
+	UserPk    int32     `json:"userPk"`
+	Device    string    `json:"device"`
+	Network   string    `json:"network"`
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+}
+
+type KeibiLoginAlert struct {
+	Pk              int32      `json:"pk"`
+	Id              uuid.UUID  `json:"id"`
+	Token           string     `json:"token"`
+	UserPk          int32      `json:"userPk"`
+	SessionId       uuid.UUID  `json:"sessionId"`
+	Device          *string    `json:"device"`
+	Ip              *string    `json:"ip"`
+	Location        *string    `json:"location"`
+	ReportedAt      *time.Time `json:"reportedAt"`
+	PasswordResetAt *time.Time `json:"passwordResetAt"`
+	ExpireAt        time.Time  `json:"expireAt"`
+	CreatedAt       time.Time  `json:"createdAt"`
+}
+
+type KeibiSpentSessionToken struct {
+	Token     string    `json:"token"`
+	SessionPk int32     `json:"sessionPk"`
+	SpentAt   time.Time `json:"spentAt"`
+}
+
+type MagicLink struct {
+	Pk            int32      `json:"pk"`
+	Id            uuid.UUID  `json:"id"`
+	Token         string     `json:"token"`
+	Email         string     `json:"email"`
+	UserPk        *int32     `json:"userPk"`
+	RedirectUrl   *string    `json:"redirectUrl"`
+	Code          *string    `json:"code"`
+	CodeAttempts  int32      `json:"codeAttempts"`
+	UsedAt        *time.Time `json:"usedAt"`
+	ExpireAt      time.Time  `json:"expireAt"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	CallbackToken *string    `json:"callbackToken"`
 }
 
 type OidcHandle struct {
@@ -42,25 +151,100 @@ type OidcLogin struct {
 	Tenant      string    `json:"tenant"`
 	Code        *string   `json:"code"`
 	CreatedAt   time.Time `json:"createdAt"`
+	RequestId   *string   `json:"requestId"`
+	Profile     []byte    `json:"profile"`
+}
+
+type OidcProvider struct {
+	Pk               int32     `json:"pk"`
+	Id               string    `json:"id"`
+	Name             string    `json:"name"`
+	Logo             *string   `json:"logo"`
+	ClientId         string    `json:"clientId"`
+	Secret           string    `json:"secret"`
+	AuthorizationUrl string    `json:"authorizationUrl"`
+	TokenUrl         string    `json:"tokenUrl"`
+	ProfileUrl       string    `json:"profileUrl"`
+	Scope            string    `json:"scope"`
+	AuthMethod       string    `json:"authMethod"`
+	Enabled          bool      `json:"enabled"`
+	CreatedAt        time.Time `json:"createdAt"`
+	UpdatedAt        time.Time `json:"updatedAt"`
+	LinkByEmail      bool      `json:"linkByEmail"`
+	Issuer           *string   `json:"issuer"`
+	JwksUrl          *string   `json:"jwksUrl"`
+	EndSessionUrl    *string   `json:"endSessionUrl"`
+}
+
+type Presign struct {
+	Pk            int32           `json:"pk"`
+	Id            uuid.UUID       `json:"id"`
+	Sub           uuid.UUID       `json:"sub"`
+	Rules         json.RawMessage `json:"rules"`
+	RemainingUses *int32          `json:"remainingUses"`
+	CreatedAt     time.Time       `json:"createdAt"`
+	ExpireAt      time.Time       `json:"expireAt"`
 }
 
 type Session struct {
-	Pk          int32     `json:"pk"`
-	Id          uuid.UUID `json:"id"`
-	Token       string    `json:"token"`
-	UserPk      int32     `json:"userPk"`
-	CreatedDate time.Time `json:"createdDate"`
-	LastUsed    time.Time `json:"lastUsed"`
-	Device      *string   `json:"device"`
+	Pk                   int32      `json:"pk"`
+	Id                   uuid.UUID  `json:"id"`
+	Token                string     `json:"token"`
+	UserPk               int32      `json:"userPk"`
+	CreatedDate          time.Time  `json:"createdDate"`
+	LastUsed             time.Time  `json:"lastUsed"`
+	Device               *string    `json:"device"`
+	Rotate               bool       `json:"rotate"`
+	ImpersonatorId       *uuid.UUID `json:"impersonatorId"`
+	ImpersonatorUsername *string    `json:"impersonatorUsername"`
+	OidcProvider         *string    `json:"oidcProvider"`
+	OidcSub              *string    `json:"oidcSub"`
+	OidcSid              *string    `json:"oidcSid"`
+	OidcIdToken          *string    `json:"oidcIdToken"`
 }
 
 type User struct {
-	Pk          int32         `json:"pk"`
-	Id          uuid.UUID     `json:"id"`
-	Username    string        `json:"username"`
-	Email       string        `json:"email"`
-	Password    *string       `json:"password"`
-	Claims      jwt.MapClaims `json:"claims"`
-	CreatedDate time.Time     `json:"createdDate"`
-	LastSeen    time.Time     `json:"lastSeen"`
+	Pk                    int32         `json:"pk"`
+	Id                    uuid.UUID     `json:"id"`
+	Username              string        `json:"username"`
+	Email                 string        `json:"email"`
+	Password              *string       `json:"password"`
+	Claims                jwt.MapClaims `json:"claims"`
+	CreatedDate           time.Time     `json:"createdDate"`
+	LastSeen              time.Time     `json:"lastSeen"`
+	DeleteAt              *time.Time    `json:"deleteAt"`
+	FailedLogins          int32         `json:"failedLogins"`
+	PasswordResetRequired bool          `json:"passwordResetRequired"`
+}
+
+type UserSetting struct {
+	UserPk    int32           `json:"userPk"`
+	SessionPk *int32          `json:"sessionPk"`
+	Settings  json.RawMessage `json:"settings"`
+	UpdatedAt time.Time       `json:"updatedAt"`
+}
+
+type Webhook struct {
+	Pk        int32     `json:"pk"`
+	Id        uuid.UUID `json:"id"`
+	Url       string    `json:"url"`
+	Secret    string    `json:"secret"`
+	Events    []string  `json:"events"`
+	Enabled   bool      `json:"enabled"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type WebhookDelivery struct {
+	Pk             int32           `json:"pk"`
+	Id             uuid.UUID       `json:"id"`
+	WebhookPk      int32           `json:"webhookPk"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         WebhookStatus   `json:"status"`
+	Attempts       int32           `json:"attempts"`
+	NextAttemptAt  time.Time       `json:"nextAttemptAt"`
+	LastStatusCode *int32          `json:"lastStatusCode"`
+	LastError      *string         `json:"lastError"`
+	CreatedAt      time.Time       `json:"createdAt"`
+	DeliveredAt    *time.Time      `json:"deliveredAt"`
 }
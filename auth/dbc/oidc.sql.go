@@ -29,7 +29,7 @@ where
 	and tenant = $3
 	and created_at + interval '10 min' > now()::timestamptz
 returning
-	pk, id, opaque, provider, redirect_url, tenant, code, created_at
+	pk, id, opaque, provider, redirect_url, tenant, code, created_at, request_id, profile
 `
 
 type ConsumeOidcLoginParams struct {
@@ -50,6 +50,8 @@ func (q *Queries) ConsumeOidcLogin(ctx context.Context, arg ConsumeOidcLoginPara
 		&i.Tenant,
 		&i.Code,
 		&i.CreatedAt,
+		&i.RequestId,
+		&i.Profile,
 	)
 	return i, err
 }
@@ -58,7 +60,7 @@ const createOidcLogin = `-- name: CreateOidcLogin :one
 insert into keibi.oidc_login(provider, opaque, redirect_url, tenant)
 	values ($1, $2, $3, $4)
 returning
-	pk, id, opaque, provider, redirect_url, tenant, code, created_at
+	pk, id, opaque, provider, redirect_url, tenant, code, created_at, request_id, profile
 `
 
 type CreateOidcLoginParams struct {
@@ -85,6 +87,115 @@ func (q *Queries) CreateOidcLogin(ctx context.Context, arg CreateOidcLoginParams
 		&i.Tenant,
 		&i.Code,
 		&i.CreatedAt,
+		&i.RequestId,
+		&i.Profile,
+	)
+	return i, err
+}
+
+const createOidcProvider = `-- name: CreateOidcProvider :one
+insert into keibi.oidc_providers(id, name, logo, client_id, secret, authorization_url, token_url,
+	profile_url, scope, auth_method, enabled, link_by_email, issuer, jwks_url, end_session_url)
+	values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+returning
+	pk, id, name, logo, client_id, secret, authorization_url, token_url, profile_url, scope, auth_method, enabled, created_at, updated_at, link_by_email, issuer, jwks_url, end_session_url
+`
+
+type CreateOidcProviderParams struct {
+	Id               string  `json:"id"`
+	Name             string  `json:"name"`
+	Logo             *string `json:"logo"`
+	ClientId         string  `json:"clientId"`
+	Secret           string  `json:"secret"`
+	AuthorizationUrl string  `json:"authorizationUrl"`
+	TokenUrl         string  `json:"tokenUrl"`
+	ProfileUrl       string  `json:"profileUrl"`
+	Scope            string  `json:"scope"`
+	AuthMethod       string  `json:"authMethod"`
+	Enabled          bool    `json:"enabled"`
+	LinkByEmail      bool    `json:"linkByEmail"`
+	Issuer           *string `json:"issuer"`
+	JwksUrl          *string `json:"jwksUrl"`
+	EndSessionUrl    *string `json:"endSessionUrl"`
+}
+
+func (q *Queries) CreateOidcProvider(ctx context.Context, arg CreateOidcProviderParams) (OidcProvider, error) {
+	row := q.db.QueryRow(ctx, createOidcProvider,
+		arg.Id,
+		arg.Name,
+		arg.Logo,
+		arg.ClientId,
+		arg.Secret,
+		arg.AuthorizationUrl,
+		arg.TokenUrl,
+		arg.ProfileUrl,
+		arg.Scope,
+		arg.AuthMethod,
+		arg.Enabled,
+		arg.LinkByEmail,
+		arg.Issuer,
+		arg.JwksUrl,
+		arg.EndSessionUrl,
+	)
+	var i OidcProvider
+	err := row.Scan(
+		&i.Pk,
+		&i.Id,
+		&i.Name,
+		&i.Logo,
+		&i.ClientId,
+		&i.Secret,
+		&i.AuthorizationUrl,
+		&i.TokenUrl,
+		&i.ProfileUrl,
+		&i.Scope,
+		&i.AuthMethod,
+		&i.Enabled,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.LinkByEmail,
+		&i.Issuer,
+		&i.JwksUrl,
+		&i.EndSessionUrl,
+	)
+	return i, err
+}
+
+const createSamlLogin = `-- name: CreateSamlLogin :one
+insert into keibi.oidc_login(provider, opaque, redirect_url, tenant, request_id)
+	values ($1, $2, $3, $4, $5)
+returning
+	pk, id, opaque, provider, redirect_url, tenant, code, created_at, request_id, profile
+`
+
+type CreateSamlLoginParams struct {
+	Provider    string  `json:"provider"`
+	Opaque      string  `json:"opaque"`
+	RedirectUrl string  `json:"redirectUrl"`
+	Tenant      string  `json:"tenant"`
+	RequestId   *string `json:"requestId"`
+}
+
+func (q *Queries) CreateSamlLogin(ctx context.Context, arg CreateSamlLoginParams) (OidcLogin, error) {
+	row := q.db.QueryRow(ctx, createSamlLogin,
+		arg.Provider,
+		arg.Opaque,
+		arg.RedirectUrl,
+		arg.Tenant,
+		arg.RequestId,
+	)
+	var i OidcLogin
+	err := row.Scan(
+		&i.Pk,
+		&i.Id,
+		&i.Opaque,
+		&i.Provider,
+		&i.RedirectUrl,
+		&i.Tenant,
+		&i.Code,
+		&i.CreatedAt,
+		&i.RequestId,
+		&i.Profile,
 	)
 	return i, err
 }
@@ -100,9 +211,42 @@ func (q *Queries) DeleteOidcLoginById(ctx context.Context, id uuid.UUID) error {
 	return err
 }
 
+const deleteOidcProvider = `-- name: DeleteOidcProvider :one
+delete from keibi.oidc_providers
+where id = $1
+returning
+	pk, id, name, logo, client_id, secret, authorization_url, token_url, profile_url, scope, auth_method, enabled, created_at, updated_at, link_by_email, issuer, jwks_url, end_session_url
+`
+
+func (q *Queries) DeleteOidcProvider(ctx context.Context, id string) (OidcProvider, error) {
+	row := q.db.QueryRow(ctx, deleteOidcProvider, id)
+	var i OidcProvider
+	err := row.Scan(
+		&i.Pk,
+		&i.Id,
+		&i.Name,
+		&i.Logo,
+		&i.ClientId,
+		&i.Secret,
+		&i.AuthorizationUrl,
+		&i.TokenUrl,
+		&i.ProfileUrl,
+		&i.Scope,
+		&i.AuthMethod,
+		&i.Enabled,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.LinkByEmail,
+		&i.Issuer,
+		&i.JwksUrl,
+		&i.EndSessionUrl,
+	)
+	return i, err
+}
+
 const getOidcLoginByOpaque = `-- name: GetOidcLoginByOpaque :one
 select
-	pk, id, opaque, provider, redirect_url, tenant, code, created_at
+	pk, id, opaque, provider, redirect_url, tenant, code, created_at, request_id, profile
 from
 	keibi.oidc_login
 where
@@ -128,10 +272,100 @@ func (q *Queries) GetOidcLoginByOpaque(ctx context.Context, arg GetOidcLoginByOp
 		&i.Tenant,
 		&i.Code,
 		&i.CreatedAt,
+		&i.RequestId,
+		&i.Profile,
 	)
 	return i, err
 }
 
+const getUserOidcHandles = `-- name: GetUserOidcHandles :many
+select
+	user_pk, provider, id, username, profile_url, access_token, refresh_token, expire_at
+from
+	keibi.oidc_handle
+where
+	user_pk = $1
+order by
+	provider
+`
+
+func (q *Queries) GetUserOidcHandles(ctx context.Context, userPk int32) ([]OidcHandle, error) {
+	rows, err := q.db.Query(ctx, getUserOidcHandles, userPk)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []OidcHandle
+	for rows.Next() {
+		var i OidcHandle
+		if err := rows.Scan(
+			&i.UserPk,
+			&i.Provider,
+			&i.Id,
+			&i.Username,
+			&i.ProfileUrl,
+			&i.AccessToken,
+			&i.RefreshToken,
+			&i.ExpireAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listOidcProviders = `-- name: ListOidcProviders :many
+select
+	pk, id, name, logo, client_id, secret, authorization_url, token_url, profile_url, scope, auth_method, enabled, created_at, updated_at, link_by_email, issuer, jwks_url, end_session_url
+from
+	keibi.oidc_providers
+order by
+	id
+`
+
+func (q *Queries) ListOidcProviders(ctx context.Context) ([]OidcProvider, error) {
+	rows, err := q.db.Query(ctx, listOidcProviders)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []OidcProvider
+	for rows.Next() {
+		var i OidcProvider
+		if err := rows.Scan(
+			&i.Pk,
+			&i.Id,
+			&i.Name,
+			&i.Logo,
+			&i.ClientId,
+			&i.Secret,
+			&i.AuthorizationUrl,
+			&i.TokenUrl,
+			&i.ProfileUrl,
+			&i.Scope,
+			&i.AuthMethod,
+			&i.Enabled,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.LinkByEmail,
+			&i.Issuer,
+			&i.JwksUrl,
+			&i.EndSessionUrl,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const saveOidcLoginCode = `-- name: SaveOidcLoginCode :exec
 update
 	keibi.oidc_login
@@ -150,3 +384,107 @@ func (q *Queries) SaveOidcLoginCode(ctx context.Context, arg SaveOidcLoginCodePa
 	_, err := q.db.Exec(ctx, saveOidcLoginCode, arg.Id, arg.Code)
 	return err
 }
+
+const saveSamlLoginProfile = `-- name: SaveSamlLoginProfile :exec
+update
+	keibi.oidc_login
+set
+	profile = $2
+where
+	id = $1
+`
+
+type SaveSamlLoginProfileParams struct {
+	Id      uuid.UUID `json:"id"`
+	Profile []byte    `json:"profile"`
+}
+
+func (q *Queries) SaveSamlLoginProfile(ctx context.Context, arg SaveSamlLoginProfileParams) error {
+	_, err := q.db.Exec(ctx, saveSamlLoginProfile, arg.Id, arg.Profile)
+	return err
+}
+
+const updateOidcProvider = `-- name: UpdateOidcProvider :one
+update
+	keibi.oidc_providers
+set
+	name = coalesce($2, name),
+	logo = coalesce($3, logo),
+	client_id = coalesce($4, client_id),
+	secret = coalesce($5, secret),
+	authorization_url = coalesce($6, authorization_url),
+	token_url = coalesce($7, token_url),
+	profile_url = coalesce($8, profile_url),
+	scope = coalesce($9, scope),
+	auth_method = coalesce($10, auth_method),
+	enabled = coalesce($11, enabled),
+	link_by_email = coalesce($12, link_by_email),
+	issuer = coalesce($13, issuer),
+	jwks_url = coalesce($14, jwks_url),
+	end_session_url = coalesce($15, end_session_url),
+	updated_at = now()::timestamptz
+where
+	id = $1
+returning
+	pk, id, name, logo, client_id, secret, authorization_url, token_url, profile_url, scope, auth_method, enabled, created_at, updated_at, link_by_email, issuer, jwks_url, end_session_url
+`
+
+type UpdateOidcProviderParams struct {
+	Id               string  `json:"id"`
+	Name             *string `json:"name"`
+	Logo             *string `json:"logo"`
+	ClientId         *string `json:"clientId"`
+	Secret           *string `json:"secret"`
+	AuthorizationUrl *string `json:"authorizationUrl"`
+	TokenUrl         *string `json:"tokenUrl"`
+	ProfileUrl       *string `json:"profileUrl"`
+	Scope            *string `json:"scope"`
+	AuthMethod       *string `json:"authMethod"`
+	Enabled          *bool   `json:"enabled"`
+	LinkByEmail      *bool   `json:"linkByEmail"`
+	Issuer           *string `json:"issuer"`
+	JwksUrl          *string `json:"jwksUrl"`
+	EndSessionUrl    *string `json:"endSessionUrl"`
+}
+
+func (q *Queries) UpdateOidcProvider(ctx context.Context, arg UpdateOidcProviderParams) (OidcProvider, error) {
+	row := q.db.QueryRow(ctx, updateOidcProvider,
+		arg.Id,
+		arg.Name,
+		arg.Logo,
+		arg.ClientId,
+		arg.Secret,
+		arg.AuthorizationUrl,
+		arg.TokenUrl,
+		arg.ProfileUrl,
+		arg.Scope,
+		arg.AuthMethod,
+		arg.Enabled,
+		arg.LinkByEmail,
+		arg.Issuer,
+		arg.JwksUrl,
+		arg.EndSessionUrl,
+	)
+	var i OidcProvider
+	err := row.Scan(
+		&i.Pk,
+		&i.Id,
+		&i.Name,
+		&i.Logo,
+		&i.ClientId,
+		&i.Secret,
+		&i.AuthorizationUrl,
+		&i.TokenUrl,
+		&i.ProfileUrl,
+		&i.Scope,
+		&i.AuthMethod,
+		&i.Enabled,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.LinkByEmail,
+		&i.Issuer,
+		&i.JwksUrl,
+		&i.EndSessionUrl,
+	)
+	return i, err
+}
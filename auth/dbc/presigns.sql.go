@@ -0,0 +1,158 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.31.1
+// source: presigns.sql
+
+package dbc
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const cleanupPresigns = `-- name: CleanupPresigns :exec
+delete from keibi.presigns
+where expire_at < now()::timestamptz
+	or remaining_uses = 0
+`
+
+func (q *Queries) CleanupPresigns(ctx context.Context) error {
+	_, err := q.db.Exec(ctx, cleanupPresigns)
+	return err
+}
+
+const createPresign = `-- name: CreatePresign :one
+insert into keibi.presigns(sub, rules, remaining_uses, expire_at)
+	values ($1, $2, $3, $4)
+returning
+	pk, id, sub, rules, remaining_uses, created_at, expire_at
+`
+
+type CreatePresignParams struct {
+	Sub           uuid.UUID       `json:"sub"`
+	Rules         json.RawMessage `json:"rules"`
+	RemainingUses *int32          `json:"remainingUses"`
+	ExpireAt      time.Time       `json:"expireAt"`
+}
+
+func (q *Queries) CreatePresign(ctx context.Context, arg CreatePresignParams) (Presign, error) {
+	row := q.db.QueryRow(ctx, createPresign,
+		arg.Sub,
+		arg.Rules,
+		arg.RemainingUses,
+		arg.ExpireAt,
+	)
+	var i Presign
+	err := row.Scan(
+		&i.Pk,
+		&i.Id,
+		&i.Sub,
+		&i.Rules,
+		&i.RemainingUses,
+		&i.CreatedAt,
+		&i.ExpireAt,
+	)
+	return i, err
+}
+
+const deletePresign = `-- name: DeletePresign :one
+delete from keibi.presigns
+where id = $1
+	and sub = $2
+returning
+	pk, id, sub, rules, remaining_uses, created_at, expire_at
+`
+
+type DeletePresignParams struct {
+	Id  uuid.UUID `json:"id"`
+	Sub uuid.UUID `json:"sub"`
+}
+
+func (q *Queries) DeletePresign(ctx context.Context, arg DeletePresignParams) (Presign, error) {
+	row := q.db.QueryRow(ctx, deletePresign, arg.Id, arg.Sub)
+	var i Presign
+	err := row.Scan(
+		&i.Pk,
+		&i.Id,
+		&i.Sub,
+		&i.Rules,
+		&i.RemainingUses,
+		&i.CreatedAt,
+		&i.ExpireAt,
+	)
+	return i, err
+}
+
+const listActivePresigns = `-- name: ListActivePresigns :many
+select
+	pk, id, sub, rules, remaining_uses, created_at, expire_at
+from
+	keibi.presigns
+where
+	sub = $1
+	and expire_at > now()::timestamptz
+	and (remaining_uses is null
+		or remaining_uses > 0)
+order by
+	created_at desc
+`
+
+func (q *Queries) ListActivePresigns(ctx context.Context, sub uuid.UUID) ([]Presign, error) {
+	rows, err := q.db.Query(ctx, listActivePresigns, sub)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Presign
+	for rows.Next() {
+		var i Presign
+		if err := rows.Scan(
+			&i.Pk,
+			&i.Id,
+			&i.Sub,
+			&i.Rules,
+			&i.RemainingUses,
+			&i.CreatedAt,
+			&i.ExpireAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const usePresign = `-- name: UsePresign :one
+update
+	keibi.presigns
+set
+	remaining_uses = remaining_uses - 1
+where
+	id = $1
+	and expire_at > now()::timestamptz
+	and (remaining_uses is null
+		or remaining_uses > 0)
+returning
+	pk, id, sub, rules, remaining_uses, created_at, expire_at
+`
+
+func (q *Queries) UsePresign(ctx context.Context, id uuid.UUID) (Presign, error) {
+	row := q.db.QueryRow(ctx, usePresign, id)
+	var i Presign
+	err := row.Scan(
+		&i.Pk,
+		&i.Id,
+		&i.Sub,
+		&i.Rules,
+		&i.RemainingUses,
+		&i.CreatedAt,
+		&i.ExpireAt,
+	)
+	return i, err
+}
@@ -12,11 +12,13 @@ import (
 	"github.com/google/uuid"
 )
 
-const clearOtherSessions = `-- name: ClearOtherSessions :exec
+const clearOtherSessions = `-- name: ClearOtherSessions :many
 delete from keibi.sessions as s using keibi.users as u
 where s.user_pk = u.pk
 	and s.id != $1
 	and u.id = $2
+returning
+	s.pk, s.id, s.token, s.user_pk, s.created_date, s.last_used, s.device, s.rotate, s.impersonator_id, s.impersonator_username, s.oidc_provider, s.oidc_sub, s.oidc_sid, s.oidc_id_token
 `
 
 type ClearOtherSessionsParams struct {
@@ -24,26 +26,147 @@ type ClearOtherSessionsParams struct {
 	UserId    uuid.UUID `json:"userId"`
 }
 
-func (q *Queries) ClearOtherSessions(ctx context.Context, arg ClearOtherSessionsParams) error {
-	_, err := q.db.Exec(ctx, clearOtherSessions, arg.SessionId, arg.UserId)
-	return err
+func (q *Queries) ClearOtherSessions(ctx context.Context, arg ClearOtherSessionsParams) ([]Session, error) {
+	rows, err := q.db.Query(ctx, clearOtherSessions, arg.SessionId, arg.UserId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Session
+	for rows.Next() {
+		var i Session
+		if err := rows.Scan(
+			&i.Pk,
+			&i.Id,
+			&i.Token,
+			&i.UserPk,
+			&i.CreatedDate,
+			&i.LastUsed,
+			&i.Device,
+			&i.Rotate,
+			&i.ImpersonatorId,
+			&i.ImpersonatorUsername,
+			&i.OidcProvider,
+			&i.OidcSub,
+			&i.OidcSid,
+			&i.OidcIdToken,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const clearUserSessions = `-- name: ClearUserSessions :many
+delete from keibi.sessions
+where user_pk = $1
+returning
+	pk, id, token, user_pk, created_date, last_used, device, rotate, impersonator_id, impersonator_username, oidc_provider, oidc_sub, oidc_sid, oidc_id_token
+`
+
+func (q *Queries) ClearUserSessions(ctx context.Context, userPk int32) ([]Session, error) {
+	rows, err := q.db.Query(ctx, clearUserSessions, userPk)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Session
+	for rows.Next() {
+		var i Session
+		if err := rows.Scan(
+			&i.Pk,
+			&i.Id,
+			&i.Token,
+			&i.UserPk,
+			&i.CreatedDate,
+			&i.LastUsed,
+			&i.Device,
+			&i.Rotate,
+			&i.ImpersonatorId,
+			&i.ImpersonatorUsername,
+			&i.OidcProvider,
+			&i.OidcSub,
+			&i.OidcSid,
+			&i.OidcIdToken,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const createImpersonationSession = `-- name: CreateImpersonationSession :one
+insert into keibi.sessions(token, user_pk, device, impersonator_id, impersonator_username)
+	values ($1, $2, $3, $4, $5)
+returning
+	pk, id, token, user_pk, created_date, last_used, device, rotate, impersonator_id, impersonator_username, oidc_provider, oidc_sub, oidc_sid, oidc_id_token
+`
+
+type CreateImpersonationSessionParams struct {
+	Token                string     `json:"token"`
+	UserPk               int32      `json:"userPk"`
+	Device               *string    `json:"device"`
+	ImpersonatorId       *uuid.UUID `json:"impersonatorId"`
+	ImpersonatorUsername *string    `json:"impersonatorUsername"`
+}
+
+func (q *Queries) CreateImpersonationSession(ctx context.Context, arg CreateImpersonationSessionParams) (Session, error) {
+	row := q.db.QueryRow(ctx, createImpersonationSession,
+		arg.Token,
+		arg.UserPk,
+		arg.Device,
+		arg.ImpersonatorId,
+		arg.ImpersonatorUsername,
+	)
+	var i Session
+	err := row.Scan(
+		&i.Pk,
+		&i.Id,
+		&i.Token,
+		&i.UserPk,
+		&i.CreatedDate,
+		&i.LastUsed,
+		&i.Device,
+		&i.Rotate,
+		&i.ImpersonatorId,
+		&i.ImpersonatorUsername,
+		&i.OidcProvider,
+		&i.OidcSub,
+		&i.OidcSid,
+		&i.OidcIdToken,
+	)
+	return i, err
 }
 
 const createSession = `-- name: CreateSession :one
-insert into keibi.sessions(token, user_pk, device)
-	values ($1, $2, $3)
+insert into keibi.sessions(token, user_pk, device, rotate)
+	values ($1, $2, $3, $4)
 returning
-	pk, id, token, user_pk, created_date, last_used, device
+	pk, id, token, user_pk, created_date, last_used, device, rotate, impersonator_id, impersonator_username, oidc_provider, oidc_sub, oidc_sid, oidc_id_token
 `
 
 type CreateSessionParams struct {
 	Token  string  `json:"token"`
 	UserPk int32   `json:"userPk"`
 	Device *string `json:"device"`
+	Rotate bool    `json:"rotate"`
 }
 
 func (q *Queries) CreateSession(ctx context.Context, arg CreateSessionParams) (Session, error) {
-	row := q.db.QueryRow(ctx, createSession, arg.Token, arg.UserPk, arg.Device)
+	row := q.db.QueryRow(ctx, createSession,
+		arg.Token,
+		arg.UserPk,
+		arg.Device,
+		arg.Rotate,
+	)
 	var i Session
 	err := row.Scan(
 		&i.Pk,
@@ -53,17 +176,34 @@ func (q *Queries) CreateSession(ctx context.Context, arg CreateSessionParams) (S
 		&i.CreatedDate,
 		&i.LastUsed,
 		&i.Device,
+		&i.Rotate,
+		&i.ImpersonatorId,
+		&i.ImpersonatorUsername,
+		&i.OidcProvider,
+		&i.OidcSub,
+		&i.OidcSid,
+		&i.OidcIdToken,
 	)
 	return i, err
 }
 
+const deleteOldSpentSessionTokens = `-- name: DeleteOldSpentSessionTokens :exec
+delete from keibi.spent_session_tokens
+where spent_at < $1
+`
+
+func (q *Queries) DeleteOldSpentSessionTokens(ctx context.Context, spentAt time.Time) error {
+	_, err := q.db.Exec(ctx, deleteOldSpentSessionTokens, spentAt)
+	return err
+}
+
 const deleteSession = `-- name: DeleteSession :one
 delete from keibi.sessions as s using keibi.users as u
 where s.user_pk = u.pk
 	and s.id = $1
 	and u.id = $2
 returning
-	s.pk, s.id, s.token, s.user_pk, s.created_date, s.last_used, s.device
+	s.pk, s.id, s.token, s.user_pk, s.created_date, s.last_used, s.device, s.rotate, s.impersonator_id, s.impersonator_username, s.oidc_provider, s.oidc_sub, s.oidc_sid, s.oidc_id_token
 `
 
 type DeleteSessionParams struct {
@@ -82,6 +222,170 @@ func (q *Queries) DeleteSession(ctx context.Context, arg DeleteSessionParams) (S
 		&i.CreatedDate,
 		&i.LastUsed,
 		&i.Device,
+		&i.Rotate,
+		&i.ImpersonatorId,
+		&i.ImpersonatorUsername,
+		&i.OidcProvider,
+		&i.OidcSub,
+		&i.OidcSid,
+		&i.OidcIdToken,
+	)
+	return i, err
+}
+
+const deleteSessionByPk = `-- name: DeleteSessionByPk :one
+delete from keibi.sessions
+where pk = $1
+returning
+	pk, id, token, user_pk, created_date, last_used, device, rotate, impersonator_id, impersonator_username, oidc_provider, oidc_sub, oidc_sid, oidc_id_token
+`
+
+func (q *Queries) DeleteSessionByPk(ctx context.Context, pk int32) (Session, error) {
+	row := q.db.QueryRow(ctx, deleteSessionByPk, pk)
+	var i Session
+	err := row.Scan(
+		&i.Pk,
+		&i.Id,
+		&i.Token,
+		&i.UserPk,
+		&i.CreatedDate,
+		&i.LastUsed,
+		&i.Device,
+		&i.Rotate,
+		&i.ImpersonatorId,
+		&i.ImpersonatorUsername,
+		&i.OidcProvider,
+		&i.OidcSub,
+		&i.OidcSid,
+		&i.OidcIdToken,
+	)
+	return i, err
+}
+
+const deleteSessionByToken = `-- name: DeleteSessionByToken :one
+delete from keibi.sessions
+where token = $1
+returning
+	pk, id, token, user_pk, created_date, last_used, device, rotate, impersonator_id, impersonator_username, oidc_provider, oidc_sub, oidc_sid, oidc_id_token
+`
+
+func (q *Queries) DeleteSessionByToken(ctx context.Context, token string) (Session, error) {
+	row := q.db.QueryRow(ctx, deleteSessionByToken, token)
+	var i Session
+	err := row.Scan(
+		&i.Pk,
+		&i.Id,
+		&i.Token,
+		&i.UserPk,
+		&i.CreatedDate,
+		&i.LastUsed,
+		&i.Device,
+		&i.Rotate,
+		&i.ImpersonatorId,
+		&i.ImpersonatorUsername,
+		&i.OidcProvider,
+		&i.OidcSub,
+		&i.OidcSid,
+		&i.OidcIdToken,
+	)
+	return i, err
+}
+
+const getOidcSessions = `-- name: GetOidcSessions :many
+select
+	s.pk, s.id, s.token, s.user_pk, s.created_date, s.last_used, s.device, s.rotate, s.impersonator_id, s.impersonator_username, s.oidc_provider, s.oidc_sub, s.oidc_sid, s.oidc_id_token,
+	u.id as user_id
+from
+	keibi.sessions as s
+	inner join keibi.users as u on u.pk = s.user_pk
+where
+	s.oidc_provider = $1::text
+	and ($2::text is null
+		or s.oidc_sub = $2)
+	and ($3::text is null
+		or s.oidc_sid = $3)
+`
+
+type GetOidcSessionsParams struct {
+	Provider string  `json:"provider"`
+	Sub      *string `json:"sub"`
+	Sid      *string `json:"sid"`
+}
+
+type GetOidcSessionsRow struct {
+	Session Session   `json:"session"`
+	UserId  uuid.UUID `json:"userId"`
+}
+
+func (q *Queries) GetOidcSessions(ctx context.Context, arg GetOidcSessionsParams) ([]GetOidcSessionsRow, error) {
+	rows, err := q.db.Query(ctx, getOidcSessions, arg.Provider, arg.Sub, arg.Sid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetOidcSessionsRow
+	for rows.Next() {
+		var i GetOidcSessionsRow
+		if err := rows.Scan(
+			&i.Session.Pk,
+			&i.Session.Id,
+			&i.Session.Token,
+			&i.Session.UserPk,
+			&i.Session.CreatedDate,
+			&i.Session.LastUsed,
+			&i.Session.Device,
+			&i.Session.Rotate,
+			&i.Session.ImpersonatorId,
+			&i.Session.ImpersonatorUsername,
+			&i.Session.OidcProvider,
+			&i.Session.OidcSub,
+			&i.Session.OidcSid,
+			&i.Session.OidcIdToken,
+			&i.UserId,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getSessionFromSpentToken = `-- name: GetSessionFromSpentToken :one
+select
+	s.pk,
+	s.id,
+	s.user_pk,
+	u.id as user_id,
+	st.spent_at
+from
+	keibi.spent_session_tokens as st
+	inner join keibi.sessions as s on s.pk = st.session_pk
+	inner join keibi.users as u on u.pk = s.user_pk
+where
+	st.token = $1
+limit 1
+`
+
+type GetSessionFromSpentTokenRow struct {
+	Pk      int32     `json:"pk"`
+	Id      uuid.UUID `json:"id"`
+	UserPk  int32     `json:"userPk"`
+	UserId  uuid.UUID `json:"userId"`
+	SpentAt time.Time `json:"spentAt"`
+}
+
+func (q *Queries) GetSessionFromSpentToken(ctx context.Context, token string) (GetSessionFromSpentTokenRow, error) {
+	row := q.db.QueryRow(ctx, getSessionFromSpentToken, token)
+	var i GetSessionFromSpentTokenRow
+	err := row.Scan(
+		&i.Pk,
+		&i.Id,
+		&i.UserPk,
+		&i.UserId,
+		&i.SpentAt,
 	)
 	return i, err
 }
@@ -91,7 +395,8 @@ select
 	s.pk,
 	s.id,
 	s.last_used,
-	u.pk, u.id, u.username, u.email, u.password, u.claims, u.created_date, u.last_seen
+	s.rotate,
+	u.pk, u.id, u.username, u.email, u.password, u.claims, u.created_date, u.last_seen, u.delete_at, u.failed_logins, u.password_reset_required
 from
 	keibi.users as u
 	inner join keibi.sessions as s on u.pk = s.user_pk
@@ -104,6 +409,7 @@ type GetUserFromSessionIdRow struct {
 	Pk       int32     `json:"pk"`
 	Id       uuid.UUID `json:"id"`
 	LastUsed time.Time `json:"lastUsed"`
+	Rotate   bool      `json:"rotate"`
 	User     User      `json:"user"`
 }
 
@@ -114,6 +420,7 @@ func (q *Queries) GetUserFromSessionId(ctx context.Context, id uuid.UUID) (GetUs
 		&i.Pk,
 		&i.Id,
 		&i.LastUsed,
+		&i.Rotate,
 		&i.User.Pk,
 		&i.User.Id,
 		&i.User.Username,
@@ -122,6 +429,9 @@ func (q *Queries) GetUserFromSessionId(ctx context.Context, id uuid.UUID) (GetUs
 		&i.User.Claims,
 		&i.User.CreatedDate,
 		&i.User.LastSeen,
+		&i.User.DeleteAt,
+		&i.User.FailedLogins,
+		&i.User.PasswordResetRequired,
 	)
 	return i, err
 }
@@ -130,8 +440,10 @@ const getUserFromToken = `-- name: GetUserFromToken :one
 select
 	s.pk,
 	s.id,
+	s.created_date,
 	s.last_used,
-	u.pk, u.id, u.username, u.email, u.password, u.claims, u.created_date, u.last_seen
+	s.rotate,
+	u.pk, u.id, u.username, u.email, u.password, u.claims, u.created_date, u.last_seen, u.delete_at, u.failed_logins, u.password_reset_required
 from
 	keibi.users as u
 	inner join keibi.sessions as s on u.pk = s.user_pk
@@ -141,10 +453,12 @@ limit 1
 `
 
 type GetUserFromTokenRow struct {
-	Pk       int32     `json:"pk"`
-	Id       uuid.UUID `json:"id"`
-	LastUsed time.Time `json:"lastUsed"`
-	User     User      `json:"user"`
+	Pk          int32     `json:"pk"`
+	Id          uuid.UUID `json:"id"`
+	CreatedDate time.Time `json:"createdDate"`
+	LastUsed    time.Time `json:"lastUsed"`
+	Rotate      bool      `json:"rotate"`
+	User        User      `json:"user"`
 }
 
 func (q *Queries) GetUserFromToken(ctx context.Context, token string) (GetUserFromTokenRow, error) {
@@ -153,7 +467,9 @@ func (q *Queries) GetUserFromToken(ctx context.Context, token string) (GetUserFr
 	err := row.Scan(
 		&i.Pk,
 		&i.Id,
+		&i.CreatedDate,
 		&i.LastUsed,
+		&i.Rotate,
 		&i.User.Pk,
 		&i.User.Id,
 		&i.User.Username,
@@ -162,13 +478,16 @@ func (q *Queries) GetUserFromToken(ctx context.Context, token string) (GetUserFr
 		&i.User.Claims,
 		&i.User.CreatedDate,
 		&i.User.LastSeen,
+		&i.User.DeleteAt,
+		&i.User.FailedLogins,
+		&i.User.PasswordResetRequired,
 	)
 	return i, err
 }
 
 const getUserSessions = `-- name: GetUserSessions :many
 select
-	s.pk, s.id, s.token, s.user_pk, s.created_date, s.last_used, s.device
+	s.pk, s.id, s.token, s.user_pk, s.created_date, s.last_used, s.device, s.rotate, s.impersonator_id, s.impersonator_username, s.oidc_provider, s.oidc_sub, s.oidc_sid, s.oidc_id_token
 from
 	keibi.sessions as s
 	inner join keibi.users as u on u.pk = s.user_pk
@@ -195,6 +514,13 @@ func (q *Queries) GetUserSessions(ctx context.Context, pk int32) ([]Session, err
 			&i.CreatedDate,
 			&i.LastUsed,
 			&i.Device,
+			&i.Rotate,
+			&i.ImpersonatorId,
+			&i.ImpersonatorUsername,
+			&i.OidcProvider,
+			&i.OidcSub,
+			&i.OidcSid,
+			&i.OidcIdToken,
 		); err != nil {
 			return nil, err
 		}
@@ -206,16 +532,105 @@ func (q *Queries) GetUserSessions(ctx context.Context, pk int32) ([]Session, err
 	return items, nil
 }
 
-const touchSession = `-- name: TouchSession :exec
+const rotateSessionToken = `-- name: RotateSessionToken :one
 update
 	keibi.sessions
 set
+	token = $2,
 	last_used = now()::timestamptz
 where
 	pk = $1
+	and token = $3
+returning
+	pk, id, token, user_pk, created_date, last_used, device, rotate, impersonator_id, impersonator_username, oidc_provider, oidc_sub, oidc_sid, oidc_id_token
+`
+
+type RotateSessionTokenParams struct {
+	Pk       int32  `json:"pk"`
+	NewToken string `json:"newToken"`
+	Token    string `json:"token"`
+}
+
+func (q *Queries) RotateSessionToken(ctx context.Context, arg RotateSessionTokenParams) (Session, error) {
+	row := q.db.QueryRow(ctx, rotateSessionToken, arg.Pk, arg.NewToken, arg.Token)
+	var i Session
+	err := row.Scan(
+		&i.Pk,
+		&i.Id,
+		&i.Token,
+		&i.UserPk,
+		&i.CreatedDate,
+		&i.LastUsed,
+		&i.Device,
+		&i.Rotate,
+		&i.ImpersonatorId,
+		&i.ImpersonatorUsername,
+		&i.OidcProvider,
+		&i.OidcSub,
+		&i.OidcSid,
+		&i.OidcIdToken,
+	)
+	return i, err
+}
+
+const setSessionOidc = `-- name: SetSessionOidc :exec
+update
+	keibi.sessions
+set
+	oidc_provider = $2,
+	oidc_sub = $3,
+	oidc_sid = $4,
+	oidc_id_token = $5
+where
+	pk = $1
+`
+
+type SetSessionOidcParams struct {
+	Pk           int32   `json:"pk"`
+	OidcProvider *string `json:"oidcProvider"`
+	OidcSub      *string `json:"oidcSub"`
+	OidcSid      *string `json:"oidcSid"`
+	OidcIdToken  *string `json:"oidcIdToken"`
+}
+
+func (q *Queries) SetSessionOidc(ctx context.Context, arg SetSessionOidcParams) error {
+	_, err := q.db.Exec(ctx, setSessionOidc,
+		arg.Pk,
+		arg.OidcProvider,
+		arg.OidcSub,
+		arg.OidcSid,
+		arg.OidcIdToken,
+	)
+	return err
+}
+
+const spendSessionToken = `-- name: SpendSessionToken :exec
+insert into keibi.spent_session_tokens(token, session_pk)
+	values ($1, $2)
+on conflict (token)
+	do nothing
+`
+
+type SpendSessionTokenParams struct {
+	Token     string `json:"token"`
+	SessionPk int32  `json:"sessionPk"`
+}
+
+func (q *Queries) SpendSessionToken(ctx context.Context, arg SpendSessionTokenParams) error {
+	_, err := q.db.Exec(ctx, spendSessionToken, arg.Token, arg.SessionPk)
+	return err
+}
+
+const touchSessions = `-- name: TouchSessions :exec
+update
+	keibi.sessions
+set
+	last_used = now()::timestamptz
+where
+	pk = any($1::int[])
 `
 
-func (q *Queries) TouchSession(ctx context.Context, pk int32) error {
-	_, err := q.db.Exec(ctx, touchSession, pk)
+func (q *Queries) TouchSessions(ctx context.Context, pks []int32) error {
+	_, err := q.db.Exec(ctx, touchSessions, pks)
 	return err
 }
@@ -0,0 +1,110 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.31.1
+// source: settings.sql
+
+package dbc
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/google/uuid"
+)
+
+const getAccountSettings = `-- name: GetAccountSettings :one
+select
+	s.settings
+from
+	keibi.user_settings as s
+	inner join keibi.users as u on u.pk = s.user_pk
+where
+	u.id = $1
+	and s.session_pk is null
+`
+
+func (q *Queries) GetAccountSettings(ctx context.Context, id uuid.UUID) (json.RawMessage, error) {
+	row := q.db.QueryRow(ctx, getAccountSettings, id)
+	var settings json.RawMessage
+	err := row.Scan(&settings)
+	return settings, err
+}
+
+const getSessionSettings = `-- name: GetSessionSettings :one
+select
+	us.settings
+from
+	keibi.user_settings as us
+	inner join keibi.sessions as s on s.pk = us.session_pk
+where
+	s.id = $1
+`
+
+func (q *Queries) GetSessionSettings(ctx context.Context, id uuid.UUID) (json.RawMessage, error) {
+	row := q.db.QueryRow(ctx, getSessionSettings, id)
+	var settings json.RawMessage
+	err := row.Scan(&settings)
+	return settings, err
+}
+
+const saveAccountSettings = `-- name: SaveAccountSettings :execrows
+insert into keibi.user_settings(user_pk, settings)
+select
+	pk,
+	$1::jsonb
+from
+	keibi.users
+where
+	id = $2
+on conflict (user_pk)
+	where session_pk is null
+	do update set
+		settings = excluded.settings,
+		updated_at = now()::timestamptz
+`
+
+type SaveAccountSettingsParams struct {
+	Settings interface{} `json:"settings"`
+	Id       uuid.UUID   `json:"id"`
+}
+
+func (q *Queries) SaveAccountSettings(ctx context.Context, arg SaveAccountSettingsParams) (int64, error) {
+	result, err := q.db.Exec(ctx, saveAccountSettings, arg.Settings, arg.Id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const saveSessionSettings = `-- name: SaveSessionSettings :execrows
+insert into keibi.user_settings(user_pk, session_pk, settings)
+select
+	s.user_pk,
+	s.pk,
+	$1::jsonb
+from
+	keibi.sessions as s
+	inner join keibi.users as u on u.pk = s.user_pk
+where
+	s.id = $2
+	and u.id = $3
+on conflict (session_pk)
+	where session_pk is not null
+	do update set
+		settings = excluded.settings,
+		updated_at = now()::timestamptz
+`
+
+type SaveSessionSettingsParams struct {
+	Settings  interface{} `json:"settings"`
+	SessionId uuid.UUID   `json:"sessionId"`
+	UserId    uuid.UUID   `json:"userId"`
+}
+
+func (q *Queries) SaveSessionSettings(ctx context.Context, arg SaveSessionSettingsParams) (int64, error) {
+	result, err := q.db.Exec(ctx, saveSessionSettings, arg.Settings, arg.SessionId, arg.UserId)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
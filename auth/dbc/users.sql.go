@@ -14,11 +14,42 @@ import (
 	"github.com/zoriya/kyoo/keibi/models"
 )
 
+const cancelUserDeletion = `-- name: CancelUserDeletion :one
+update
+	keibi.users
+set
+	delete_at = null
+where
+	id = $1
+	and delete_at is not null
+returning
+	pk, id, username, email, password, claims, created_date, last_seen, delete_at, failed_logins, password_reset_required
+`
+
+func (q *Queries) CancelUserDeletion(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRow(ctx, cancelUserDeletion, id)
+	var i User
+	err := row.Scan(
+		&i.Pk,
+		&i.Id,
+		&i.Username,
+		&i.Email,
+		&i.Password,
+		&i.Claims,
+		&i.CreatedDate,
+		&i.LastSeen,
+		&i.DeleteAt,
+		&i.FailedLogins,
+		&i.PasswordResetRequired,
+	)
+	return i, err
+}
+
 const createUser = `-- name: CreateUser :one
 insert into keibi.users(username, email, password, claims)
 	values ($1, $2, $3, case when not exists (
 			select
-				pk, id, username, email, password, claims, created_date, last_seen
+				pk, id, username, email, password, claims, created_date, last_seen, delete_at, failed_logins, password_reset_required
 			from
 				keibi.users) then
 			$4::jsonb
@@ -26,7 +57,7 @@ insert into keibi.users(username, email, password, claims)
 			$5::jsonb
 		end)
 returning
-	pk, id, username, email, password, claims, created_date, last_seen
+	pk, id, username, email, password, claims, created_date, last_seen, delete_at, failed_logins, password_reset_required
 `
 
 type CreateUserParams struct {
@@ -55,6 +86,9 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		&i.Claims,
 		&i.CreatedDate,
 		&i.LastSeen,
+		&i.DeleteAt,
+		&i.FailedLogins,
+		&i.PasswordResetRequired,
 	)
 	return i, err
 }
@@ -76,11 +110,50 @@ func (q *Queries) DeleteOidcHandle(ctx context.Context, arg DeleteOidcHandlePara
 	return err
 }
 
+const deleteScheduledUsers = `-- name: DeleteScheduledUsers :many
+delete from keibi.users
+where delete_at < now()::timestamptz
+returning
+	pk, id, username, email, password, claims, created_date, last_seen, delete_at, failed_logins, password_reset_required
+`
+
+func (q *Queries) DeleteScheduledUsers(ctx context.Context) ([]User, error) {
+	rows, err := q.db.Query(ctx, deleteScheduledUsers)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []User
+	for rows.Next() {
+		var i User
+		if err := rows.Scan(
+			&i.Pk,
+			&i.Id,
+			&i.Username,
+			&i.Email,
+			&i.Password,
+			&i.Claims,
+			&i.CreatedDate,
+			&i.LastSeen,
+			&i.DeleteAt,
+			&i.FailedLogins,
+			&i.PasswordResetRequired,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const deleteUser = `-- name: DeleteUser :one
 delete from keibi.users
 where id = $1
 returning
-	pk, id, username, email, password, claims, created_date, last_seen
+	pk, id, username, email, password, claims, created_date, last_seen, delete_at, failed_logins, password_reset_required
 `
 
 func (q *Queries) DeleteUser(ctx context.Context, id uuid.UUID) (User, error) {
@@ -95,13 +168,16 @@ func (q *Queries) DeleteUser(ctx context.Context, id uuid.UUID) (User, error) {
 		&i.Claims,
 		&i.CreatedDate,
 		&i.LastSeen,
+		&i.DeleteAt,
+		&i.FailedLogins,
+		&i.PasswordResetRequired,
 	)
 	return i, err
 }
 
 const getAllUsers = `-- name: GetAllUsers :many
 select
-	u.pk, u.id, u.username, u.email, u.password, u.claims, u.created_date, u.last_seen,
+	u.pk, u.id, u.username, u.email, u.password, u.claims, u.created_date, u.last_seen, u.delete_at, u.failed_logins, u.password_reset_required,
 	coalesce(
 		jsonb_object_agg(
 			h.provider,
@@ -149,6 +225,9 @@ func (q *Queries) GetAllUsers(ctx context.Context, limit int32) ([]GetAllUsersRo
 			&i.User.Claims,
 			&i.User.CreatedDate,
 			&i.User.LastSeen,
+			&i.User.DeleteAt,
+			&i.User.FailedLogins,
+			&i.User.PasswordResetRequired,
 			&i.Oidc,
 		); err != nil {
 			return nil, err
@@ -163,7 +242,7 @@ func (q *Queries) GetAllUsers(ctx context.Context, limit int32) ([]GetAllUsersRo
 
 const getAllUsersAfter = `-- name: GetAllUsersAfter :many
 select
-	u.pk, u.id, u.username, u.email, u.password, u.claims, u.created_date, u.last_seen,
+	u.pk, u.id, u.username, u.email, u.password, u.claims, u.created_date, u.last_seen, u.delete_at, u.failed_logins, u.password_reset_required,
 	coalesce(
 		jsonb_object_agg(
 			h.provider,
@@ -218,6 +297,9 @@ func (q *Queries) GetAllUsersAfter(ctx context.Context, arg GetAllUsersAfterPara
 			&i.User.Claims,
 			&i.User.CreatedDate,
 			&i.User.LastSeen,
+			&i.User.DeleteAt,
+			&i.User.FailedLogins,
+			&i.User.PasswordResetRequired,
 			&i.Oidc,
 		); err != nil {
 			return nil, err
@@ -232,7 +314,7 @@ func (q *Queries) GetAllUsersAfter(ctx context.Context, arg GetAllUsersAfterPara
 
 const getUser = `-- name: GetUser :one
 select
-	u.pk, u.id, u.username, u.email, u.password, u.claims, u.created_date, u.last_seen,
+	u.pk, u.id, u.username, u.email, u.password, u.claims, u.created_date, u.last_seen, u.delete_at, u.failed_logins, u.password_reset_required,
 	coalesce(
 		jsonb_object_agg(
 			h.provider,
@@ -281,23 +363,27 @@ func (q *Queries) GetUser(ctx context.Context, arg GetUserParams) (GetUserRow, e
 		&i.User.Claims,
 		&i.User.CreatedDate,
 		&i.User.LastSeen,
+		&i.User.DeleteAt,
+		&i.User.FailedLogins,
+		&i.User.PasswordResetRequired,
 		&i.Oidc,
 	)
 	return i, err
 }
 
-const getUserByEmail = `-- name: GetUserByEmail :one
+const getUserByLogin = `-- name: GetUserByLogin :one
 select
-	pk, id, username, email, password, claims, created_date, last_seen
+	pk, id, username, email, password, claims, created_date, last_seen, delete_at, failed_logins, password_reset_required
 from
 	keibi.users
 where
 	email = $1
+	or username = $1
 limit 1
 `
 
-func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error) {
-	row := q.db.QueryRow(ctx, getUserByEmail, email)
+func (q *Queries) GetUserByLogin(ctx context.Context, login string) (User, error) {
+	row := q.db.QueryRow(ctx, getUserByLogin, login)
 	var i User
 	err := row.Scan(
 		&i.Pk,
@@ -308,23 +394,32 @@ func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error
 		&i.Claims,
 		&i.CreatedDate,
 		&i.LastSeen,
+		&i.DeleteAt,
+		&i.FailedLogins,
+		&i.PasswordResetRequired,
 	)
 	return i, err
 }
 
-const getUserByLogin = `-- name: GetUserByLogin :one
+const getUserByOidc = `-- name: GetUserByOidc :one
 select
-	pk, id, username, email, password, claims, created_date, last_seen
+	u.pk, u.id, u.username, u.email, u.password, u.claims, u.created_date, u.last_seen, u.delete_at, u.failed_logins, u.password_reset_required
 from
-	keibi.users
+	keibi.users as u
+	inner join keibi.oidc_handle as h on u.pk = h.user_pk
 where
-	email = $1
-	or username = $1
+	h.provider = $1
+	and h.id = $2
 limit 1
 `
 
-func (q *Queries) GetUserByLogin(ctx context.Context, login string) (User, error) {
-	row := q.db.QueryRow(ctx, getUserByLogin, login)
+type GetUserByOidcParams struct {
+	Provider string `json:"provider"`
+	Id       string `json:"id"`
+}
+
+func (q *Queries) GetUserByOidc(ctx context.Context, arg GetUserByOidcParams) (User, error) {
+	row := q.db.QueryRow(ctx, getUserByOidc, arg.Provider, arg.Id)
 	var i User
 	err := row.Scan(
 		&i.Pk,
@@ -335,29 +430,133 @@ func (q *Queries) GetUserByLogin(ctx context.Context, login string) (User, error
 		&i.Claims,
 		&i.CreatedDate,
 		&i.LastSeen,
+		&i.DeleteAt,
+		&i.FailedLogins,
+		&i.PasswordResetRequired,
 	)
 	return i, err
 }
 
-const getUserByOidc = `-- name: GetUserByOidc :one
+const getUserByPk = `-- name: GetUserByPk :one
 select
-	u.pk, u.id, u.username, u.email, u.password, u.claims, u.created_date, u.last_seen
+	pk, id, username, email, password, claims, created_date, last_seen, delete_at, failed_logins, password_reset_required
 from
-	keibi.users as u
-	inner join keibi.oidc_handle as h on u.pk = h.user_pk
+	keibi.users
 where
-	h.provider = $1
-	and h.id = $2
-limit 1
+	pk = $1
 `
 
-type GetUserByOidcParams struct {
-	Provider string `json:"provider"`
-	Id       string `json:"id"`
+func (q *Queries) GetUserByPk(ctx context.Context, pk int32) (User, error) {
+	row := q.db.QueryRow(ctx, getUserByPk, pk)
+	var i User
+	err := row.Scan(
+		&i.Pk,
+		&i.Id,
+		&i.Username,
+		&i.Email,
+		&i.Password,
+		&i.Claims,
+		&i.CreatedDate,
+		&i.LastSeen,
+		&i.DeleteAt,
+		&i.FailedLogins,
+		&i.PasswordResetRequired,
+	)
+	return i, err
 }
 
-func (q *Queries) GetUserByOidc(ctx context.Context, arg GetUserByOidcParams) (User, error) {
-	row := q.db.QueryRow(ctx, getUserByOidc, arg.Provider, arg.Id)
+const getUsersByEmail = `-- name: GetUsersByEmail :many
+select
+	pk, id, username, email, password, claims, created_date, last_seen, delete_at, failed_logins, password_reset_required
+from
+	keibi.users
+where
+	lower(email) = lower($1)
+limit 2
+`
+
+func (q *Queries) GetUsersByEmail(ctx context.Context, email string) ([]User, error) {
+	rows, err := q.db.Query(ctx, getUsersByEmail, email)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []User
+	for rows.Next() {
+		var i User
+		if err := rows.Scan(
+			&i.Pk,
+			&i.Id,
+			&i.Username,
+			&i.Email,
+			&i.Password,
+			&i.Claims,
+			&i.CreatedDate,
+			&i.LastSeen,
+			&i.DeleteAt,
+			&i.FailedLogins,
+			&i.PasswordResetRequired,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const incrementFailedLogins = `-- name: IncrementFailedLogins :one
+update
+	keibi.users
+set
+	failed_logins = failed_logins + 1
+where
+	pk = $1
+returning
+	failed_logins
+`
+
+func (q *Queries) IncrementFailedLogins(ctx context.Context, pk int32) (int32, error) {
+	row := q.db.QueryRow(ctx, incrementFailedLogins, pk)
+	var failed_logins int32
+	err := row.Scan(&failed_logins)
+	return failed_logins, err
+}
+
+const resetFailedLogins = `-- name: ResetFailedLogins :exec
+update
+	keibi.users
+set
+	failed_logins = 0
+where
+	pk = $1
+`
+
+func (q *Queries) ResetFailedLogins(ctx context.Context, pk int32) error {
+	_, err := q.db.Exec(ctx, resetFailedLogins, pk)
+	return err
+}
+
+const scheduleUserDeletion = `-- name: ScheduleUserDeletion :one
+update
+	keibi.users
+set
+	delete_at = $2
+where
+	id = $1
+returning
+	pk, id, username, email, password, claims, created_date, last_seen, delete_at, failed_logins, password_reset_required
+`
+
+type ScheduleUserDeletionParams struct {
+	Id       uuid.UUID  `json:"id"`
+	DeleteAt *time.Time `json:"deleteAt"`
+}
+
+func (q *Queries) ScheduleUserDeletion(ctx context.Context, arg ScheduleUserDeletionParams) (User, error) {
+	row := q.db.QueryRow(ctx, scheduleUserDeletion, arg.Id, arg.DeleteAt)
 	var i User
 	err := row.Scan(
 		&i.Pk,
@@ -368,21 +567,24 @@ func (q *Queries) GetUserByOidc(ctx context.Context, arg GetUserByOidcParams) (U
 		&i.Claims,
 		&i.CreatedDate,
 		&i.LastSeen,
+		&i.DeleteAt,
+		&i.FailedLogins,
+		&i.PasswordResetRequired,
 	)
 	return i, err
 }
 
-const touchUser = `-- name: TouchUser :exec
+const touchUsers = `-- name: TouchUsers :exec
 update
 	keibi.users
 set
 	last_seen = now()::timestamptz
 where
-	pk = $1
+	pk = any($1::int[])
 `
 
-func (q *Queries) TouchUser(ctx context.Context, pk int32) error {
-	_, err := q.db.Exec(ctx, touchUser, pk)
+func (q *Queries) TouchUsers(ctx context.Context, pks []int32) error {
+	_, err := q.db.Exec(ctx, touchUsers, pks)
 	return err
 }
 
@@ -393,11 +595,14 @@ set
 	username = coalesce($2, username),
 	email = coalesce($3, email),
 	password = coalesce($4, password),
+	-- setting a new password fulfills a required password reset.
+	password_reset_required = password_reset_required
+	and $4::text is null,
 	claims = claims || coalesce($5, '{}'::jsonb)
 where
 	id = $1
 returning
-	pk, id, username, email, password, claims, created_date, last_seen
+	pk, id, username, email, password, claims, created_date, last_seen, delete_at, failed_logins, password_reset_required
 `
 
 type UpdateUserParams struct {
@@ -426,6 +631,9 @@ func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, e
 		&i.Claims,
 		&i.CreatedDate,
 		&i.LastSeen,
+		&i.DeleteAt,
+		&i.FailedLogins,
+		&i.PasswordResetRequired,
 	)
 	return i, err
 }
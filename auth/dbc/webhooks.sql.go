@@ -0,0 +1,369 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.31.1
+// source: webhooks.sql
+
+package dbc
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const claimWebhookDeliveries = `-- name: ClaimWebhookDeliveries :many
+update
+	keibi.webhook_deliveries as d
+set
+	-- lease the delivery so other instances don't send it at the same time.
+	-- a batch is sent concurrently, the lease only needs to outlast the http client's timeout (10s).
+	next_attempt_at = now()::timestamptz + interval '1 min'
+from
+	keibi.webhooks as w
+where
+	w.pk = d.webhook_pk
+	and d.pk in (
+		select
+			pk
+		from
+			keibi.webhook_deliveries
+		where
+			status = 'pending'
+			and next_attempt_at <= now()::timestamptz
+		order by
+			next_attempt_at
+		limit $1
+		for update
+			skip locked)
+returning
+	d.pk,
+	d.id,
+	d.event,
+	d.payload,
+	d.attempts,
+	w.url,
+	w.secret
+`
+
+type ClaimWebhookDeliveriesRow struct {
+	Pk       int32           `json:"pk"`
+	Id       uuid.UUID       `json:"id"`
+	Event    string          `json:"event"`
+	Payload  json.RawMessage `json:"payload"`
+	Attempts int32           `json:"attempts"`
+	Url      string          `json:"url"`
+	Secret   string          `json:"secret"`
+}
+
+func (q *Queries) ClaimWebhookDeliveries(ctx context.Context, limit int32) ([]ClaimWebhookDeliveriesRow, error) {
+	rows, err := q.db.Query(ctx, claimWebhookDeliveries, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ClaimWebhookDeliveriesRow
+	for rows.Next() {
+		var i ClaimWebhookDeliveriesRow
+		if err := rows.Scan(
+			&i.Pk,
+			&i.Id,
+			&i.Event,
+			&i.Payload,
+			&i.Attempts,
+			&i.Url,
+			&i.Secret,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const cleanupWebhookDeliveries = `-- name: CleanupWebhookDeliveries :exec
+delete from keibi.webhook_deliveries
+where status != 'pending'
+	and created_at + interval '30 days' < now()::timestamptz
+`
+
+func (q *Queries) CleanupWebhookDeliveries(ctx context.Context) error {
+	_, err := q.db.Exec(ctx, cleanupWebhookDeliveries)
+	return err
+}
+
+const createWebhook = `-- name: CreateWebhook :one
+insert into keibi.webhooks(url, secret, events, enabled)
+	values ($1, $2, $3, $4)
+returning
+	pk, id, url, secret, events, enabled, created_at
+`
+
+type CreateWebhookParams struct {
+	Url     string   `json:"url"`
+	Secret  string   `json:"secret"`
+	Events  []string `json:"events"`
+	Enabled bool     `json:"enabled"`
+}
+
+func (q *Queries) CreateWebhook(ctx context.Context, arg CreateWebhookParams) (Webhook, error) {
+	row := q.db.QueryRow(ctx, createWebhook,
+		arg.Url,
+		arg.Secret,
+		arg.Events,
+		arg.Enabled,
+	)
+	var i Webhook
+	err := row.Scan(
+		&i.Pk,
+		&i.Id,
+		&i.Url,
+		&i.Secret,
+		&i.Events,
+		&i.Enabled,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const deleteWebhook = `-- name: DeleteWebhook :one
+delete from keibi.webhooks
+where id = $1
+returning
+	pk, id, url, secret, events, enabled, created_at
+`
+
+func (q *Queries) DeleteWebhook(ctx context.Context, id uuid.UUID) (Webhook, error) {
+	row := q.db.QueryRow(ctx, deleteWebhook, id)
+	var i Webhook
+	err := row.Scan(
+		&i.Pk,
+		&i.Id,
+		&i.Url,
+		&i.Secret,
+		&i.Events,
+		&i.Enabled,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const enqueueWebhookDeliveries = `-- name: EnqueueWebhookDeliveries :exec
+insert into keibi.webhook_deliveries(webhook_pk, event, payload)
+select
+	pk,
+	$1,
+	$2
+from
+	keibi.webhooks
+where
+	enabled
+	and (cardinality(events) = 0
+		or $1::text = any (events))
+`
+
+type EnqueueWebhookDeliveriesParams struct {
+	Event   string          `json:"event"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+func (q *Queries) EnqueueWebhookDeliveries(ctx context.Context, arg EnqueueWebhookDeliveriesParams) error {
+	_, err := q.db.Exec(ctx, enqueueWebhookDeliveries, arg.Event, arg.Payload)
+	return err
+}
+
+const getWebhook = `-- name: GetWebhook :one
+select
+	pk, id, url, secret, events, enabled, created_at
+from
+	keibi.webhooks
+where
+	id = $1
+`
+
+func (q *Queries) GetWebhook(ctx context.Context, id uuid.UUID) (Webhook, error) {
+	row := q.db.QueryRow(ctx, getWebhook, id)
+	var i Webhook
+	err := row.Scan(
+		&i.Pk,
+		&i.Id,
+		&i.Url,
+		&i.Secret,
+		&i.Events,
+		&i.Enabled,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const listWebhookDeliveries = `-- name: ListWebhookDeliveries :many
+select
+	d.pk, d.id, d.webhook_pk, d.event, d.payload, d.status, d.attempts, d.next_attempt_at, d.last_status_code, d.last_error, d.created_at, d.delivered_at
+from
+	keibi.webhook_deliveries as d
+	inner join keibi.webhooks as w on w.pk = d.webhook_pk
+where
+	w.id = $1
+order by
+	d.created_at desc
+limit $2
+`
+
+type ListWebhookDeliveriesParams struct {
+	Id    uuid.UUID `json:"id"`
+	Limit int32     `json:"limit"`
+}
+
+func (q *Queries) ListWebhookDeliveries(ctx context.Context, arg ListWebhookDeliveriesParams) ([]WebhookDelivery, error) {
+	rows, err := q.db.Query(ctx, listWebhookDeliveries, arg.Id, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []WebhookDelivery
+	for rows.Next() {
+		var i WebhookDelivery
+		if err := rows.Scan(
+			&i.Pk,
+			&i.Id,
+			&i.WebhookPk,
+			&i.Event,
+			&i.Payload,
+			&i.Status,
+			&i.Attempts,
+			&i.NextAttemptAt,
+			&i.LastStatusCode,
+			&i.LastError,
+			&i.CreatedAt,
+			&i.DeliveredAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listWebhooks = `-- name: ListWebhooks :many
+select
+	pk, id, url, secret, events, enabled, created_at
+from
+	keibi.webhooks
+order by
+	created_at
+`
+
+func (q *Queries) ListWebhooks(ctx context.Context) ([]Webhook, error) {
+	rows, err := q.db.Query(ctx, listWebhooks)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Webhook
+	for rows.Next() {
+		var i Webhook
+		if err := rows.Scan(
+			&i.Pk,
+			&i.Id,
+			&i.Url,
+			&i.Secret,
+			&i.Events,
+			&i.Enabled,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const saveWebhookAttempt = `-- name: SaveWebhookAttempt :exec
+update
+	keibi.webhook_deliveries
+set
+	status = $2,
+	attempts = attempts + 1,
+	next_attempt_at = $3,
+	last_status_code = $4,
+	last_error = $5,
+	delivered_at = case when $2 = 'delivered'::keibi.webhook_status then
+		now()::timestamptz
+	else
+		null
+	end
+where
+	pk = $1
+`
+
+type SaveWebhookAttemptParams struct {
+	Pk             int32         `json:"pk"`
+	Status         WebhookStatus `json:"status"`
+	NextAttemptAt  time.Time     `json:"nextAttemptAt"`
+	LastStatusCode *int32        `json:"lastStatusCode"`
+	LastError      *string       `json:"lastError"`
+}
+
+func (q *Queries) SaveWebhookAttempt(ctx context.Context, arg SaveWebhookAttemptParams) error {
+	_, err := q.db.Exec(ctx, saveWebhookAttempt,
+		arg.Pk,
+		arg.Status,
+		arg.NextAttemptAt,
+		arg.LastStatusCode,
+		arg.LastError,
+	)
+	return err
+}
+
+const updateWebhook = `-- name: UpdateWebhook :one
+update
+	keibi.webhooks
+set
+	url = coalesce($2, url),
+	secret = coalesce($3, secret),
+	events = coalesce($4, events),
+	enabled = coalesce($5, enabled)
+where
+	id = $1
+returning
+	pk, id, url, secret, events, enabled, created_at
+`
+
+type UpdateWebhookParams struct {
+	Id      uuid.UUID `json:"id"`
+	Url     *string   `json:"url"`
+	Secret  *string   `json:"secret"`
+	Events  []string  `json:"events"`
+	Enabled *bool     `json:"enabled"`
+}
+
+func (q *Queries) UpdateWebhook(ctx context.Context, arg UpdateWebhookParams) (Webhook, error) {
+	row := q.db.QueryRow(ctx, updateWebhook,
+		arg.Id,
+		arg.Url,
+		arg.Secret,
+		arg.Events,
+		arg.Enabled,
+	)
+	var i Webhook
+	err := row.Scan(
+		&i.Pk,
+		&i.Id,
+		&i.Url,
+		&i.Secret,
+		&i.Events,
+		&i.Enabled,
+		&i.CreatedAt,
+	)
+	return i, err
+}
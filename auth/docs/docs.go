@@ -43,6 +43,32 @@ const docTemplate = `{
                 }
             }
         },
+        "/challenges": {
+            "post": {
+                "description": "Create a proof-of-work challenge, only available if the challenge provider (see /info) is ` + "`" + `pow` + "`" + `.\nTo solve it, find a ` + "`" + `response` + "`" + ` such that sha256(nonce + response) starts with ` + "`" + `difficulty` + "`" + ` zero bits.\nSend ` + "`" + `{ id, response }` + "`" + ` as the ` + "`" + `challenge` + "`" + ` field of the register or login body.\nA challenge can only be used once (even if the solution was invalid).",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Create challenge",
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/main.PowChallenge"
+                        }
+                    },
+                    "404": {
+                        "description": "Proof-of-work challenges are disabled",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    }
+                }
+            }
+        },
         "/info": {
             "get": {
                 "description": "List keibi's settings (oidc providers, public url...)",
@@ -63,6 +89,60 @@ const docTemplate = `{
                 }
             }
         },
+        "/introspect": {
+            "post": {
+                "security": [
+                    {
+                        "Jwt": [
+                            "tokens.introspect"
+                        ]
+                    }
+                ],
+                "description": "Check if a session token, an api key or a jwt is valid and retrieve its subject, claims \u0026 expiry\n(see [RFC 7662](https://www.rfc-editor.org/rfc/rfc7662)). Invalid tokens return ` + "`" + `{ active: false }` + "`" + `.\nJwts are only active while the session (or api key) they were created from is not revoked.\nOnly api keys \u0026 client certificates can call this route, users can't (even with the permission).",
+                "consumes": [
+                    "application/x-www-form-urlencoded",
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "jwt"
+                ],
+                "summary": "Introspect token",
+                "parameters": [
+                    {
+                        "description": "Token to introspect",
+                        "name": "token",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.TokenDto"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Introspection"
+                        }
+                    },
+                    "403": {
+                        "description": "Missing permissions: tokens.introspect. Or the caller is an user.",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    },
+                    "422": {
+                        "description": "Missing token",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    }
+                }
+            }
+        },
         "/jwt": {
             "get": {
                 "security": [
@@ -70,7 +150,7 @@ const docTemplate = `{
                         "Token": []
                     }
                 ],
-                "description": "Convert a session token or an API key to a short lived JWT. Passing an existing JWT will refresh it.",
+                "description": "Convert a session token, an API key or a client certificate to a short lived JWT. Passing an existing JWT will refresh it.",
                 "produces": [
                     "application/json"
                 ],
@@ -82,7 +162,7 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/main.Jwt"
+                            "$ref": "#/definitions/models.Jwt"
                         },
                         "headers": {
                             "Authorization": {
@@ -92,7 +172,7 @@ const docTemplate = `{
                         }
                     },
                     "403": {
-                        "description": "Invalid session token (or expired)",
+                        "description": "Invalid session token (or expired). Reusing a rotated session token revokes its session.",
                         "schema": {
                             "$ref": "#/definitions/main.KError"
                         }
@@ -124,7 +204,7 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/main.Page-main_ApiKey"
+                            "$ref": "#/definitions/models.Page-models_ApiKey"
                         }
                     }
                 }
@@ -154,7 +234,7 @@ const docTemplate = `{
                         "name": "key",
                         "in": "body",
                         "schema": {
-                            "$ref": "#/definitions/main.ApiKeyDto"
+                            "$ref": "#/definitions/models.ApiKeyDto"
                         }
                     }
                 ],
@@ -162,7 +242,7 @@ const docTemplate = `{
                     "201": {
                         "description": "Created",
                         "schema": {
-                            "$ref": "#/definitions/main.ApiKeyWToken"
+                            "$ref": "#/definitions/models.ApiKeyWToken"
                         }
                     },
                     "409": {
@@ -172,7 +252,7 @@ const docTemplate = `{
                         }
                     },
                     "422": {
-                        "description": "Invalid create body",
+                        "description": "Invalid create body or unknown permissions",
                         "schema": {
                             "$ref": "#/definitions/main.KError"
                         }
@@ -202,7 +282,7 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/main.ApiKey"
+                            "$ref": "#/definitions/models.ApiKey"
                         }
                     },
                     "404": {
@@ -220,6 +300,52 @@ const docTemplate = `{
                 }
             }
         },
+        "/oidc/backchannel-logout/{provider}": {
+            "post": {
+                "description": "Called by the provider (don't call it manually) to logout sessions created via this provider.\nRegister ` + "`" + `{PUBLIC_URL}/auth/oidc/backchannel-logout/{provider}` + "`" + ` as the back-channel logout uri.",
+                "consumes": [
+                    "application/x-www-form-urlencoded"
+                ],
+                "tags": [
+                    "oidc"
+                ],
+                "summary": "OIDC back-channel logout",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "example": "google",
+                        "description": "Name of the provider",
+                        "name": "provider",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Logout token signed by the provider",
+                        "name": "logout_token",
+                        "in": "formData",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Sessions logged out"
+                    },
+                    "400": {
+                        "description": "Invalid logout token",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    },
+                    "404": {
+                        "description": "Unknown OIDC provider",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    }
+                }
+            }
+        },
         "/oidc/callback/{provider}": {
             "get": {
                 "description": "Exchange an opaque OIDC token for a local session.",
@@ -259,6 +385,12 @@ const docTemplate = `{
                         "name": "device",
                         "in": "query"
                     },
+                    {
+                        "type": "boolean",
+                        "description": "Rotate the session token each time it's exchanged for a jwt",
+                        "name": "rotate",
+                        "in": "query"
+                    },
                     {
                         "type": "string",
                         "description": "Bearer token to link provider to current account",
@@ -270,7 +402,7 @@ const docTemplate = `{
                     "201": {
                         "description": "Created",
                         "schema": {
-                            "$ref": "#/definitions/main.SessionWToken"
+                            "$ref": "#/definitions/models.SessionWToken"
                         }
                     },
                     "404": {
@@ -403,7 +535,7 @@ const docTemplate = `{
                         "Jwt": []
                     }
                 ],
-                "description": "Remove an OIDC provider from the current account.",
+                "description": "Remove an OIDC (or SAML) provider from the current account.",
                 "produces": [
                     "application/json"
                 ],
@@ -415,7 +547,7 @@ const docTemplate = `{
                     {
                         "type": "string",
                         "example": "google",
-                        "description": "OIDC provider id",
+                        "description": "OIDC or SAML provider id",
                         "name": "provider",
                         "in": "path",
                         "required": true
@@ -426,7 +558,7 @@ const docTemplate = `{
                         "description": "No Content"
                     },
                     "404": {
-                        "description": "Unknown OIDC provider",
+                        "description": "Unknown OIDC or SAML provider",
                         "schema": {
                             "$ref": "#/definitions/main.KError"
                         }
@@ -434,14 +566,50 @@ const docTemplate = `{
                 }
             }
         },
-        "/presign": {
+        "/oidc/providers": {
+            "get": {
+                "security": [
+                    {
+                        "Jwt": [
+                            "oidc.read"
+                        ]
+                    }
+                ],
+                "description": "List every OIDC provider, including disabled, broken and env-defined ones. Secrets are never returned.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "oidc"
+                ],
+                "summary": "List OIDC providers",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.OidcProviderSettings"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Missing permissions: oidc.read.",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    }
+                }
+            },
             "post": {
                 "security": [
                     {
-                        "Jwt": []
+                        "Jwt": [
+                            "oidc.write"
+                        ]
                     }
                 ],
-                "description": "Add the signature as a ` + "`" + `x-presign` + "`" + ` query parameter",
+                "description": "Add a new OIDC provider. Its secret is encrypted with ` + "`" + `SECRET_ENCRYPTION_KEY` + "`" + ` before being stored.",
                 "consumes": [
                     "application/json"
                 ],
@@ -449,35 +617,35 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "jwt"
+                    "oidc"
                 ],
-                "summary": "Presign a group of urls",
+                "summary": "Create OIDC provider",
                 "parameters": [
                     {
-                        "description": "The requests to presign",
-                        "name": "presign",
+                        "description": "Provider settings",
+                        "name": "provider",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/main.PresignRequest"
+                            "$ref": "#/definitions/main.CreateOidcProviderDto"
                         }
                     }
                 ],
                 "responses": {
-                    "200": {
-                        "description": "OK",
+                    "201": {
+                        "description": "Created",
                         "schema": {
-                            "$ref": "#/definitions/main.Presign"
+                            "$ref": "#/definitions/main.OidcProviderSettings"
                         }
                     },
-                    "400": {
-                        "description": "Invalid parameters",
+                    "403": {
+                        "description": "Missing permissions: oidc.write.",
                         "schema": {
                             "$ref": "#/definitions/main.KError"
                         }
                     },
-                    "401": {
-                        "description": "Not logged in",
+                    "409": {
+                        "description": "A provider with the same id already exists",
                         "schema": {
                             "$ref": "#/definitions/main.KError"
                         }
@@ -491,47 +659,60 @@ const docTemplate = `{
                 }
             }
         },
-        "/sessions": {
-            "get": {
+        "/oidc/providers/{id}": {
+            "delete": {
                 "security": [
                     {
-                        "Jwt": []
+                        "Jwt": [
+                            "oidc.write"
+                        ]
                     }
                 ],
-                "description": "List all active sessions for the currently connected user",
+                "description": "Delete an OIDC provider. Users keep their account but can't login with this provider anymore.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "sessions"
+                    "oidc"
+                ],
+                "summary": "Delete OIDC provider",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "example": "google",
+                        "description": "Id of the provider",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
                 ],
-                "summary": "List my sessions",
                 "responses": {
-                    "200": {
-                        "description": "OK",
-                        "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/main.SessionWCurrent"
-                            }
-                        }
+                    "204": {
+                        "description": "No Content"
                     },
-                    "401": {
-                        "description": "Missing jwt token",
+                    "403": {
+                        "description": "Env-defined providers are read-only",
                         "schema": {
                             "$ref": "#/definitions/main.KError"
                         }
                     },
-                    "403": {
-                        "description": "Invalid jwt token (or expired)",
+                    "404": {
+                        "description": "Unknown OIDC provider",
                         "schema": {
                             "$ref": "#/definitions/main.KError"
                         }
                     }
                 }
             },
-            "post": {
-                "description": "Login to your account and open a session",
+            "patch": {
+                "security": [
+                    {
+                        "Jwt": [
+                            "oidc.write"
+                        ]
+                    }
+                ],
+                "description": "Edit or disable an OIDC provider. Env-defined providers can't be edited.",
                 "consumes": [
                     "application/json"
                 ],
@@ -539,47 +720,49 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "sessions"
+                    "oidc"
                 ],
-                "summary": "Login",
+                "summary": "Edit OIDC provider",
                 "parameters": [
                     {
                         "type": "string",
-                        "example": "android tv",
-                        "description": "The device the created session will be used on",
-                        "name": "device",
-                        "in": "query"
+                        "example": "google",
+                        "description": "Id of the provider",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
                     },
                     {
-                        "description": "Account informations",
-                        "name": "login",
+                        "description": "Edited settings",
+                        "name": "provider",
                         "in": "body",
+                        "required": true,
                         "schema": {
-                            "$ref": "#/definitions/main.LoginDto"
+                            "$ref": "#/definitions/main.EditOidcProviderDto"
                         }
                     }
                 ],
                 "responses": {
-                    "201": {
-                        "description": "Created",
+                    "200": {
+                        "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/main.SessionWToken"
+                            "$ref": "#/definitions/main.OidcProviderSettings"
                         }
                     },
                     "403": {
-                        "description": "Invalid password",
+                        "description": "Env-defined providers are read-only",
                         "schema": {
                             "$ref": "#/definitions/main.KError"
                         }
                     },
                     "404": {
-                        "description": "Account does not exists",
+                        "description": "Unknown OIDC provider",
                         "schema": {
                             "$ref": "#/definitions/main.KError"
                         }
                     },
                     "422": {
-                        "description": "User does not have a password (registered via oidc, please login via oidc)",
+                        "description": "Invalid body",
                         "schema": {
                             "$ref": "#/definitions/main.KError"
                         }
@@ -587,136 +770,69 @@ const docTemplate = `{
                 }
             }
         },
-        "/sessions/current": {
-            "delete": {
-                "security": [
-                    {
-                        "Jwt": []
-                    }
-                ],
-                "description": "Delete a session and logout",
+        "/permissions": {
+            "get": {
+                "description": "List every known permission. The ` + "`" + `permissions` + "`" + ` claim can also contain wildcards:\n` + "`" + `*` + "`" + ` grants every permission and ` + "`" + `users.*` + "`" + ` grants every permission starting with ` + "`" + `users.` + "`" + `.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "sessions"
+                    "users"
                 ],
-                "summary": "Logout",
+                "summary": "List permissions",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/main.Session"
-                        }
-                    },
-                    "401": {
-                        "description": "Missing jwt token",
-                        "schema": {
-                            "$ref": "#/definitions/main.KError"
-                        }
-                    },
-                    "403": {
-                        "description": "Invalid jwt token (or expired)",
-                        "schema": {
-                            "$ref": "#/definitions/main.KError"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.Permission"
+                            }
                         }
                     }
                 }
             }
         },
-        "/sessions/{id}": {
-            "delete": {
+        "/presign": {
+            "get": {
                 "security": [
                     {
                         "Jwt": []
                     }
                 ],
-                "description": "Delete a session and logout",
+                "description": "List the presigned signatures you created that can still be used",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "sessions"
-                ],
-                "summary": "Delete other session",
-                "parameters": [
-                    {
-                        "type": "string",
-                        "format": "uuid",
-                        "example": "e05089d6-9179-4b5b-a63e-94dd5fc2a397",
-                        "description": "The id of the session to delete",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
-                    }
+                    "jwt"
                 ],
+                "summary": "List presigns",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/main.Session"
-                        }
-                    },
-                    "404": {
-                        "description": "Session not found with specified id (if not using the /current route)",
-                        "schema": {
-                            "$ref": "#/definitions/main.KError"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.ActivePresign"
+                            }
                         }
                     },
-                    "422": {
-                        "description": "Invalid session id",
+                    "401": {
+                        "description": "Not logged in",
                         "schema": {
                             "$ref": "#/definitions/main.KError"
                         }
                     }
                 }
-            }
-        },
-        "/users": {
-            "get": {
+            },
+            "post": {
                 "security": [
                     {
-                        "Jwt": [
-                            "users.read"
-                        ]
-                    }
-                ],
-                "description": "List all users existing in this instance.",
-                "consumes": [
-                    "application/json"
-                ],
-                "produces": [
-                    "application/json"
-                ],
-                "tags": [
-                    "users"
-                ],
-                "summary": "List all users",
-                "parameters": [
-                    {
-                        "type": "string",
-                        "description": "used for pagination.",
-                        "name": "after",
-                        "in": "query"
+                        "Jwt": []
                     }
                 ],
-                "responses": {
-                    "200": {
-                        "description": "OK",
-                        "schema": {
-                            "$ref": "#/definitions/main.Page-models_User"
-                        }
-                    },
-                    "422": {
-                        "description": "Invalid after id",
-                        "schema": {
-                            "$ref": "#/definitions/main.KError"
-                        }
-                    }
-                }
-            },
-            "post": {
-                "description": "Register as a new user and open a session for it",
+                "description": "Add the signature as a ` + "`" + `x-presign` + "`" + ` query parameter. Use ` + "`" + `maxUses` + "`" + ` or ` + "`" + `singleUse` + "`" + ` to limit the number\nof requests the signature can be used for.",
                 "consumes": [
                     "application/json"
                 ],
@@ -724,47 +840,41 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "users"
+                    "jwt"
                 ],
-                "summary": "Register",
+                "summary": "Presign a group of urls",
                 "parameters": [
                     {
-                        "type": "string",
-                        "example": "android",
-                        "description": "The device the created session will be used on",
-                        "name": "device",
-                        "in": "query"
-                    },
-                    {
-                        "description": "Registration informations",
-                        "name": "user",
+                        "description": "The requests to presign",
+                        "name": "presign",
                         "in": "body",
+                        "required": true,
                         "schema": {
-                            "$ref": "#/definitions/models.RegisterDto"
+                            "$ref": "#/definitions/models.PresignRequest"
                         }
                     }
                 ],
                 "responses": {
-                    "201": {
-                        "description": "Created",
+                    "200": {
+                        "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/main.SessionWToken"
+                            "$ref": "#/definitions/models.Presign"
                         }
                     },
-                    "403": {
-                        "description": "Registrations are disabled",
+                    "400": {
+                        "description": "Invalid parameters",
                         "schema": {
                             "$ref": "#/definitions/main.KError"
                         }
                     },
-                    "409": {
-                        "description": "Duplicated email or username",
+                    "401": {
+                        "description": "Not logged in",
                         "schema": {
                             "$ref": "#/definitions/main.KError"
                         }
                     },
                     "422": {
-                        "description": "Invalid register body",
+                        "description": "Invalid body or unknown permissions",
                         "schema": {
                             "$ref": "#/definitions/main.KError"
                         }
@@ -772,110 +882,109 @@ const docTemplate = `{
                 }
             }
         },
-        "/users/me": {
-            "get": {
+        "/presign/{id}": {
+            "delete": {
                 "security": [
                     {
                         "Jwt": []
                     }
                 ],
-                "description": "Get informations about the currently connected user",
+                "description": "Revoke a presigned signature you created, it can't be used anymore.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "users"
+                    "jwt"
+                ],
+                "summary": "Revoke presign",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "format": "uuid",
+                        "description": "The id of the presign to revoke",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
                 ],
-                "summary": "Get me",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/models.User"
+                            "$ref": "#/definitions/models.ActivePresign"
                         }
                     },
                     "401": {
-                        "description": "Missing jwt token",
+                        "description": "Not logged in",
                         "schema": {
                             "$ref": "#/definitions/main.KError"
                         }
                     },
-                    "403": {
-                        "description": "Invalid jwt token (or expired)",
+                    "404": {
+                        "description": "No presign found with this id",
                         "schema": {
                             "$ref": "#/definitions/main.KError"
                         }
-                    }
-                }
-            },
-            "delete": {
-                "security": [
-                    {
-                        "Jwt": []
-                    }
-                ],
-                "description": "Delete your account and all your sessions",
-                "consumes": [
-                    "application/json"
-                ],
-                "produces": [
-                    "application/json"
-                ],
-                "tags": [
-                    "users"
-                ],
-                "summary": "Delete self",
-                "responses": {
-                    "200": {
-                        "description": "OK",
+                    },
+                    "422": {
+                        "description": "Invalid id format",
                         "schema": {
-                            "$ref": "#/definitions/models.User"
+                            "$ref": "#/definitions/main.KError"
                         }
                     }
                 }
-            },
-            "patch": {
+            }
+        },
+        "/revoke": {
+            "post": {
                 "security": [
                     {
-                        "Jwt": []
+                        "Jwt": [
+                            "tokens.revoke"
+                        ]
                     }
                 ],
-                "description": "Edit your account's info",
+                "description": "Revoke a session token or an api key by value (see [RFC 7009](https://www.rfc-editor.org/rfc/rfc7009)).\nUnknown or already revoked tokens are ignored. Jwts are short lived and can't be revoked,\nrevoke the session token (or api key) used to create them instead.\nOnly api keys \u0026 client certificates can call this route, users can't (even with the permission).",
                 "consumes": [
+                    "application/x-www-form-urlencoded",
                     "application/json"
                 ],
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "users"
+                    "jwt"
                 ],
-                "summary": "Edit self",
+                "summary": "Revoke token",
                 "parameters": [
                     {
-                        "description": "Edited user info",
-                        "name": "user",
+                        "description": "Token to revoke",
+                        "name": "token",
                         "in": "body",
+                        "required": true,
                         "schema": {
-                            "$ref": "#/definitions/models.EditUserDto"
+                            "$ref": "#/definitions/main.TokenDto"
                         }
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "OK",
+                        "description": "OK"
+                    },
+                    "400": {
+                        "description": "Jwts or api keys defined in the environment can't be revoked",
                         "schema": {
-                            "$ref": "#/definitions/models.User"
+                            "$ref": "#/definitions/main.KError"
                         }
                     },
                     "403": {
-                        "description": "You can't edit a protected claim",
+                        "description": "Missing permissions: tokens.revoke. Or the caller is an user.",
                         "schema": {
                             "$ref": "#/definitions/main.KError"
                         }
                     },
                     "422": {
-                        "description": "Invalid body",
+                        "description": "Missing token",
                         "schema": {
                             "$ref": "#/definitions/main.KError"
                         }
@@ -883,121 +992,242 @@ const docTemplate = `{
                 }
             }
         },
-        "/users/me/logo": {
-            "get": {
-                "security": [
-                    {
-                        "Jwt": []
-                    }
-                ],
-                "description": "Get the current user's logo (manual upload if available, gravatar otherwise)",
-                "produces": [
-                    "image/*"
+        "/saml/acs/{provider}": {
+            "post": {
+                "description": "Endpoint the IdP posts its response to (don't call it manually). The response's signature,\naudience, destination and validity are checked before redirecting to the app like /oidc/logged.",
+                "consumes": [
+                    "application/x-www-form-urlencoded"
                 ],
                 "tags": [
-                    "users"
+                    "oidc"
                 ],
-                "summary": "Get my logo",
-                "responses": {
-                    "200": {
-                        "description": "OK",
-                        "schema": {
-                            "type": "file"
-                        }
+                "summary": "SAML assertion consumer",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "example": "adfs",
+                        "description": "SAML provider id",
+                        "name": "provider",
+                        "in": "path",
+                        "required": true
                     },
-                    "401": {
-                        "description": "Missing jwt token",
-                        "schema": {
-                            "$ref": "#/definitions/main.KError"
-                        }
+                    {
+                        "type": "string",
+                        "description": "Base64 encoded SAML response",
+                        "name": "SAMLResponse",
+                        "in": "formData",
+                        "required": true
                     },
-                    "403": {
-                        "description": "Invalid jwt token (or expired)",
-                        "schema": {
-                            "$ref": "#/definitions/main.KError"
-                        }
+                    {
+                        "type": "string",
+                        "description": "State sent with the authentication request",
+                        "name": "RelayState",
+                        "in": "formData",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "302": {
+                        "description": "Found"
                     },
                     "404": {
-                        "description": "No gravatar image found for this user",
+                        "description": "Unknown SAML provider or login state",
                         "schema": {
                             "$ref": "#/definitions/main.KError"
                         }
                     }
                 }
-            },
-            "post": {
-                "security": [
-                    {
-                        "Jwt": []
-                    }
-                ],
-                "description": "Upload a manual profile picture for the current user",
-                "consumes": [
-                    "multipart/form-data"
-                ],
+            }
+        },
+        "/saml/callback/{provider}": {
+            "get": {
+                "description": "Exchange an opaque SAML token for a local session. This works like the OIDC callback.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "users"
+                    "oidc"
                 ],
-                "summary": "Upload my logo",
+                "summary": "SAML callback",
                 "parameters": [
                     {
-                        "type": "file",
-                        "description": "Profile picture image (jpeg/png/gif/webp, max 5MB)",
-                        "name": "logo",
-                        "in": "formData",
+                        "type": "string",
+                        "example": "adfs",
+                        "description": "SAML provider id",
+                        "name": "provider",
+                        "in": "path",
                         "required": true
-                    }
-                ],
-                "responses": {
-                    "204": {
-                        "description": "No Content"
                     },
-                    "401": {
-                        "description": "Missing jwt token",
-                        "schema": {
-                            "$ref": "#/definitions/main.KError"
+                    {
+                        "type": "string",
+                        "description": "Opaque token returned by /saml/acs/:provider",
+                        "name": "token",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Optional tenant passthrough for federated setups",
+                        "name": "tenant",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "android tv",
+                        "description": "The device the created session will be used on",
+                        "name": "device",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Rotate the session token each time it's exchanged for a jwt",
+                        "name": "rotate",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Bearer token to link provider to current account",
+                        "name": "Authorization",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/models.SessionWToken"
                         }
                     },
-                    "403": {
-                        "description": "Invalid jwt token (or expired)",
+                    "404": {
+                        "description": "Unknown SAML provider",
                         "schema": {
                             "$ref": "#/definitions/main.KError"
                         }
                     },
-                    "413": {
-                        "description": "File too large",
+                    "410": {
+                        "description": "Login token expired or already used",
                         "schema": {
                             "$ref": "#/definitions/main.KError"
                         }
+                    }
+                }
+            }
+        },
+        "/saml/login/{provider}": {
+            "get": {
+                "description": "Start a SAML login with an IdP. This works like the OIDC login.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "oidc"
+                ],
+                "summary": "SAML login",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "example": "adfs",
+                        "description": "SAML provider id",
+                        "name": "provider",
+                        "in": "path",
+                        "required": true
                     },
-                    "422": {
-                        "description": "Missing or invalid logo file",
+                    {
+                        "type": "string",
+                        "description": "URL to redirect the browser to after the IdP's response",
+                        "name": "redirectUrl",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Optional tenant passthrough for federated setups",
+                        "name": "tenant",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "302": {
+                        "description": "Found"
+                    },
+                    "400": {
+                        "description": "Missing redirectUrl",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    },
+                    "404": {
+                        "description": "Unknown SAML provider",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    },
+                    "502": {
+                        "description": "Could not load the IdP metadata",
                         "schema": {
                             "$ref": "#/definitions/main.KError"
                         }
                     }
                 }
-            },
-            "delete": {
+            }
+        },
+        "/saml/metadata/{provider}": {
+            "get": {
+                "description": "Service provider metadata to register keibi in your SAML IdP.",
+                "produces": [
+                    "text/xml"
+                ],
+                "tags": [
+                    "oidc"
+                ],
+                "summary": "SAML metadata",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "example": "adfs",
+                        "description": "SAML provider id",
+                        "name": "provider",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    },
+                    "404": {
+                        "description": "Unknown SAML provider",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    }
+                }
+            }
+        },
+        "/sessions": {
+            "get": {
                 "security": [
                     {
                         "Jwt": []
                     }
                 ],
-                "description": "Delete the current user's manually uploaded profile picture",
+                "description": "List all active sessions for the currently connected user",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "users"
+                    "sessions"
                 ],
-                "summary": "Delete my logo",
+                "summary": "List my sessions",
                 "responses": {
-                    "204": {
-                        "description": "No Content"
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.SessionWCurrent"
+                            }
+                        }
                     },
                     "401": {
                         "description": "Missing jwt token",
@@ -1012,16 +1242,9 @@ const docTemplate = `{
                         }
                     }
                 }
-            }
-        },
-        "/users/me/password": {
-            "patch": {
-                "security": [
-                    {
-                        "Jwt": []
-                    }
-                ],
-                "description": "Edit your password",
+            },
+            "post": {
+                "description": "Login to your account and open a session",
                 "consumes": [
                     "application/json"
                 ],
@@ -1029,32 +1252,59 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "users"
+                    "sessions"
                 ],
-                "summary": "Edit password",
+                "summary": "Login",
                 "parameters": [
+                    {
+                        "type": "string",
+                        "example": "android tv",
+                        "description": "The device the created session will be used on",
+                        "name": "device",
+                        "in": "query"
+                    },
                     {
                         "type": "boolean",
-                        "default": true,
-                        "description": "Invalidate other sessions",
-                        "name": "invalidate",
+                        "description": "Rotate the session token each time it's exchanged for a jwt",
+                        "name": "rotate",
                         "in": "query"
                     },
                     {
-                        "description": "New password",
-                        "name": "user",
+                        "description": "Account informations",
+                        "name": "login",
                         "in": "body",
                         "schema": {
-                            "$ref": "#/definitions/models.EditPasswordDto"
+                            "$ref": "#/definitions/models.LoginDto"
                         }
                     }
                 ],
                 "responses": {
-                    "204": {
-                        "description": "No Content"
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/models.SessionWToken"
+                        }
+                    },
+                    "403": {
+                        "description": "Invalid password or challenge solution (or a reported login requires a password change)",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    },
+                    "404": {
+                        "description": "Account does not exists",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
                     },
                     "422": {
-                        "description": "Invalid body",
+                        "description": "User does not have a password (registered via oidc, please login via oidc)",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    },
+                    "428": {
+                        "description": "Too many failed logins, a challenge must be solved (see /info)",
                         "schema": {
                             "$ref": "#/definitions/main.KError"
                         }
@@ -1062,33 +1312,42 @@ const docTemplate = `{
                 }
             }
         },
-        "/users/me/{id}": {
+        "/sessions/current": {
             "delete": {
                 "security": [
                     {
                         "Jwt": []
                     }
                 ],
-                "description": "Delete the user's manually uploaded profile picture",
+                "description": "Delete a session and logout. If the session was created via an OIDC provider that supports it,\n` + "`" + `logoutUrl` + "`" + ` can be opened to also end the session on the provider.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "users"
+                    "sessions"
                 ],
-                "summary": "Delete user logo",
+                "summary": "Logout",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "The id or username of the user",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
+                        "example": "https://kyoo.zoriya.dev/login",
+                        "description": "Url the provider redirects to after its logout",
+                        "name": "redirectUrl",
+                        "in": "query"
                     }
                 ],
                 "responses": {
-                    "204": {
-                        "description": "No Content"
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.SessionWLogout"
+                        }
+                    },
+                    "400": {
+                        "description": "Unauthorized redirectUrl",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
                     },
                     "401": {
                         "description": "Missing jwt token",
@@ -1105,63 +1364,39 @@ const docTemplate = `{
                 }
             }
         },
-        "/users/{id}": {
+        "/sessions/magic-link": {
             "get": {
-                "security": [
-                    {
-                        "Jwt": [
-                            "users.read"
-                        ]
-                    }
-                ],
-                "description": "Get informations about a user from it's id",
+                "description": "Link sent by email (don't call it manually). It only shows a confirmation page (mail scanners open\nlinks on their own), the link is used when the page is submitted.",
                 "produces": [
-                    "application/json"
+                    "text/html"
                 ],
                 "tags": [
-                    "users"
+                    "sessions"
                 ],
-                "summary": "Get user",
+                "summary": "Open a magic link",
                 "parameters": [
                     {
                         "type": "string",
-                        "format": "uuid",
-                        "description": "The id of the user",
-                        "name": "id",
-                        "in": "path",
+                        "description": "Token of the link",
+                        "name": "token",
+                        "in": "query",
                         "required": true
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "OK",
-                        "schema": {
-                            "$ref": "#/definitions/models.User"
-                        }
-                    },
-                    "404": {
-                        "description": "No user with the given id found",
-                        "schema": {
-                            "$ref": "#/definitions/main.KError"
-                        }
+                        "description": "Confirmation page"
                     },
-                    "422": {
-                        "description": "Invalid id (not a uuid)",
+                    "410": {
+                        "description": "Link expired or already used",
                         "schema": {
                             "$ref": "#/definitions/main.KError"
                         }
                     }
                 }
             },
-            "delete": {
-                "security": [
-                    {
-                        "Jwt": [
-                            "users.delete"
-                        ]
-                    }
-                ],
-                "description": "Delete an account and all it's sessions.",
+            "post": {
+                "description": "Email a single-use login link. The response is the same whether an account uses the email or not.",
                 "consumes": [
                     "application/json"
                 ],
@@ -1169,48 +1404,148 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "users"
+                    "sessions"
                 ],
-                "summary": "Delete user",
+                "summary": "Request a magic link",
                 "parameters": [
                     {
-                        "type": "string",
-                        "format": "uuid",
-                        "description": "User id of the user to delete",
-                        "name": "id",
-                        "in": "path"
+                        "description": "Email \u0026 optional redirect url",
+                        "name": "link",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.MagicLinkDto"
+                        }
                     }
                 ],
                 "responses": {
-                    "200": {
-                        "description": "OK",
+                    "202": {
+                        "description": "Accepted",
                         "schema": {
-                            "$ref": "#/definitions/models.User"
+                            "$ref": "#/definitions/main.MagicLink"
                         }
                     },
-                    "404": {
-                        "description": "Invalid user id",
+                    "400": {
+                        "description": "Unauthorized redirectUrl",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    },
+                    "403": {
+                        "description": "Magic links are disabled",
                         "schema": {
                             "$ref": "#/definitions/main.KError"
                         }
                     },
                     "422": {
-                        "description": "Invalid id format",
+                        "description": "Invalid body",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many links sent to this address",
                         "schema": {
                             "$ref": "#/definitions/main.KError"
                         }
                     }
                 }
-            },
-            "patch": {
-                "security": [
+            }
+        },
+        "/sessions/magic-link/callback": {
+            "post": {
+                "description": "Exchange the opaque token given to a magic link's redirect url for a session.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "sessions"
+                ],
+                "summary": "Magic link callback",
+                "parameters": [
                     {
-                        "Jwt": [
-                            "users.write"
-                        ]
+                        "description": "Opaque token given to the redirect url",
+                        "name": "token",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.MagicLinkCallbackDto"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "example": "android tv",
+                        "description": "The device the created session will be used on",
+                        "name": "device",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Rotate the session token each time it's exchanged for a jwt",
+                        "name": "rotate",
+                        "in": "query"
                     }
                 ],
-                "description": "Edit an account info or permissions",
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/models.SessionWToken"
+                        }
+                    },
+                    "410": {
+                        "description": "Token expired or already used",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    }
+                }
+            }
+        },
+        "/sessions/magic-link/open": {
+            "post": {
+                "description": "Submitted by the page of /sessions/magic-link (don't call it manually). It redirects to the requested\nurl with an opaque token (` + "`" + `?token=` + "`" + `) to exchange via /sessions/magic-link/callback, or shows a code\nto type on the device that asked for the link.",
+                "consumes": [
+                    "application/x-www-form-urlencoded"
+                ],
+                "produces": [
+                    "text/html"
+                ],
+                "tags": [
+                    "sessions"
+                ],
+                "summary": "Use a magic link",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Token of the link",
+                        "name": "token",
+                        "in": "formData",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Page showing a login code"
+                    },
+                    "302": {
+                        "description": "Redirect with an opaque token"
+                    },
+                    "410": {
+                        "description": "Link expired or already used",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    }
+                }
+            }
+        },
+        "/sessions/magic-link/{id}": {
+            "post": {
+                "description": "Exchange the code shown when opening a magic link (requested without redirect url) for a session.",
                 "consumes": [
                     "application/json"
                 ],
@@ -1218,41 +1553,44 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "users"
+                    "sessions"
                 ],
-                "summary": "Edit user",
+                "summary": "Login with a magic link code",
                 "parameters": [
                     {
                         "type": "string",
                         "format": "uuid",
-                        "description": "User id of the user to edit",
+                        "description": "Id returned when requesting the link",
                         "name": "id",
-                        "in": "path"
+                        "in": "path",
+                        "required": true
                     },
                     {
-                        "description": "Edited user info",
-                        "name": "user",
+                        "description": "Code shown by the link",
+                        "name": "code",
                         "in": "body",
+                        "required": true,
                         "schema": {
-                            "$ref": "#/definitions/models.EditUserDto"
+                            "$ref": "#/definitions/main.MagicLinkCodeDto"
                         }
+                    },
+                    {
+                        "type": "string",
+                        "example": "android tv",
+                        "description": "The device the created session will be used on",
+                        "name": "device",
+                        "in": "query"
                     }
                 ],
                 "responses": {
-                    "200": {
-                        "description": "OK",
+                    "201": {
+                        "description": "Created",
                         "schema": {
-                            "$ref": "#/definitions/models.User"
+                            "$ref": "#/definitions/models.SessionWToken"
                         }
                     },
                     "403": {
-                        "description": "You don't have permissions to edit another account",
-                        "schema": {
-                            "$ref": "#/definitions/main.KError"
-                        }
-                    },
-                    "422": {
-                        "description": "Invalid body",
+                        "description": "Invalid or expired code",
                         "schema": {
                             "$ref": "#/definitions/main.KError"
                         }
@@ -1260,41 +1598,113 @@ const docTemplate = `{
                 }
             }
         },
-        "/users/{id}/logo": {
+        "/sessions/not-me": {
             "get": {
-                "security": [
+                "description": "Link sent by login alerts (don't call it manually). It only shows a confirmation page, since mail\nscanners open links: the report is done by the page's form.",
+                "produces": [
+                    "text/html"
+                ],
+                "tags": [
+                    "sessions"
+                ],
+                "summary": "Report a login",
+                "parameters": [
                     {
-                        "Jwt": [
-                            "users.read"
-                        ]
+                        "type": "string",
+                        "description": "Token of the alert",
+                        "name": "token",
+                        "in": "query",
+                        "required": true
                     }
                 ],
-                "description": "Get a user's logo (manual upload if available, gravatar otherwise)",
+                "responses": {
+                    "200": {
+                        "description": "Confirmation page"
+                    },
+                    "410": {
+                        "description": "Link expired or already used",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Submitted by the page of /sessions/not-me (don't call it manually). It logs out every session of\nthe user and blocks logins with the current password until a new one is chosen.",
+                "consumes": [
+                    "application/x-www-form-urlencoded"
+                ],
                 "produces": [
-                    "image/*"
+                    "text/html"
                 ],
                 "tags": [
-                    "users"
+                    "sessions"
                 ],
-                "summary": "Get user logo",
+                "summary": "Confirm a login report",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "The id or username of the user",
-                        "name": "id",
-                        "in": "path",
+                        "description": "Token of the alert",
+                        "name": "token",
+                        "in": "formData",
                         "required": true
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "OK",
+                        "description": "Page to choose a new password"
+                    },
+                    "410": {
+                        "description": "Link expired or already used",
                         "schema": {
-                            "type": "file"
+                            "$ref": "#/definitions/main.KError"
                         }
+                    }
+                }
+            }
+        },
+        "/sessions/not-me/password": {
+            "post": {
+                "description": "Submitted by the page of /sessions/not-me (don't call it manually). The alert's token alone is not\nenough, the current password is also required.",
+                "consumes": [
+                    "application/x-www-form-urlencoded"
+                ],
+                "produces": [
+                    "text/html"
+                ],
+                "tags": [
+                    "sessions"
+                ],
+                "summary": "Reset password after a reported login",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Token of the alert",
+                        "name": "token",
+                        "in": "formData",
+                        "required": true
                     },
-                    "404": {
-                        "description": "No gravatar image found for this user",
+                    {
+                        "type": "string",
+                        "description": "Current password",
+                        "name": "oldPassword",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "New password",
+                        "name": "password",
+                        "in": "formData",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Password changed"
+                    },
+                    "410": {
+                        "description": "Link expired or already used",
                         "schema": {
                             "$ref": "#/definitions/main.KError"
                         }
@@ -1302,26 +1712,27 @@ const docTemplate = `{
                 }
             }
         },
-        "/users/{id}/sessions": {
-            "get": {
+        "/sessions/{id}": {
+            "delete": {
                 "security": [
                     {
                         "Jwt": []
                     }
                 ],
-                "description": "List all active sessions for a user. Listing someone else's sessions requires users.read.",
+                "description": "Delete a session and logout",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
                     "sessions"
                 ],
-                "summary": "List user sessions",
+                "summary": "Delete other session",
                 "parameters": [
                     {
                         "type": "string",
+                        "format": "uuid",
                         "example": "e05089d6-9179-4b5b-a63e-94dd5fc2a397",
-                        "description": "The id or username of the user",
+                        "description": "The id of the session to delete",
                         "name": "id",
                         "in": "path",
                         "required": true
@@ -1331,28 +1742,1495 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/main.Session"
-                            }
+                            "$ref": "#/definitions/models.Session"
                         }
                     },
-                    "401": {
-                        "description": "Missing jwt token",
+                    "404": {
+                        "description": "Session not found with specified id (if not using the /current route)",
                         "schema": {
                             "$ref": "#/definitions/main.KError"
                         }
                     },
-                    "403": {
-                        "description": "Missing permissions: users.read.",
+                    "422": {
+                        "description": "Invalid session id",
                         "schema": {
                             "$ref": "#/definitions/main.KError"
                         }
-                    },
-                    "404": {
-                        "description": "No user found with id or username",
+                    }
+                }
+            }
+        },
+        "/settings/schema": {
+            "get": {
+                "description": "Json schema of user settings (types, allowed values \u0026 defaults).",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "settings"
+                ],
+                "summary": "Settings schema",
+                "responses": {
+                    "200": {
+                        "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/main.KError"
+                            "$ref": "#/definitions/main.SettingsSchema"
+                        }
+                    }
+                }
+            }
+        },
+        "/users": {
+            "get": {
+                "security": [
+                    {
+                        "Jwt": [
+                            "users.read"
+                        ]
+                    }
+                ],
+                "description": "List all users existing in this instance.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "List all users",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "used for pagination.",
+                        "name": "after",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.Page-models_User"
+                        }
+                    },
+                    "422": {
+                        "description": "Invalid after id",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Register as a new user and open a session for it",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Register",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "example": "android",
+                        "description": "The device the created session will be used on",
+                        "name": "device",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Rotate the session token each time it's exchanged for a jwt",
+                        "name": "rotate",
+                        "in": "query"
+                    },
+                    {
+                        "description": "Registration informations",
+                        "name": "user",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/models.RegisterDto"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/models.SessionWToken"
+                        }
+                    },
+                    "403": {
+                        "description": "Registrations are disabled or invalid challenge solution",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    },
+                    "409": {
+                        "description": "Duplicated email or username",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    },
+                    "422": {
+                        "description": "Invalid register body",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    },
+                    "428": {
+                        "description": "A challenge must be solved (see /info)",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/import": {
+            "post": {
+                "security": [
+                    {
+                        "Jwt": [
+                            "users.write"
+                        ]
+                    }
+                ],
+                "description": "Create users from another service (jellyfin, emby, plex...), keeping their password hashes.\nAccepts either a json array or a csv file (` + "`" + `Content-Type: text/csv` + "`" + `) with an\n` + "`" + `username,email,passwordHash` + "`" + ` header. Legacy hashes are replaced by argon2id on the next login.",
+                "consumes": [
+                    "application/json",
+                    "text/csv"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Import users",
+                "parameters": [
+                    {
+                        "description": "Users to import",
+                        "name": "users",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.ImportUserDto"
+                            }
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.ImportResult"
+                        }
+                    },
+                    "403": {
+                        "description": "Missing permissions: users.write.",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    },
+                    "422": {
+                        "description": "Invalid body",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/me": {
+            "get": {
+                "security": [
+                    {
+                        "Jwt": []
+                    }
+                ],
+                "description": "Get informations about the currently connected user",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Get me",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.Me"
+                        }
+                    },
+                    "401": {
+                        "description": "Missing jwt token",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    },
+                    "403": {
+                        "description": "Invalid jwt token (or expired)",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "Jwt": []
+                    }
+                ],
+                "description": "Delete your account and all your sessions. If the instance has a deletion delay, the account is\nonly scheduled for deletion and can be restored until ` + "`" + `deleteAt` + "`" + ` via DELETE /users/me/deletion.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Delete self",
+                "responses": {
+                    "200": {
+                        "description": "Account deleted",
+                        "schema": {
+                            "$ref": "#/definitions/models.User"
+                        }
+                    },
+                    "202": {
+                        "description": "Account scheduled for deletion",
+                        "schema": {
+                            "$ref": "#/definitions/models.User"
+                        }
+                    }
+                }
+            },
+            "patch": {
+                "security": [
+                    {
+                        "Jwt": []
+                    }
+                ],
+                "description": "Edit your account's info",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Edit self",
+                "parameters": [
+                    {
+                        "description": "Edited user info",
+                        "name": "user",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/models.EditUserDto"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.User"
+                        }
+                    },
+                    "403": {
+                        "description": "Claims can't be edited on yourself",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    },
+                    "422": {
+                        "description": "Invalid body",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/me/deletion": {
+            "delete": {
+                "security": [
+                    {
+                        "Jwt": []
+                    }
+                ],
+                "description": "Cancel a pending deletion of your account",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Cancel self deletion",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.User"
+                        }
+                    },
+                    "404": {
+                        "description": "No deletion pending for this account",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/me/export": {
+            "get": {
+                "security": [
+                    {
+                        "Jwt": []
+                    }
+                ],
+                "description": "Export every personal data keibi stores about the current user.\nUse ` + "`" + `format=zip` + "`" + ` to also retrieve the uploaded profile picture.",
+                "produces": [
+                    "application/json",
+                    "application/zip"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Export my data",
+                "parameters": [
+                    {
+                        "enum": [
+                            "json",
+                            "zip"
+                        ],
+                        "type": "string",
+                        "default": "json",
+                        "description": "Export format",
+                        "name": "format",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.UserExport"
+                        }
+                    },
+                    "401": {
+                        "description": "Missing jwt token",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    },
+                    "422": {
+                        "description": "Invalid format",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/me/logo": {
+            "get": {
+                "security": [
+                    {
+                        "Jwt": []
+                    }
+                ],
+                "description": "Get the current user's logo (manual upload if available, gravatar otherwise)",
+                "produces": [
+                    "image/*"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Get my logo",
+                "parameters": [
+                    {
+                        "enum": [
+                            32,
+                            64,
+                            128,
+                            256,
+                            512
+                        ],
+                        "type": "integer",
+                        "description": "Size (in pixels) of the returned square image, defaults to the stored one (max 512)",
+                        "name": "size",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "304": {
+                        "description": "Logo not modified (via If-None-Match or If-Modified-Since)"
+                    },
+                    "401": {
+                        "description": "Missing jwt token",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    },
+                    "403": {
+                        "description": "Invalid jwt token (or expired)",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    },
+                    "404": {
+                        "description": "No gravatar image found for this user",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    },
+                    "422": {
+                        "description": "Invalid size",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "Jwt": []
+                    }
+                ],
+                "description": "Upload a manual profile picture for the current user. The image is cropped to a centered square,\ndownscaled to 512x512 and stored as a png (metadata like exif are removed).",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Upload my logo",
+                "parameters": [
+                    {
+                        "type": "file",
+                        "description": "Profile picture image (jpeg/png/gif/webp, max 5MB)",
+                        "name": "logo",
+                        "in": "formData",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "401": {
+                        "description": "Missing jwt token",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    },
+                    "403": {
+                        "description": "Invalid jwt token (or expired)",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    },
+                    "413": {
+                        "description": "File too large",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    },
+                    "422": {
+                        "description": "Missing or invalid logo file",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "Jwt": []
+                    }
+                ],
+                "description": "Delete the current user's manually uploaded profile picture",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Delete my logo",
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "401": {
+                        "description": "Missing jwt token",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    },
+                    "403": {
+                        "description": "Invalid jwt token (or expired)",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/me/password": {
+            "patch": {
+                "security": [
+                    {
+                        "Jwt": []
+                    }
+                ],
+                "description": "Edit your password",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Edit password",
+                "parameters": [
+                    {
+                        "type": "boolean",
+                        "default": true,
+                        "description": "Invalidate other sessions",
+                        "name": "invalidate",
+                        "in": "query"
+                    },
+                    {
+                        "description": "New password",
+                        "name": "user",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/models.EditPasswordDto"
+                        }
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "422": {
+                        "description": "Invalid body",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/me/settings": {
+            "get": {
+                "security": [
+                    {
+                        "Jwt": []
+                    }
+                ],
+                "description": "Get your settings. Unlike claims, settings are not stored in jwts.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "settings"
+                ],
+                "summary": "Get my settings",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.UserSettings"
+                        }
+                    }
+                }
+            },
+            "patch": {
+                "security": [
+                    {
+                        "Jwt": []
+                    }
+                ],
+                "description": "Edit your settings with a json merge patch (RFC 7396): objects are merged and null resets a\nsetting to its default. Use ` + "`" + `device=true` + "`" + ` to override settings only for the current session.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "settings"
+                ],
+                "summary": "Edit my settings",
+                "parameters": [
+                    {
+                        "type": "boolean",
+                        "description": "Edit the current device's overrides instead of the account's settings",
+                        "name": "device",
+                        "in": "query"
+                    },
+                    {
+                        "description": "Settings to change",
+                        "name": "settings",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.UserSettings"
+                        }
+                    },
+                    "403": {
+                        "description": "Settings can only be edited by users (or device settings without a session)",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    },
+                    "422": {
+                        "description": "Unknown setting or invalid value",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/me/tokens": {
+            "get": {
+                "security": [
+                    {
+                        "Jwt": []
+                    }
+                ],
+                "description": "List the api keys bound to your user",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "apikeys"
+                ],
+                "summary": "List personal tokens",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.Page-models_ApiKey"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "Jwt": []
+                    }
+                ],
+                "description": "Create an api key bound to your user (for scripts or plugins). It acts as you and can't\nhave more permissions than you (now or after your permissions are changed).",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "apikeys"
+                ],
+                "summary": "Create personal token",
+                "parameters": [
+                    {
+                        "description": "Token info",
+                        "name": "token",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/models.PersonalTokenDto"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/models.ApiKeyWToken"
+                        }
+                    },
+                    "403": {
+                        "description": "Trying to grant permissions you don't have",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    },
+                    "409": {
+                        "description": "Duplicated token name",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    },
+                    "422": {
+                        "description": "Invalid create body or unknown permissions",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/me/tokens/{id}": {
+            "delete": {
+                "security": [
+                    {
+                        "Jwt": []
+                    }
+                ],
+                "description": "Delete one of your personal tokens",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "apikeys"
+                ],
+                "summary": "Delete personal token",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "format": "uuid",
+                        "description": "The id of the token to delete",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.ApiKey"
+                        }
+                    },
+                    "404": {
+                        "description": "Invalid id",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    },
+                    "422": {
+                        "description": "Invalid id format",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "Jwt": [
+                            "users.read"
+                        ]
+                    }
+                ],
+                "description": "Get informations about a user from it's id",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Get user",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "format": "uuid",
+                        "description": "The id of the user",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.User"
+                        }
+                    },
+                    "404": {
+                        "description": "No user with the given id found",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    },
+                    "422": {
+                        "description": "Invalid id (not a uuid)",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "Jwt": [
+                            "users.delete"
+                        ]
+                    }
+                ],
+                "description": "Delete an account and all it's sessions.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Delete user",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "format": "uuid",
+                        "description": "User id of the user to delete",
+                        "name": "id",
+                        "in": "path"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.User"
+                        }
+                    },
+                    "404": {
+                        "description": "Invalid user id",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    },
+                    "422": {
+                        "description": "Invalid id format",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    }
+                }
+            },
+            "patch": {
+                "security": [
+                    {
+                        "Jwt": [
+                            "users.write"
+                        ]
+                    }
+                ],
+                "description": "Edit an account info or permissions",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Edit user",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "format": "uuid",
+                        "description": "User id of the user to edit",
+                        "name": "id",
+                        "in": "path"
+                    },
+                    {
+                        "description": "Edited user info",
+                        "name": "user",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/models.EditUserDto"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.User"
+                        }
+                    },
+                    "403": {
+                        "description": "You don't have permissions to edit another account",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    },
+                    "422": {
+                        "description": "Invalid body or unknown permissions",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/{id}/impersonate": {
+            "post": {
+                "security": [
+                    {
+                        "Jwt": [
+                            "users.impersonate"
+                        ]
+                    }
+                ],
+                "description": "Create a short lived jwt to view the app as another user (for debugging purposes).\nThe jwt has an ` + "`" + `act` + "`" + ` claim (RFC 8693) identifying you and only keeps the read permissions of the user\nthat you also have.\nIt is rejected by keibi's write routes and /jwt never extends its expiration. Each impersonation is visible in\nthe user's session list and audit log.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Impersonate user",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The id or username of the user to impersonate",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Impersonation settings",
+                        "name": "body",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/main.ImpersonateDto"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/models.Jwt"
+                        }
+                    },
+                    "403": {
+                        "description": "Missing permissions: users.impersonate.",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    },
+                    "404": {
+                        "description": "No user found with id or username",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    },
+                    "422": {
+                        "description": "Invalid duration or trying to impersonate yourself",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/{id}/logo": {
+            "get": {
+                "security": [
+                    {
+                        "Jwt": [
+                            "users.read"
+                        ]
+                    }
+                ],
+                "description": "Get a user's logo (manual upload if available, gravatar otherwise)",
+                "produces": [
+                    "image/*"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Get user logo",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The id or username of the user",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "enum": [
+                            32,
+                            64,
+                            128,
+                            256,
+                            512
+                        ],
+                        "type": "integer",
+                        "description": "Size (in pixels) of the returned square image, defaults to the stored one (max 512)",
+                        "name": "size",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "304": {
+                        "description": "Logo not modified (via If-None-Match or If-Modified-Since)"
+                    },
+                    "404": {
+                        "description": "No gravatar image found for this user",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    },
+                    "422": {
+                        "description": "Invalid size",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "Jwt": [
+                            "users.write"
+                        ]
+                    }
+                ],
+                "description": "Delete the user's manually uploaded profile picture",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Delete user logo",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The id or username of the user",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "401": {
+                        "description": "Missing jwt token",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    },
+                    "403": {
+                        "description": "Invalid jwt token (or expired)",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    },
+                    "404": {
+                        "description": "User does not have a custom profile picture",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/{id}/sessions": {
+            "get": {
+                "security": [
+                    {
+                        "Jwt": []
+                    }
+                ],
+                "description": "List all active sessions for a user. Listing someone else's sessions requires users.read.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "sessions"
+                ],
+                "summary": "List user sessions",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "example": "e05089d6-9179-4b5b-a63e-94dd5fc2a397",
+                        "description": "The id or username of the user",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.Session"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Missing jwt token",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    },
+                    "403": {
+                        "description": "Missing permissions: users.read.",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    },
+                    "404": {
+                        "description": "No user found with id or username",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/{id}/settings": {
+            "get": {
+                "security": [
+                    {
+                        "Jwt": [
+                            "users.read"
+                        ]
+                    }
+                ],
+                "description": "Get the settings of a user (without device overrides).",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "settings"
+                ],
+                "summary": "Get user settings",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "format": "uuid",
+                        "description": "The id of the user",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.UserSettings"
+                        }
+                    },
+                    "404": {
+                        "description": "No user with the given id found",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    }
+                }
+            }
+        },
+        "/webhooks": {
+            "get": {
+                "security": [
+                    {
+                        "Jwt": [
+                            "webhooks.read"
+                        ]
+                    }
+                ],
+                "description": "List every webhook configured on this instance",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "List webhooks",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.Webhook"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Missing permissions: webhooks.read.",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "Jwt": [
+                            "webhooks.write"
+                        ]
+                    }
+                ],
+                "description": "Register a new webhook. Each event is sent as a POST request signed with an HMAC-SHA256 of\n` + "`" + `{X-Keibi-Timestamp}.{body}` + "`" + ` in the ` + "`" + `X-Keibi-Signature` + "`" + ` header.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "Create webhook",
+                "parameters": [
+                    {
+                        "description": "Webhook settings",
+                        "name": "webhook",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.WebhookDto"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/main.WebhookWSecret"
+                        }
+                    },
+                    "403": {
+                        "description": "Missing permissions: webhooks.write.",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    },
+                    "422": {
+                        "description": "Invalid body",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    }
+                }
+            }
+        },
+        "/webhooks/{id}": {
+            "delete": {
+                "security": [
+                    {
+                        "Jwt": [
+                            "webhooks.write"
+                        ]
+                    }
+                ],
+                "description": "Delete a webhook and its delivery log",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "Delete webhook",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "format": "uuid",
+                        "description": "Id of the webhook",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Webhook"
+                        }
+                    },
+                    "404": {
+                        "description": "No webhook found with this id",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    },
+                    "422": {
+                        "description": "Invalid id format",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    }
+                }
+            },
+            "patch": {
+                "security": [
+                    {
+                        "Jwt": [
+                            "webhooks.write"
+                        ]
+                    }
+                ],
+                "description": "Edit, disable or rotate the secret of a webhook",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "Edit webhook",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "format": "uuid",
+                        "description": "Id of the webhook",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Edited settings",
+                        "name": "webhook",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.EditWebhookDto"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Webhook"
+                        }
+                    },
+                    "404": {
+                        "description": "No webhook found with this id",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    },
+                    "422": {
+                        "description": "Invalid body",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    }
+                }
+            }
+        },
+        "/webhooks/{id}/deliveries": {
+            "get": {
+                "security": [
+                    {
+                        "Jwt": [
+                            "webhooks.read"
+                        ]
+                    }
+                ],
+                "description": "List the last deliveries (and their status) of a webhook",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "List webhook deliveries",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "format": "uuid",
+                        "description": "Id of the webhook",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.WebhookDelivery"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "No webhook with the given id",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
+                        }
+                    },
+                    "422": {
+                        "description": "Invalid id format",
+                        "schema": {
+                            "$ref": "#/definitions/main.KError"
                         }
                     }
                 }
@@ -1360,7 +3238,1295 @@ const docTemplate = `{
         }
     },
     "definitions": {
-        "main.ApiKey": {
+        "main.AuditLog": {
+            "type": "object",
+            "properties": {
+                "action": {
+                    "description": "What happened on the account.",
+                    "type": "string",
+                    "example": "session.created"
+                },
+                "createdAt": {
+                    "description": "When did it happen?",
+                    "type": "string",
+                    "example": "2025-03-29T18:20:05.267Z"
+                },
+                "details": {
+                    "description": "Extra information about the action (device, session id...)."
+                },
+                "id": {
+                    "description": "Unique id of this entry.",
+                    "type": "string",
+                    "example": "e05089d6-9179-4b5b-a63e-94dd5fc2a397"
+                }
+            }
+        },
+        "main.ChallengeConfig": {
+            "type": "object",
+            "properties": {
+                "afterFailedLogins": {
+                    "description": "Number of consecutive failed logins on an account after which logging in to it requires\nsolving a challenge. 0 if login never requires a challenge.",
+                    "type": "integer",
+                    "example": 3
+                },
+                "onRegister": {
+                    "description": "If true, registering requires solving a challenge.",
+                    "type": "boolean",
+                    "example": true
+                },
+                "provider": {
+                    "description": "Kind of challenge the frontend must solve.",
+                    "enum": [
+                        "pow",
+                        "hcaptcha",
+                        "turnstile"
+                    ],
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/main.ChallengeProvider"
+                        }
+                    ],
+                    "example": "pow"
+                },
+                "siteKey": {
+                    "description": "Public site key to use in the captcha widget (hcaptcha and turnstile only).",
+                    "type": "string",
+                    "example": "10000000-ffff-ffff-ffff-000000000001"
+                }
+            }
+        },
+        "main.ChallengeProvider": {
+            "type": "string",
+            "enum": [
+                "pow",
+                "hcaptcha",
+                "turnstile"
+            ],
+            "x-enum-varnames": [
+                "ChallengePow",
+                "ChallengeHCaptcha",
+                "ChallengeTurnstile"
+            ]
+        },
+        "main.CreateOidcProviderDto": {
+            "type": "object",
+            "required": [
+                "authorization",
+                "clientId",
+                "id",
+                "name",
+                "profile",
+                "secret",
+                "token"
+            ],
+            "properties": {
+                "authMethod": {
+                    "enum": [
+                        "ClientSecretBasic",
+                        "ClientSecretPost"
+                    ],
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/main.OidcAuthMethod"
+                        }
+                    ],
+                    "example": "ClientSecretBasic"
+                },
+                "authorization": {
+                    "type": "string",
+                    "example": "https://accounts.google.com/o/oauth2/auth"
+                },
+                "clientId": {
+                    "type": "string",
+                    "example": "xxx.apps.googleusercontent.com"
+                },
+                "enabled": {
+                    "type": "boolean"
+                },
+                "endSession": {
+                    "type": "string",
+                    "example": "https://accounts.google.com/logout"
+                },
+                "id": {
+                    "type": "string",
+                    "maxLength": 256,
+                    "example": "google"
+                },
+                "issuer": {
+                    "type": "string",
+                    "example": "https://accounts.google.com"
+                },
+                "jwks": {
+                    "type": "string",
+                    "example": "https://www.googleapis.com/oauth2/v3/certs"
+                },
+                "linkByEmail": {
+                    "type": "boolean"
+                },
+                "logo": {
+                    "type": "string",
+                    "example": "https://www.gstatic.com/marketing-cms/assets/images/d5/dc/cfe9ce8b4425b410b49b7f2dd3f3/g.webp=s200"
+                },
+                "name": {
+                    "type": "string",
+                    "maxLength": 256,
+                    "example": "Google"
+                },
+                "profile": {
+                    "type": "string",
+                    "example": "https://www.googleapis.com/oauth2/v2/userinfo"
+                },
+                "scope": {
+                    "type": "string",
+                    "example": "openid profile email"
+                },
+                "secret": {
+                    "type": "string",
+                    "example": "GOCSPX-xxx"
+                },
+                "token": {
+                    "type": "string",
+                    "example": "https://oauth2.googleapis.com/token"
+                }
+            }
+        },
+        "main.EditOidcProviderDto": {
+            "type": "object",
+            "properties": {
+                "authMethod": {
+                    "enum": [
+                        "ClientSecretBasic",
+                        "ClientSecretPost"
+                    ],
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/main.OidcAuthMethod"
+                        }
+                    ],
+                    "example": "ClientSecretBasic"
+                },
+                "authorization": {
+                    "type": "string",
+                    "example": "https://accounts.google.com/o/oauth2/auth"
+                },
+                "clientId": {
+                    "type": "string",
+                    "example": "xxx.apps.googleusercontent.com"
+                },
+                "enabled": {
+                    "type": "boolean"
+                },
+                "endSession": {
+                    "type": "string",
+                    "example": "https://accounts.google.com/logout"
+                },
+                "issuer": {
+                    "type": "string",
+                    "example": "https://accounts.google.com"
+                },
+                "jwks": {
+                    "type": "string",
+                    "example": "https://www.googleapis.com/oauth2/v3/certs"
+                },
+                "linkByEmail": {
+                    "type": "boolean"
+                },
+                "logo": {
+                    "type": "string",
+                    "example": "https://www.gstatic.com/marketing-cms/assets/images/d5/dc/cfe9ce8b4425b410b49b7f2dd3f3/g.webp=s200"
+                },
+                "name": {
+                    "type": "string",
+                    "maxLength": 256,
+                    "example": "Google"
+                },
+                "profile": {
+                    "type": "string",
+                    "example": "https://www.googleapis.com/oauth2/v2/userinfo"
+                },
+                "scope": {
+                    "type": "string",
+                    "example": "openid profile email"
+                },
+                "secret": {
+                    "type": "string",
+                    "example": "GOCSPX-xxx"
+                },
+                "token": {
+                    "type": "string",
+                    "example": "https://oauth2.googleapis.com/token"
+                }
+            }
+        },
+        "main.EditWebhookDto": {
+            "type": "object",
+            "properties": {
+                "enabled": {
+                    "type": "boolean"
+                },
+                "events": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "user.created",
+                        "user.deleted"
+                    ]
+                },
+                "secret": {
+                    "type": "string",
+                    "minLength": 16,
+                    "example": "lyHzTYm9yi-pkEv3m2tamAeeK7Dj7N3QRP7xv7dPU5q"
+                },
+                "url": {
+                    "type": "string",
+                    "example": "https://example.com/hooks/kyoo"
+                }
+            }
+        },
+        "main.ErrorCode": {
+            "type": "string",
+            "enum": [
+                "request.bad_request",
+                "request.invalid_body",
+                "request.validation_failed",
+                "request.invalid_id",
+                "request.invalid_cursor",
+                "request.invalid_duration",
+                "request.invalid_format",
+                "request.file_too_large",
+                "request.missing_redirect_url",
+                "request.unauthorized_redirect_url",
+                "request.not_found",
+                "request.method_not_allowed",
+                "request.rate_limited",
+                "server.internal_error",
+                "server.missing_secret_key",
+                "auth.unauthorized",
+                "auth.forbidden",
+                "auth.not_logged_in",
+                "auth.guests_not_allowed",
+                "auth.invalid_bearer",
+                "auth.invalid_token",
+                "auth.token_expired",
+                "auth.token_reused",
+                "auth.invalid_session",
+                "auth.session_expired",
+                "auth.session_required",
+                "auth.rotating_session",
+                "auth.invalid_api_key",
+                "auth.users_only",
+                "auth.services_only",
+                "auth.missing_permissions",
+                "auth.invalid_permission_claim",
+                "auth.impersonation_read_only",
+                "auth.invalid_password",
+                "auth.missing_old_password",
+                "auth.password_policy",
+                "auth.no_password",
+                "auth.registrations_disabled",
+                "auth.password_reset_required",
+                "auth.challenge_required",
+                "auth.challenge_invalid",
+                "auth.challenge_unavailable",
+                "auth.challenges_disabled",
+                "users.not_found",
+                "users.already_exists",
+                "users.ambiguous_email",
+                "users.protected_claim",
+                "users.no_pending_deletion",
+                "users.impersonate_self",
+                "sessions.not_found",
+                "apikeys.not_found",
+                "apikeys.already_exists",
+                "apikeys.read_only",
+                "apikeys.permission_escalation",
+                "permissions.unknown",
+                "tokens.jwt_not_revocable",
+                "presign.invalid",
+                "presign.revoked",
+                "presign.not_found",
+                "presign.invalid_rule",
+                "logos.not_found",
+                "logos.invalid_image",
+                "logos.invalid_size",
+                "logos.gravatar_unavailable",
+                "settings.invalid",
+                "webhooks.not_found",
+                "oidc.provider_not_found",
+                "oidc.provider_already_exists",
+                "oidc.provider_read_only",
+                "oidc.provider_error",
+                "oidc.state_expired",
+                "oidc.login_expired",
+                "oidc.missing_code",
+                "oidc.already_linked",
+                "oidc.backchannel_logout_disabled",
+                "oidc.invalid_logout_token",
+                "magic_links.disabled",
+                "magic_links.expired",
+                "magic_links.invalid_code",
+                "magic_links.rate_limited",
+                "login_alerts.expired"
+            ],
+            "x-enum-varnames": [
+                "ErrBadRequest",
+                "ErrInvalidBody",
+                "ErrValidationFailed",
+                "ErrInvalidId",
+                "ErrInvalidCursor",
+                "ErrInvalidDuration",
+                "ErrInvalidFormat",
+                "ErrFileTooLarge",
+                "ErrMissingRedirectUrl",
+                "ErrUnauthorizedRedirect",
+                "ErrNotFound",
+                "ErrMethodNotAllowed",
+                "ErrRateLimited",
+                "ErrInternal",
+                "ErrMissingSecretKey",
+                "ErrUnauthorized",
+                "ErrForbidden",
+                "ErrNotLoggedIn",
+                "ErrGuestsNotAllowed",
+                "ErrInvalidBearer",
+                "ErrInvalidToken",
+                "ErrTokenExpired",
+                "ErrTokenReused",
+                "ErrInvalidSession",
+                "ErrSessionExpired",
+                "ErrSessionRequired",
+                "ErrRotatingSession",
+                "ErrInvalidApiKey",
+                "ErrUsersOnly",
+                "ErrServicesOnly",
+                "ErrMissingPermissions",
+                "ErrInvalidPermissionClaim",
+                "ErrImpersonationReadOnly",
+                "ErrInvalidPassword",
+                "ErrMissingOldPassword",
+                "ErrPasswordPolicy",
+                "ErrNoPassword",
+                "ErrRegistrationsDisabled",
+                "ErrPasswordResetRequired",
+                "ErrChallengeRequired",
+                "ErrChallengeInvalid",
+                "ErrChallengeUnavailable",
+                "ErrChallengesDisabled",
+                "ErrUserNotFound",
+                "ErrUserExists",
+                "ErrAmbiguousEmail",
+                "ErrProtectedClaim",
+                "ErrNoPendingDeletion",
+                "ErrImpersonateSelf",
+                "ErrSessionNotFound",
+                "ErrApiKeyNotFound",
+                "ErrApiKeyExists",
+                "ErrApiKeyReadOnly",
+                "ErrPermissionEscalation",
+                "ErrUnknownPermissions",
+                "ErrJwtNotRevocable",
+                "ErrInvalidPresign",
+                "ErrPresignRevoked",
+                "ErrPresignNotFound",
+                "ErrInvalidPresignRule",
+                "ErrLogoNotFound",
+                "ErrInvalidImage",
+                "ErrInvalidLogoSize",
+                "ErrGravatarUnavailable",
+                "ErrInvalidSettings",
+                "ErrWebhookNotFound",
+                "ErrProviderNotFound",
+                "ErrProviderExists",
+                "ErrProviderReadOnly",
+                "ErrProviderUnavailable",
+                "ErrOidcStateExpired",
+                "ErrOidcLoginExpired",
+                "ErrOidcMissingCode",
+                "ErrOidcAlreadyLinked",
+                "ErrBackchannelDisabled",
+                "ErrInvalidLogoutToken",
+                "ErrMagicLinksDisabled",
+                "ErrMagicLinkExpired",
+                "ErrMagicLinkInvalidCode",
+                "ErrTooManyMagicLinks",
+                "ErrLoginAlertExpired"
+            ]
+        },
+        "main.ImpersonateDto": {
+            "type": "object",
+            "properties": {
+                "duration": {
+                    "description": "How long the impersonation jwt is valid (a go duration, max 1h).",
+                    "type": "string",
+                    "example": "15m"
+                }
+            }
+        },
+        "main.ImportError": {
+            "type": "object",
+            "properties": {
+                "line": {
+                    "description": "Line (or index) of the invalid entry, starting at 1.",
+                    "type": "integer",
+                    "example": 3
+                },
+                "message": {
+                    "type": "string",
+                    "example": "Email or username already taken"
+                },
+                "username": {
+                    "type": "string",
+                    "example": "zoriya"
+                }
+            }
+        },
+        "main.ImportResult": {
+            "type": "object",
+            "properties": {
+                "created": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.User"
+                    }
+                },
+                "errors": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.ImportError"
+                    }
+                }
+            }
+        },
+        "main.ImportUserDto": {
+            "type": "object",
+            "required": [
+                "email",
+                "username"
+            ],
+            "properties": {
+                "email": {
+                    "type": "string",
+                    "format": "email",
+                    "example": "kyoo@zoriya.dev"
+                },
+                "passwordHash": {
+                    "description": "Hash of the user's password, either argon2id, bcrypt (` + "`" + `$2b$...` + "`" + `) or jellyfin/emby's pbkdf2\n(` + "`" + `$PBKDF2-SHA512$iterations=210000$SALT$HASH` + "`" + `). Leave empty for users without passwords (they\nwill need to login via oidc or have their password set by an admin).",
+                    "type": "string",
+                    "example": "$PBKDF2-SHA512$iterations=210000$F1A2...$9B3C..."
+                },
+                "username": {
+                    "description": "Username of the account, can't contain @ signs.",
+                    "type": "string",
+                    "example": "zoriya"
+                }
+            }
+        },
+        "main.Introspection": {
+            "type": "object",
+            "properties": {
+                "active": {
+                    "description": "False if the token is invalid, expired or revoked. Other fields are omitted in this case.",
+                    "type": "boolean",
+                    "example": true
+                },
+                "claims": {
+                    "description": "Claims a jwt created from this token would contain.",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "exp": {
+                    "description": "Unix timestamp after which the token won't be valid anymore. Omitted for api keys (they never expire).",
+                    "type": "integer",
+                    "example": 1745864405
+                },
+                "iat": {
+                    "description": "Unix timestamp of the creation of the token.",
+                    "type": "integer",
+                    "example": 1743272405
+                },
+                "iss": {
+                    "type": "string",
+                    "example": "https://kyoo.zoriya.dev"
+                },
+                "sid": {
+                    "description": "Id of the session (or of the api key).",
+                    "type": "string",
+                    "example": "e05089d6-9179-4b5b-a63e-94dd5fc2a397"
+                },
+                "sub": {
+                    "description": "Id of the user (or of the api key).",
+                    "type": "string",
+                    "example": "e05089d6-9179-4b5b-a63e-94dd5fc2a397"
+                },
+                "token_type": {
+                    "description": "Kind of the introspected token.",
+                    "type": "string",
+                    "enum": [
+                        "session",
+                        "apikey",
+                        "jwt"
+                    ],
+                    "example": "session"
+                },
+                "username": {
+                    "description": "Username of the user (or name of the api key).",
+                    "type": "string",
+                    "example": "zoriya"
+                }
+            }
+        },
+        "main.JwkSet": {
+            "type": "object",
+            "properties": {
+                "keys": {
+                    "type": "array",
+                    "items": {
+                        "type": "object",
+                        "properties": {
+                            "alg": {
+                                "type": "string",
+                                "enum": [
+                                    "RS256",
+                                    "ES256",
+                                    "EdDSA"
+                                ],
+                                "example": "RS256"
+                            },
+                            "crv": {
+                                "description": "Curve of the key (only for EC \u0026 OKP keys)",
+                                "type": "string",
+                                "enum": [
+                                    "P-256",
+                                    "Ed25519"
+                                ],
+                                "example": "P-256"
+                            },
+                            "e": {
+                                "description": "RSA public exponent (only for RSA keys)",
+                                "type": "string",
+                                "example": "AQAB"
+                            },
+                            "key_ops": {
+                                "type": "array",
+                                "items": {
+                                    "type": "string"
+                                },
+                                "example": [
+                                    "[verify]"
+                                ]
+                            },
+                            "kid": {
+                                "type": "string",
+                                "example": "YrzFm8pqsdY3OEc0uOEaL8dWldNwnl3N5t0fqpRq9Ow"
+                            },
+                            "kty": {
+                                "type": "string",
+                                "enum": [
+                                    "RSA",
+                                    "EC",
+                                    "OKP"
+                                ],
+                                "example": "RSA"
+                            },
+                            "n": {
+                                "description": "RSA modulus (only for RSA keys)",
+                                "type": "string",
+                                "example": "oBcXcJUR-Sb8_b4qIj28LRAPxdF_6odRr52K5-ymiEkR2DOlEuXBtM-biWxPESW-U-zhfHzdVLf6ioy5xL0bJTh8BMIorkrDliN3vb81jCvyOMgZ7ATMJpMAQMmSDN7sL3U45r22FaoQufCJMQHmUsZPecdQSgj2aFBiRXxsLleYlSezdBVT_gKH-coqeYXSC_hk-ezSq4aDZ10BlDnZ-FA7-ES3T7nBmJEAU7KDAGeSvbYAfYimOW0r-Vc0xQNuwGCfzZtSexKXDbYbNwOVo3SjfCabq-gMfap_owcHbKicGBZu1LDlh7CpkmLQf_kv6GihM2LWFFh6Vwg2cltiwF22EIPlUDtYTkUR0qRkdNJaNkwV5Vv_6r3pzSmu5ovRriKtlrvJMjlTnLb4_ltsge3fw5Z34cJrsp094FbUc2O6Or4FGEXUldieJCnVRhs2_h6SDcmeMXs1zfvE5GlDnq8tZV6WMJ5Sb4jNO7rs_hTkr23_E6mVg-DdtRS256ozGfqzRzhIjPym6D_jVfR6dZv5W0sKwOHRmT7nYq-C7b2sAwmNNII296M4Rq-jn0b5pgSeMDYbIpbIA4thU8LYU0lBZp_ZVwWKG1RFZDxz3k9O5UVth2kTpTWlwn0hB1aAvgXHo6in1CScITGA72p73RbDieNnLFaCK4xUVstkWAKLqPxs"
+                            },
+                            "use": {
+                                "type": "string",
+                                "example": "sig"
+                            },
+                            "x": {
+                                "description": "Public key (only for EC \u0026 OKP keys)",
+                                "type": "string",
+                                "example": "f83OJ3D2xF1Bg8vub9tLe1gHMzV76e8Tus9uPHvRVEU"
+                            },
+                            "y": {
+                                "description": "Public key y coordinate (only for EC keys)",
+                                "type": "string",
+                                "example": "x_FEzRu9m36HLN_tue659LNpXW6pCyStikYjKIWI5a0"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "main.KError": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "description": "Stable identifier of the error, use it instead of the message to react to specific errors.",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/main.ErrorCode"
+                        }
+                    ],
+                    "example": "users.not_found"
+                },
+                "details": {},
+                "message": {
+                    "type": "string",
+                    "example": "No user found with this id"
+                },
+                "status": {
+                    "type": "integer",
+                    "example": 404
+                }
+            }
+        },
+        "main.MagicLink": {
+            "type": "object",
+            "properties": {
+                "expireAt": {
+                    "type": "string",
+                    "example": "2025-03-29T18:20:05.267Z"
+                },
+                "id": {
+                    "description": "Id of the link, used to exchange the code shown once the link is opened.",
+                    "type": "string",
+                    "example": "e05089d6-9179-4b5b-a63e-94dd5fc2a397"
+                }
+            }
+        },
+        "main.MagicLinkCallbackDto": {
+            "type": "object",
+            "required": [
+                "token"
+            ],
+            "properties": {
+                "token": {
+                    "description": "Opaque token given to the redirect url.",
+                    "type": "string"
+                }
+            }
+        },
+        "main.MagicLinkCodeDto": {
+            "type": "object",
+            "required": [
+                "code"
+            ],
+            "properties": {
+                "code": {
+                    "type": "string",
+                    "example": "ABCD-2345"
+                }
+            }
+        },
+        "main.MagicLinkDto": {
+            "type": "object",
+            "required": [
+                "email"
+            ],
+            "properties": {
+                "email": {
+                    "description": "Email of the account to login to.",
+                    "type": "string",
+                    "example": "kyoo@zoriya.dev"
+                },
+                "redirectUrl": {
+                    "description": "Url to redirect the browser to once the link is opened, with an opaque token (` + "`" + `?token=` + "`" + `) to exchange\nvia /sessions/magic-link/callback.\nIf unset, a code is shown instead that can be used via /sessions/magic-link/{id}.",
+                    "type": "string",
+                    "example": "https://kyoo.zoriya.dev/login/callback"
+                }
+            }
+        },
+        "main.OidcAuthMethod": {
+            "type": "string",
+            "enum": [
+                "ClientSecretBasic",
+                "ClientSecretPost"
+            ],
+            "x-enum-varnames": [
+                "OidcClientSecretBasic",
+                "OidcClientSecretPost"
+            ]
+        },
+        "main.OidcInfo": {
+            "type": "object",
+            "properties": {
+                "logo": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.OidcLink": {
+            "type": "object",
+            "properties": {
+                "expireAt": {
+                    "description": "When the access token keibi holds for this provider expires.",
+                    "type": "string",
+                    "example": "2025-03-29T18:20:05.267Z"
+                },
+                "id": {
+                    "description": "Id of the user on the external service.",
+                    "type": "string",
+                    "example": "e05089d6-9179-4b5b-a63e-94dd5fc2a397"
+                },
+                "profileUrl": {
+                    "description": "Link to the profile of the user on the external service.",
+                    "type": "string",
+                    "format": "url",
+                    "example": "https://myanimelist.net/profile/zoriya"
+                },
+                "provider": {
+                    "description": "Id of the oidc provider.",
+                    "type": "string",
+                    "example": "google"
+                },
+                "username": {
+                    "description": "Username of the user on the external service.",
+                    "type": "string",
+                    "example": "zoriya"
+                }
+            }
+        },
+        "main.OidcProviderSettings": {
+            "type": "object",
+            "properties": {
+                "authMethod": {
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/main.OidcAuthMethod"
+                        }
+                    ],
+                    "example": "ClientSecretBasic"
+                },
+                "authorization": {
+                    "type": "string",
+                    "format": "url",
+                    "example": "https://accounts.google.com/o/oauth2/auth"
+                },
+                "broken": {
+                    "description": "True if the provider's secret can't be decrypted (for example after a ` + "`" + `SECRET_ENCRYPTION_KEY` + "`" + ` change).\nBroken providers can't be used to login until a new secret is set.",
+                    "type": "boolean"
+                },
+                "clientId": {
+                    "description": "Client id given by the provider. The client secret is never returned.",
+                    "type": "string",
+                    "example": "xxx.apps.googleusercontent.com"
+                },
+                "enabled": {
+                    "description": "Disabled providers are hidden and can't be used to login.",
+                    "type": "boolean"
+                },
+                "endSession": {
+                    "description": "Url users are redirected to when logging out to also end their session on the provider.",
+                    "type": "string",
+                    "format": "url",
+                    "example": "https://accounts.google.com/logout"
+                },
+                "id": {
+                    "description": "Id of the provider, used in urls (` + "`" + `/oidc/login/{id}` + "`" + `).",
+                    "type": "string",
+                    "example": "google"
+                },
+                "issuer": {
+                    "description": "Issuer \u0026 jwks of the provider, required to accept back-channel logouts.",
+                    "type": "string",
+                    "example": "https://accounts.google.com"
+                },
+                "jwks": {
+                    "type": "string",
+                    "format": "url",
+                    "example": "https://www.googleapis.com/oauth2/v3/certs"
+                },
+                "linkByEmail": {
+                    "description": "If true, logging in with this provider links the account to the existing user with the same email\n(only if the provider says the email is verified) instead of failing.",
+                    "type": "boolean"
+                },
+                "logo": {
+                    "description": "Logo displayed to users.",
+                    "type": "string",
+                    "format": "url",
+                    "example": "https://www.gstatic.com/marketing-cms/assets/images/d5/dc/cfe9ce8b4425b410b49b7f2dd3f3/g.webp=s200"
+                },
+                "name": {
+                    "description": "Name displayed to users.",
+                    "type": "string",
+                    "example": "Google"
+                },
+                "profile": {
+                    "type": "string",
+                    "format": "url",
+                    "example": "https://www.googleapis.com/oauth2/v2/userinfo"
+                },
+                "readOnly": {
+                    "description": "True if the provider is defined via env vars (it can't be edited at runtime).",
+                    "type": "boolean"
+                },
+                "scope": {
+                    "type": "string",
+                    "example": "openid profile email"
+                },
+                "token": {
+                    "type": "string",
+                    "format": "url",
+                    "example": "https://oauth2.googleapis.com/token"
+                }
+            }
+        },
+        "main.PasswordPolicy": {
+            "type": "object",
+            "properties": {
+                "forbidPersonalInfo": {
+                    "description": "If true, passwords can't contain the username or the email of the user.",
+                    "type": "boolean",
+                    "example": false
+                },
+                "minLength": {
+                    "description": "Minimum number of characters of a password.",
+                    "type": "integer",
+                    "example": 8
+                },
+                "requiredClasses": {
+                    "description": "Character classes a password must contain.",
+                    "type": "array",
+                    "items": {
+                        "type": "string",
+                        "enum": [
+                            "lower",
+                            "upper",
+                            "digit",
+                            "symbol"
+                        ]
+                    },
+                    "example": [
+                        "lower",
+                        "digit"
+                    ]
+                }
+            }
+        },
+        "main.Permission": {
+            "type": "object",
+            "properties": {
+                "description": {
+                    "description": "What this permission allows.",
+                    "type": "string",
+                    "example": "List users and read their profiles."
+                },
+                "name": {
+                    "description": "Name of the permission, used in the ` + "`" + `permissions` + "`" + ` claim.",
+                    "type": "string",
+                    "example": "users.read"
+                }
+            }
+        },
+        "main.PowChallenge": {
+            "type": "object",
+            "properties": {
+                "algorithm": {
+                    "description": "Hash function to use.",
+                    "type": "string",
+                    "example": "sha256"
+                },
+                "difficulty": {
+                    "description": "Number of leading zero bits the hash must have.",
+                    "type": "integer",
+                    "example": 20
+                },
+                "expireAt": {
+                    "description": "Date after which the challenge can't be solved anymore.",
+                    "type": "string",
+                    "example": "2025-03-29T18:20:05.267Z"
+                },
+                "id": {
+                    "description": "Id to send back with the solution.",
+                    "type": "string",
+                    "example": "e05089d6-9179-4b5b-a63e-94dd5fc2a397"
+                },
+                "nonce": {
+                    "description": "Random prefix of the string to hash.",
+                    "type": "string",
+                    "example": "9f86d081884c7d659a2feaa0c55ad015"
+                }
+            }
+        },
+        "main.ServerInfo": {
+            "type": "object",
+            "properties": {
+                "allowRegister": {
+                    "type": "boolean"
+                },
+                "challenge": {
+                    "description": "Challenge to solve when registering or logging in, null if disabled.",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/main.ChallengeConfig"
+                        }
+                    ]
+                },
+                "magicLink": {
+                    "description": "True if users can login via an emailed link (see /sessions/magic-link).",
+                    "type": "boolean"
+                },
+                "oidc": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/main.OidcInfo"
+                    }
+                },
+                "passwordPolicy": {
+                    "$ref": "#/definitions/main.PasswordPolicy"
+                },
+                "publicUrl": {
+                    "type": "string"
+                },
+                "saml": {
+                    "description": "SAML providers, login via /saml/login/{id} instead of /oidc/login/{id}.",
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/main.OidcInfo"
+                    }
+                }
+            }
+        },
+        "main.SettingsSchema": {
+            "type": "object",
+            "properties": {
+                "additionalProperties": {
+                    "type": "boolean"
+                },
+                "default": {
+                    "description": "Raw to differentiate a null default from a missing one.",
+                    "type": "object"
+                },
+                "description": {
+                    "type": "string"
+                },
+                "enum": {
+                    "type": "array",
+                    "items": {}
+                },
+                "items": {
+                    "$ref": "#/definitions/main.SettingsSchema"
+                },
+                "maximum": {
+                    "type": "number"
+                },
+                "minimum": {
+                    "type": "number"
+                },
+                "properties": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/main.SettingsSchema"
+                    }
+                },
+                "type": {
+                    "type": "string",
+                    "example": "string"
+                }
+            }
+        },
+        "main.TokenDto": {
+            "type": "object",
+            "required": [
+                "token"
+            ],
+            "properties": {
+                "token": {
+                    "description": "A session token, an api key or a jwt.",
+                    "type": "string",
+                    "example": "lyHzTYm9yi+pkEv3m2tamAeeK7Dj7N3QRP7xv7dPU5q9MAe8tU4ySwYczE0RaMr4fijsA=="
+                },
+                "token_type_hint": {
+                    "description": "Ignored, the type of the token is always detected from its value.",
+                    "type": "string",
+                    "example": "refresh_token"
+                }
+            }
+        },
+        "main.UserExport": {
+            "type": "object",
+            "properties": {
+                "apiKeys": {
+                    "description": "Api keys created by this user (tokens are not exported).",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.ApiKey"
+                    }
+                },
+                "audit": {
+                    "description": "History of security relevant actions on this account.",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.AuditLog"
+                    }
+                },
+                "exportedAt": {
+                    "description": "When was this export generated?",
+                    "type": "string",
+                    "example": "2025-03-29T18:20:05.267Z"
+                },
+                "oidc": {
+                    "description": "External accounts linked to this one (access tokens are not exported).",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.OidcLink"
+                    }
+                },
+                "sessions": {
+                    "description": "Sessions currently opened for this account (tokens are not exported).",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.Session"
+                    }
+                },
+                "user": {
+                    "description": "Profile of the user, including its claims.",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.User"
+                        }
+                    ]
+                }
+            }
+        },
+        "main.UserSettings": {
+            "type": "object",
+            "properties": {
+                "account": {
+                    "description": "Settings saved for the account.",
+                    "type": "object",
+                    "additionalProperties": {},
+                    "example": {
+                        "preferOriginal": " true"
+                    }
+                },
+                "device": {
+                    "description": "Overrides saved for the current device (session).",
+                    "type": "object",
+                    "additionalProperties": {},
+                    "example": {
+                        "preferOriginal": " false"
+                    }
+                },
+                "settings": {
+                    "description": "Settings to use: the schema's defaults overridden by the account's settings then the device's.",
+                    "type": "object",
+                    "additionalProperties": {},
+                    "example": {
+                        "preferOriginal": " true"
+                    }
+                }
+            }
+        },
+        "main.Webhook": {
+            "type": "object",
+            "properties": {
+                "createdAt": {
+                    "type": "string",
+                    "example": "2025-03-29T18:20:05.267Z"
+                },
+                "enabled": {
+                    "description": "Disabled webhooks don't receive events.",
+                    "type": "boolean"
+                },
+                "events": {
+                    "description": "Events this webhook is subscribed to. Empty means every events.",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "user.created",
+                        "user.deleted"
+                    ]
+                },
+                "id": {
+                    "type": "string",
+                    "example": "e05089d6-9179-4b5b-a63e-94dd5fc2a397"
+                },
+                "url": {
+                    "description": "Url that will receive a POST request for each event.",
+                    "type": "string",
+                    "format": "url",
+                    "example": "https://example.com/hooks/kyoo"
+                }
+            }
+        },
+        "main.WebhookDelivery": {
+            "type": "object",
+            "properties": {
+                "attempts": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "createdAt": {
+                    "type": "string",
+                    "example": "2025-03-29T18:20:05.267Z"
+                },
+                "deliveredAt": {
+                    "type": "string",
+                    "example": "2025-03-29T18:20:05.267Z"
+                },
+                "event": {
+                    "type": "string",
+                    "example": "user.created"
+                },
+                "id": {
+                    "type": "string",
+                    "example": "e05089d6-9179-4b5b-a63e-94dd5fc2a397"
+                },
+                "lastError": {
+                    "description": "Error of the last attempt.",
+                    "type": "string",
+                    "example": "webhook returned status 500"
+                },
+                "lastStatusCode": {
+                    "description": "Http status returned by the webhook on the last attempt.",
+                    "type": "integer",
+                    "example": 200
+                },
+                "nextAttemptAt": {
+                    "description": "When will this delivery be retried (only relevant for pending deliveries).",
+                    "type": "string",
+                    "example": "2025-03-29T18:20:05.267Z"
+                },
+                "payload": {
+                    "description": "The body sent to the webhook.",
+                    "type": "object"
+                },
+                "status": {
+                    "description": "` + "`" + `pending` + "`" + ` deliveries will be retried, ` + "`" + `failed` + "`" + ` ones won't.",
+                    "type": "string",
+                    "enum": [
+                        "pending",
+                        "delivered",
+                        "failed"
+                    ],
+                    "example": "delivered"
+                }
+            }
+        },
+        "main.WebhookDto": {
+            "type": "object",
+            "required": [
+                "url"
+            ],
+            "properties": {
+                "enabled": {
+                    "type": "boolean"
+                },
+                "events": {
+                    "description": "Events to subscribe to. Empty means every events.",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "user.created",
+                        "user.deleted"
+                    ]
+                },
+                "secret": {
+                    "description": "Secret used to sign payloads. A random one is generated if unspecified.",
+                    "type": "string",
+                    "minLength": 16,
+                    "example": "lyHzTYm9yi-pkEv3m2tamAeeK7Dj7N3QRP7xv7dPU5q"
+                },
+                "url": {
+                    "type": "string",
+                    "example": "https://example.com/hooks/kyoo"
+                }
+            }
+        },
+        "main.WebhookWSecret": {
+            "type": "object",
+            "properties": {
+                "createdAt": {
+                    "type": "string",
+                    "example": "2025-03-29T18:20:05.267Z"
+                },
+                "enabled": {
+                    "description": "Disabled webhooks don't receive events.",
+                    "type": "boolean"
+                },
+                "events": {
+                    "description": "Events this webhook is subscribed to. Empty means every events.",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "user.created",
+                        "user.deleted"
+                    ]
+                },
+                "id": {
+                    "type": "string",
+                    "example": "e05089d6-9179-4b5b-a63e-94dd5fc2a397"
+                },
+                "secret": {
+                    "description": "Secret used to sign payloads (` + "`" + `X-Keibi-Signature` + "`" + ` header). Only returned on creation.",
+                    "type": "string",
+                    "example": "lyHzTYm9yi-pkEv3m2tamAeeK7Dj7N3QRP7xv7dPU5q"
+                },
+                "url": {
+                    "description": "Url that will receive a POST request for each event.",
+                    "type": "string",
+                    "format": "url",
+                    "example": "https://example.com/hooks/kyoo"
+                }
+            }
+        },
+        "models.ActivePresign": {
+            "type": "object",
+            "properties": {
+                "createdAt": {
+                    "description": "When was the signature created.",
+                    "type": "string",
+                    "example": "2025-03-29T18:20:05.267Z"
+                },
+                "expireAt": {
+                    "description": "When this signature stops being valid.",
+                    "type": "string",
+                    "example": "2025-03-29T18:20:05.267Z"
+                },
+                "for": {
+                    "description": "The list of requests the signature is valid for.",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.PresignRule"
+                    }
+                },
+                "id": {
+                    "description": "Id of the presign, can be used to revoke it.",
+                    "type": "string",
+                    "example": "e05089d6-9179-4b5b-a63e-94dd5fc2a397"
+                },
+                "remainingUses": {
+                    "description": "How many requests the signature can still be used for. Null if unlimited.",
+                    "type": "integer",
+                    "example": 4
+                }
+            }
+        },
+        "models.Actor": {
+            "type": "object",
+            "properties": {
+                "id": {
+                    "description": "Id of the admin acting as the user.",
+                    "type": "string",
+                    "example": "e05089d6-9179-4b5b-a63e-94dd5fc2a397"
+                },
+                "username": {
+                    "description": "Username of the admin acting as the user.",
+                    "type": "string",
+                    "example": "zoriya"
+                }
+            }
+        },
+        "models.ApiKey": {
             "type": "object",
             "properties": {
                 "claims": {
@@ -1390,7 +4556,7 @@ const docTemplate = `{
                 }
             }
         },
-        "main.ApiKeyDto": {
+        "models.ApiKeyDto": {
             "type": "object",
             "properties": {
                 "claims": {
@@ -1408,7 +4574,7 @@ const docTemplate = `{
                 }
             }
         },
-        "main.ApiKeyWToken": {
+        "models.ApiKeyWToken": {
             "type": "object",
             "properties": {
                 "claims": {
@@ -1442,47 +4608,70 @@ const docTemplate = `{
                 }
             }
         },
-        "main.JwkSet": {
+        "models.ChallengeDto": {
             "type": "object",
+            "required": [
+                "response"
+            ],
             "properties": {
-                "keys": {
-                    "type": "array",
-                    "items": {
-                        "type": "object",
-                        "properties": {
-                            "e": {
-                                "type": "string",
-                                "example": "AQAB"
-                            },
-                            "key_ops": {
-                                "type": "array",
-                                "items": {
-                                    "type": "string"
-                                },
-                                "example": [
-                                    "[verify]"
-                                ]
-                            },
-                            "kty": {
-                                "type": "string",
-                                "example": "RSA"
-                            },
-                            "n": {
-                                "type": "string",
-                                "example": "oBcXcJUR-Sb8_b4qIj28LRAPxdF_6odRr52K5-ymiEkR2DOlEuXBtM-biWxPESW-U-zhfHzdVLf6ioy5xL0bJTh8BMIorkrDliN3vb81jCvyOMgZ7ATMJpMAQMmSDN7sL3U45r22FaoQufCJMQHmUsZPecdQSgj2aFBiRXxsLleYlSezdBVT_gKH-coqeYXSC_hk-ezSq4aDZ10BlDnZ-FA7-ES3T7nBmJEAU7KDAGeSvbYAfYimOW0r-Vc0xQNuwGCfzZtSexKXDbYbNwOVo3SjfCabq-gMfap_owcHbKicGBZu1LDlh7CpkmLQf_kv6GihM2LWFFh6Vwg2cltiwF22EIPlUDtYTkUR0qRkdNJaNkwV5Vv_6r3pzSmu5ovRriKtlrvJMjlTnLb4_ltsge3fw5Z34cJrsp094FbUc2O6Or4FGEXUldieJCnVRhs2_h6SDcmeMXs1zfvE5GlDnq8tZV6WMJ5Sb4jNO7rs_hTkr23_E6mVg-DdtRS256ozGfqzRzhIjPym6D_jVfR6dZv5W0sKwOHRmT7nYq-C7b2sAwmNNII296M4Rq-jn0b5pgSeMDYbIpbIA4thU8LYU0lBZp_ZVwWKG1RFZDxz3k9O5UVth2kTpTWlwn0hB1aAvgXHo6in1CScITGA72p73RbDieNnLFaCK4xUVstkWAKLqPxs"
-                            },
-                            "use": {
-                                "type": "string",
-                                "example": "sig"
-                            }
-                        }
+                "id": {
+                    "description": "Id of the proof-of-work challenge (from POST /challenges). Unused for captchas.",
+                    "type": "string",
+                    "example": "e05089d6-9179-4b5b-a63e-94dd5fc2a397"
+                },
+                "response": {
+                    "description": "Solution of the proof-of-work or response token of the captcha widget.",
+                    "type": "string",
+                    "example": "1589302"
+                }
+            }
+        },
+        "models.EditPasswordDto": {
+            "type": "object",
+            "required": [
+                "newPassword"
+            ],
+            "properties": {
+                "newPassword": {
+                    "type": "string",
+                    "example": "password1234"
+                },
+                "oldPassword": {
+                    "type": "string",
+                    "example": "password1234"
+                }
+            }
+        },
+        "models.EditUserDto": {
+            "type": "object",
+            "properties": {
+                "claims": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    },
+                    "example": {
+                        "preferOriginal": " true"
                     }
+                },
+                "email": {
+                    "type": "string",
+                    "example": "kyoo@zoriya.dev"
+                },
+                "username": {
+                    "type": "string",
+                    "example": "zoriya"
                 }
             }
         },
-        "main.Jwt": {
+        "models.Jwt": {
             "type": "object",
             "properties": {
+                "refreshToken": {
+                    "description": "New session token, only set for sessions created with ` + "`" + `rotate=true` + "`" + `.\nThe session token used for this call is now invalid, use this one for the next call.",
+                    "type": "string",
+                    "example": "lyHzTYm9yi+pkEv3m2tamAeeK7Dj7N3QRP7xv7dPU5q9MAe8tU4ySwYczE0RaMr4fijsA=="
+                },
                 "token": {
                     "description": "The jwt token you can use for all authorized call to either keibi or other services.",
                     "type": "string",
@@ -1490,27 +4679,21 @@ const docTemplate = `{
                 }
             }
         },
-        "main.KError": {
-            "type": "object",
-            "properties": {
-                "details": {},
-                "message": {
-                    "type": "string",
-                    "example": "No user found with this id"
-                },
-                "status": {
-                    "type": "integer",
-                    "example": 404
-                }
-            }
-        },
-        "main.LoginDto": {
+        "models.LoginDto": {
             "type": "object",
             "required": [
                 "login",
                 "password"
             ],
             "properties": {
+                "challenge": {
+                    "description": "Solution of the challenge advertised in /info, required after too many failed logins.",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.ChallengeDto"
+                        }
+                    ]
+                },
                 "login": {
                     "description": "Either the email or the username.",
                     "type": "string",
@@ -1523,24 +4706,99 @@ const docTemplate = `{
                 }
             }
         },
-        "main.OidcInfo": {
+        "models.Me": {
             "type": "object",
             "properties": {
-                "logo": {
-                    "type": "string"
+                "claims": {
+                    "description": "List of custom claims JWT created via get /jwt will have",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    },
+                    "example": {
+                        "isAdmin": " true"
+                    }
                 },
-                "name": {
-                    "type": "string"
+                "createdDate": {
+                    "description": "When was this account created?",
+                    "type": "string",
+                    "example": "2025-03-29T18:20:05.267Z"
+                },
+                "deleteAt": {
+                    "description": "When will this account be deleted? Null if no deletion has been requested.",
+                    "type": "string",
+                    "example": "2025-04-05T18:20:05.267Z"
+                },
+                "email": {
+                    "description": "Email of the user. Can be used as a login.",
+                    "type": "string",
+                    "format": "email",
+                    "example": "kyoo@zoriya.dev"
+                },
+                "hasPassword": {
+                    "description": "False if the user has never setup a password and only used oidc.",
+                    "type": "boolean"
+                },
+                "id": {
+                    "description": "Id of the user.",
+                    "type": "string",
+                    "example": "e05089d6-9179-4b5b-a63e-94dd5fc2a397"
+                },
+                "impersonatedBy": {
+                    "description": "Set if the current jwt is an impersonation token: this is the admin viewing the app as you.",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.Actor"
+                        }
+                    ]
+                },
+                "lastSeen": {
+                    "description": "When was the last time this account made any authorized request?",
+                    "type": "string",
+                    "example": "2025-03-29T18:20:05.267Z"
+                },
+                "oidc": {
+                    "description": "List of other login method available for this user. Access tokens wont be returned here.",
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/models.OidcHandle"
+                    }
+                },
+                "username": {
+                    "description": "Username of the user. Can be used as a login.",
+                    "type": "string",
+                    "example": "zoriya"
+                }
+            }
+        },
+        "models.OidcHandle": {
+            "type": "object",
+            "properties": {
+                "id": {
+                    "description": "Id of this oidc handle.",
+                    "type": "string",
+                    "example": "e05089d6-9179-4b5b-a63e-94dd5fc2a397"
+                },
+                "profileUrl": {
+                    "description": "Link to the profile of the user on the external service. Null if unknown or irrelevant.",
+                    "type": "string",
+                    "format": "url",
+                    "example": "https://myanimelist.net/profile/zoriya"
+                },
+                "username": {
+                    "description": "Username of the user on the external service.",
+                    "type": "string",
+                    "example": "zoriya"
                 }
             }
         },
-        "main.Page-main_ApiKey": {
+        "models.Page-models_ApiKey": {
             "type": "object",
             "properties": {
                 "items": {
                     "type": "array",
                     "items": {
-                        "$ref": "#/definitions/main.ApiKey"
+                        "$ref": "#/definitions/models.ApiKey"
                     }
                 },
                 "next": {
@@ -1553,7 +4811,7 @@ const docTemplate = `{
                 }
             }
         },
-        "main.Page-models_User": {
+        "models.Page-models_User": {
             "type": "object",
             "properties": {
                 "items": {
@@ -1572,13 +4830,38 @@ const docTemplate = `{
                 }
             }
         },
-        "main.Presign": {
+        "models.PersonalTokenDto": {
+            "type": "object",
+            "properties": {
+                "name": {
+                    "type": "string",
+                    "example": "kodi"
+                },
+                "permissions": {
+                    "description": "Permissions of the token, they must be a subset of your own. Defaults to all your permissions.",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "core.read",
+                        "core.play"
+                    ]
+                }
+            }
+        },
+        "models.Presign": {
             "type": "object",
             "required": [
                 "duration",
                 "for"
             ],
             "properties": {
+                "claims": {
+                    "description": "Extra claims to add to the signed jwt. Protected claims (` + "`" + `sub` + "`" + `, ` + "`" + `permissions` + "`" + `, ...) are rejected.",
+                    "type": "object",
+                    "additionalProperties": {}
+                },
                 "duration": {
                     "description": "How long the signature stays valid (go duration, e.g. ` + "`" + `24h` + "`" + `).",
                     "type": "string",
@@ -1594,23 +4877,43 @@ const docTemplate = `{
                     "type": "array",
                     "minItems": 1,
                     "items": {
-                        "$ref": "#/definitions/main.PresignRule"
+                        "$ref": "#/definitions/models.PresignRule"
                     }
                 },
+                "id": {
+                    "description": "Id of the presign, can be used to revoke it.",
+                    "type": "string",
+                    "example": "e05089d6-9179-4b5b-a63e-94dd5fc2a397"
+                },
+                "maxUses": {
+                    "description": "How many requests the signature can be used for. Unlimited if not set.",
+                    "type": "integer",
+                    "minimum": 1,
+                    "example": 5
+                },
                 "signature": {
                     "description": "The signature to append as a ` + "`" + `x-presign` + "`" + ` query parameter to any request allowed by ` + "`" + `for` + "`" + `.",
                     "type": "string",
                     "example": "eyJhbGciOiJSUzI1NiIsImtpZCI6Ii4uLiJ9.eyJmb3IiOlt7InVybCI6Ii4uLiJ9XX0.KMUFsID..."
+                },
+                "singleUse": {
+                    "description": "Shorthand for ` + "`" + `maxUses: 1` + "`" + `, useful for one-time download links.",
+                    "type": "boolean"
                 }
             }
         },
-        "main.PresignRequest": {
+        "models.PresignRequest": {
             "type": "object",
             "required": [
                 "duration",
                 "for"
             ],
             "properties": {
+                "claims": {
+                    "description": "Extra claims to add to the signed jwt. Protected claims (` + "`" + `sub` + "`" + `, ` + "`" + `permissions` + "`" + `, ...) are rejected.",
+                    "type": "object",
+                    "additionalProperties": {}
+                },
                 "duration": {
                     "description": "How long the signature stays valid (go duration, e.g. ` + "`" + `24h` + "`" + `).",
                     "type": "string",
@@ -1621,12 +4924,22 @@ const docTemplate = `{
                     "type": "array",
                     "minItems": 1,
                     "items": {
-                        "$ref": "#/definitions/main.PresignRule"
+                        "$ref": "#/definitions/models.PresignRule"
                     }
+                },
+                "maxUses": {
+                    "description": "How many requests the signature can be used for. Unlimited if not set.",
+                    "type": "integer",
+                    "minimum": 1,
+                    "example": 5
+                },
+                "singleUse": {
+                    "description": "Shorthand for ` + "`" + `maxUses: 1` + "`" + `, useful for one-time download links.",
+                    "type": "boolean"
                 }
             }
         },
-        "main.PresignRule": {
+        "models.PresignRule": {
             "type": "object",
             "required": [
                 "verb"
@@ -1649,24 +4962,41 @@ const docTemplate = `{
                 }
             }
         },
-        "main.ServerInfo": {
+        "models.RegisterDto": {
             "type": "object",
+            "required": [
+                "email",
+                "password",
+                "username"
+            ],
             "properties": {
-                "allowRegister": {
-                    "type": "boolean"
+                "challenge": {
+                    "description": "Solution of the challenge advertised in /info, if any.",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.ChallengeDto"
+                        }
+                    ]
                 },
-                "oidc": {
-                    "type": "object",
-                    "additionalProperties": {
-                        "$ref": "#/definitions/main.OidcInfo"
-                    }
+                "email": {
+                    "description": "Valid email that could be used for forgotten password requests. Can be used for login.",
+                    "type": "string",
+                    "format": "email",
+                    "example": "kyoo@zoriya.dev"
                 },
-                "publicUrl": {
-                    "type": "string"
+                "password": {
+                    "description": "Password to use.",
+                    "type": "string",
+                    "example": "password1234"
+                },
+                "username": {
+                    "description": "Username of the new account, can't contain @ signs. Can be used for login.",
+                    "type": "string",
+                    "example": "zoriya"
                 }
             }
         },
-        "main.Session": {
+        "models.Session": {
             "type": "object",
             "properties": {
                 "createdDate": {
@@ -1684,14 +5014,27 @@ const docTemplate = `{
                     "type": "string",
                     "example": "e05089d6-9179-4b5b-a63e-94dd5fc2a397"
                 },
+                "impersonatedBy": {
+                    "description": "Admin that created this session to impersonate the user. Null for regular sessions.",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.Actor"
+                        }
+                    ]
+                },
                 "lastUsed": {
                     "description": "Last date this session was used to access a service.",
                     "type": "string",
                     "example": "2025-03-29T18:20:05.267Z"
+                },
+                "rotate": {
+                    "description": "If true, the session token changes each time it's exchanged for a jwt.",
+                    "type": "boolean",
+                    "example": false
                 }
             }
         },
-        "main.SessionWCurrent": {
+        "models.SessionWCurrent": {
             "type": "object",
             "properties": {
                 "createdDate": {
@@ -1712,14 +5055,27 @@ const docTemplate = `{
                     "type": "string",
                     "example": "e05089d6-9179-4b5b-a63e-94dd5fc2a397"
                 },
+                "impersonatedBy": {
+                    "description": "Admin that created this session to impersonate the user. Null for regular sessions.",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.Actor"
+                        }
+                    ]
+                },
                 "lastUsed": {
                     "description": "Last date this session was used to access a service.",
                     "type": "string",
                     "example": "2025-03-29T18:20:05.267Z"
+                },
+                "rotate": {
+                    "description": "If true, the session token changes each time it's exchanged for a jwt.",
+                    "type": "boolean",
+                    "example": false
                 }
             }
         },
-        "main.SessionWToken": {
+        "models.SessionWLogout": {
             "type": "object",
             "properties": {
                 "createdDate": {
@@ -1737,99 +5093,70 @@ const docTemplate = `{
                     "type": "string",
                     "example": "e05089d6-9179-4b5b-a63e-94dd5fc2a397"
                 },
+                "impersonatedBy": {
+                    "description": "Admin that created this session to impersonate the user. Null for regular sessions.",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.Actor"
+                        }
+                    ]
+                },
                 "lastUsed": {
                     "description": "Last date this session was used to access a service.",
                     "type": "string",
                     "example": "2025-03-29T18:20:05.267Z"
                 },
-                "token": {
-                    "type": "string",
-                    "example": "lyHzTYm9yi+pkEv3m2tamAeeK7Dj7N3QRP7xv7dPU5q9MAe8tU4ySwYczE0RaMr4fijsA=="
-                }
-            }
-        },
-        "models.EditPasswordDto": {
-            "type": "object",
-            "required": [
-                "newPassword"
-            ],
-            "properties": {
-                "newPassword": {
+                "logoutUrl": {
+                    "description": "Url to open to also logout from the OIDC provider that created this session, if it supports it.",
                     "type": "string",
-                    "example": "password1234"
+                    "example": "https://accounts.google.com/logout?id_token_hint=..."
                 },
-                "oldPassword": {
-                    "type": "string",
-                    "example": "password1234"
+                "rotate": {
+                    "description": "If true, the session token changes each time it's exchanged for a jwt.",
+                    "type": "boolean",
+                    "example": false
                 }
             }
         },
-        "models.EditUserDto": {
+        "models.SessionWToken": {
             "type": "object",
             "properties": {
-                "claims": {
-                    "type": "object",
-                    "additionalProperties": {
-                        "type": "string"
-                    },
-                    "example": {
-                        "preferOriginal": " true"
-                    }
-                },
-                "email": {
+                "createdDate": {
+                    "description": "When was the session first opened",
                     "type": "string",
-                    "example": "kyoo@zoriya.dev"
+                    "example": "2025-03-29T18:20:05.267Z"
                 },
-                "username": {
+                "device": {
+                    "description": "Device that created the session.",
                     "type": "string",
-                    "example": "zoriya"
-                }
-            }
-        },
-        "models.OidcHandle": {
-            "type": "object",
-            "properties": {
+                    "example": "Web - Firefox"
+                },
                 "id": {
-                    "description": "Id of this oidc handle.",
+                    "description": "Unique id of this session. Can be used for calls to DELETE",
                     "type": "string",
                     "example": "e05089d6-9179-4b5b-a63e-94dd5fc2a397"
                 },
-                "profileUrl": {
-                    "description": "Link to the profile of the user on the external service. Null if unknown or irrelevant.",
-                    "type": "string",
-                    "format": "url",
-                    "example": "https://myanimelist.net/profile/zoriya"
+                "impersonatedBy": {
+                    "description": "Admin that created this session to impersonate the user. Null for regular sessions.",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.Actor"
+                        }
+                    ]
                 },
-                "username": {
-                    "description": "Username of the user on the external service.",
-                    "type": "string",
-                    "example": "zoriya"
-                }
-            }
-        },
-        "models.RegisterDto": {
-            "type": "object",
-            "required": [
-                "email",
-                "password",
-                "username"
-            ],
-            "properties": {
-                "email": {
-                    "description": "Valid email that could be used for forgotten password requests. Can be used for login.",
+                "lastUsed": {
+                    "description": "Last date this session was used to access a service.",
                     "type": "string",
-                    "format": "email",
-                    "example": "kyoo@zoriya.dev"
+                    "example": "2025-03-29T18:20:05.267Z"
                 },
-                "password": {
-                    "description": "Password to use.",
-                    "type": "string",
-                    "example": "password1234"
+                "rotate": {
+                    "description": "If true, the session token changes each time it's exchanged for a jwt.",
+                    "type": "boolean",
+                    "example": false
                 },
-                "username": {
-                    "description": "Username of the new account, can't contain @ signs. Can be used for login.",
+                "token": {
                     "type": "string",
-                    "example": "zoriya"
+                    "example": "lyHzTYm9yi+pkEv3m2tamAeeK7Dj7N3QRP7xv7dPU5q9MAe8tU4ySwYczE0RaMr4fijsA=="
                 }
             }
         },
@@ -1851,6 +5178,11 @@ const docTemplate = `{
                     "type": "string",
                     "example": "2025-03-29T18:20:05.267Z"
                 },
+                "deleteAt": {
+                    "description": "When will this account be deleted? Null if no deletion has been requested.",
+                    "type": "string",
+                    "example": "2025-04-05T18:20:05.267Z"
+                },
                 "email": {
                     "description": "Email of the user. Can be used as a login.",
                     "type": "string",
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v5"
+	"github.com/zoriya/kyoo/keibi/dbc"
+	. "github.com/zoriya/kyoo/keibi/models"
+)
+
+type OidcLink struct {
+	// Id of the oidc provider.
+	Provider string `json:"provider" example:"google"`
+	// Id of the user on the external service.
+	Id string `json:"id" example:"e05089d6-9179-4b5b-a63e-94dd5fc2a397"`
+	// Username of the user on the external service.
+	Username string `json:"username" example:"zoriya"`
+	// Link to the profile of the user on the external service.
+	ProfileUrl *string `json:"profileUrl" format:"url" example:"https://myanimelist.net/profile/zoriya"`
+	// When the access token keibi holds for this provider expires.
+	ExpireAt *time.Time `json:"expireAt" example:"2025-03-29T18:20:05.267Z"`
+}
+
+type UserExport struct {
+	// When was this export generated?
+	ExportedAt time.Time `json:"exportedAt" example:"2025-03-29T18:20:05.267Z"`
+	// Profile of the user, including its claims.
+	User User `json:"user"`
+	// Sessions currently opened for this account (tokens are not exported).
+	Sessions []Session `json:"sessions"`
+	// External accounts linked to this one (access tokens are not exported).
+	Oidc []OidcLink `json:"oidc"`
+	// Api keys created by this user (tokens are not exported).
+	ApiKeys []ApiKey `json:"apiKeys"`
+	// History of security relevant actions on this account.
+	Audit []AuditLog `json:"audit"`
+}
+
+// @Summary      Export my data
+// @Description  Export every personal data keibi stores about the current user.
+// @Description  Use `format=zip` to also retrieve the uploaded profile picture.
+// @Tags         users
+// @Produce      json
+// @Produce      application/zip
+// @Security     Jwt
+// @Param        format  query  string  false  "Export format" Enums(json, zip) default(json)
+// @Success      200  {object}  UserExport
+// @Failure      401  {object}  KError "Missing jwt token"
+// @Failure      422  {object}  KError "Invalid format"
+// @Router /users/me/export [get]
+func (h *Handler) ExportMe(c *echo.Context) error {
+	ctx := c.Request().Context()
+	format := c.QueryParam("format")
+	if format != "" && format != "json" && format != "zip" {
+		return NewError(http.StatusUnprocessableEntity, ErrInvalidFormat, "Invalid format, expected json or zip.")
+	}
+
+	uid, err := GetCurrentUserId(c)
+	if err != nil {
+		return err
+	}
+	dbuser, err := h.db.GetUser(ctx, dbc.GetUserParams{
+		UseId: true,
+		Id:    uid,
+	})
+	if err != nil {
+		return err
+	}
+
+	ret := UserExport{
+		ExportedAt: time.Now().UTC(),
+		User:       MapDbUser(&dbuser.User),
+		Sessions:   make([]Session, 0),
+		Oidc:       make([]OidcLink, 0),
+		ApiKeys:    make([]ApiKey, 0),
+		Audit:      make([]AuditLog, 0),
+	}
+	ret.User.Oidc = dbuser.Oidc
+
+	sessions, err := h.db.GetUserSessions(ctx, dbuser.User.Pk)
+	if err != nil {
+		return err
+	}
+	for _, ses := range sessions {
+		ret.Sessions = append(ret.Sessions, MapSession(&ses))
+	}
+
+	handles, err := h.db.GetUserOidcHandles(ctx, dbuser.User.Pk)
+	if err != nil {
+		return err
+	}
+	for _, handle := range handles {
+		ret.Oidc = append(ret.Oidc, OidcLink{
+			Provider:   handle.Provider,
+			Id:         handle.Id,
+			Username:   handle.Username,
+			ProfileUrl: handle.ProfileUrl,
+			ExpireAt:   handle.ExpireAt,
+		})
+	}
+
+	keys, err := h.db.ListApiKeysCreatedBy(ctx, &dbuser.User.Pk)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		ret.ApiKeys = append(ret.ApiKeys, MapDbKey(&key).ApiKey)
+	}
+
+	logs, err := h.db.GetUserAuditLogs(ctx, dbuser.User.Pk)
+	if err != nil {
+		return err
+	}
+	for _, log := range logs {
+		ret.Audit = append(ret.Audit, MapAuditLog(&log))
+	}
+
+	h.audit(ctx, dbuser.User.Pk, "user.exported", map[string]any{
+		"format": format,
+	})
+
+	if format != "zip" {
+		return c.JSON(http.StatusOK, ret)
+	}
+	return h.writeExportZip(c, &ret)
+}
+
+func (h *Handler) writeExportZip(c *echo.Context, export *UserExport) error {
+	logo, err := os.ReadFile(h.logoPath(export.User.Id))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, "application/zip")
+	c.Response().Header().Set(
+		echo.HeaderContentDisposition,
+		fmt.Sprintf("attachment; filename=\"keibi-%s.zip\"", export.User.Id),
+	)
+	c.Response().WriteHeader(http.StatusOK)
+
+	archive := zip.NewWriter(c.Response())
+	data, err := archive.Create("user.json")
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(data)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(export); err != nil {
+		return err
+	}
+
+	if logo != nil {
+		ext := strings.TrimPrefix(http.DetectContentType(logo), "image/")
+		file, err := archive.Create(fmt.Sprintf("logo.%s", ext))
+		if err != nil {
+			return err
+		}
+		if _, err := file.Write(logo); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/pbkdf2"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+
+	"github.com/alexedwards/argon2id"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Passwords are stored as PHC-like strings, the first segment (`$argon2id$`, `$2b$`, `$PBKDF2-SHA512$`...)
+// tags the algorithm used. Only argon2id is used for new passwords, other algorithms are accepted for
+// imported users and transparently replaced by an argon2id hash on the next login.
+
+var ErrUnknownHash = errors.New("unsupported password hash format")
+
+// verifyPassword checks a password against a stored hash.
+// needsRehash is true if the hash uses a legacy algorithm and should be replaced.
+func verifyPassword(password string, hash string) (match bool, needsRehash bool, err error) {
+	switch {
+	case strings.HasPrefix(hash, "$argon2id$"):
+		match, err = argon2id.ComparePasswordAndHash(password, hash)
+		return match, false, err
+	case isBcryptHash(hash):
+		err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, false, nil
+		}
+		return err == nil, true, err
+	case strings.HasPrefix(hash, "$PBKDF2"):
+		match, err = comparePbkdf2(password, hash)
+		return match, true, err
+	}
+	return false, false, ErrUnknownHash
+}
+
+// validateLegacyHash returns an error if the given hash can't be verified by verifyPassword.
+func validateLegacyHash(hash string) error {
+	switch {
+	case strings.HasPrefix(hash, "$argon2id$"):
+		_, _, _, err := argon2id.DecodeHash(hash)
+		return err
+	case isBcryptHash(hash):
+		_, err := bcrypt.Cost([]byte(hash))
+		return err
+	case strings.HasPrefix(hash, "$PBKDF2"):
+		_, _, _, _, err := decodePbkdf2(hash)
+		return err
+	}
+	return ErrUnknownHash
+}
+
+func isBcryptHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+// decodePbkdf2 parses hashes formatted like jellyfin/emby's: `$PBKDF2-SHA512$iterations=210000$SALT$HASH`
+// with an hex encoded salt & hash. `$PBKDF2$` (without digest) means SHA1.
+func decodePbkdf2(encoded string) (func() hash.Hash, int, []byte, []byte, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 5 {
+		return nil, 0, nil, nil, fmt.Errorf("invalid pbkdf2 hash: expected 4 segments, got %d", len(parts)-1)
+	}
+
+	var digest func() hash.Hash
+	switch parts[1] {
+	case "PBKDF2", "PBKDF2-SHA1":
+		digest = sha1.New
+	case "PBKDF2-SHA256":
+		digest = sha256.New
+	case "PBKDF2-SHA512":
+		digest = sha512.New
+	default:
+		return nil, 0, nil, nil, fmt.Errorf("invalid pbkdf2 hash: unsupported digest %s", parts[1])
+	}
+
+	iter, err := strconv.Atoi(strings.TrimPrefix(parts[2], "iterations="))
+	if err != nil || iter < 1 {
+		return nil, 0, nil, nil, fmt.Errorf("invalid pbkdf2 hash: invalid iterations %q", parts[2])
+	}
+	salt, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return nil, 0, nil, nil, fmt.Errorf("invalid pbkdf2 hash: invalid salt: %w", err)
+	}
+	key, err := hex.DecodeString(parts[4])
+	if err != nil || len(key) == 0 {
+		return nil, 0, nil, nil, errors.New("invalid pbkdf2 hash: invalid hash")
+	}
+	return digest, iter, salt, key, nil
+}
+
+func comparePbkdf2(password string, encoded string) (bool, error) {
+	digest, iter, salt, key, err := decodePbkdf2(encoded)
+	if err != nil {
+		return false, err
+	}
+	other, err := pbkdf2.Key(digest, password, salt, iter, len(key))
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v5"
+	"github.com/zoriya/kyoo/keibi/dbc"
+	. "github.com/zoriya/kyoo/keibi/models"
+)
+
+const maxImpersonationDuration = time.Hour
+
+type ImpersonateDto struct {
+	// How long the impersonation jwt is valid (a go duration, max 1h).
+	Duration string `json:"duration" example:"15m"`
+}
+
+// getActor returns the `act` claim (RFC 8693) of impersonation jwts, nil otherwise.
+func getActor(c *echo.Context) *Actor {
+	token, ok := c.Get("user").(*jwt.Token)
+	if !ok {
+		return nil
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil
+	}
+	act, ok := claims["act"].(map[string]any)
+	if !ok {
+		return nil
+	}
+	sub, _ := act["sub"].(string)
+	id, err := uuid.Parse(sub)
+	if err != nil {
+		return nil
+	}
+	username, _ := act["username"].(string)
+	return &Actor{Id: id, Username: username}
+}
+
+// RejectImpersonatedWrites makes impersonation tokens read-only.
+func (h *Handler) RejectImpersonatedWrites(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c *echo.Context) error {
+		method := c.Request().Method
+		if method != http.MethodGet && method != http.MethodHead && getActor(c) != nil {
+			return NewError(http.StatusForbidden, ErrImpersonationReadOnly, "Impersonation tokens are read-only.")
+		}
+		return next(c)
+	}
+}
+
+// readOnlyPermissions returns the read permissions granted by both `permissions` claims (the impersonated user's
+// and the actor's), expanding wildcards. Impersonating someone never grants permissions the actor doesn't have.
+func (h *Handler) readOnlyPermissions(claims jwt.MapClaims, actorClaims jwt.MapClaims) []string {
+	granted := claimPermissions(claims)
+	actorGranted := claimPermissions(actorClaims)
+
+	names := make([]string, 0, len(Permissions)+len(h.config.ExtraPermissions))
+	for _, p := range Permissions {
+		names = append(names, p.Name)
+	}
+	names = append(names, h.config.ExtraPermissions...)
+
+	ret := make([]string, 0)
+	for _, name := range names {
+		readOnly := strings.HasSuffix(name, ".read") || name == "core.play"
+		if readOnly && HasPermission(granted, name) && HasPermission(actorGranted, name) && !slices.Contains(ret, name) {
+			ret = append(ret, name)
+		}
+	}
+	return ret
+}
+
+// @Summary      Impersonate user
+// @Description  Create a short lived jwt to view the app as another user (for debugging purposes).
+// @Description  The jwt has an `act` claim (RFC 8693) identifying you and only keeps the read permissions of the user
+// @Description  that you also have.
+// @Description  It is rejected by keibi's write routes and /jwt never extends its expiration. Each impersonation is visible in
+// @Description  the user's session list and audit log.
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Security     Jwt[users.impersonate]
+// @Param        id    path  string          true   "The id or username of the user to impersonate"
+// @Param        body  body  ImpersonateDto  false  "Impersonation settings"
+// @Success      201  {object}  Jwt
+// @Failure      403  {object}  KError "Missing permissions: users.impersonate."
+// @Failure      404  {object}  KError "No user found with id or username"
+// @Failure      422  {object}  KError "Invalid duration or trying to impersonate yourself"
+// @Router /users/{id}/impersonate [post]
+func (h *Handler) ImpersonateUser(c *echo.Context) error {
+	ctx := c.Request().Context()
+	err := CheckPermissions(c, []string{"users.impersonate"})
+	if err != nil {
+		return err
+	}
+
+	var req ImpersonateDto
+	if err := c.Bind(&req); err != nil {
+		return NewError(http.StatusUnprocessableEntity, ErrInvalidBody, err.Error())
+	}
+	duration, err := time.ParseDuration(cmp.Or(req.Duration, "15m"))
+	if err != nil || duration <= 0 || duration > maxImpersonationDuration {
+		return NewError(
+			http.StatusUnprocessableEntity,
+			ErrInvalidDuration,
+			"Invalid `duration`: must be a positive duration of at most 1h.",
+		)
+	}
+
+	adminId, err := GetCurrentUserId(c)
+	if err != nil {
+		return err
+	}
+	admin, err := h.db.GetUser(ctx, dbc.GetUserParams{
+		UseId: true,
+		Id:    adminId,
+	})
+	if err == pgx.ErrNoRows {
+		return NewError(http.StatusForbidden, ErrUsersOnly, "Only users can impersonate others (not api keys).")
+	} else if err != nil {
+		return err
+	}
+
+	id := c.Param("id")
+	uid, err := uuid.Parse(id)
+	target, err := h.db.GetUser(ctx, dbc.GetUserParams{
+		UseId:    err == nil,
+		Id:       uid,
+		Username: id,
+	})
+	if err == pgx.ErrNoRows {
+		return NewError(404, ErrUserNotFound, fmt.Sprintf("No user found with id or username: '%s'.", id))
+	} else if err != nil {
+		return err
+	}
+	if target.User.Id == admin.User.Id {
+		return NewError(http.StatusUnprocessableEntity, ErrImpersonateSelf, "You can't impersonate yourself.")
+	}
+
+	// the session is only used to show the impersonation in the user's session list, its token is never returned.
+	token := make([]byte, 64)
+	if _, err := rand.Read(token); err != nil {
+		return err
+	}
+	session, err := h.db.CreateImpersonationSession(ctx, dbc.CreateImpersonationSessionParams{
+		Token:                base64.RawURLEncoding.EncodeToString(token),
+		UserPk:               target.User.Pk,
+		Device:               new("Impersonation"),
+		ImpersonatorId:       &admin.User.Id,
+		ImpersonatorUsername: &admin.User.Username,
+	})
+	if err != nil {
+		return err
+	}
+
+	expireAt := time.Now().UTC().Add(duration)
+	signed, err := h.createImpersonationJwt(&target.User, &admin.User, session.Id, uuid.New(), expireAt)
+	if err != nil {
+		return err
+	}
+
+	h.audit(ctx, target.User.Pk, "user.impersonated", map[string]any{
+		"session":       session.Id,
+		"actor":         admin.User.Id,
+		"actorUsername": admin.User.Username,
+		"expireAt":      expireAt,
+	})
+	h.emit(ctx, EventSessionCreated, map[string]any{
+		"userId":  target.User.Id,
+		"session": MapSession(&session),
+	})
+	return c.JSON(http.StatusCreated, Jwt{
+		Token: &signed,
+	})
+}
+
+// createImpersonationJwt signs a read-only jwt of user for the actor.
+func (h *Handler) createImpersonationJwt(
+	user *dbc.User,
+	actor *dbc.User,
+	sid uuid.UUID,
+	jti uuid.UUID,
+	expireAt time.Time,
+) (string, error) {
+	claims := maps.Clone(user.Claims)
+	claims["permissions"] = h.readOnlyPermissions(user.Claims, actor.Claims)
+	claims["username"] = user.Username
+	claims["sub"] = user.Id.String()
+	claims["sid"] = sid.String()
+	claims["jti"] = jti.String()
+	claims["iss"] = h.config.PublicUrl
+	claims["act"] = map[string]any{
+		"sub":      actor.Id.String(),
+		"username": actor.Username,
+	}
+	claims["iat"] = &jwt.NumericDate{
+		Time: time.Now().UTC(),
+	}
+	claims["exp"] = &jwt.NumericDate{
+		Time: expireAt,
+	}
+	jwtTok := jwt.NewWithClaims(h.config.JwtSigningMethod, claims)
+	jwtTok.Header["kid"] = h.config.JwtKid
+	return jwtTok.SignedString(h.config.JwtPrivateKey)
+}
+
+// refreshImpersonationJwt re-signs an impersonation jwt (for example when it goes through the api's forward auth).
+// The new jwt keeps the same actor and never outlives the original one. Its permissions are computed again from
+// the current permissions of both users, so revoking the actor's permissions applies to running impersonations.
+func (h *Handler) refreshImpersonationJwt(ctx context.Context, claims jwt.MapClaims, sid uuid.UUID, jti uuid.UUID) (string, error) {
+	exp, err := claims.GetExpirationTime()
+	if err != nil || exp == nil {
+		return "", NewError(http.StatusForbidden, ErrInvalidToken, "Missing expiration in JWT")
+	}
+	// the session is deleted when the impersonated user logs it out, which revokes the impersonation.
+	session, err := h.getSessionFromId(ctx, sid)
+	if err != nil {
+		return "", NewError(http.StatusForbidden, ErrInvalidSession, "Session not found")
+	}
+	act, _ := claims["act"].(map[string]any)
+	actorSub, _ := act["sub"].(string)
+	actorId, err := uuid.Parse(actorSub)
+	if err != nil {
+		return "", NewError(http.StatusForbidden, ErrInvalidToken, "Invalid actor in JWT")
+	}
+	actor, err := h.db.GetUser(ctx, dbc.GetUserParams{
+		UseId: true,
+		Id:    actorId,
+	})
+	if err == pgx.ErrNoRows {
+		return "", NewError(http.StatusForbidden, ErrInvalidSession, "The impersonating user was deleted")
+	} else if err != nil {
+		return "", err
+	}
+	if !HasPermission(claimPermissions(actor.User.Claims), "users.impersonate") {
+		return "", NewError(http.StatusForbidden, ErrMissingPermissions, "The impersonating user lost the users.impersonate permission.")
+	}
+	return h.createImpersonationJwt(&session.User, &actor.User, sid, jti, exp.Time)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/jackc/pgerrcode"
+	"github.com/labstack/echo/v5"
+	"github.com/zoriya/kyoo/keibi/dbc"
+	. "github.com/zoriya/kyoo/keibi/models"
+)
+
+type ImportUserDto struct {
+	// Username of the account, can't contain @ signs.
+	Username string `json:"username" validate:"required,excludes=@" example:"zoriya"`
+	Email    string `json:"email" validate:"required,email" format:"email" example:"kyoo@zoriya.dev"`
+	// Hash of the user's password, either argon2id, bcrypt (`$2b$...`) or jellyfin/emby's pbkdf2
+	// (`$PBKDF2-SHA512$iterations=210000$SALT$HASH`). Leave empty for users without passwords (they
+	// will need to login via oidc or have their password set by an admin).
+	PasswordHash string `json:"passwordHash" example:"$PBKDF2-SHA512$iterations=210000$F1A2...$9B3C..."`
+}
+
+type ImportError struct {
+	// Line (or index) of the invalid entry, starting at 1.
+	Line     int    `json:"line" example:"3"`
+	Username string `json:"username" example:"zoriya"`
+	Message  string `json:"message" example:"Email or username already taken"`
+}
+
+type ImportResult struct {
+	Created []User        `json:"created"`
+	Errors  []ImportError `json:"errors"`
+}
+
+// parseImport reads a json array or a csv file (with an `username,email,passwordHash` header).
+func parseImport(r io.Reader, isCsv bool) ([]ImportUserDto, error) {
+	var ret []ImportUserDto
+	if !isCsv {
+		if err := json.NewDecoder(r).Decode(&ret); err != nil {
+			return nil, fmt.Errorf("invalid json: %w", err)
+		}
+		return ret, nil
+	}
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("invalid csv: %w", err)
+	}
+	columns := map[string]int{"username": -1, "email": -1, "passwordhash": -1}
+	for i, col := range header {
+		col = strings.ToLower(strings.TrimSpace(col))
+		if _, ok := columns[col]; ok {
+			columns[col] = i
+		}
+	}
+	if columns["username"] == -1 || columns["email"] == -1 {
+		return nil, errors.New("invalid csv: missing username or email column")
+	}
+	get := func(record []string, col string) string {
+		if i := columns[col]; i >= 0 && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid csv: %w", err)
+		}
+		ret = append(ret, ImportUserDto{
+			Username:     get(record, "username"),
+			Email:        get(record, "email"),
+			PasswordHash: get(record, "passwordhash"),
+		})
+	}
+	return ret, nil
+}
+
+// importUsers creates every valid entry. Invalid or conflicting entries are skipped and reported.
+func (h *Handler) importUsers(ctx context.Context, validate *validator.Validate, users []ImportUserDto, source string) (ImportResult, error) {
+	ret := ImportResult{
+		Created: make([]User, 0, len(users)),
+		Errors:  make([]ImportError, 0),
+	}
+	for i, entry := range users {
+		fail := func(msg string) {
+			ret.Errors = append(ret.Errors, ImportError{
+				Line:     i + 1,
+				Username: entry.Username,
+				Message:  msg,
+			})
+		}
+
+		if err := validate.Struct(&entry); err != nil {
+			fail(err.Error())
+			continue
+		}
+		var password *string
+		if entry.PasswordHash != "" {
+			if err := validateLegacyHash(entry.PasswordHash); err != nil {
+				fail(err.Error())
+				continue
+			}
+			password = &entry.PasswordHash
+		}
+
+		duser, err := h.db.CreateUser(ctx, dbc.CreateUserParams{
+			Username: entry.Username,
+			Email:    entry.Email,
+			Password: password,
+			Claims:   h.config.DefaultClaims,
+			// imported users should never become admin, even on an empty instance.
+			FirstClaims: h.config.DefaultClaims,
+		})
+		if ErrIs(err, pgerrcode.UniqueViolation) {
+			fail("Email or username already taken")
+			continue
+		} else if err != nil {
+			return ret, err
+		}
+
+		user := MapDbUser(&duser)
+		ret.Created = append(ret.Created, user)
+		h.audit(ctx, user.Pk, "user.imported", map[string]any{
+			"source": source,
+		})
+		h.emit(ctx, EventUserCreated, user)
+	}
+	return ret, nil
+}
+
+// @Summary      Import users
+// @Description  Create users from another service (jellyfin, emby, plex...), keeping their password hashes.
+// @Description  Accepts either a json array or a csv file (`Content-Type: text/csv`) with an
+// @Description  `username,email,passwordHash` header. Legacy hashes are replaced by argon2id on the next login.
+// @Tags         users
+// @Accept       json
+// @Accept       text/csv
+// @Produce      json
+// @Security     Jwt[users.write]
+// @Param        users  body  []ImportUserDto  true  "Users to import"
+// @Success      200  {object}  ImportResult
+// @Failure      403  {object}  KError "Missing permissions: users.write."
+// @Failure      422  {object}  KError "Invalid body"
+// @Router /users/import [post]
+func (h *Handler) ImportUsers(c *echo.Context) error {
+	ctx := c.Request().Context()
+	err := CheckPermissions(c, []string{"users.write"})
+	if err != nil {
+		return err
+	}
+
+	mediatype, _, _ := mime.ParseMediaType(c.Request().Header.Get(echo.HeaderContentType))
+	isCsv := slices.Contains([]string{"text/csv", "application/csv"}, mediatype)
+	users, err := parseImport(c.Request().Body, isCsv)
+	if err != nil {
+		return NewError(http.StatusUnprocessableEntity, ErrInvalidFormat, err.Error())
+	}
+
+	v := c.Echo().Validator.(*Validator)
+	ret, err := h.importUsers(ctx, v.validator, users, "api")
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, ret)
+}
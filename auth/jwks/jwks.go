@@ -0,0 +1,82 @@
+// Package jwks caches json web key sets, it's shared by keibi (oidc back-channel logouts) and its go client.
+package jwks
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v3/jwk"
+)
+
+// How long a jwks is kept before being fetched again.
+const CacheDuration = time.Hour
+
+// Unknown key ids trigger a refetch (for key rotations), at most once per this duration.
+const RefreshInterval = time.Minute
+
+type cachedSet struct {
+	// held while fetching, concurrent lookups of the same url wait for it instead of fetching again.
+	lock      sync.Mutex
+	set       jwk.Set
+	fetchedAt time.Time
+}
+
+// Cache keeps the jwks of multiple urls, the zero value is ready to use.
+type Cache struct {
+	lock sync.Mutex
+	sets map[string]*cachedSet
+}
+
+func (c *Cache) entry(url string) *cachedSet {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.sets == nil {
+		c.sets = make(map[string]*cachedSet)
+	}
+	ret, ok := c.sets[url]
+	if !ok {
+		ret = &cachedSet{}
+		c.sets[url] = ret
+	}
+	return ret
+}
+
+// Key returns the public key with the given kid from the (cached) jwks served at url.
+// A slow jwks only blocks lookups of its own url.
+func (c *Cache) Key(ctx context.Context, url string, kid string) (any, error) {
+	cached := c.entry(url)
+	cached.lock.Lock()
+	defer cached.lock.Unlock()
+
+	if cached.set != nil && time.Since(cached.fetchedAt) < CacheDuration {
+		if key, found := cached.set.LookupKeyID(kid); found {
+			return export(key)
+		}
+		if time.Since(cached.fetchedAt) < RefreshInterval {
+			return nil, fmt.Errorf("unknown key id %q", kid)
+		}
+	}
+
+	set, err := jwk.Fetch(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	cached.set = set
+	cached.fetchedAt = time.Now()
+	key, found := set.LookupKeyID(kid)
+	if !found {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	return export(key)
+}
+
+func export(key jwk.Key) (any, error) {
+	var raw any
+	if err := jwk.Export(key, &raw); err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
@@ -0,0 +1,154 @@
+package jwks
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v3/jwk"
+)
+
+// jwksServer serves the public keys of kids, it can be changed to simulate a key rotation.
+type jwksServer struct {
+	lock sync.Mutex
+	kids []string
+	hits atomic.Int32
+}
+
+func (s *jwksServer) setKids(kids ...string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.kids = kids
+}
+
+func (s *jwksServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.hits.Add(1)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	set := jwk.NewSet()
+	for _, kid := range s.kids {
+		pub, _, _ := ed25519.GenerateKey(rand.Reader)
+		key, _ := jwk.Import(pub)
+		key.Set(jwk.KeyIDKey, kid)
+		set.AddKey(key)
+	}
+	json.NewEncoder(w).Encode(set)
+}
+
+func TestCacheRefetchesUnknownKid(t *testing.T) {
+	jwks := &jwksServer{kids: []string{"key-1"}}
+	server := httptest.NewServer(jwks)
+	defer server.Close()
+
+	var cache Cache
+	ctx := context.Background()
+
+	if _, err := cache.Key(ctx, server.URL, "key-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.Key(ctx, server.URL, "key-1"); err != nil {
+		t.Fatal(err)
+	}
+	if hits := jwks.hits.Load(); hits != 1 {
+		t.Fatalf("expected the jwks to be cached, got %d fetches", hits)
+	}
+
+	jwks.setKids("key-2", "key-1")
+	// the jwks was just fetched, unknown kids are rejected without a new fetch.
+	if _, err := cache.Key(ctx, server.URL, "key-2"); err == nil {
+		t.Fatal("expected key-2 to be unknown")
+	}
+	if hits := jwks.hits.Load(); hits != 1 {
+		t.Fatalf("expected no refetch within the refresh interval, got %d fetches", hits)
+	}
+
+	cache.backdate(server.URL, RefreshInterval)
+	if _, err := cache.Key(ctx, server.URL, "key-2"); err != nil {
+		t.Fatal(err)
+	}
+	if hits := jwks.hits.Load(); hits != 2 {
+		t.Fatalf("expected the unknown kid to trigger a refetch, got %d fetches", hits)
+	}
+
+	// known kids are only refetched once the cache expires.
+	cache.backdate(server.URL, RefreshInterval)
+	if _, err := cache.Key(ctx, server.URL, "key-1"); err != nil {
+		t.Fatal(err)
+	}
+	if hits := jwks.hits.Load(); hits != 2 {
+		t.Fatalf("expected a cache hit, got %d fetches", hits)
+	}
+	cache.backdate(server.URL, CacheDuration)
+	if _, err := cache.Key(ctx, server.URL, "key-1"); err != nil {
+		t.Fatal(err)
+	}
+	if hits := jwks.hits.Load(); hits != 3 {
+		t.Fatalf("expected the expired jwks to be refetched, got %d fetches", hits)
+	}
+}
+
+func TestCacheKeepsUrlsApart(t *testing.T) {
+	first := httptest.NewServer(&jwksServer{kids: []string{"first"}})
+	defer first.Close()
+	second := httptest.NewServer(&jwksServer{kids: []string{"second"}})
+	defer second.Close()
+
+	var cache Cache
+	ctx := context.Background()
+	if _, err := cache.Key(ctx, first.URL, "first"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.Key(ctx, second.URL, "second"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.Key(ctx, first.URL, "second"); err == nil {
+		t.Fatal("expected keys of another jwks to be rejected")
+	}
+}
+
+func TestSlowJwksDoesNotBlockOtherUrls(t *testing.T) {
+	unblock := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+		json.NewEncoder(w).Encode(jwk.NewSet())
+	}))
+	defer slow.Close()
+	defer close(unblock)
+	fast := httptest.NewServer(&jwksServer{kids: []string{"fast"}})
+	defer fast.Close()
+
+	var cache Cache
+	ctx := context.Background()
+	go cache.Key(ctx, slow.URL, "slow")
+
+	done := make(chan error)
+	go func() {
+		// give the slow fetch time to start.
+		time.Sleep(50 * time.Millisecond)
+		_, err := cache.Key(ctx, fast.URL, "fast")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the lookup waited for the fetch of another url")
+	}
+}
+
+func (c *Cache) backdate(url string, by time.Duration) {
+	cached := c.entry(url)
+	cached.lock.Lock()
+	defer cached.lock.Unlock()
+	cached.fetchedAt = cached.fetchedAt.Add(-by)
+}
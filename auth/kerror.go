@@ -1,7 +1,164 @@
 package main
 
+import (
+	"net/http"
+)
+
 type KError struct {
-	Status  int    `json:"status" example:"404"`
-	Message string `json:"message" example:"No user found with this id"`
-	Details any    `json:"details"`
+	Status int `json:"status" example:"404"`
+	// Stable identifier of the error, use it instead of the message to react to specific errors.
+	Code    ErrorCode `json:"code" example:"users.not_found"`
+	Message string    `json:"message" example:"No user found with this id"`
+	Details any       `json:"details"`
+}
+
+// DetailedError is an error whose details are sent to the client in KError.Details.
+type DetailedError interface {
+	error
+	StatusCode() int
+	ErrorCode() ErrorCode
+	Details() any
+}
+
+// ErrorCode is a machine-readable error identifier. Codes are part of the api: never rename or reuse one.
+type ErrorCode string
+
+const (
+	// Generic errors, mostly used when no specific code applies.
+	ErrBadRequest             ErrorCode = "request.bad_request"
+	ErrInvalidBody            ErrorCode = "request.invalid_body"
+	ErrValidationFailed       ErrorCode = "request.validation_failed"
+	ErrInvalidId              ErrorCode = "request.invalid_id"
+	ErrInvalidCursor          ErrorCode = "request.invalid_cursor"
+	ErrInvalidDuration        ErrorCode = "request.invalid_duration"
+	ErrInvalidFormat          ErrorCode = "request.invalid_format"
+	ErrFileTooLarge           ErrorCode = "request.file_too_large"
+	ErrMissingRedirectUrl     ErrorCode = "request.missing_redirect_url"
+	ErrUnauthorizedRedirect   ErrorCode = "request.unauthorized_redirect_url"
+	ErrNotFound               ErrorCode = "request.not_found"
+	ErrMethodNotAllowed       ErrorCode = "request.method_not_allowed"
+	ErrRateLimited            ErrorCode = "request.rate_limited"
+	ErrInternal               ErrorCode = "server.internal_error"
+	ErrMissingSecretKey       ErrorCode = "server.missing_secret_key"
+	ErrUnauthorized           ErrorCode = "auth.unauthorized"
+	ErrForbidden              ErrorCode = "auth.forbidden"
+	ErrNotLoggedIn            ErrorCode = "auth.not_logged_in"
+	ErrGuestsNotAllowed       ErrorCode = "auth.guests_not_allowed"
+	ErrInvalidBearer          ErrorCode = "auth.invalid_bearer"
+	ErrInvalidToken           ErrorCode = "auth.invalid_token"
+	ErrTokenExpired           ErrorCode = "auth.token_expired"
+	ErrTokenReused            ErrorCode = "auth.token_reused"
+	ErrInvalidSession         ErrorCode = "auth.invalid_session"
+	ErrSessionExpired         ErrorCode = "auth.session_expired"
+	ErrSessionRequired        ErrorCode = "auth.session_required"
+	ErrRotatingSession        ErrorCode = "auth.rotating_session"
+	ErrInvalidApiKey          ErrorCode = "auth.invalid_api_key"
+	ErrUsersOnly              ErrorCode = "auth.users_only"
+	ErrServicesOnly           ErrorCode = "auth.services_only"
+	ErrMissingPermissions     ErrorCode = "auth.missing_permissions"
+	ErrInvalidPermissionClaim ErrorCode = "auth.invalid_permission_claim"
+	ErrImpersonationReadOnly  ErrorCode = "auth.impersonation_read_only"
+	ErrInvalidPassword        ErrorCode = "auth.invalid_password"
+	ErrMissingOldPassword     ErrorCode = "auth.missing_old_password"
+	ErrPasswordPolicy         ErrorCode = "auth.password_policy"
+	ErrNoPassword             ErrorCode = "auth.no_password"
+	ErrRegistrationsDisabled  ErrorCode = "auth.registrations_disabled"
+	ErrPasswordResetRequired  ErrorCode = "auth.password_reset_required"
+	ErrChallengeRequired      ErrorCode = "auth.challenge_required"
+	ErrChallengeInvalid       ErrorCode = "auth.challenge_invalid"
+	ErrChallengeUnavailable   ErrorCode = "auth.challenge_unavailable"
+	ErrChallengesDisabled     ErrorCode = "auth.challenges_disabled"
+	ErrUserNotFound           ErrorCode = "users.not_found"
+	ErrUserExists             ErrorCode = "users.already_exists"
+	ErrAmbiguousEmail         ErrorCode = "users.ambiguous_email"
+	ErrProtectedClaim         ErrorCode = "users.protected_claim"
+	ErrNoPendingDeletion      ErrorCode = "users.no_pending_deletion"
+	ErrImpersonateSelf        ErrorCode = "users.impersonate_self"
+	ErrSessionNotFound        ErrorCode = "sessions.not_found"
+	ErrApiKeyNotFound         ErrorCode = "apikeys.not_found"
+	ErrApiKeyExists           ErrorCode = "apikeys.already_exists"
+	ErrApiKeyReadOnly         ErrorCode = "apikeys.read_only"
+	ErrPermissionEscalation   ErrorCode = "apikeys.permission_escalation"
+	ErrUnknownPermissions     ErrorCode = "permissions.unknown"
+	ErrJwtNotRevocable        ErrorCode = "tokens.jwt_not_revocable"
+	ErrInvalidPresign         ErrorCode = "presign.invalid"
+	ErrPresignRevoked         ErrorCode = "presign.revoked"
+	ErrPresignNotFound        ErrorCode = "presign.not_found"
+	ErrInvalidPresignRule     ErrorCode = "presign.invalid_rule"
+	ErrLogoNotFound           ErrorCode = "logos.not_found"
+	ErrInvalidImage           ErrorCode = "logos.invalid_image"
+	ErrInvalidLogoSize        ErrorCode = "logos.invalid_size"
+	ErrGravatarUnavailable    ErrorCode = "logos.gravatar_unavailable"
+	ErrInvalidSettings        ErrorCode = "settings.invalid"
+	ErrWebhookNotFound        ErrorCode = "webhooks.not_found"
+	ErrProviderNotFound       ErrorCode = "oidc.provider_not_found"
+	ErrProviderExists         ErrorCode = "oidc.provider_already_exists"
+	ErrProviderReadOnly       ErrorCode = "oidc.provider_read_only"
+	ErrProviderUnavailable    ErrorCode = "oidc.provider_error"
+	ErrOidcStateExpired       ErrorCode = "oidc.state_expired"
+	ErrOidcLoginExpired       ErrorCode = "oidc.login_expired"
+	ErrOidcMissingCode        ErrorCode = "oidc.missing_code"
+	ErrOidcAlreadyLinked      ErrorCode = "oidc.already_linked"
+	ErrBackchannelDisabled    ErrorCode = "oidc.backchannel_logout_disabled"
+	ErrInvalidLogoutToken     ErrorCode = "oidc.invalid_logout_token"
+	ErrMagicLinksDisabled     ErrorCode = "magic_links.disabled"
+	ErrMagicLinkExpired       ErrorCode = "magic_links.expired"
+	ErrMagicLinkInvalidCode   ErrorCode = "magic_links.invalid_code"
+	ErrTooManyMagicLinks      ErrorCode = "magic_links.rate_limited"
+	ErrLoginAlertExpired      ErrorCode = "login_alerts.expired"
+)
+
+// CodedError is an http error with a stable code, returned by handlers instead of plain echo errors.
+type CodedError struct {
+	Status  int
+	Code    ErrorCode
+	Message string
+	Detail  any
+}
+
+func NewError(status int, code ErrorCode, message string) *CodedError {
+	return &CodedError{Status: status, Code: code, Message: message}
+}
+
+func (e *CodedError) Error() string {
+	return e.Message
+}
+
+func (e *CodedError) StatusCode() int {
+	return e.Status
+}
+
+func (e *CodedError) ErrorCode() ErrorCode {
+	return e.Code
+}
+
+func (e *CodedError) Details() any {
+	return e.Detail
+}
+
+// defaultErrorCode is used for errors raised outside of our handlers (routing, middlewares...).
+func defaultErrorCode(status int) ErrorCode {
+	switch status {
+	case http.StatusBadRequest:
+		return ErrBadRequest
+	case http.StatusUnauthorized:
+		return ErrUnauthorized
+	case http.StatusForbidden:
+		return ErrForbidden
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusMethodNotAllowed:
+		return ErrMethodNotAllowed
+	case http.StatusRequestEntityTooLarge:
+		return ErrFileTooLarge
+	case http.StatusUnsupportedMediaType, http.StatusUnprocessableEntity:
+		return ErrInvalidBody
+	case http.StatusTooManyRequests:
+		return ErrRateLimited
+	default:
+		if status < 500 {
+			return ErrBadRequest
+		}
+		return ErrInternal
+	}
 }
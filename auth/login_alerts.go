@@ -0,0 +1,403 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/alexedwards/argon2id"
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v5"
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/zoriya/kyoo/keibi/dbc"
+	. "github.com/zoriya/kyoo/keibi/models"
+)
+
+type LoginAlertConfig struct {
+	Enabled bool
+	// How long the "this wasn't me" link of an alert stays valid.
+	Ttl time.Duration
+	// Optional local GeoIP database (mmdb, like MaxMind's GeoLite2 City) used to locate logins.
+	GeoIp *maxminddb.Reader
+}
+
+// Subset of a GeoIP city/country database record.
+type geoIpRecord struct {
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Country struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+}
+
+var loginAlertPage = template.Must(template.New("alert").Parse(`<!doctype html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<title>Secure your account</title>
+</head>
+<body style="font-family: sans-serif; max-width: 30em; margin: 15vh auto">
+	{{if eq .Step "confirm"}}
+	<p>Was this login not made by you? This will logout every session of your account and block logins with your current password until you choose a new one.</p>
+	<form method="post" action="not-me">
+		<input type="hidden" name="token" value="{{.Token}}">
+		<button type="submit">This wasn't me</button>
+	</form>
+	{{else if eq .Step "reset"}}
+	<p>Every session of your account has been logged out and logins with your current password are blocked.</p>
+	<p>Confirm your current password and choose a new one to secure your account:</p>
+	{{if .Error}}<p style="color: red">{{.Error}}</p>{{end}}
+	{{if .Violations}}<ul style="color: red">{{range .Violations}}<li>{{.}}</li>{{end}}</ul>{{end}}
+	<form method="post" action="not-me/password">
+		<input type="hidden" name="token" value="{{.Token}}">
+		<input type="password" name="oldPassword" autocomplete="current-password" placeholder="Current password" required autofocus>
+		<input type="password" name="password" autocomplete="new-password" placeholder="New password" required>
+		<button type="submit">Change password</button>
+	</form>
+	{{else if eq .Step "reported"}}
+	<p>Every session of your account has been logged out.</p>
+	{{else}}
+	<p>Your password has been changed, you can now login again.</p>
+	{{end}}
+</body>
+</html>
+`))
+
+type loginAlertPageData struct {
+	// One of confirm, reset, reported (for accounts without password) or done.
+	Step  string
+	Token string
+	Error string
+	// Messages of the password policy rules the new password breaks.
+	Violations []string
+}
+
+func renderLoginAlertPage(c *echo.Context, status int, data loginAlertPageData) error {
+	c.Response().Header().Set("Cache-Control", "no-store")
+	var page strings.Builder
+	if err := loginAlertPage.Execute(&page, data); err != nil {
+		return err
+	}
+	return c.HTML(status, page.String())
+}
+
+// loginNetwork returns the network (/24 for ipv4, /64 for ipv6) of an ip, users often change ip inside it.
+func loginNetwork(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	addr = addr.Unmap()
+	bits := 64
+	if addr.Is4() {
+		bits = 24
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return ""
+	}
+	return prefix.String()
+}
+
+// locateIp returns an approximate location (`City, Country`) of an ip using the GeoIP database, if any.
+func (h *Handler) locateIp(ip string) *string {
+	if h.config.LoginAlerts.GeoIp == nil {
+		return nil
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return nil
+	}
+	var record geoIpRecord
+	if err := h.config.LoginAlerts.GeoIp.Lookup(addr, &record); err != nil {
+		slog.Warn("Could not locate ip", "err", err)
+		return nil
+	}
+	parts := make([]string, 0, 2)
+	if city := record.City.Names["en"]; city != "" {
+		parts = append(parts, city)
+	}
+	if country := record.Country.Names["en"]; country != "" {
+		parts = append(parts, country)
+	}
+	if len(parts) == 0 {
+		return nil
+	}
+	return new(strings.Join(parts, ", "))
+}
+
+// checkNewDevice compares a new session with the user's previous logins and notifies them (via email and the
+// `session.new_device` webhook) if it comes from a new device or network.
+func (h *Handler) checkNewDevice(ctx context.Context, user User, session dbc.Session, ip string) {
+	if !h.config.LoginAlerts.Enabled {
+		return
+	}
+	device := ""
+	if session.Device != nil {
+		device = formatDevice(*session.Device)
+	}
+	network := loginNetwork(ip)
+
+	known, err := h.db.GetKnownDevice(ctx, dbc.GetKnownDeviceParams{
+		UserPk:  user.Pk,
+		Device:  device,
+		Network: network,
+	})
+	if err != nil {
+		slog.Error("Could not read known devices", "user", user.Id, "err", err)
+		return
+	}
+	err = h.db.TouchKnownDevice(ctx, dbc.TouchKnownDeviceParams{
+		UserPk:  user.Pk,
+		Device:  device,
+		Network: network,
+	})
+	if err != nil {
+		slog.Error("Could not store known device", "user", user.Id, "err", err)
+		return
+	}
+	go h.db.DeleteStaleKnownDevices(ctx)
+	// the first login (or the first since login alerts exist) has nothing to compare with.
+	if !known.HasHistory || (known.KnownDevice && known.KnownNetwork) {
+		return
+	}
+
+	token := make([]byte, 64)
+	if _, err = rand.Read(token); err != nil {
+		slog.Error("Could not create login alert", "user", user.Id, "err", err)
+		return
+	}
+	alert, err := h.db.CreateLoginAlert(ctx, dbc.CreateLoginAlertParams{
+		Token:     base64.RawURLEncoding.EncodeToString(token),
+		UserPk:    user.Pk,
+		SessionId: session.Id,
+		Device:    &device,
+		Ip:        &ip,
+		Location:  h.locateIp(ip),
+		ExpireAt:  time.Now().UTC().Add(h.config.LoginAlerts.Ttl),
+	})
+	if err != nil {
+		slog.Error("Could not create login alert", "user", user.Id, "err", err)
+		return
+	}
+	go h.db.DeleteExpiredLoginAlerts(ctx)
+
+	h.audit(ctx, user.Pk, "session.new_device", map[string]any{
+		"session":  session.Id,
+		"device":   device,
+		"ip":       ip,
+		"location": alert.Location,
+	})
+	// the report link is only sent to the user's email: it must never be readable by webhooks.
+	h.emit(ctx, EventSessionNewDevice, map[string]any{
+		"userId":     user.Id,
+		"session":    MapSession(&session),
+		"ip":         ip,
+		"location":   alert.Location,
+		"newDevice":  !known.KnownDevice,
+		"newNetwork": !known.KnownNetwork,
+	})
+
+	if !h.config.Smtp.Enabled() || user.Email == "" {
+		return
+	}
+	reportUrl := fmt.Sprintf(
+		"%s/auth/sessions/not-me?token=%s",
+		h.config.PublicUrl,
+		url.QueryEscape(alert.Token),
+	)
+	location := "unknown"
+	if alert.Location != nil {
+		location = *alert.Location
+	}
+	body := fmt.Sprintf(
+		"Hello %s,\n\nYour account was just used to login from a new device or location:\n\nDevice: %s\nIp: %s (%s)\nDate: %s\n\nIf it was you, you can ignore this email. Else, open this link to logout every session and change your password:\n\n%s\n",
+		user.Username,
+		cmp.Or(device, "unknown"),
+		ip,
+		location,
+		session.CreatedDate.UTC().Format(time.RFC1123),
+		reportUrl,
+	)
+	if err := h.sendMail(ctx, user.Email, "New login to your account", body); err != nil {
+		slog.Error("Could not send login alert", "user", user.Id, "err", err)
+	}
+}
+
+// @Summary      Report a login
+// @Description  Link sent by login alerts (don't call it manually). It only shows a confirmation page, since mail
+// @Description  scanners open links: the report is done by the page's form.
+// @Tags         sessions
+// @Produce      html
+// @Param        token   query  string  true   "Token of the alert"
+// @Success      200  "Confirmation page"
+// @Failure      410  {object}  KError "Link expired or already used"
+// @Router /sessions/not-me [get]
+func (h *Handler) ReportLoginPage(c *echo.Context) error {
+	ctx := c.Request().Context()
+
+	alert, err := h.db.GetActiveLoginAlert(ctx, c.QueryParam("token"))
+	if err == pgx.ErrNoRows {
+		return NewError(http.StatusGone, ErrLoginAlertExpired, "This link expired or was already used.")
+	} else if err != nil {
+		return err
+	}
+	return renderLoginAlertPage(c, http.StatusOK, loginAlertPageData{Step: "confirm", Token: alert.Token})
+}
+
+// @Summary      Confirm a login report
+// @Description  Submitted by the page of /sessions/not-me (don't call it manually). It logs out every session of
+// @Description  the user and blocks logins with the current password until a new one is chosen.
+// @Tags         sessions
+// @Accept       x-www-form-urlencoded
+// @Produce      html
+// @Param        token   formData  string  true   "Token of the alert"
+// @Success      200  "Page to choose a new password"
+// @Failure      410  {object}  KError "Link expired or already used"
+// @Router /sessions/not-me [post]
+func (h *Handler) ReportLogin(c *echo.Context) error {
+	ctx := c.Request().Context()
+
+	alert, err := h.db.ReportLoginAlert(ctx, c.FormValue("token"))
+	if err == pgx.ErrNoRows {
+		return NewError(http.StatusGone, ErrLoginAlertExpired, "This link expired or was already used.")
+	} else if err != nil {
+		return err
+	}
+	dbuser, err := h.db.GetUserByPk(ctx, alert.UserPk)
+	if err != nil {
+		return err
+	}
+
+	// accounts without password (oidc, saml...) have nothing to reset.
+	if dbuser.Password != nil {
+		err = h.db.SetPasswordResetRequired(ctx, dbc.SetPasswordResetRequiredParams{
+			Pk:                    alert.UserPk,
+			PasswordResetRequired: true,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	sessions, err := h.db.ClearUserSessions(ctx, alert.UserPk)
+	if err != nil {
+		return err
+	}
+	h.audit(ctx, alert.UserPk, "session.reported", map[string]any{
+		"session":  alert.SessionId,
+		"device":   alert.Device,
+		"ip":       alert.Ip,
+		"sessions": len(sessions),
+	})
+	h.emitSessionsDeleted(ctx, dbuser.Id, sessions)
+
+	if dbuser.Password == nil {
+		return renderLoginAlertPage(c, http.StatusOK, loginAlertPageData{Step: "reported"})
+	}
+	return renderLoginAlertPage(c, http.StatusOK, loginAlertPageData{Step: "reset", Token: alert.Token})
+}
+
+// @Summary      Reset password after a reported login
+// @Description  Submitted by the page of /sessions/not-me (don't call it manually). The alert's token alone is not
+// @Description  enough, the current password is also required.
+// @Tags         sessions
+// @Accept       x-www-form-urlencoded
+// @Produce      html
+// @Param        token        formData  string  true  "Token of the alert"
+// @Param        oldPassword  formData  string  true  "Current password"
+// @Param        password     formData  string  true  "New password"
+// @Success      200  "Password changed"
+// @Failure      410  {object}  KError "Link expired or already used"
+// @Router /sessions/not-me/password [post]
+func (h *Handler) ResetReportedPassword(c *echo.Context) error {
+	ctx := c.Request().Context()
+	token := c.FormValue("token")
+	password := c.FormValue("password")
+
+	alert, err := h.db.GetLoginAlert(ctx, token)
+	if err == pgx.ErrNoRows {
+		return NewError(http.StatusGone, ErrLoginAlertExpired, "This link expired or was already used.")
+	} else if err != nil {
+		return err
+	}
+	dbuser, err := h.db.GetUserByPk(ctx, alert.UserPk)
+	if err != nil {
+		return err
+	}
+	if dbuser.Password == nil {
+		return NewError(http.StatusUnprocessableEntity, ErrNoPassword, "This account does not have a password.")
+	}
+	match, _, err := verifyPassword(c.FormValue("oldPassword"), *dbuser.Password)
+	if err != nil {
+		return err
+	}
+	if !match {
+		if h.config.Challenge.AfterFailedLogins > 0 {
+			if _, err = h.db.IncrementFailedLogins(ctx, dbuser.Pk); err != nil {
+				return err
+			}
+		}
+		return renderLoginAlertPage(c, http.StatusForbidden, loginAlertPageData{
+			Step:  "reset",
+			Token: token,
+			Error: "Invalid password",
+		})
+	}
+	if err = h.checkPassword(ctx, password, dbuser.Username, dbuser.Email); err != nil {
+		data := loginAlertPageData{
+			Step:  "reset",
+			Token: token,
+			Error: err.Error(),
+		}
+		var policy *PasswordPolicyError
+		if errors.As(err, &policy) {
+			for _, v := range policy.Violations {
+				data.Violations = append(data.Violations, v.Message)
+			}
+		}
+		return renderLoginAlertPage(c, http.StatusUnprocessableEntity, data)
+	}
+
+	// marks the link as used before changing the password so it can't be used twice concurrently.
+	_, err = h.db.ResetPasswordFromLoginAlert(ctx, token)
+	if err == pgx.ErrNoRows {
+		return NewError(http.StatusGone, ErrLoginAlertExpired, "This link expired or was already used.")
+	} else if err != nil {
+		return err
+	}
+	pass, err := argon2id.CreateHash(password, argon2id.DefaultParams)
+	if err != nil {
+		return err
+	}
+	user, err := h.db.UpdateUser(ctx, dbc.UpdateUserParams{
+		Id:       dbuser.Id,
+		Password: &pass,
+	})
+	if err != nil {
+		return err
+	}
+	// sessions created between the report and the reset.
+	sessions, err := h.db.ClearUserSessions(ctx, user.Pk)
+	if err != nil {
+		return err
+	}
+	h.audit(ctx, user.Pk, "user.password_changed", map[string]any{
+		"reason": "login_reported",
+	})
+	h.emit(ctx, EventUserUpdated, MapDbUser(&user))
+	h.emitSessionsDeleted(ctx, user.Id, sessions)
+	return renderLoginAlertPage(c, http.StatusOK, loginAlertPageData{Step: "done"})
+}
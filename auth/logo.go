@@ -1,26 +1,45 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"crypto/md5"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
 	"io"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
 	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/labstack/echo/v5"
 	"github.com/zoriya/kyoo/keibi/dbc"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
 )
 
 const maxLogoSize = 5 << 20
 
+// Logos are cropped to a square & downscaled to this size before being stored.
+const maxLogoDimension = 512
+
+// Refuse images that would use too much memory once decoded.
+const maxLogoPixels = 50_000_000
+
+// How long gravatar images (or their absence) are cached before being fetched again.
+const gravatarCacheDuration = 24 * time.Hour
+
 var allowedLogoTypes = []string{
 	"image/jpeg",
 	"image/png",
@@ -28,39 +47,67 @@ var allowedLogoTypes = []string{
 	"image/webp",
 }
 
+// Sizes that can be requested via `?size=`.
+var logoSizes = []int{32, 64, 128, 256, 512}
+
+var errInvalidLogo = errors.New("invalid image")
+
 func (h *Handler) logoPath(id uuid.UUID) string {
 	return filepath.Join(h.config.ProfilePicturePath, id.String())
 }
 
-func (h *Handler) streamManualLogo(c *echo.Context, id uuid.UUID) error {
-	file, err := os.Open(h.logoPath(id))
+func (h *Handler) gravatarPath(hash string) string {
+	return filepath.Join(h.config.ProfilePicturePath, "gravatar", hash)
+}
+
+func (h *Handler) logoVariantPath(key string, size int) string {
+	return filepath.Join(h.config.ProfilePicturePath, "cache", fmt.Sprintf("%s-%d.png", key, size))
+}
+
+// normalizeLogo decodes an image, crops it to a centered square and re-encodes it as a png
+// (this also strips exif or any other metadata).
+func normalizeLogo(data []byte, size int) ([]byte, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
 	if err != nil {
-		if os.IsNotExist(err) {
-			return echo.NewHTTPError(http.StatusNotFound, "No manual logo found")
-		}
-		return err
+		return nil, errInvalidLogo
 	}
-	defer file.Close()
-
-	header := make([]byte, 512)
-	n, err := file.Read(header)
-	if err != nil && err != io.EOF {
-		return err
+	if config.Width*config.Height > maxLogoPixels {
+		return nil, errInvalidLogo
 	}
-	if _, err := file.Seek(0, io.SeekStart); err != nil {
-		return err
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, errInvalidLogo
+	}
+
+	bounds := img.Bounds()
+	side := min(bounds.Dx(), bounds.Dy())
+	if side == 0 {
+		return nil, errInvalidLogo
 	}
+	crop := image.Rect(
+		bounds.Min.X+(bounds.Dx()-side)/2,
+		bounds.Min.Y+(bounds.Dy()-side)/2,
+		bounds.Min.X+(bounds.Dx()-side)/2+side,
+		bounds.Min.Y+(bounds.Dy()-side)/2+side,
+	)
+	size = min(side, size)
+	dst := image.NewNRGBA(image.Rect(0, 0, size, size))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, crop, draw.Src, nil)
 
-	contentType := http.DetectContentType(header[:n])
-	return c.Stream(http.StatusOK, contentType, file)
+	var ret bytes.Buffer
+	if err := png.Encode(&ret, dst); err != nil {
+		return nil, err
+	}
+	return ret.Bytes(), nil
 }
 
-func (h *Handler) writeManualLogo(id uuid.UUID, data []byte) error {
-	if err := os.MkdirAll(h.config.ProfilePicturePath, 0o755); err != nil {
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
 		return err
 	}
 
-	tmpFile, err := os.CreateTemp(h.config.ProfilePicturePath, id.String()+"-*.tmp")
+	tmpFile, err := os.CreateTemp(dir, filepath.Base(path)+"-*.tmp")
 	if err != nil {
 		return err
 	}
@@ -75,13 +122,36 @@ func (h *Handler) writeManualLogo(id uuid.UUID, data []byte) error {
 		return err
 	}
 
-	if err := os.Rename(tmpPath, h.logoPath(id)); err != nil {
+	if err := os.Rename(tmpPath, path); err != nil {
 		os.Remove(tmpPath)
 		return err
 	}
 	return nil
 }
 
+func (h *Handler) writeManualLogo(id uuid.UUID, data []byte) error {
+	if err := writeFileAtomic(h.logoPath(id), data); err != nil {
+		return err
+	}
+	h.removeLogoVariants(id.String())
+	return nil
+}
+
+func (h *Handler) removeLogoVariants(key string) {
+	for _, size := range logoSizes {
+		err := os.Remove(h.logoVariantPath(key, size))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.Warn("Could not remove cached logo", "key", key, "size", size, "err", err)
+		}
+	}
+}
+
+// removeLogo deletes the manual logo of an user and its cached variants.
+func (h *Handler) removeLogo(id uuid.UUID) error {
+	h.removeLogoVariants(id.String())
+	return os.Remove(h.logoPath(id))
+}
+
 func (h *Handler) downloadLogo(ctx context.Context, id uuid.UUID, logoURL string) error {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, logoURL, nil)
 	if err != nil {
@@ -109,38 +179,183 @@ func (h *Handler) downloadLogo(ctx context.Context, id uuid.UUID, logoURL string
 	if !slices.Contains(allowedLogoTypes, http.DetectContentType(data)) {
 		return fmt.Errorf("unsupported logo content type")
 	}
+	data, err = normalizeLogo(data, maxLogoDimension)
+	if err != nil {
+		return err
+	}
 
 	return h.writeManualLogo(id, data)
 }
 
-func (h *Handler) streamGravatar(c *echo.Context, email string) error {
-	hash := md5.Sum([]byte(strings.TrimSpace(strings.ToLower(email))))
-	url := fmt.Sprintf("https://www.gravatar.com/avatar/%s?d=404", hex.EncodeToString(hash[:]))
+// cacheGravatar returns the path of the cached gravatar of this email, fetching it if needed.
+// It returns os.ErrNotExist if the email has no gravatar.
+func (h *Handler) cacheGravatar(ctx context.Context, email string) (string, error) {
+	sum := md5.Sum([]byte(strings.TrimSpace(strings.ToLower(email))))
+	hash := hex.EncodeToString(sum[:])
+	path := h.gravatarPath(hash)
+	missing := path + ".missing"
 
-	req, err := http.NewRequestWithContext(c.Request().Context(), http.MethodGet, url, nil)
+	if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) < gravatarCacheDuration {
+		return path, nil
+	}
+	if info, err := os.Stat(missing); err == nil && time.Since(info.ModTime()) < gravatarCacheDuration {
+		return "", os.ErrNotExist
+	}
+
+	data, err := fetchGravatar(ctx, h.config.GravatarUrl, hash)
+	if errors.Is(err, os.ErrNotExist) {
+		os.Remove(path)
+		h.removeLogoVariants("gravatar-" + hash)
+		if err := writeFileAtomic(missing, nil); err != nil {
+			slog.Warn("Could not cache missing gravatar", "err", err)
+		}
+		return "", err
+	}
 	if err != nil {
-		return err
+		// serve the outdated image if gravatar is unreachable.
+		if _, statErr := os.Stat(path); statErr == nil {
+			slog.Warn("Could not refresh gravatar, using cached version", "err", err)
+			return path, nil
+		}
+		return "", err
+	}
+
+	os.Remove(missing)
+	if err := writeFileAtomic(path, data); err != nil {
+		return "", err
+	}
+	h.removeLogoVariants("gravatar-" + hash)
+	return path, nil
+}
+
+func fetchGravatar(ctx context.Context, baseUrl string, hash string) ([]byte, error) {
+	url := fmt.Sprintf("%s/%s?d=404&s=%d", baseUrl, hash, maxLogoDimension)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
 	}
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadGateway, "Could not fetch gravatar image")
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode == http.StatusNotFound {
-		return echo.NewHTTPError(http.StatusNotFound, "No gravatar image found for this user")
+		return nil, os.ErrNotExist
 	}
 	if resp.StatusCode != http.StatusOK {
-		return echo.NewHTTPError(http.StatusBadGateway, "Could not fetch gravatar image")
+		return nil, fmt.Errorf("unexpected gravatar response status: %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxLogoSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxLogoSize {
+		return nil, fmt.Errorf("gravatar file too large")
+	}
+	return normalizeLogo(data, maxLogoDimension)
+}
+
+// logoVariant returns the path of the logo at `path` resized to `size` (0 means the stored size),
+// generating it if it isn't cached or outdated.
+func (h *Handler) logoVariant(path string, key string, size int) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if size == 0 {
+		return path, nil
+	}
+
+	variant := h.logoVariantPath(key, size)
+	if vinfo, err := os.Stat(variant); err == nil && !vinfo.ModTime().Before(info.ModTime()) {
+		return variant, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	data, err = normalizeLogo(data, size)
+	if err != nil {
+		return "", err
+	}
+	if err := writeFileAtomic(variant, data); err != nil {
+		return "", err
+	}
+	return variant, nil
+}
+
+func parseLogoSize(c *echo.Context) (int, error) {
+	param := c.QueryParam("size")
+	if param == "" {
+		return 0, nil
+	}
+	size, err := strconv.Atoi(param)
+	if err != nil || !slices.Contains(logoSizes, size) {
+		return 0, NewError(
+			http.StatusUnprocessableEntity,
+			ErrInvalidLogoSize,
+			"Invalid size, expected one of 32, 64, 128, 256 or 512.",
+		)
+	}
+	return size, nil
+}
+
+func serveLogoFile(c *echo.Context, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, 512)
+	n, err := file.Read(header)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
 	}
 
-	contentType := resp.Header.Get("Content-Type")
-	if contentType == "" {
-		contentType = "application/octet-stream"
+	resp := c.Response()
+	resp.Header().Set(echo.HeaderContentType, http.DetectContentType(header[:n]))
+	resp.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()))
+	resp.Header().Set("Cache-Control", "private, max-age=3600")
+	// handles If-None-Match/If-Modified-Since & returns 304 when the logo did not change.
+	http.ServeContent(resp, c.Request(), "", info.ModTime(), file)
+	return nil
+}
+
+// serveLogo streams the manual logo of an user if available, its gravatar otherwise.
+func (h *Handler) serveLogo(c *echo.Context, id uuid.UUID, email string) error {
+	size, err := parseLogoSize(c)
+	if err != nil {
+		return err
 	}
 
-	return c.Stream(http.StatusOK, contentType, resp.Body)
+	path, err := h.logoVariant(h.logoPath(id), id.String(), size)
+	if errors.Is(err, os.ErrNotExist) {
+		var gravatar string
+		gravatar, err = h.cacheGravatar(c.Request().Context(), email)
+		if errors.Is(err, os.ErrNotExist) {
+			return NewError(http.StatusNotFound, ErrLogoNotFound, "No gravatar image found for this user")
+		} else if err != nil {
+			slog.Warn("Could not fetch gravatar", "err", err)
+			return NewError(http.StatusBadGateway, ErrGravatarUnavailable, "Could not fetch gravatar image")
+		}
+		path, err = h.logoVariant(gravatar, "gravatar-"+filepath.Base(gravatar), size)
+	}
+	if err != nil {
+		return err
+	}
+	return serveLogoFile(c, path)
 }
 
 // @Summary      Get my logo
@@ -148,10 +363,13 @@ func (h *Handler) streamGravatar(c *echo.Context, email string) error {
 // @Tags         users
 // @Produce      image/*
 // @Security     Jwt
+// @Param        size  query  int  false  "Size (in pixels) of the returned square image, defaults to the stored one (max 512)"  Enums(32, 64, 128, 256, 512)
 // @Success      200  {file}  binary
+// @Success      304  "Logo not modified (via If-None-Match or If-Modified-Since)"
 // @Failure      401  {object}  KError "Missing jwt token"
 // @Failure      403  {object}  KError "Invalid jwt token (or expired)"
 // @Failure      404  {object}  KError "No gravatar image found for this user"
+// @Failure      422  {object}  KError "Invalid size"
 // @Router /users/me/logo [get]
 func (h *Handler) GetMyLogo(c *echo.Context) error {
 	ctx := c.Request().Context()
@@ -160,12 +378,6 @@ func (h *Handler) GetMyLogo(c *echo.Context) error {
 		return err
 	}
 
-	if err := h.streamManualLogo(c, id); err == nil {
-		return nil
-	} else if httpErr, ok := err.(*echo.HTTPError); !ok || httpErr.Code != http.StatusNotFound {
-		return err
-	}
-
 	user, err := h.db.GetUser(ctx, dbc.GetUserParams{
 		UseId: true,
 		Id:    id,
@@ -174,7 +386,7 @@ func (h *Handler) GetMyLogo(c *echo.Context) error {
 		return err
 	}
 
-	return h.streamGravatar(c, user.User.Email)
+	return h.serveLogo(c, id, user.User.Email)
 }
 
 // @Summary      Get user logo
@@ -182,10 +394,13 @@ func (h *Handler) GetMyLogo(c *echo.Context) error {
 // @Tags         users
 // @Produce      image/*
 // @Security     Jwt[users.read]
-// @Param        id   path      string    true  "The id or username of the user"
+// @Param        id    path   string  true   "The id or username of the user"
+// @Param        size  query  int     false  "Size (in pixels) of the returned square image, defaults to the stored one (max 512)"  Enums(32, 64, 128, 256, 512)
 // @Success      200  {file}  binary
+// @Success      304  "Logo not modified (via If-None-Match or If-Modified-Since)"
 // @Failure      404  {object}  KError "No user found with id or username"
 // @Failure      404  {object}  KError "No gravatar image found for this user"
+// @Failure      422  {object}  KError "Invalid size"
 // @Router /users/{id}/logo [get]
 func (h *Handler) GetUserLogo(c *echo.Context) error {
 	ctx := c.Request().Context()
@@ -202,22 +417,17 @@ func (h *Handler) GetUserLogo(c *echo.Context) error {
 		Username: id,
 	})
 	if err == pgx.ErrNoRows {
-		return echo.NewHTTPError(404, fmt.Sprintf("No user found with id or username: '%s'.", id))
+		return NewError(404, ErrUserNotFound, fmt.Sprintf("No user found with id or username: '%s'.", id))
 	} else if err != nil {
 		return err
 	}
 
-	if err := h.streamManualLogo(c, user.User.Id); err == nil {
-		return nil
-	} else if httpErr, ok := err.(*echo.HTTPError); !ok || httpErr.Code != http.StatusNotFound {
-		return err
-	}
-
-	return h.streamGravatar(c, user.User.Email)
+	return h.serveLogo(c, user.User.Id, user.User.Email)
 }
 
 // @Summary      Upload my logo
-// @Description  Upload a manual profile picture for the current user
+// @Description  Upload a manual profile picture for the current user. The image is cropped to a centered square,
+// @Description  downscaled to 512x512 and stored as a png (metadata like exif are removed).
 // @Tags         users
 // @Accept       multipart/form-data
 // @Produce      json
@@ -237,10 +447,10 @@ func (h *Handler) UploadMyLogo(c *echo.Context) error {
 
 	fileHeader, err := c.FormFile("logo")
 	if err != nil {
-		return echo.NewHTTPError(http.StatusUnprocessableEntity, "Missing form file `logo`")
+		return NewError(http.StatusUnprocessableEntity, ErrInvalidBody, "Missing form file `logo`")
 	}
 	if fileHeader.Size > maxLogoSize {
-		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, "File too large")
+		return NewError(http.StatusRequestEntityTooLarge, ErrFileTooLarge, "File too large")
 	}
 
 	file, err := fileHeader.Open()
@@ -254,11 +464,17 @@ func (h *Handler) UploadMyLogo(c *echo.Context) error {
 		return err
 	}
 	if len(data) > maxLogoSize {
-		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, "File too large")
+		return NewError(http.StatusRequestEntityTooLarge, ErrFileTooLarge, "File too large")
 	}
 
 	if !slices.Contains(allowedLogoTypes, http.DetectContentType(data)) {
-		return echo.NewHTTPError(http.StatusUnprocessableEntity, "Only jpeg, png, gif or webp images are allowed")
+		return NewError(http.StatusUnprocessableEntity, ErrInvalidImage, "Only jpeg, png, gif or webp images are allowed")
+	}
+	data, err = normalizeLogo(data, maxLogoDimension)
+	if err == errInvalidLogo {
+		return NewError(http.StatusUnprocessableEntity, ErrInvalidImage, "Invalid or corrupted image")
+	} else if err != nil {
+		return err
 	}
 
 	if err := h.writeManualLogo(id, data); err != nil {
@@ -282,10 +498,11 @@ func (h *Handler) DeleteMyLogo(c *echo.Context) error {
 		return err
 	}
 
-	err = os.Remove(h.logoPath(id))
+	err = h.removeLogo(id)
 	if errors.Is(err, os.ErrNotExist) {
-		return echo.NewHTTPError(
+		return NewError(
 			404,
+			ErrLogoNotFound,
 			"User does not have a custom profile picture.",
 		)
 	} else if err != nil {
@@ -298,12 +515,13 @@ func (h *Handler) DeleteMyLogo(c *echo.Context) error {
 // @Description  Delete the user's manually uploaded profile picture
 // @Tags         users
 // @Produce      json
-// @Security     Jwt
+// @Security     Jwt[users.write]
 // @Success      204
 // @Param        id   path      string    true  "The id or username of the user"
 // @Failure      401  {object}  KError "Missing jwt token"
 // @Failure      403  {object}  KError "Invalid jwt token (or expired)"
-// @Router /users/me/{id} [delete]
+// @Failure      404  {object}  KError "User does not have a custom profile picture"
+// @Router /users/{id}/logo [delete]
 func (h *Handler) DeleteUserLogo(c *echo.Context) error {
 	ctx := c.Request().Context()
 	err := CheckPermissions(c, []string{"users.write"})
@@ -319,15 +537,16 @@ func (h *Handler) DeleteUserLogo(c *echo.Context) error {
 		Username: id,
 	})
 	if err == pgx.ErrNoRows {
-		return echo.NewHTTPError(404, fmt.Sprintf("No user found with id or username: '%s'.", id))
+		return NewError(404, ErrUserNotFound, fmt.Sprintf("No user found with id or username: '%s'.", id))
 	} else if err != nil {
 		return err
 	}
 
-	err = os.Remove(h.logoPath(user.User.Id))
+	err = h.removeLogo(user.User.Id)
 	if errors.Is(err, os.ErrNotExist) {
-		return echo.NewHTTPError(
+		return NewError(
 			404,
+			ErrLogoNotFound,
 			"User does not have a custom profile picture.",
 		)
 	} else if err != nil {
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v5"
+	"github.com/zoriya/kyoo/keibi/dbc"
+)
+
+type MagicLinkConfig struct {
+	Enabled bool
+	Ttl     time.Duration
+	// Max number of links sent to an address per hour.
+	HourlyLimit int32
+}
+
+// Characters used for codes typed on a tv (no 0/O or 1/I to avoid confusions).
+const magicLinkCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// Wrong codes allowed before a link's code is locked.
+const magicLinkCodeAttempts = 5
+
+type MagicLinkDto struct {
+	// Email of the account to login to.
+	Email string `json:"email" validate:"required,email" example:"kyoo@zoriya.dev"`
+	// Url to redirect the browser to once the link is opened, with an opaque token (`?token=`) to exchange
+	// via /sessions/magic-link/callback.
+	// If unset, a code is shown instead that can be used via /sessions/magic-link/{id}.
+	RedirectUrl *string `json:"redirectUrl,omitempty" example:"https://kyoo.zoriya.dev/login/callback"`
+}
+
+type MagicLink struct {
+	// Id of the link, used to exchange the code shown once the link is opened.
+	Id       uuid.UUID `json:"id" example:"e05089d6-9179-4b5b-a63e-94dd5fc2a397"`
+	ExpireAt time.Time `json:"expireAt" example:"2025-03-29T18:20:05.267Z"`
+}
+
+type MagicLinkCodeDto struct {
+	Code string `json:"code" validate:"required" example:"ABCD-2345"`
+}
+
+type MagicLinkCallbackDto struct {
+	// Opaque token given to the redirect url.
+	Token string `json:"token" validate:"required"`
+}
+
+var magicLinkPage = template.Must(template.New("link").Parse(`<!doctype html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<title>Login</title>
+</head>
+<body style="font-family: sans-serif; text-align: center; margin-top: 20vh">
+	{{if .Code}}
+	<p>Type this code on the device you want to login to:</p>
+	<h1 style="letter-spacing: 0.2em">{{.Code}}</h1>
+	<p>It can only be used once.</p>
+	{{else}}
+	<form method="post" action="magic-link/open">
+		<input type="hidden" name="token" value="{{.Token}}">
+		<button type="submit">Login</button>
+	</form>
+	{{end}}
+</body>
+</html>
+`))
+
+type magicLinkPageData struct {
+	// Token of the link, for the confirmation page.
+	Token string
+	// Code to type on the device that asked for the link, once confirmed.
+	Code string
+}
+
+func renderMagicLinkPage(c *echo.Context, data magicLinkPageData) error {
+	c.Response().Header().Set("Cache-Control", "no-store")
+	var page strings.Builder
+	if err := magicLinkPage.Execute(&page, data); err != nil {
+		return err
+	}
+	return c.HTML(http.StatusOK, page.String())
+}
+
+func newMagicLinkCode() (string, error) {
+	raw := make([]byte, 8)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	var ret strings.Builder
+	for i, b := range raw {
+		if i == 4 {
+			ret.WriteByte('-')
+		}
+		ret.WriteByte(magicLinkCodeAlphabet[int(b)%len(magicLinkCodeAlphabet)])
+	}
+	return ret.String(), nil
+}
+
+func normalizeMagicLinkCode(code string) string {
+	code = strings.ToUpper(strings.Join(strings.Fields(code), ""))
+	code = strings.ReplaceAll(code, "-", "")
+	if len(code) != 8 {
+		return code
+	}
+	return code[:4] + "-" + code[4:]
+}
+
+// @Summary      Request a magic link
+// @Description  Email a single-use login link. The response is the same whether an account uses the email or not.
+// @Tags         sessions
+// @Accept       json
+// @Produce      json
+// @Param        link  body      MagicLinkDto  true  "Email & optional redirect url"
+// @Success      202   {object}  MagicLink
+// @Failure      400   {object}  KError "Unauthorized redirectUrl"
+// @Failure      403   {object}  KError "Magic links are disabled"
+// @Failure      422   {object}  KError "Invalid body"
+// @Failure      429   {object}  KError "Too many links sent to this address"
+// @Router /sessions/magic-link [post]
+func (h *Handler) RequestMagicLink(c *echo.Context) error {
+	ctx := c.Request().Context()
+	if !h.config.MagicLink.Enabled {
+		return NewError(http.StatusForbidden, ErrMagicLinksDisabled, "Magic links are disabled on this instance.")
+	}
+
+	var req MagicLinkDto
+	err := c.Bind(&req)
+	if err != nil {
+		return NewError(http.StatusUnprocessableEntity, ErrInvalidBody, err.Error())
+	}
+	if err = c.Validate(&req); err != nil {
+		return err
+	}
+	if req.RedirectUrl != nil && !h.isAllowedRedirectUrl(*req.RedirectUrl) {
+		return NewError(http.StatusBadRequest, ErrUnauthorizedRedirect, "Unauthorized redirectUrl, ask your server admin to whitelist it.")
+	}
+
+	count, err := h.db.CountRecentMagicLinks(ctx, req.Email)
+	if err != nil {
+		return err
+	}
+	if count >= int64(h.config.MagicLink.HourlyLimit) {
+		return NewError(http.StatusTooManyRequests, ErrTooManyMagicLinks, "Too many login links were sent to this address, retry later.")
+	}
+
+	var userPk *int32
+	user, err := h.getUserByEmail(ctx, req.Email)
+	// ambiguous emails are handled like unknown ones so the response stays the same.
+	var ambiguous *CodedError
+	if err == nil {
+		userPk = &user.Pk
+	} else if err != pgx.ErrNoRows && !errors.As(err, &ambiguous) {
+		return err
+	}
+
+	token := make([]byte, 64)
+	if _, err = rand.Read(token); err != nil {
+		return err
+	}
+	link, err := h.db.CreateMagicLink(ctx, dbc.CreateMagicLinkParams{
+		Token:       base64.RawURLEncoding.EncodeToString(token),
+		Email:       req.Email,
+		UserPk:      userPk,
+		RedirectUrl: req.RedirectUrl,
+		ExpireAt:    time.Now().UTC().Add(h.config.MagicLink.Ttl),
+	})
+	if err != nil {
+		return err
+	}
+
+	if userPk != nil {
+		// sent in the background so the response time doesn't leak if the account exists.
+		go func() {
+			ctx := context.WithoutCancel(ctx)
+			linkUrl := fmt.Sprintf(
+				"%s/auth/sessions/magic-link?token=%s",
+				h.config.PublicUrl,
+				url.QueryEscape(link.Token),
+			)
+			body := fmt.Sprintf(
+				"Hello %s,\n\nOpen this link to login, it expires in %s and can only be used once:\n\n%s\n\nIf you did not ask for it, you can ignore this email.\n",
+				user.Username,
+				h.config.MagicLink.Ttl,
+				linkUrl,
+			)
+			if err := h.sendMail(ctx, user.Email, "Your login link", body); err != nil {
+				slog.Error("Could not send magic link", "user", user.Id, "err", err)
+			}
+		}()
+	}
+	go h.db.DeleteExpiredMagicLinks(context.WithoutCancel(ctx))
+
+	return c.JSON(http.StatusAccepted, MagicLink{
+		Id:       link.Id,
+		ExpireAt: link.ExpireAt,
+	})
+}
+
+// @Summary      Open a magic link
+// @Description  Link sent by email (don't call it manually). It only shows a confirmation page (mail scanners open
+// @Description  links on their own), the link is used when the page is submitted.
+// @Tags         sessions
+// @Produce      html
+// @Param        token   query  string  true   "Token of the link"
+// @Success      200  "Confirmation page"
+// @Failure      410  {object}  KError "Link expired or already used"
+// @Router /sessions/magic-link [get]
+func (h *Handler) MagicLinkPage(c *echo.Context) error {
+	ctx := c.Request().Context()
+	if !h.config.MagicLink.Enabled {
+		return NewError(http.StatusForbidden, ErrMagicLinksDisabled, "Magic links are disabled on this instance.")
+	}
+
+	link, err := h.db.GetActiveMagicLink(ctx, c.QueryParam("token"))
+	if err == pgx.ErrNoRows {
+		return NewError(http.StatusGone, ErrMagicLinkExpired, "This login link expired or was already used.")
+	} else if err != nil {
+		return err
+	}
+	return renderMagicLinkPage(c, magicLinkPageData{Token: link.Token})
+}
+
+// @Summary      Use a magic link
+// @Description  Submitted by the page of /sessions/magic-link (don't call it manually). It redirects to the requested
+// @Description  url with an opaque token (`?token=`) to exchange via /sessions/magic-link/callback, or shows a code
+// @Description  to type on the device that asked for the link.
+// @Tags         sessions
+// @Accept       x-www-form-urlencoded
+// @Produce      html
+// @Param        token   formData  string  true   "Token of the link"
+// @Success      200  "Page showing a login code"
+// @Success      302  "Redirect with an opaque token"
+// @Failure      410  {object}  KError "Link expired or already used"
+// @Router /sessions/magic-link/open [post]
+func (h *Handler) UseMagicLink(c *echo.Context) error {
+	ctx := c.Request().Context()
+	if !h.config.MagicLink.Enabled {
+		return NewError(http.StatusForbidden, ErrMagicLinksDisabled, "Magic links are disabled on this instance.")
+	}
+
+	link, err := h.db.UseMagicLink(ctx, c.FormValue("token"))
+	if err == pgx.ErrNoRows {
+		return NewError(http.StatusGone, ErrMagicLinkExpired, "This login link expired or was already used.")
+	} else if err != nil {
+		return err
+	}
+
+	if link.RedirectUrl == nil {
+		code, err := newMagicLinkCode()
+		if err != nil {
+			return err
+		}
+		err = h.db.SetMagicLinkCode(ctx, dbc.SetMagicLinkCodeParams{
+			Pk:   link.Pk,
+			Code: &code,
+		})
+		if err != nil {
+			return err
+		}
+		return renderMagicLinkPage(c, magicLinkPageData{Code: code})
+	}
+
+	// the session token is not put in the url (browser history, logs, referer...), only a single-use opaque.
+	raw := make([]byte, 64)
+	if _, err = rand.Read(raw); err != nil {
+		return err
+	}
+	opaque := base64.RawURLEncoding.EncodeToString(raw)
+	err = h.db.SetMagicLinkCallbackToken(ctx, dbc.SetMagicLinkCallbackTokenParams{
+		Pk:            link.Pk,
+		CallbackToken: &opaque,
+	})
+	if err != nil {
+		return err
+	}
+
+	ret, err := url.Parse(*link.RedirectUrl)
+	if err != nil {
+		return NewError(http.StatusInternalServerError, ErrInternal, "Invalid magic link redirect URL")
+	}
+	params := ret.Query()
+	params.Set("token", opaque)
+	ret.RawQuery = params.Encode()
+	return c.Redirect(http.StatusFound, ret.String())
+}
+
+// @Summary      Magic link callback
+// @Description  Exchange the opaque token given to a magic link's redirect url for a session.
+// @Tags         sessions
+// @Accept       json
+// @Produce      json
+// @Param        token   body    MagicLinkCallbackDto  true   "Opaque token given to the redirect url"
+// @Param        device  query   string                false  "The device the created session will be used on"  example(android tv)
+// @Param        rotate  query   bool                  false  "Rotate the session token each time it's exchanged for a jwt"
+// @Success      201  {object}  models.SessionWToken
+// @Failure      410  {object}  KError "Token expired or already used"
+// @Router /sessions/magic-link/callback [post]
+func (h *Handler) MagicLinkCallback(c *echo.Context) error {
+	ctx := c.Request().Context()
+	if !h.config.MagicLink.Enabled {
+		return NewError(http.StatusForbidden, ErrMagicLinksDisabled, "Magic links are disabled on this instance.")
+	}
+
+	var req MagicLinkCallbackDto
+	err := c.Bind(&req)
+	if err != nil {
+		return NewError(http.StatusUnprocessableEntity, ErrInvalidBody, err.Error())
+	}
+	if err = c.Validate(&req); err != nil {
+		return err
+	}
+
+	link, err := h.db.ConsumeMagicLinkCallback(ctx, &req.Token)
+	if err == pgx.ErrNoRows {
+		return NewError(http.StatusGone, ErrMagicLinkExpired, "This login token expired or was already used.")
+	} else if err != nil {
+		return err
+	}
+
+	dbuser, err := h.db.GetUserByPk(ctx, *link.UserPk)
+	if err != nil {
+		return err
+	}
+	user := MapDbUser(&dbuser)
+	return h.createSession(c, &user)
+}
+
+// @Summary      Login with a magic link code
+// @Description  Exchange the code shown when opening a magic link (requested without redirect url) for a session.
+// @Tags         sessions
+// @Accept       json
+// @Produce      json
+// @Param        id      path    string            true   "Id returned when requesting the link"  Format(uuid)
+// @Param        code    body    MagicLinkCodeDto  true   "Code shown by the link"
+// @Param        device  query   string            false  "The device the created session will be used on"  example(android tv)
+// @Success      201  {object}  models.SessionWToken
+// @Failure      403  {object}  KError "Invalid or expired code"
+// @Router /sessions/magic-link/{id} [post]
+func (h *Handler) ConsumeMagicLinkCode(c *echo.Context) error {
+	ctx := c.Request().Context()
+	if !h.config.MagicLink.Enabled {
+		return NewError(http.StatusForbidden, ErrMagicLinksDisabled, "Magic links are disabled on this instance.")
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return NewError(http.StatusBadRequest, ErrInvalidId, "Invalid id given: not an uuid")
+	}
+	var req MagicLinkCodeDto
+	err = c.Bind(&req)
+	if err != nil {
+		return NewError(http.StatusUnprocessableEntity, ErrInvalidBody, err.Error())
+	}
+	if err = c.Validate(&req); err != nil {
+		return err
+	}
+
+	code := normalizeMagicLinkCode(req.Code)
+	link, err := h.db.ConsumeMagicLinkCode(ctx, dbc.ConsumeMagicLinkCodeParams{
+		Id:          id,
+		Code:        &code,
+		MaxAttempts: magicLinkCodeAttempts,
+	})
+	if err == pgx.ErrNoRows {
+		if err = h.db.FailMagicLinkCode(ctx, id); err != nil {
+			return err
+		}
+		return NewError(http.StatusForbidden, ErrMagicLinkInvalidCode, "Invalid or expired code.")
+	} else if err != nil {
+		return err
+	}
+
+	dbuser, err := h.db.GetUserByPk(ctx, *link.UserPk)
+	if err != nil {
+		return err
+	}
+	user := MapDbUser(&dbuser)
+	return h.createSession(c, &user)
+}
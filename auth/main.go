@@ -1,14 +1,16 @@
 package main
 
 import (
+	"cmp"
 	"context"
-	"encoding/base64"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
+	"reflect"
 	"slices"
 	"sort"
 	"strings"
@@ -16,6 +18,7 @@ import (
 
 	"github.com/zoriya/kyoo/keibi/dbc"
 	_ "github.com/zoriya/kyoo/keibi/docs"
+	"github.com/zoriya/kyoo/keibi/jwks"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/golang-migrate/migrate/v4"
@@ -39,16 +42,28 @@ func ErrorHandler(c *echo.Context, err error) {
 	}
 
 	code := http.StatusInternalServerError
+	var errCode ErrorCode
 	var message string
+	var details any
 	var sc echo.HTTPStatusCoder
+	var de DetailedError
 
 	if he, ok := err.(*echo.HTTPError); ok {
 		code = he.Code
 		message = fmt.Sprint(he.Message)
 
-		if message == "missing or malformed jwt" {
+		switch message {
+		case "missing or malformed jwt":
 			code = http.StatusUnauthorized
+			errCode = ErrNotLoggedIn
+		case "invalid or expired jwt":
+			errCode = ErrInvalidToken
 		}
+	} else if errors.As(err, &de) {
+		code = de.StatusCode()
+		errCode = de.ErrorCode()
+		message = de.Error()
+		details = de.Details()
 	} else if errors.As(err, &sc) {
 		if tmp := sc.StatusCode(); tmp != 0 {
 			code = tmp
@@ -59,8 +74,9 @@ func ErrorHandler(c *echo.Context, err error) {
 
 	c.JSON(code, KError{
 		Status:  code,
+		Code:    cmp.Or(errCode, defaultErrorCode(code)),
 		Message: message,
-		Details: nil,
+		Details: details,
 	})
 }
 
@@ -68,11 +84,76 @@ type Validator struct {
 	validator *validator.Validate
 }
 
+type ValidationError struct {
+	// Path of the invalid field, using json names.
+	Field string `json:"field" example:"email"`
+	// Validation rule that failed.
+	Rule string `json:"rule" example:"email"`
+	// Parameter of the rule, if any (for example the max length).
+	Param   string `json:"param,omitempty" example:""`
+	Message string `json:"message" example:"email must be a valid email"`
+}
+
+func newValidator() *validator.Validate {
+	ret := validator.New(validator.WithRequiredStructEnabled())
+	// use json names in errors since those are the ones clients know.
+	ret.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return cmp.Or(name, field.Name)
+	})
+	return ret
+}
+
 func (v *Validator) Validate(i any) error {
-	if err := v.validator.Struct(i); err != nil {
-		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	err := v.validator.Struct(i)
+	if err == nil {
+		return nil
+	}
+	var verrs validator.ValidationErrors
+	if !errors.As(err, &verrs) {
+		return NewError(http.StatusUnprocessableEntity, ErrInvalidBody, err.Error())
+	}
+	details := make([]ValidationError, 0, len(verrs))
+	for _, fe := range verrs {
+		// strip the root struct's name
+		_, field, _ := strings.Cut(fe.Namespace(), ".")
+		details = append(details, ValidationError{
+			Field:   field,
+			Rule:    fe.Tag(),
+			Param:   fe.Param(),
+			Message: validationMessage(field, fe),
+		})
+	}
+	return &CodedError{
+		Status:  http.StatusUnprocessableEntity,
+		Code:    ErrValidationFailed,
+		Message: err.Error(),
+		Detail:  details,
+	}
+}
+
+func validationMessage(field string, fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required", "required_with":
+		return fmt.Sprintf("%s is required", field)
+	case "email":
+		return fmt.Sprintf("%s must be a valid email", field)
+	case "url":
+		return fmt.Sprintf("%s must be a valid url", field)
+	case "min":
+		return fmt.Sprintf("%s must be at least %s", field, fe.Param())
+	case "max":
+		return fmt.Sprintf("%s must be at most %s", field, fe.Param())
+	case "oneof":
+		return fmt.Sprintf("%s must be one of %s", field, fe.Param())
+	case "excludes", "excludesall":
+		return fmt.Sprintf("%s can't contain %s", field, fe.Param())
+	default:
+		return fmt.Sprintf("%s failed the %s rule", field, fe.Tag())
 	}
-	return nil
 }
 
 func (h *Handler) CheckHealth(c *echo.Context) error {
@@ -108,6 +189,25 @@ func GetenvOr(env string, def string) string {
 }
 
 func OpenDatabase(ctx context.Context) (*pgxpool.Pool, error) {
+	db, err := ConnectDatabase(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info("Database migration state", "state", "starting")
+	m, closeMigrator, err := NewMigrator(db)
+	if err != nil {
+		return nil, err
+	}
+	defer closeMigrator()
+	m.Up()
+	slog.Info("Database migration state", "state", "completed")
+
+	return db, nil
+}
+
+// ConnectDatabase opens a connection pool without running migrations.
+func ConnectDatabase(ctx context.Context) (*pgxpool.Pool, error) {
 	connectionString := os.Getenv("POSTGRES_URL")
 	config, err := pgxpool.ParseConfig(connectionString)
 	if err != nil {
@@ -136,32 +236,37 @@ func OpenDatabase(ctx context.Context) (*pgxpool.Pool, error) {
 		fmt.Printf("Could not connect to database, check your env variables!\n")
 		return nil, err
 	}
+	return db, nil
+}
 
-	slog.Info("Database migration state", "state", "starting")
+// NewMigrator returns a migrator for the keibi schema. The returned function must be called once done.
+func NewMigrator(db *pgxpool.Pool) (*migrate.Migrate, func(), error) {
 	dbi := stdlib.OpenDBFromPool(db)
-	defer dbi.Close()
 
 	dbi.Exec("create schema if not exists keibi")
 	driver, err := pgxd.WithInstance(dbi, &pgxd.Config{
 		SchemaName: "keibi",
 	})
 	if err != nil {
-		return nil, err
+		dbi.Close()
+		return nil, nil, err
 	}
 	m, err := migrate.NewWithDatabaseInstance("file://sql/migrations", "postgres", driver)
 	if err != nil {
-		return nil, err
+		dbi.Close()
+		return nil, nil, err
 	}
-	m.Up()
-	slog.Info("Database migration state", "state", "completed")
-
-	return db, nil
+	return m, func() { dbi.Close() }, nil
 }
 
 type Handler struct {
-	db     *dbc.Queries
-	rawDb  *pgxpool.Pool
-	config *Configuration
+	db           *dbc.Queries
+	rawDb        *pgxpool.Pool
+	config       *Configuration
+	oidcCache    *OidcProviderCache
+	samlCache    *SamlMetadataCache
+	jwksCache    *jwks.Cache
+	sessionCache *SessionCache
 }
 
 func (h *Handler) TokenToJwt(next echo.HandlerFunc) echo.HandlerFunc {
@@ -194,22 +299,25 @@ func (h *Handler) TokenToJwt(next echo.HandlerFunc) echo.HandlerFunc {
 			} else if strings.HasPrefix(auth, "Bearer ") {
 				token = auth[len("Bearer "):]
 			} else if auth != "" {
-				return echo.NewHTTPError(http.StatusUnauthorized, "Invalid bearer format.")
+				return NewError(http.StatusUnauthorized, ErrInvalidBearer, "Invalid bearer format.")
 			}
 
 			if token == "" {
-				jwt = h.createGuestJwt()
-				if jwt == nil {
-					return echo.NewHTTPError(http.StatusUnauthorized, "Guests not allowed.")
+				if identity := h.getCertIdentity(c); identity != nil {
+					tkn, err := h.createCertJwt(identity)
+					if err != nil {
+						return err
+					}
+					jwt = &tkn
+				} else if jwt = h.createGuestJwt(); jwt == nil {
+					return NewError(http.StatusUnauthorized, ErrGuestsNotAllowed, "Guests not allowed.")
 				}
 			} else {
-				// this is only used to check if it is a session token or a jwt
-				_, err := base64.RawURLEncoding.DecodeString(token)
-				if err != nil {
+				if !isOpaqueToken(token) {
 					return next(c)
 				}
 
-				tkn, err := h.createJwt(ctx, token)
+				tkn, _, err := h.createJwt(ctx, token, false)
 				if err != nil {
 					return err
 				}
@@ -235,17 +343,15 @@ func (h *Handler) OptionalAuthToJwt(jwtMiddlware echo.MiddlewareFunc) echo.Middl
 			}
 
 			if !strings.HasPrefix(auth, "Bearer ") {
-				return echo.NewHTTPError(http.StatusForbidden, "Invalid bearer format")
+				return NewError(http.StatusForbidden, ErrInvalidBearer, "Invalid bearer format")
 			}
 			token := auth[len("Bearer "):]
 
-			// this is only used to check if it is a session token or a jwt
-			_, err := base64.RawURLEncoding.DecodeString(token)
-			if err != nil {
+			if !isOpaqueToken(token) {
 				return jwtMiddlware(next)(c)
 			}
 
-			jwt, err := h.createJwt(ctx, token)
+			jwt, _, err := h.createJwt(ctx, token, false)
 			if err != nil {
 				return err
 			}
@@ -283,6 +389,10 @@ func main() {
 		slog.Error("logger init", "err", err)
 	}
 
+	if len(os.Args) > 1 {
+		os.Exit(RunCli(ctx, os.Args[1:]))
+	}
+
 	cleanup, err := setupOtel(ctx)
 	if err != nil {
 		slog.Error("Failed to setup otel", "err", err)
@@ -350,7 +460,7 @@ func main() {
 		},
 	}))
 
-	e.Validator = &Validator{validator: validator.New(validator.WithRequiredStructEnabled())}
+	e.Validator = &Validator{validator: newValidator()}
 	e.HTTPErrorHandler = ErrorHandler
 
 	db, err := OpenDatabase(ctx)
@@ -360,8 +470,11 @@ func main() {
 	}
 
 	h := Handler{
-		db:    dbc.New(db),
-		rawDb: db,
+		db:        dbc.New(db),
+		rawDb:     db,
+		oidcCache: &OidcProviderCache{},
+		samlCache: &SamlMetadataCache{metadata: make(map[string]*samlMetadata)},
+		jwksCache: &jwks.Cache{},
 	}
 	conf, err := LoadConfiguration(ctx, h.db)
 	if err != nil {
@@ -369,9 +482,16 @@ func main() {
 		return
 	}
 	h.config = conf
+	h.sessionCache = NewSessionCache(conf.SessionCacheSize, conf.SessionCacheTtl)
+
+	h.warnUnknownPermissions()
+	go h.DeleteScheduledUsers(ctx)
+	go h.DeliverWebhooks(ctx)
+	go h.ListenCacheInvalidations(ctx)
+	go h.FlushTouches(ctx)
 
 	jwtMiddleware := echojwt.WithConfig(echojwt.Config{
-		SigningMethod: "RS256",
+		SigningMethod: h.config.JwtSigningMethod.Alg(),
 		SigningKey:    h.config.JwtPublicKey,
 	})
 
@@ -379,6 +499,7 @@ func main() {
 	r := e.Group("/auth")
 	r.Use(h.TokenToJwt)
 	r.Use(jwtMiddleware)
+	r.Use(h.RejectImpersonatedWrites)
 
 	g.GET("/health", h.CheckHealth)
 	g.GET("/ready", h.CheckReady)
@@ -390,39 +511,82 @@ func main() {
 	r.POST("/users/me/logo", h.UploadMyLogo)
 	r.DELETE("/users/me/logo", h.DeleteMyLogo)
 	r.GET("/users/:id/logo", h.GetUserLogo)
+	r.DELETE("/users/:id/logo", h.DeleteUserLogo)
 	r.DELETE("/users/:id", h.DeleteUser)
 	r.DELETE("/users/me", h.DeleteSelf)
+	r.DELETE("/users/me/deletion", h.CancelSelfDeletion)
+	r.POST("/users/import", h.ImportUsers)
+	r.GET("/users/me/export", h.ExportMe)
 	r.PATCH("/users/:id", h.EditUser)
 	r.PATCH("/users/me", h.EditSelf)
 	r.PATCH("/users/me/password", h.ChangePassword)
+	r.GET("/users/me/settings", h.GetMySettings)
+	r.PATCH("/users/me/settings", h.EditMySettings)
+	r.GET("/users/:id/settings", h.GetUserSettings)
+	g.GET("/settings/schema", h.GetSettingsSchema)
 	g.POST("/users", h.Register)
+	g.POST("/challenges", h.CreateChallenge)
 
 	g.POST("/sessions", h.Login)
+	g.POST("/sessions/magic-link", h.RequestMagicLink)
+	g.GET("/sessions/magic-link", h.MagicLinkPage)
+	g.POST("/sessions/magic-link/open", h.UseMagicLink)
+	g.POST("/sessions/magic-link/callback", h.MagicLinkCallback)
+	g.POST("/sessions/magic-link/:id", h.ConsumeMagicLinkCode)
+	g.GET("/sessions/not-me", h.ReportLoginPage)
+	g.POST("/sessions/not-me", h.ReportLogin)
+	g.POST("/sessions/not-me/password", h.ResetReportedPassword)
 	r.GET("/sessions", h.ListMySessions)
 	r.DELETE("/sessions", h.Logout)
 	r.DELETE("/sessions/:id", h.Logout)
 	r.GET("/users/:id/sessions", h.ListUserSessions)
+	r.POST("/users/:id/impersonate", h.ImpersonateUser)
 	r.GET("/users/me/sessions", h.ListMySessions)
 
 	g.GET("/oidc/login/:provider", h.OidcLogin)
 	r.DELETE("/oidc/login/:provider", h.OidcUnlink)
 	g.GET("/oidc/logged/:provider", h.OidcLogged)
+	g.POST("/oidc/backchannel-logout/:provider", h.OidcBackchannelLogout)
+	r.GET("/oidc/providers", h.ListOidcProviders)
+	r.POST("/oidc/providers", h.CreateOidcProvider)
+	r.PATCH("/oidc/providers/:id", h.EditOidcProvider)
+	r.DELETE("/oidc/providers/:id", h.DeleteOidcProvider)
+
+	r.GET("/webhooks", h.ListWebhooks)
+	r.POST("/webhooks", h.CreateWebhook)
+	r.PATCH("/webhooks/:id", h.EditWebhook)
+	r.DELETE("/webhooks/:id", h.DeleteWebhook)
+	r.GET("/webhooks/:id/deliveries", h.ListWebhookDeliveries)
 
 	or := e.Group("/auth")
 	or.Use(h.OptionalAuthToJwt(jwtMiddleware))
 	or.GET("/oidc/callback/:provider", h.OidcCallback)
 
+	g.GET("/saml/metadata/:provider", h.SamlMetadata)
+	g.GET("/saml/login/:provider", h.SamlLogin)
+	g.POST("/saml/acs/:provider", h.SamlAcs)
+	or.GET("/saml/callback/:provider", h.SamlCallback)
+
 	r.GET("/keys", h.ListApiKey)
 	r.POST("/keys", h.CreateApiKey)
 	r.DELETE("/keys/:id", h.DeleteApiKey)
+	r.GET("/users/me/tokens", h.ListPersonalTokens)
+	r.POST("/users/me/tokens", h.CreatePersonalToken)
+	r.DELETE("/users/me/tokens/:id", h.DeletePersonalToken)
+
+	r.POST("/introspect", h.Introspect)
+	r.POST("/revoke", h.RevokeToken)
 
 	g.Any("/jwt", h.CreateJwt)
 	g.Any("/jwt/*", h.CreateJwt)
 	r.POST("/presign", h.Presign)
+	r.GET("/presign", h.ListPresigns)
+	r.DELETE("/presign/:id", h.RevokePresign)
 	e.GET("/.well-known/jwks.json", h.GetJwks)
 	e.GET("/.well-known/openid-configuration", h.GetOidcConfig)
 
 	g.GET("/info", h.Info)
+	g.GET("/permissions", h.ListPermissions)
 
 	g.GET("/swagger/*", echoSwagger.WrapHandler)
 
@@ -431,6 +595,17 @@ func main() {
 		GracefulTimeout: 10 * time.Second,
 		HideBanner:      true,
 	}
+	if conf.TlsCertificate != nil {
+		sc.TLSConfig = &tls.Config{
+			MinVersion:   tls.VersionTLS12,
+			NextProtos:   []string{"h2", "http/1.1"},
+			Certificates: []tls.Certificate{*conf.TlsCertificate},
+		}
+		if conf.TlsClientCAs != nil {
+			sc.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+			sc.TLSConfig.ClientCAs = conf.TlsClientCAs
+		}
+	}
 	if err := sc.Start(ctx, e); err != nil {
 		e.Logger.Error("server failed", "err", err)
 		os.Exit(1)
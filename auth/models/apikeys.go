@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+type ApiKey struct {
+	Id        uuid.UUID     `json:"id" example:"e05089d6-9179-4b5b-a63e-94dd5fc2a397"`
+	Name      string        `json:"name" example:"myapp"`
+	CreatedAt time.Time     `json:"createAt" example:"2025-03-29T18:20:05.267Z"`
+	LastUsed  time.Time     `json:"lastUsed" example:"2025-03-29T18:20:05.267Z"`
+	Claims    jwt.MapClaims `json:"claims" example:"isAdmin: true"`
+}
+
+type ApiKeyWToken struct {
+	ApiKey
+	Token string `json:"token" example:"myapp-lyHzTYm9yi+pkEv3m2tamAeeK7Dj7N3QRP7xv7dPU5q9MAe8tU4ySwYczE0RaMr4fijsA=="`
+}
+
+type ApiKeyDto struct {
+	Name   string        `json:"name" example:"myapp" validate:"alpha"`
+	Claims jwt.MapClaims `json:"claims" example:"isAdmin: true"`
+}
+
+type PersonalTokenDto struct {
+	Name string `json:"name" example:"kodi" validate:"alpha"`
+	// Permissions of the token, they must be a subset of your own. Defaults to all your permissions.
+	Permissions []string `json:"permissions" example:"core.read,core.play"`
+}
@@ -0,0 +1,7 @@
+package models
+
+type Page[T any] struct {
+	Items []T     `json:"items"`
+	This  string  `json:"this" example:"https://kyoo.zoriya.dev/auth/users"`
+	Next  *string `json:"next" example:"https://kyoo.zoriya.dev/auth/users?after=aoeusth"`
+}
@@ -0,0 +1,17 @@
+package models
+
+import "strings"
+
+// HasPermission checks if `perm` is granted by one of the given permissions.
+// `*` grants everything and `users.*` grants every permission starting with `users.`.
+func HasPermission(granted []string, perm string) bool {
+	for _, g := range granted {
+		if g == perm || g == "*" {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(g, "*"); ok && strings.HasSuffix(prefix, ".") && strings.HasPrefix(perm, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type PresignRequest struct {
+	// The list of requests the signature should be valid for.
+	For []PresignRule `json:"for" validate:"required,min=1,dive"`
+	// How long the signature stays valid (go duration, e.g. `24h`).
+	Duration string `json:"duration" validate:"required" example:"24h"`
+	// Extra claims to add to the signed jwt. Protected claims (`sub`, `permissions`, ...) are rejected.
+	Claims map[string]any `json:"claims,omitempty"`
+	// How many requests the signature can be used for. Unlimited if not set.
+	MaxUses *int32 `json:"maxUses,omitempty" validate:"omitnil,min=1" example:"5"`
+	// Shorthand for `maxUses: 1`, useful for one-time download links.
+	SingleUse bool `json:"singleUse,omitempty"`
+}
+
+type PresignRule struct {
+	// The exact url (matched against the request path) this rule allows. Ignored when `prefix` is set.
+	Url *string `json:"url" example:"/api/videos/example"`
+	// The http verb this rule allows.
+	Verb string `json:"verb" validate:"required" example:"GET"`
+	// When set, matches any request path starting with this prefix instead of the exact `url`.
+	Prefix *string `json:"prefix,omitempty" example:"/videos/e05089d6-9179-4b5b-a63e-94dd5fc2a397/"`
+}
+
+// Matches returns true if this rule allows a request to the given path & method.
+func (r PresignRule) Matches(path string, method string) bool {
+	if !strings.EqualFold(r.Verb, method) {
+		return false
+	}
+	if r.Prefix != nil {
+		return strings.HasPrefix(path, *r.Prefix)
+	}
+	return r.Url != nil && path == *r.Url
+}
+
+type Presign struct {
+	PresignRequest
+	// Id of the presign, can be used to revoke it.
+	Id uuid.UUID `json:"id" example:"e05089d6-9179-4b5b-a63e-94dd5fc2a397"`
+	// The signature to append as a `x-presign` query parameter to any request allowed by `for`.
+	Signature string `json:"signature" example:"eyJhbGciOiJSUzI1NiIsImtpZCI6Ii4uLiJ9.eyJmb3IiOlt7InVybCI6Ii4uLiJ9XX0.KMUFsID..."`
+	// When this signature stops being valid.
+	ExpireAt time.Time `json:"expireAt" example:"2025-03-29T18:20:05.267Z"`
+}
+
+type ActivePresign struct {
+	// Id of the presign, can be used to revoke it.
+	Id uuid.UUID `json:"id" example:"e05089d6-9179-4b5b-a63e-94dd5fc2a397"`
+	// The list of requests the signature is valid for.
+	For []PresignRule `json:"for"`
+	// How many requests the signature can still be used for. Null if unlimited.
+	RemainingUses *int32 `json:"remainingUses" example:"4"`
+	// When was the signature created.
+	CreatedAt time.Time `json:"createdAt" example:"2025-03-29T18:20:05.267Z"`
+	// When this signature stops being valid.
+	ExpireAt time.Time `json:"expireAt" example:"2025-03-29T18:20:05.267Z"`
+}
@@ -22,12 +22,20 @@ type User struct {
 	CreatedDate time.Time `json:"createdDate" example:"2025-03-29T18:20:05.267Z"`
 	// When was the last time this account made any authorized request?
 	LastSeen time.Time `json:"lastSeen" example:"2025-03-29T18:20:05.267Z"`
+	// When will this account be deleted? Null if no deletion has been requested.
+	DeleteAt *time.Time `json:"deleteAt" example:"2025-04-05T18:20:05.267Z"`
 	// List of custom claims JWT created via get /jwt will have
 	Claims jwt.MapClaims `json:"claims" example:"isAdmin: true"`
 	// List of other login method available for this user. Access tokens wont be returned here.
 	Oidc map[string]OidcHandle `json:"oidc"`
 }
 
+type Me struct {
+	User
+	// Set if the current jwt is an impersonation token: this is the admin viewing the app as you.
+	ImpersonatedBy *Actor `json:"impersonatedBy,omitempty"`
+}
+
 type OidcHandle struct {
 	// Id of this oidc handle.
 	Id string `json:"id" example:"e05089d6-9179-4b5b-a63e-94dd5fc2a397"`
@@ -45,6 +53,15 @@ type RegisterDto struct {
 	Email string `json:"email" validate:"required,email" format:"email" example:"kyoo@zoriya.dev"`
 	// Password to use.
 	Password string `json:"password" validate:"required" example:"password1234"`
+	// Solution of the challenge advertised in /info, if any.
+	Challenge *ChallengeDto `json:"challenge,omitempty"`
+}
+
+type ChallengeDto struct {
+	// Id of the proof-of-work challenge (from POST /challenges). Unused for captchas.
+	Id string `json:"id,omitempty" example:"e05089d6-9179-4b5b-a63e-94dd5fc2a397"`
+	// Solution of the proof-of-work or response token of the captcha widget.
+	Response string `json:"response" validate:"required" example:"1589302"`
 }
 
 type EditUserDto struct {
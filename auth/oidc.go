@@ -2,11 +2,13 @@ package main
 
 import (
 	"cmp"
+	"context"
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"maps"
 	"net/http"
 	"net/url"
 	"strings"
@@ -26,10 +28,14 @@ type OidcProvider struct {
 	Logo string `json:"logo,omitempty" format:"url" example:"https://www.gstatic.com/marketing-cms/assets/images/d5/dc/cfe9ce8b4425b410b49b7f2dd3f3/g.webp=s200"`
 }
 
-func (h *Handler) getOidcProvider(provider string) (OidcProviderConfig, error) {
-	p, ok := h.config.OidcProviders[strings.ToLower(provider)]
-	if !ok {
-		return OidcProviderConfig{}, echo.NewHTTPError(http.StatusNotFound, "Unknown OIDC provider")
+func (h *Handler) getOidcProvider(ctx context.Context, provider string) (OidcProviderConfig, error) {
+	providers, err := h.listOidcProviders(ctx)
+	if err != nil {
+		return OidcProviderConfig{}, err
+	}
+	p, ok := providers[strings.ToLower(provider)]
+	if !ok || !p.Enabled || p.Broken {
+		return OidcProviderConfig{}, NewError(http.StatusNotFound, ErrProviderNotFound, "Unknown OIDC provider")
 	}
 	return p, nil
 }
@@ -72,17 +78,17 @@ func (h *Handler) isAllowedRedirectUrl(redirectURL string) bool {
 // @Router /oidc/login/{provider} [get]
 func (h *Handler) OidcLogin(c *echo.Context) error {
 	ctx := c.Request().Context()
-	provider, err := h.getOidcProvider(c.Param("provider"))
+	provider, err := h.getOidcProvider(ctx, c.Param("provider"))
 	if err != nil {
 		return err
 	}
 
 	redirectURL := c.QueryParam("redirectUrl")
 	if redirectURL == "" {
-		return echo.NewHTTPError(http.StatusBadRequest, "Missing redirectUrl")
+		return NewError(http.StatusBadRequest, ErrMissingRedirectUrl, "Missing redirectUrl")
 	}
 	if !h.isAllowedRedirectUrl(redirectURL) {
-		return echo.NewHTTPError(http.StatusBadRequest, "Unauthorized redirectUrl, ask your server admin to whitelist it.")
+		return NewError(http.StatusBadRequest, ErrUnauthorizedRedirect, "Unauthorized redirectUrl, ask your server admin to whitelist it.")
 	}
 
 	opaque := make([]byte, 64)
@@ -103,7 +109,7 @@ func (h *Handler) OidcLogin(c *echo.Context) error {
 
 	authURL, err := url.Parse(provider.Authorization)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Invalid OIDC authorization URL")
+		return NewError(http.StatusInternalServerError, ErrInternal, "Invalid OIDC authorization URL")
 	}
 	params := authURL.Query()
 	params.Set("response_type", "code")
@@ -135,7 +141,7 @@ func (h *Handler) OidcLogin(c *echo.Context) error {
 // @Router /oidc/logged/{provider} [get]
 func (h *Handler) OidcLogged(c *echo.Context) error {
 	ctx := c.Request().Context()
-	provider, err := h.getOidcProvider(c.Param("provider"))
+	provider, err := h.getOidcProvider(ctx, c.Param("provider"))
 	if err != nil {
 		return err
 	}
@@ -145,13 +151,13 @@ func (h *Handler) OidcLogged(c *echo.Context) error {
 		Provider: provider.Id,
 	})
 	if err == pgx.ErrNoRows {
-		return echo.NewHTTPError(http.StatusNotFound, "Login state not found or expired.")
+		return NewError(http.StatusNotFound, ErrOidcStateExpired, "Login state not found or expired.")
 	} else if err != nil {
 		return err
 	}
 
 	if login.CreatedAt.Add(time.Hour).Compare(time.Now().UTC()) < 0 {
-		return echo.NewHTTPError(http.StatusGone, "Login state expired")
+		return NewError(http.StatusGone, ErrOidcStateExpired, "Login state expired")
 	}
 
 	providerErr := c.QueryParam("error")
@@ -169,7 +175,7 @@ func (h *Handler) OidcLogged(c *echo.Context) error {
 
 	ret, err := url.Parse(login.RedirectUrl)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Invalid OIDC redirect URL")
+		return NewError(http.StatusInternalServerError, ErrInternal, "Invalid OIDC redirect URL")
 	}
 	params := ret.Query()
 	params.Set("provider", provider.Id)
@@ -187,14 +193,15 @@ func (h *Handler) OidcLogged(c *echo.Context) error {
 // @Param        token         query  string  true   "Opaque token returned by /oidc/logged/:provider"
 // @Param        tenant        query  string  false  "Optional tenant passthrough for federated setups"
 // @Param        device        query  string  false  "The device the created session will be used on"  example(android tv)
+// @Param        rotate        query  bool    false  "Rotate the session token each time it's exchanged for a jwt"
 // @Param        Authorization header string  false  "Bearer token to link provider to current account"
-// @Success      201  {object}  SessionWToken
+// @Success      201  {object}  models.SessionWToken
 // @Failure      404  {object}  KError "Unknown OIDC provider"
 // @Failure      410  {object}  KError "Login token expired or already used"
 // @Router /oidc/callback/{provider} [get]
 func (h *Handler) OidcCallback(c *echo.Context) error {
 	ctx := c.Request().Context()
-	provider, err := h.getOidcProvider(c.Param("provider"))
+	provider, err := h.getOidcProvider(ctx, c.Param("provider"))
 	if err != nil {
 		return err
 	}
@@ -205,13 +212,13 @@ func (h *Handler) OidcCallback(c *echo.Context) error {
 		Tenant:   c.QueryParam("tenant"),
 	})
 	if err == pgx.ErrNoRows {
-		return echo.NewHTTPError(http.StatusGone, "Login token expired or already used")
+		return NewError(http.StatusGone, ErrOidcLoginExpired, "Login token expired or already used")
 	} else if err != nil {
 		return err
 	}
 
 	if login.Code == nil || *login.Code == "" {
-		return echo.NewHTTPError(http.StatusBadRequest, "Missing authorization code")
+		return NewError(http.StatusBadRequest, ErrOidcMissingCode, "Missing authorization code")
 	}
 
 	token, err := h.exchangeOidcCode(c, provider, *login.Code)
@@ -224,6 +231,9 @@ func (h *Handler) OidcCallback(c *echo.Context) error {
 	}
 
 	if uid, err := GetCurrentUserId(c); err == nil {
+		if getActor(c) != nil {
+			return NewError(http.StatusForbidden, ErrImpersonationReadOnly, "Impersonation tokens are read-only.")
+		}
 		return h.LinkOidcTo(c, provider, profile, token, uid)
 	}
 	return h.CreateUserByOidc(c, provider, profile, token)
@@ -233,6 +243,15 @@ type Token struct {
 	AccessToken  string  `json:"access_token"`
 	RefreshToken *string `json:"refresh_token"`
 	ExpiresIn    float64 `json:"expires_in"`
+	IdToken      string  `json:"id_token"`
+}
+
+// accessToken returns nil for providers without access tokens (saml).
+func (t *Token) accessToken() *string {
+	if t.AccessToken == "" {
+		return nil
+	}
+	return &t.AccessToken
 }
 
 func (h *Handler) exchangeOidcCode(c *echo.Context, provider OidcProviderConfig, code string) (Token, error) {
@@ -273,18 +292,18 @@ func (h *Handler) exchangeOidcCode(c *echo.Context, provider OidcProviderConfig,
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		slog.Error("Error calling oidc token endpoint: %v", "err", err)
-		return Token{}, echo.NewHTTPError(http.StatusBadGateway, "Could not reach OIDC token endpoint")
+		return Token{}, NewError(http.StatusBadGateway, ErrProviderUnavailable, "Could not reach OIDC token endpoint")
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		slog.Error("Error on oidc token endpoint: %v", "err", err)
-		return Token{}, echo.NewHTTPError(http.StatusBadGateway, "OIDC token exchange failed")
+		return Token{}, NewError(http.StatusBadGateway, ErrProviderUnavailable, "OIDC token exchange failed")
 	}
 
 	var ret Token
 	if err := json.NewDecoder(resp.Body).Decode(&ret); err != nil {
 		slog.Error("Couldn't decode token: %v", "err", err)
-		return Token{}, echo.NewHTTPError(http.StatusBadGateway, "Invalid OIDC token response")
+		return Token{}, NewError(http.StatusBadGateway, ErrProviderUnavailable, "Invalid OIDC token response")
 	}
 	return ret, nil
 }
@@ -303,15 +322,32 @@ type RawProfile struct {
 	Name              *string        `json:"name"`
 	Nickname          *string        `json:"nickname"`
 	Email             *string        `json:"email"`
+	EmailVerified     any            `json:"email_verified"`
+	VerifiedEmail     any            `json:"verified_email"`
 	Account           map[string]any `json:"account"`
 	User              map[string]any `json:"user"`
 }
 
 type Profile struct {
-	Sub        string `json:"sub,omitempty"`
-	Username   string `json:"username,omitempty"`
-	Email      string `json:"email,omitempty"`
-	PictureURL string `json:"pictureUrl,omitempty"`
+	Sub      string `json:"sub,omitempty"`
+	Username string `json:"username,omitempty"`
+	Email    string `json:"email,omitempty"`
+	// True if the provider asserted that the user owns this email.
+	EmailVerified bool   `json:"emailVerified,omitempty"`
+	PictureURL    string `json:"pictureUrl,omitempty"`
+	// Claims set by the provider (saml attributes), they override the user's claims on each login.
+	Claims map[string]any `json:"claims,omitempty"`
+}
+
+// isVerifiedClaim parses `email_verified` claims, some providers send them as strings.
+func isVerifiedClaim(claim any) bool {
+	switch v := claim.(type) {
+	case bool:
+		return v
+	case string:
+		return strings.EqualFold(v, "true")
+	}
+	return false
 }
 
 func (h *Handler) fetchOidcProfile(c *echo.Context, provider OidcProviderConfig, accessToken string) (Profile, error) {
@@ -325,18 +361,18 @@ func (h *Handler) fetchOidcProfile(c *echo.Context, provider OidcProviderConfig,
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		slog.Error("Error calling oidc profile endpoint: %v", "err", err)
-		return Profile{}, echo.NewHTTPError(http.StatusInternalServerError, "Could not reach OIDC profile endpoint")
+		return Profile{}, NewError(http.StatusInternalServerError, ErrProviderUnavailable, "Could not reach OIDC profile endpoint")
 	}
 	defer resp.Body.Close()
 
 	var profile RawProfile
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		slog.Error("Error on oidc profile endpoint: %v", "err", err)
-		return Profile{}, echo.NewHTTPError(http.StatusInternalServerError, "Could not fetch OIDC profile")
+		return Profile{}, NewError(http.StatusInternalServerError, ErrProviderUnavailable, "Could not fetch OIDC profile")
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&profile); err != nil {
 		slog.Error("Error parsing oidc profile: %v", "err", err)
-		return Profile{}, echo.NewHTTPError(http.StatusInternalServerError, "Invalid OIDC profile response")
+		return Profile{}, NewError(http.StatusInternalServerError, ErrProviderUnavailable, "Invalid OIDC profile response")
 	}
 	sub := cmp.Or(profile.Sub, profile.Uid, profile.Id, profile.Guid)
 	if sub == nil {
@@ -347,7 +383,7 @@ func (h *Handler) fetchOidcProfile(c *echo.Context, provider OidcProviderConfig,
 		}
 	}
 	if sub == nil {
-		return Profile{}, echo.NewHTTPError(http.StatusInternalServerError, "Missing sub or username")
+		return Profile{}, NewError(http.StatusInternalServerError, ErrProviderUnavailable, "Missing sub or username")
 	}
 	picture := cmp.Or(profile.Picture, profile.AvatarURL, profile.Avatar)
 	if picture == nil {
@@ -381,8 +417,10 @@ func (h *Handler) fetchOidcProfile(c *echo.Context, provider OidcProviderConfig,
 		Email: *cmp.Or(profile.Email, new(fmt.Sprintf(
 			"%s@%s.local",
 			*sub,
-			provider,
+			provider.Id,
 		))),
+		EmailVerified: profile.Email != nil &&
+			(isVerifiedClaim(profile.EmailVerified) || isVerifiedClaim(profile.VerifiedEmail)),
 		PictureURL: pictureURL,
 	}, nil
 }
@@ -400,7 +438,7 @@ func (h *Handler) LinkOidcTo(
 		Id:       profile.Sub,
 	})
 	if err == nil && existing.Id != uid {
-		return echo.NewHTTPError(http.StatusConflict, "This OIDC account is already linked to another user")
+		return NewError(http.StatusConflict, ErrOidcAlreadyLinked, "This OIDC account is already linked to another user")
 	}
 	if err != nil && err != pgx.ErrNoRows {
 		return err
@@ -425,7 +463,7 @@ func (h *Handler) LinkOidcTo(
 		Id:           profile.Sub,
 		Username:     profile.Username,
 		ProfileUrl:   nil,
-		AccessToken:  new(token.AccessToken),
+		AccessToken:  token.accessToken(),
 		RefreshToken: token.RefreshToken,
 		ExpireAt:     expireAt,
 	})
@@ -439,6 +477,7 @@ func (h *Handler) LinkOidcTo(
 		Username:   profile.Username,
 		ProfileUrl: nil,
 	}
+	h.emitOidcLinked(ctx, ret.Id, provider, profile)
 	return c.JSON(http.StatusOK, ret)
 }
 
@@ -454,29 +493,51 @@ func (h *Handler) CreateUserByOidc(
 		Provider: provider.Id,
 		Id:       profile.Sub,
 	})
-	if err != nil {
-		if err != pgx.ErrNoRows {
+	isNew := err == pgx.ErrNoRows
+	if err != nil && !isNew {
+		return err
+	}
+	linkedByEmail := false
+	if isNew && provider.LinkByEmail && profile.EmailVerified {
+		user, err = h.getUserToLinkByEmail(ctx, provider, profile)
+		if err == nil {
+			// link the existing account instead of creating a new one.
+			isNew = false
+			linkedByEmail = true
+		} else if err != pgx.ErrNoRows {
 			return err
 		}
+	}
+	if isNew {
 
 		username := strings.ReplaceAll(profile.Username, "@", "-")
 		if len(username) > 256 {
 			username = username[:256]
 		}
 
+		claims, firstClaims := h.config.DefaultClaims, h.config.FirstUserClaims
+		if len(profile.Claims) > 0 {
+			claims, firstClaims = maps.Clone(claims), maps.Clone(firstClaims)
+			maps.Copy(claims, profile.Claims)
+			maps.Copy(firstClaims, profile.Claims)
+		}
 		user, err = h.db.CreateUser(ctx, dbc.CreateUserParams{
 			Username:    username,
 			Email:       profile.Email,
 			Password:    nil,
-			Claims:      h.config.DefaultClaims,
-			FirstClaims: h.config.FirstUserClaims,
+			Claims:      claims,
+			FirstClaims: firstClaims,
 		})
 		if ErrIs(err, pgerrcode.UniqueViolation) {
-			return echo.NewHTTPError(http.StatusConflict, "A user already exists with the same username or email. If this is you, login via username and then link your account.")
+			if provider.LinkByEmail && !profile.EmailVerified {
+				return NewError(http.StatusConflict, ErrUserExists, fmt.Sprintf("A user already exists with the same username or email but %s did not verify your email. If this is you, login via username and then link your account.", provider.Name))
+			}
+			return NewError(http.StatusConflict, ErrUserExists, "A user already exists with the same username or email. If this is you, login via username and then link your account.")
 		}
 		if err != nil {
 			return err
 		}
+		h.emit(ctx, EventUserCreated, MapDbUser(&user))
 
 		if profile.PictureURL != "" {
 			if err := h.downloadLogo(ctx, user.Id, profile.PictureURL); err != nil {
@@ -492,6 +553,14 @@ func (h *Handler) CreateUserByOidc(
 			}
 		}
 
+	} else if len(profile.Claims) > 0 {
+		user, err = h.db.UpdateUser(ctx, dbc.UpdateUserParams{
+			Id:     user.Id,
+			Claims: profile.Claims,
+		})
+		if err != nil {
+			return err
+		}
 	}
 
 	var expireAt *time.Time
@@ -505,47 +574,100 @@ func (h *Handler) CreateUserByOidc(
 		Id:           profile.Sub,
 		Username:     profile.Username,
 		ProfileUrl:   nil,
-		AccessToken:  &token.AccessToken,
+		AccessToken:  token.accessToken(),
 		RefreshToken: token.RefreshToken,
 		ExpireAt:     expireAt,
 	})
 	if err != nil {
 		return err
 	}
+	if linkedByEmail {
+		h.audit(ctx, user.Pk, "oidc.linked_by_email", map[string]any{
+			"provider": provider.Id,
+			"sub":      profile.Sub,
+		})
+	}
+	if isNew || linkedByEmail {
+		h.emitOidcLinked(ctx, user.Id, provider, profile)
+	}
 
-	return h.createSession(c, new(MapDbUser(&user)))
+	session, err := h.newSession(c, new(MapDbUser(&user)))
+	if err != nil {
+		return err
+	}
+	// remember the provider's session for back-channel & rp-initiated logouts.
+	var idToken *string
+	if token.IdToken != "" {
+		idToken = &token.IdToken
+	}
+	err = h.db.SetSessionOidc(ctx, dbc.SetSessionOidcParams{
+		Pk:           session.Pk,
+		OidcProvider: &provider.Id,
+		OidcSub:      &profile.Sub,
+		OidcSid:      oidcSessionId(token.IdToken),
+		OidcIdToken:  idToken,
+	})
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusCreated, MapSessionToken(&session))
+}
+
+// getUserToLinkByEmail returns the user owning the profile's email, or pgx.ErrNoRows if there is none.
+func (h *Handler) getUserToLinkByEmail(ctx context.Context, provider OidcProviderConfig, profile Profile) (dbc.User, error) {
+	user, err := h.getUserByEmail(ctx, profile.Email)
+	if err != nil {
+		return user, err
+	}
+
+	handles, err := h.db.GetUserOidcHandles(ctx, user.Pk)
+	if err != nil {
+		return user, err
+	}
+	for _, handle := range handles {
+		if handle.Provider == provider.Id && handle.Id != profile.Sub {
+			return user, NewError(
+				http.StatusConflict,
+				ErrOidcAlreadyLinked,
+				fmt.Sprintf("The user with this email is already linked to another %s account.", provider.Name),
+			)
+		}
+	}
+	return user, nil
 }
 
 // @Summary      OIDC unlink provider
-// @Description  Remove an OIDC provider from the current account.
+// @Description  Remove an OIDC (or SAML) provider from the current account.
 // @Tags         oidc
 // @Produce      json
 // @Security     Jwt
-// @Param        provider  path  string  true  "OIDC provider id"  Example(google)
+// @Param        provider  path  string  true  "OIDC or SAML provider id"  Example(google)
 // @Success      204
-// @Failure      404  {object}  KError "Unknown OIDC provider"
+// @Failure      404  {object}  KError "Unknown OIDC or SAML provider"
 // @Router /oidc/login/{provider} [delete]
 func (h *Handler) OidcUnlink(c *echo.Context) error {
+	ctx := c.Request().Context()
 	providerName := strings.ToLower(c.Param("provider"))
-	_, err := h.getOidcProvider(providerName)
-	if err != nil {
-		return err
+	// saml providers store their handles with oidc's, they are unlinked the same way.
+	if _, err := h.getSamlProviderConfig(providerName); err != nil {
+		if _, err := h.getOidcProvider(ctx, providerName); err != nil {
+			return err
+		}
 	}
 
 	uid, err := GetCurrentUserId(c)
 	if err != nil {
 		return err
 	}
-	ctx := c.Request().Context()
 
 	user, err := h.db.GetUser(ctx, dbc.GetUserParams{UseId: true, Id: uid})
 	if err == pgx.ErrNoRows {
-		return echo.NewHTTPError(http.StatusNotFound, "No user found")
+		return NewError(http.StatusNotFound, ErrUserNotFound, "No user found")
 	} else if err != nil {
-		return nil
+		return err
 	}
 	if user.User.Password == nil {
-		return echo.NewHTTPError(http.StatusUnprocessableEntity, "You must configure a password before unlinking your OIDC provider")
+		return NewError(http.StatusUnprocessableEntity, ErrNoPassword, "You must configure a password before unlinking your OIDC provider")
 	}
 
 	err = h.db.DeleteOidcHandle(ctx, dbc.DeleteOidcHandleParams{
@@ -562,6 +684,13 @@ type ServerInfo struct {
 	PublicUrl     string              `json:"publicUrl"`
 	AllowRegister bool                `json:"allowRegister"`
 	Oidc          map[string]OidcInfo `json:"oidc"`
+	// SAML providers, login via /saml/login/{id} instead of /oidc/login/{id}.
+	Saml           map[string]OidcInfo `json:"saml"`
+	PasswordPolicy PasswordPolicy      `json:"passwordPolicy"`
+	// Challenge to solve when registering or logging in, null if disabled.
+	Challenge *ChallengeConfig `json:"challenge"`
+	// True if users can login via an emailed link (see /sessions/magic-link).
+	MagicLink bool `json:"magicLink"`
 }
 
 type OidcInfo struct {
@@ -576,16 +705,36 @@ type OidcInfo struct {
 // @Success      200  {object}  ServerInfo
 // @Router /info [get]
 func (h *Handler) Info(c *echo.Context) error {
-	ret := ServerInfo{
-		PublicUrl:     h.config.PublicUrl,
-		AllowRegister: !h.config.DisableRegistration,
-		Oidc:          make(map[string]OidcInfo),
+	providers, err := h.listOidcProviders(c.Request().Context())
+	if err != nil {
+		return err
 	}
-	for _, provider := range h.config.OidcProviders {
+
+	ret := ServerInfo{
+		PublicUrl:      h.config.PublicUrl,
+		AllowRegister:  !h.config.DisableRegistration,
+		Oidc:           make(map[string]OidcInfo),
+		Saml:           make(map[string]OidcInfo),
+		PasswordPolicy: h.config.PasswordPolicy,
+		MagicLink:      h.config.MagicLink.Enabled,
+	}
+	if h.config.Challenge.Enabled() {
+		ret.Challenge = &h.config.Challenge
+	}
+	for _, provider := range providers {
+		if !provider.Enabled || provider.Broken {
+			continue
+		}
 		ret.Oidc[provider.Id] = OidcInfo{
 			Name: provider.Name,
 			Logo: provider.Logo,
 		}
 	}
+	for _, provider := range h.config.SamlProviders {
+		ret.Saml[provider.Id] = OidcInfo{
+			Name: provider.Name,
+			Logo: provider.Logo,
+		}
+	}
 	return c.JSON(http.StatusOK, ret)
 }
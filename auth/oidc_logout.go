@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v5"
+	"github.com/zoriya/kyoo/keibi/dbc"
+)
+
+const backchannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+
+// getOidcJwksKey returns the public key with the given kid from the provider's (cached) jwks.
+func (h *Handler) getOidcJwksKey(ctx context.Context, provider OidcProviderConfig, kid string) (any, error) {
+	key, err := h.jwksCache.Key(ctx, provider.Jwks, kid)
+	if err != nil {
+		slog.Error("Could not get oidc jwks key", "provider", provider.Id, "kid", kid, "err", err)
+	}
+	return key, err
+}
+
+// verifyLogoutToken validates a back-channel logout token (OpenID Connect Back-Channel Logout 1.0, section 2.6).
+func (h *Handler) verifyLogoutToken(ctx context.Context, provider OidcProviderConfig, logoutToken string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(
+		logoutToken,
+		claims,
+		func(token *jwt.Token) (any, error) {
+			kid, _ := token.Header["kid"].(string)
+			return h.getOidcJwksKey(ctx, provider, kid)
+		},
+		// logout tokens must be signed with the provider's keys, never with a shared secret (or none).
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}),
+		jwt.WithIssuer(provider.Issuer),
+		jwt.WithAudience(provider.ClientId),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	events, ok := claims["events"].(map[string]any)
+	if !ok {
+		return nil, errors.New("missing events claim")
+	}
+	if _, ok := events[backchannelLogoutEvent]; !ok {
+		return nil, errors.New("missing back-channel logout event")
+	}
+	// prevents id tokens from being used as logout tokens.
+	if _, ok := claims["nonce"]; ok {
+		return nil, errors.New("logout tokens can't contain a nonce")
+	}
+	sub, _ := claims["sub"].(string)
+	sid, _ := claims["sid"].(string)
+	if sub == "" && sid == "" {
+		return nil, errors.New("missing sub or sid claim")
+	}
+	return claims, nil
+}
+
+// @Summary      OIDC back-channel logout
+// @Description  Called by the provider (don't call it manually) to logout sessions created via this provider.
+// @Description  Register `{PUBLIC_URL}/auth/oidc/backchannel-logout/{provider}` as the back-channel logout uri.
+// @Tags         oidc
+// @Accept       x-www-form-urlencoded
+// @Param        provider      path      string  true  "Name of the provider"  Example(google)
+// @Param        logout_token  formData  string  true  "Logout token signed by the provider"
+// @Success      200  "Sessions logged out"
+// @Failure      400  {object}  KError "Invalid logout token"
+// @Failure      404  {object}  KError "Unknown OIDC provider"
+// @Router /oidc/backchannel-logout/{provider} [post]
+func (h *Handler) OidcBackchannelLogout(c *echo.Context) error {
+	ctx := c.Request().Context()
+	c.Response().Header().Set("Cache-Control", "no-store")
+
+	providers, err := h.listOidcProviders(ctx)
+	if err != nil {
+		return err
+	}
+	// disabled providers are still allowed to logout their users.
+	provider, ok := providers[strings.ToLower(c.Param("provider"))]
+	if !ok {
+		return NewError(http.StatusNotFound, ErrProviderNotFound, "Unknown OIDC provider")
+	}
+	if provider.Issuer == "" || provider.Jwks == "" {
+		return NewError(http.StatusBadRequest, ErrBackchannelDisabled, "Back-channel logout is not configured for this provider.")
+	}
+
+	claims, err := h.verifyLogoutToken(ctx, provider, c.FormValue("logout_token"))
+	if err != nil {
+		slog.Warn("Invalid oidc logout token", "provider", provider.Id, "err", err)
+		return NewError(http.StatusBadRequest, ErrInvalidLogoutToken, "Invalid logout token.")
+	}
+
+	params := dbc.GetOidcSessionsParams{Provider: provider.Id}
+	if sub, ok := claims["sub"].(string); ok && sub != "" {
+		params.Sub = &sub
+	}
+	if sid, ok := claims["sid"].(string); ok && sid != "" {
+		params.Sid = &sid
+	}
+	sessions, err := h.db.GetOidcSessions(ctx, params)
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		ret, err := h.db.DeleteSessionByPk(ctx, session.Session.Pk)
+		if err == pgx.ErrNoRows {
+			// already deleted by a concurrent logout.
+			continue
+		} else if err != nil {
+			return err
+		}
+		h.audit(ctx, ret.UserPk, "session.oidc_logout", map[string]any{
+			"session":  ret.Id,
+			"provider": provider.Id,
+		})
+		h.emit(ctx, EventSessionDeleted, map[string]any{
+			"userId":  session.UserId,
+			"session": MapSession(&ret),
+		})
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// oidcLogoutUrl returns the provider's url to end the session created with it (RP-initiated logout), if any.
+func (h *Handler) oidcLogoutUrl(ctx context.Context, session *dbc.Session, redirectUrl string) *string {
+	if session.OidcProvider == nil {
+		return nil
+	}
+	providers, err := h.listOidcProviders(ctx)
+	if err != nil {
+		return nil
+	}
+	provider, ok := providers[*session.OidcProvider]
+	if !ok || provider.EndSession == "" {
+		return nil
+	}
+	ret, err := url.Parse(provider.EndSession)
+	if err != nil {
+		return nil
+	}
+	params := ret.Query()
+	params.Set("client_id", provider.ClientId)
+	if session.OidcIdToken != nil {
+		params.Set("id_token_hint", *session.OidcIdToken)
+	}
+	if redirectUrl != "" {
+		params.Set("post_logout_redirect_uri", redirectUrl)
+	}
+	ret.RawQuery = params.Encode()
+	return new(ret.String())
+}
+
+// oidcSessionId reads the provider's session id (sid) of an id token. The token comes directly from the
+// provider's token endpoint so it doesn't need to be verified.
+func oidcSessionId(idToken string) *string {
+	if idToken == "" {
+		return nil
+	}
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(idToken, claims); err != nil {
+		return nil
+	}
+	sid, ok := claims["sid"].(string)
+	if !ok || sid == "" {
+		return nil
+	}
+	return &sid
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v5"
+	"github.com/zoriya/kyoo/keibi/dbc"
+)
+
+// Providers are also cached on other replicas, so we can't rely only on
+// invalidation. This is how long they can serve an outdated provider.
+const oidcProvidersCacheDuration = time.Minute
+
+type OidcProviderCache struct {
+	lock      sync.RWMutex
+	providers map[string]OidcProviderConfig
+	expireAt  time.Time
+}
+
+type OidcProviderSettings struct {
+	// Id of the provider, used in urls (`/oidc/login/{id}`).
+	Id string `json:"id" example:"google"`
+	// Name displayed to users.
+	Name string `json:"name" example:"Google"`
+	// Logo displayed to users.
+	Logo string `json:"logo" format:"url" example:"https://www.gstatic.com/marketing-cms/assets/images/d5/dc/cfe9ce8b4425b410b49b7f2dd3f3/g.webp=s200"`
+	// Client id given by the provider. The client secret is never returned.
+	ClientId      string         `json:"clientId" example:"xxx.apps.googleusercontent.com"`
+	Authorization string         `json:"authorization" format:"url" example:"https://accounts.google.com/o/oauth2/auth"`
+	Token         string         `json:"token" format:"url" example:"https://oauth2.googleapis.com/token"`
+	Profile       string         `json:"profile" format:"url" example:"https://www.googleapis.com/oauth2/v2/userinfo"`
+	Scope         string         `json:"scope" example:"openid profile email"`
+	AuthMethod    OidcAuthMethod `json:"authMethod" example:"ClientSecretBasic"`
+	// Disabled providers are hidden and can't be used to login.
+	Enabled bool `json:"enabled"`
+	// If true, logging in with this provider links the account to the existing user with the same email
+	// (only if the provider says the email is verified) instead of failing.
+	LinkByEmail bool `json:"linkByEmail"`
+	// Issuer & jwks of the provider, required to accept back-channel logouts.
+	Issuer string `json:"issuer,omitempty" example:"https://accounts.google.com"`
+	Jwks   string `json:"jwks,omitempty" format:"url" example:"https://www.googleapis.com/oauth2/v3/certs"`
+	// Url users are redirected to when logging out to also end their session on the provider.
+	EndSession string `json:"endSession,omitempty" format:"url" example:"https://accounts.google.com/logout"`
+	// True if the provider is defined via env vars (it can't be edited at runtime).
+	ReadOnly bool `json:"readOnly"`
+	// True if the provider's secret can't be decrypted (for example after a `SECRET_ENCRYPTION_KEY` change).
+	// Broken providers can't be used to login until a new secret is set.
+	Broken bool `json:"broken"`
+}
+
+type CreateOidcProviderDto struct {
+	Id            string         `json:"id" validate:"required,max=256,excludesall=/?#" example:"google"`
+	Name          string         `json:"name" validate:"required,max=256" example:"Google"`
+	Logo          *string        `json:"logo,omitempty" validate:"omitnil,url" example:"https://www.gstatic.com/marketing-cms/assets/images/d5/dc/cfe9ce8b4425b410b49b7f2dd3f3/g.webp=s200"`
+	ClientId      string         `json:"clientId" validate:"required" example:"xxx.apps.googleusercontent.com"`
+	Secret        string         `json:"secret" validate:"required" example:"GOCSPX-xxx"`
+	Authorization string         `json:"authorization" validate:"required,url" example:"https://accounts.google.com/o/oauth2/auth"`
+	Token         string         `json:"token" validate:"required,url" example:"https://oauth2.googleapis.com/token"`
+	Profile       string         `json:"profile" validate:"required,url" example:"https://www.googleapis.com/oauth2/v2/userinfo"`
+	Scope         *string        `json:"scope,omitempty" example:"openid profile email"`
+	AuthMethod    OidcAuthMethod `json:"authMethod,omitempty" validate:"omitempty,oneof=ClientSecretBasic ClientSecretPost" example:"ClientSecretBasic"`
+	Enabled       *bool          `json:"enabled,omitempty"`
+	LinkByEmail   *bool          `json:"linkByEmail,omitempty"`
+	Issuer        *string        `json:"issuer,omitempty" validate:"required_with=Jwks" example:"https://accounts.google.com"`
+	Jwks          *string        `json:"jwks,omitempty" validate:"required_with=Issuer,omitnil,url" example:"https://www.googleapis.com/oauth2/v3/certs"`
+	EndSession    *string        `json:"endSession,omitempty" validate:"omitnil,url" example:"https://accounts.google.com/logout"`
+}
+
+type EditOidcProviderDto struct {
+	Name          *string         `json:"name,omitempty" validate:"omitnil,max=256" example:"Google"`
+	Logo          *string         `json:"logo,omitempty" validate:"omitnil,url" example:"https://www.gstatic.com/marketing-cms/assets/images/d5/dc/cfe9ce8b4425b410b49b7f2dd3f3/g.webp=s200"`
+	ClientId      *string         `json:"clientId,omitempty" example:"xxx.apps.googleusercontent.com"`
+	Secret        *string         `json:"secret,omitempty" example:"GOCSPX-xxx"`
+	Authorization *string         `json:"authorization,omitempty" validate:"omitnil,url" example:"https://accounts.google.com/o/oauth2/auth"`
+	Token         *string         `json:"token,omitempty" validate:"omitnil,url" example:"https://oauth2.googleapis.com/token"`
+	Profile       *string         `json:"profile,omitempty" validate:"omitnil,url" example:"https://www.googleapis.com/oauth2/v2/userinfo"`
+	Scope         *string         `json:"scope,omitempty" example:"openid profile email"`
+	AuthMethod    *OidcAuthMethod `json:"authMethod,omitempty" validate:"omitnil,oneof=ClientSecretBasic ClientSecretPost" example:"ClientSecretBasic"`
+	Enabled       *bool           `json:"enabled,omitempty"`
+	LinkByEmail   *bool           `json:"linkByEmail,omitempty"`
+	Issuer        *string         `json:"issuer,omitempty" example:"https://accounts.google.com"`
+	Jwks          *string         `json:"jwks,omitempty" validate:"omitnil,url" example:"https://www.googleapis.com/oauth2/v3/certs"`
+	EndSession    *string         `json:"endSession,omitempty" validate:"omitnil,url" example:"https://accounts.google.com/logout"`
+}
+
+func MapOidcProviderSettings(provider *OidcProviderConfig) OidcProviderSettings {
+	return OidcProviderSettings{
+		Id:            provider.Id,
+		Name:          provider.Name,
+		Logo:          provider.Logo,
+		ClientId:      provider.ClientId,
+		Authorization: provider.Authorization,
+		Token:         provider.Token,
+		Profile:       provider.Profile,
+		Scope:         provider.Scope,
+		AuthMethod:    provider.AuthMethod,
+		Enabled:       provider.Enabled,
+		LinkByEmail:   provider.LinkByEmail,
+		Issuer:        provider.Issuer,
+		Jwks:          provider.Jwks,
+		EndSession:    provider.EndSession,
+		ReadOnly:      provider.ReadOnly,
+		Broken:        provider.Broken,
+	}
+}
+
+// mapDbOidcProvider flags providers whose secret can't be decrypted as broken instead of failing so they
+// can still be listed, fixed or deleted by admins.
+func (h *Handler) mapDbOidcProvider(provider *dbc.OidcProvider) OidcProviderConfig {
+	secret, err := decryptSecret(h.config.SecretKey, provider.Secret)
+	if err != nil {
+		slog.Error("Could not decrypt oidc provider's secret", "provider", provider.Id, "err", err)
+	}
+	return OidcProviderConfig{
+		Id:            provider.Id,
+		Name:          provider.Name,
+		Logo:          *cmp.Or(provider.Logo, new("")),
+		ClientId:      provider.ClientId,
+		Secret:        secret,
+		Authorization: provider.AuthorizationUrl,
+		Token:         provider.TokenUrl,
+		Profile:       provider.ProfileUrl,
+		Scope:         provider.Scope,
+		AuthMethod:    OidcAuthMethod(provider.AuthMethod),
+		Enabled:       provider.Enabled,
+		LinkByEmail:   provider.LinkByEmail,
+		Issuer:        *cmp.Or(provider.Issuer, new("")),
+		Jwks:          *cmp.Or(provider.JwksUrl, new("")),
+		EndSession:    *cmp.Or(provider.EndSessionUrl, new("")),
+		ReadOnly:      false,
+		Broken:        err != nil,
+	}
+}
+
+// listOidcProviders returns every provider (env & database ones, including disabled & broken ones).
+func (h *Handler) listOidcProviders(ctx context.Context) (map[string]OidcProviderConfig, error) {
+	cache := h.oidcCache
+	cache.lock.RLock()
+	if cache.providers != nil && time.Now().Before(cache.expireAt) {
+		defer cache.lock.RUnlock()
+		return cache.providers, nil
+	}
+	cache.lock.RUnlock()
+
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	if cache.providers != nil && time.Now().Before(cache.expireAt) {
+		return cache.providers, nil
+	}
+
+	dbProviders, err := h.db.ListOidcProviders(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ret := maps.Clone(h.config.OidcProviders)
+	for _, dbProvider := range dbProviders {
+		if _, ok := ret[dbProvider.Id]; ok {
+			slog.Warn("Ignoring database oidc provider shadowed by an env one", "provider", dbProvider.Id)
+			continue
+		}
+		provider := h.mapDbOidcProvider(&dbProvider)
+		ret[provider.Id] = provider
+	}
+
+	cache.providers = ret
+	cache.expireAt = time.Now().Add(oidcProvidersCacheDuration)
+	return ret, nil
+}
+
+func (h *Handler) invalidateOidcProviders() {
+	h.oidcCache.lock.Lock()
+	defer h.oidcCache.lock.Unlock()
+	h.oidcCache.providers = nil
+}
+
+// @Summary      List OIDC providers
+// @Description  List every OIDC provider, including disabled, broken and env-defined ones. Secrets are never returned.
+// @Tags         oidc
+// @Produce      json
+// @Security     Jwt[oidc.read]
+// @Success      200  {array}  OidcProviderSettings
+// @Failure      403  {object}  KError "Missing permissions: oidc.read."
+// @Router /oidc/providers [get]
+func (h *Handler) ListOidcProviders(c *echo.Context) error {
+	ctx := c.Request().Context()
+	if err := CheckPermissions(c, []string{"oidc.read"}); err != nil {
+		return err
+	}
+
+	providers, err := h.listOidcProviders(ctx)
+	if err != nil {
+		return err
+	}
+	ret := make([]OidcProviderSettings, 0, len(providers))
+	for _, id := range slices.Sorted(maps.Keys(providers)) {
+		provider := providers[id]
+		ret = append(ret, MapOidcProviderSettings(&provider))
+	}
+	return c.JSON(http.StatusOK, ret)
+}
+
+// @Summary      Create OIDC provider
+// @Description  Add a new OIDC provider. Its secret is encrypted with `SECRET_ENCRYPTION_KEY` before being stored.
+// @Tags         oidc
+// @Accept       json
+// @Produce      json
+// @Security     Jwt[oidc.write]
+// @Param        provider  body  CreateOidcProviderDto  true  "Provider settings"
+// @Success      201  {object}  OidcProviderSettings
+// @Failure      403  {object}  KError "Missing permissions: oidc.write."
+// @Failure      409  {object}  KError "A provider with the same id already exists"
+// @Failure      422  {object}  KError "Invalid body"
+// @Router /oidc/providers [post]
+func (h *Handler) CreateOidcProvider(c *echo.Context) error {
+	ctx := c.Request().Context()
+	if err := CheckPermissions(c, []string{"oidc.write"}); err != nil {
+		return err
+	}
+
+	var req CreateOidcProviderDto
+	if err := c.Bind(&req); err != nil {
+		return NewError(http.StatusUnprocessableEntity, ErrInvalidBody, err.Error())
+	}
+	if err := c.Validate(&req); err != nil {
+		return err
+	}
+
+	id := strings.ToLower(req.Id)
+	if _, ok := h.config.OidcProviders[id]; ok {
+		return NewError(http.StatusConflict, ErrProviderExists, "A provider with the same id is already defined via env vars.")
+	}
+	if _, ok := h.config.SamlProviders[id]; ok {
+		return NewError(http.StatusConflict, ErrProviderExists, "A SAML provider with the same id is already defined via env vars.")
+	}
+
+	secret, err := encryptSecret(h.config.SecretKey, req.Secret)
+	if err == ErrNoSecretKey {
+		return NewError(http.StatusInternalServerError, ErrMissingSecretKey, "SECRET_ENCRYPTION_KEY must be set to store oidc providers.")
+	} else if err != nil {
+		return err
+	}
+
+	dbProvider, err := h.db.CreateOidcProvider(ctx, dbc.CreateOidcProviderParams{
+		Id:               id,
+		Name:             req.Name,
+		Logo:             req.Logo,
+		ClientId:         req.ClientId,
+		Secret:           secret,
+		AuthorizationUrl: req.Authorization,
+		TokenUrl:         req.Token,
+		ProfileUrl:       req.Profile,
+		Scope:            *cmp.Or(req.Scope, new("openid profile email")),
+		AuthMethod:       string(cmp.Or(req.AuthMethod, OidcClientSecretBasic)),
+		Enabled:          *cmp.Or(req.Enabled, new(true)),
+		LinkByEmail:      *cmp.Or(req.LinkByEmail, new(false)),
+		Issuer:           req.Issuer,
+		JwksUrl:          req.Jwks,
+		EndSessionUrl:    req.EndSession,
+	})
+	if ErrIs(err, pgerrcode.UniqueViolation) {
+		return NewError(http.StatusConflict, ErrProviderExists, "A provider with the same id already exists.")
+	} else if err != nil {
+		return err
+	}
+	h.invalidateOidcProviders()
+
+	provider := h.mapDbOidcProvider(&dbProvider)
+	return c.JSON(http.StatusCreated, MapOidcProviderSettings(&provider))
+}
+
+// @Summary      Edit OIDC provider
+// @Description  Edit or disable an OIDC provider. Env-defined providers can't be edited.
+// @Tags         oidc
+// @Accept       json
+// @Produce      json
+// @Security     Jwt[oidc.write]
+// @Param        id        path  string               true  "Id of the provider"  Example(google)
+// @Param        provider  body  EditOidcProviderDto  true  "Edited settings"
+// @Success      200  {object}  OidcProviderSettings
+// @Failure      403  {object}  KError "Env-defined providers are read-only"
+// @Failure      404  {object}  KError "Unknown OIDC provider"
+// @Failure      422  {object}  KError "Invalid body"
+// @Router /oidc/providers/{id} [patch]
+func (h *Handler) EditOidcProvider(c *echo.Context) error {
+	ctx := c.Request().Context()
+	if err := CheckPermissions(c, []string{"oidc.write"}); err != nil {
+		return err
+	}
+
+	id := strings.ToLower(c.Param("id"))
+	if _, ok := h.config.OidcProviders[id]; ok {
+		return NewError(http.StatusForbidden, ErrProviderReadOnly, "This provider is defined via env vars and can't be edited.")
+	}
+
+	var req EditOidcProviderDto
+	if err := c.Bind(&req); err != nil {
+		return NewError(http.StatusUnprocessableEntity, ErrInvalidBody, err.Error())
+	}
+	if err := c.Validate(&req); err != nil {
+		return err
+	}
+
+	var secret *string
+	if req.Secret != nil {
+		encrypted, err := encryptSecret(h.config.SecretKey, *req.Secret)
+		if err == ErrNoSecretKey {
+			return NewError(http.StatusInternalServerError, ErrMissingSecretKey, "SECRET_ENCRYPTION_KEY must be set to store oidc providers.")
+		} else if err != nil {
+			return err
+		}
+		secret = &encrypted
+	}
+	var authMethod *string
+	if req.AuthMethod != nil {
+		authMethod = new(string(*req.AuthMethod))
+	}
+
+	dbProvider, err := h.db.UpdateOidcProvider(ctx, dbc.UpdateOidcProviderParams{
+		Id:               id,
+		Name:             req.Name,
+		Logo:             req.Logo,
+		ClientId:         req.ClientId,
+		Secret:           secret,
+		AuthorizationUrl: req.Authorization,
+		TokenUrl:         req.Token,
+		ProfileUrl:       req.Profile,
+		Scope:            req.Scope,
+		AuthMethod:       authMethod,
+		Enabled:          req.Enabled,
+		LinkByEmail:      req.LinkByEmail,
+		Issuer:           req.Issuer,
+		JwksUrl:          req.Jwks,
+		EndSessionUrl:    req.EndSession,
+	})
+	if err == pgx.ErrNoRows {
+		return NewError(http.StatusNotFound, ErrProviderNotFound, "Unknown OIDC provider")
+	} else if err != nil {
+		return err
+	}
+	h.invalidateOidcProviders()
+
+	provider := h.mapDbOidcProvider(&dbProvider)
+	return c.JSON(http.StatusOK, MapOidcProviderSettings(&provider))
+}
+
+// @Summary      Delete OIDC provider
+// @Description  Delete an OIDC provider. Users keep their account but can't login with this provider anymore.
+// @Tags         oidc
+// @Produce      json
+// @Security     Jwt[oidc.write]
+// @Param        id  path  string  true  "Id of the provider"  Example(google)
+// @Success      204
+// @Failure      403  {object}  KError "Env-defined providers are read-only"
+// @Failure      404  {object}  KError "Unknown OIDC provider"
+// @Router /oidc/providers/{id} [delete]
+func (h *Handler) DeleteOidcProvider(c *echo.Context) error {
+	ctx := c.Request().Context()
+	if err := CheckPermissions(c, []string{"oidc.write"}); err != nil {
+		return err
+	}
+
+	id := strings.ToLower(c.Param("id"))
+	if _, ok := h.config.OidcProviders[id]; ok {
+		return NewError(http.StatusForbidden, ErrProviderReadOnly, "This provider is defined via env vars and can't be deleted.")
+	}
+
+	_, err := h.db.DeleteOidcProvider(ctx, id)
+	if err == pgx.ErrNoRows {
+		return NewError(http.StatusNotFound, ErrProviderNotFound, "Unknown OIDC provider")
+	} else if err != nil {
+		return err
+	}
+	h.invalidateOidcProviders()
+	return c.NoContent(http.StatusNoContent)
+}
@@ -6,12 +6,6 @@ import (
 	. "github.com/zoriya/kyoo/keibi/models"
 )
 
-type Page[T any] struct {
-	Items []T     `json:"items"`
-	This  string  `json:"this" example:"https://kyoo.zoriya.dev/auth/users"`
-	Next  *string `json:"next" example:"https://kyoo.zoriya.dev/auth/users?after=aoeusth"`
-}
-
 func NewPage(items []User, url *url.URL, limit int32) Page[User] {
 	this := url.String()
 
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+type PasswordClass string
+
+const (
+	PasswordLower  PasswordClass = "lower"
+	PasswordUpper  PasswordClass = "upper"
+	PasswordDigit  PasswordClass = "digit"
+	PasswordSymbol PasswordClass = "symbol"
+)
+
+var PasswordClasses = []PasswordClass{PasswordLower, PasswordUpper, PasswordDigit, PasswordSymbol}
+
+type PasswordPolicy struct {
+	// Minimum number of characters of a password.
+	MinLength int `json:"minLength" example:"8"`
+	// Character classes a password must contain.
+	RequiredClasses []PasswordClass `json:"requiredClasses" swaggertype:"array,string" enums:"lower,upper,digit,symbol" example:"lower,digit"`
+	// If true, passwords can't contain the username or the email of the user.
+	ForbidPersonalInfo bool `json:"forbidPersonalInfo" example:"false"`
+	// Either an url of a k-anonymity range api (like https://api.pwnedpasswords.com/range/)
+	// or a directory containing `{PREFIX}.txt` range files. Empty to disable the check.
+	BreachCheck string `json:"-"`
+}
+
+type PasswordViolation struct {
+	// Machine readable reason of the violation.
+	Code string `json:"code" example:"too_short" enums:"too_short,missing_lower,missing_upper,missing_digit,missing_symbol,contains_username,contains_email,breached"`
+	// Human readable description of the violation.
+	Message string `json:"message" example:"Password must be at least 8 characters long."`
+}
+
+type PasswordPolicyError struct {
+	Violations []PasswordViolation
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return "Password does not match the password policy."
+}
+
+func (e *PasswordPolicyError) StatusCode() int {
+	return http.StatusUnprocessableEntity
+}
+
+func (e *PasswordPolicyError) ErrorCode() ErrorCode {
+	return ErrPasswordPolicy
+}
+
+func (e *PasswordPolicyError) Details() any {
+	return e.Violations
+}
+
+var breachClient = &http.Client{Timeout: 5 * time.Second}
+
+// checkPassword verifies a new password against the configured policy.
+// It returns a *PasswordPolicyError listing every violation.
+func (h *Handler) checkPassword(ctx context.Context, password string, username string, email string) error {
+	policy := &h.config.PasswordPolicy
+	var violations []PasswordViolation
+
+	if utf8.RuneCountInString(password) < policy.MinLength {
+		violations = append(violations, PasswordViolation{
+			Code:    "too_short",
+			Message: fmt.Sprintf("Password must be at least %d characters long.", policy.MinLength),
+		})
+	}
+
+	for _, class := range policy.RequiredClasses {
+		if !strings.ContainsFunc(password, class.matches) {
+			violations = append(violations, PasswordViolation{
+				Code:    "missing_" + string(class),
+				Message: fmt.Sprintf("Password must contain at least one %s character.", class.describe()),
+			})
+		}
+	}
+
+	if policy.ForbidPersonalInfo {
+		lower := strings.ToLower(password)
+		if len(username) >= 3 && strings.Contains(lower, strings.ToLower(username)) {
+			violations = append(violations, PasswordViolation{
+				Code:    "contains_username",
+				Message: "Password can't contain your username.",
+			})
+		}
+		local, _, _ := strings.Cut(email, "@")
+		if len(local) >= 3 && strings.Contains(lower, strings.ToLower(local)) {
+			violations = append(violations, PasswordViolation{
+				Code:    "contains_email",
+				Message: "Password can't contain your email.",
+			})
+		}
+	}
+
+	if policy.BreachCheck != "" {
+		breached, err := isPasswordBreached(ctx, policy.BreachCheck, password)
+		if err != nil {
+			// don't prevent users from changing their password if the breach source is unavailable.
+			slog.Warn("Could not check if password was breached", "err", err)
+		} else if breached {
+			violations = append(violations, PasswordViolation{
+				Code:    "breached",
+				Message: "This password appeared in a data breach, please use another one.",
+			})
+		}
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
+
+func (class PasswordClass) matches(r rune) bool {
+	switch class {
+	case PasswordLower:
+		return unicode.IsLower(r)
+	case PasswordUpper:
+		return unicode.IsUpper(r)
+	case PasswordDigit:
+		return unicode.IsDigit(r)
+	case PasswordSymbol:
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsSpace(r)
+	}
+	return false
+}
+
+func (class PasswordClass) describe() string {
+	switch class {
+	case PasswordLower:
+		return "lowercase"
+	case PasswordUpper:
+		return "uppercase"
+	case PasswordDigit:
+		return "digit"
+	default:
+		return "symbol"
+	}
+}
+
+// isPasswordBreached uses the k-anonymity model of https://haveibeenpwned.com/API/v3#PwnedPasswords:
+// only the first 5 characters of the SHA-1 of the password are used to retrieve the list of breached
+// hash suffixes.
+func isPasswordBreached(ctx context.Context, source string, password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	var body io.ReadCloser
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, source+prefix, nil)
+		if err != nil {
+			return false, err
+		}
+		req.Header.Set("Add-Padding", "true")
+		req.Header.Set("User-Agent", "keibi")
+		resp, err := breachClient.Do(req)
+		if err != nil {
+			return false, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return false, fmt.Errorf("breach check returned status %d", resp.StatusCode)
+		}
+		body = resp.Body
+	} else {
+		file, err := os.Open(filepath.Join(source, prefix+".txt"))
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		body = file
+	}
+	defer body.Close()
+
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		// lines are formatted as SUFFIX:COUNT
+		entry, count, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if !strings.EqualFold(entry, suffix) {
+			continue
+		}
+		// padding entries have a count of 0
+		n, err := strconv.Atoi(count)
+		return err != nil || n > 0, nil
+	}
+	return false, scanner.Err()
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v5"
+)
+
+type Permission struct {
+	// Name of the permission, used in the `permissions` claim.
+	Name string `json:"name" example:"users.read"`
+	// What this permission allows.
+	Description string `json:"description" example:"List users and read their profiles."`
+}
+
+// Permissions is the catalog of permissions used by kyoo's services.
+// Additional permissions (for third-party services) can be declared via EXTRA_PERMISSIONS.
+var Permissions = []Permission{
+	{Name: "users.read", Description: "List users and read their profiles, logos and sessions."},
+	{Name: "users.write", Description: "Edit other users (including their claims), delete their logos and import users."},
+	{Name: "users.delete", Description: "Delete other users."},
+	{Name: "users.impersonate", Description: "Create read-only jwts to view the app as another user."},
+	{Name: "apikeys.read", Description: "List api keys."},
+	{Name: "apikeys.write", Description: "Create and delete api keys."},
+	{Name: "oidc.read", Description: "List oidc providers and their settings."},
+	{Name: "oidc.write", Description: "Create, edit and delete oidc providers."},
+	{Name: "webhooks.read", Description: "List webhooks and their deliveries."},
+	{Name: "webhooks.write", Description: "Create, edit and delete webhooks."},
+	{Name: "tokens.introspect", Description: "Check if a session token, api key or jwt is valid (POST /introspect)."},
+	{Name: "tokens.revoke", Description: "Revoke any session token or api key by value (POST /revoke)."},
+	{Name: "core.read", Description: "Browse the library (shows, movies, collections...)."},
+	{Name: "core.write", Description: "Edit the library's metadata."},
+	{Name: "core.play", Description: "Play videos via the transcoder."},
+	{Name: "scanner.trigger", Description: "Trigger a library scan and read its status."},
+	{Name: "scanner.guess", Description: "Use the scanner's filename guesser."},
+	{Name: "scanner.search", Description: "Search metadata providers via the scanner."},
+	{Name: "scanner.add", Description: "Manually add movies or series via the scanner."},
+}
+
+// claimPermissions returns the string entries of a `permissions` claim.
+func claimPermissions(claims jwt.MapClaims) []string {
+	ret := make([]string, 0)
+	if perms, ok := claims["permissions"].([]any); ok {
+		for _, perm := range perms {
+			if p, ok := perm.(string); ok {
+				ret = append(ret, p)
+			}
+		}
+	} else if perms, ok := claims["permissions"].([]string); ok {
+		ret = append(ret, perms...)
+	}
+	return ret
+}
+
+func (h *Handler) isKnownPermission(perm string) bool {
+	if perm == "*" {
+		return true
+	}
+	names := make([]string, 0, len(Permissions)+len(h.config.ExtraPermissions))
+	for _, p := range Permissions {
+		names = append(names, p.Name)
+	}
+	names = append(names, h.config.ExtraPermissions...)
+
+	if prefix, ok := strings.CutSuffix(perm, ".*"); ok {
+		return slices.ContainsFunc(names, func(name string) bool {
+			return strings.HasPrefix(name, prefix+".")
+		})
+	}
+	return slices.Contains(names, perm)
+}
+
+// unknownPermissions returns the entries of the `permissions` claim that are not in the catalog.
+// It returns an error if the claim is not a list of strings.
+func (h *Handler) unknownPermissions(claims jwt.MapClaims) ([]string, error) {
+	raw, ok := claims["permissions"]
+	if !ok {
+		return nil, nil
+	}
+	perms, ok := raw.([]any)
+	if !ok {
+		return nil, fmt.Errorf("the permissions claim must be a list of strings")
+	}
+
+	ret := make([]string, 0)
+	for _, perm := range perms {
+		p, ok := perm.(string)
+		if !ok {
+			return nil, fmt.Errorf("the permissions claim must be a list of strings")
+		}
+		if !h.isKnownPermission(p) {
+			ret = append(ret, p)
+		}
+	}
+	return ret, nil
+}
+
+// validatePermissions returns a 422 if the `permissions` claim contains unknown permissions.
+func (h *Handler) validatePermissions(claims jwt.MapClaims) error {
+	unknown, err := h.unknownPermissions(claims)
+	if err != nil {
+		return NewError(http.StatusUnprocessableEntity, ErrUnknownPermissions, fmt.Sprintf("Invalid permissions: %s.", err))
+	}
+	if len(unknown) > 0 {
+		return NewError(
+			http.StatusUnprocessableEntity,
+			ErrUnknownPermissions,
+			fmt.Sprintf("Unknown permissions: %s. See GET /permissions for the list of valid permissions.", strings.Join(unknown, ", ")),
+		)
+	}
+	return nil
+}
+
+// warnUnknownPermissions logs claims set via env vars that reference unknown permissions.
+func (h *Handler) warnUnknownPermissions() {
+	check := func(name string, claims jwt.MapClaims) {
+		unknown, err := h.unknownPermissions(claims)
+		if err != nil {
+			slog.Warn("Invalid permissions claim", "source", name, "err", err)
+		} else if len(unknown) > 0 {
+			slog.Warn("Unknown permissions, this is probably a typo", "source", name, "permissions", unknown)
+		}
+	}
+	check("EXTRA_CLAIMS", h.config.DefaultClaims)
+	check("FIRST_USER_CLAIMS", h.config.FirstUserClaims)
+	check("GUEST_CLAIMS", h.config.GuestClaims)
+	for _, key := range h.config.EnvApiKeys {
+		check(fmt.Sprintf("KEIBI_APIKEY_%s_CLAIMS", strings.ToUpper(key.Name)), key.Claims)
+	}
+}
+
+// @Summary      List permissions
+// @Description  List every known permission. The `permissions` claim can also contain wildcards:
+// @Description  `*` grants every permission and `users.*` grants every permission starting with `users.`.
+// @Tags         users
+// @Produce      json
+// @Success      200  {array}  Permission
+// @Router /permissions [get]
+func (h *Handler) ListPermissions(c *echo.Context) error {
+	ret := slices.Clone(Permissions)
+	for _, name := range h.config.ExtraPermissions {
+		ret = append(ret, Permission{Name: name, Description: "Custom permission (from EXTRA_PERMISSIONS)."})
+	}
+	return c.JSON(http.StatusOK, ret)
+}
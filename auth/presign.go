@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"maps"
@@ -12,47 +13,28 @@ import (
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/labstack/echo/v5"
+	"github.com/zoriya/kyoo/keibi/dbc"
+	. "github.com/zoriya/kyoo/keibi/models"
 )
 
-type PresignRequest struct {
-	// The list of requests the signature should be valid for.
-	For []PresignRule `json:"for" validate:"required,min=1,dive"`
-	// How long the signature stays valid (go duration, e.g. `24h`).
-	Duration string `json:"duration" validate:"required" example:"24h"`
-	// Extra claims to add to the signed jwt. Protected claims (`sub`, `permissions`, ...) are rejected.
-	Claims map[string]any `json:"claims,omitempty"`
-}
-
-type PresignRule struct {
-	// The exact url (matched against the request path) this rule allows. Ignored when `prefix` is set.
-	Url *string `json:"url" example:"/api/videos/example"`
-	// The http verb this rule allows.
-	Verb string `json:"verb" validate:"required" example:"GET"`
-	// When set, matches any request path starting with this prefix instead of the exact `url`.
-	Prefix *string `json:"prefix,omitempty" example:"/videos/e05089d6-9179-4b5b-a63e-94dd5fc2a397/"`
-}
-
-func (r PresignRule) matches(path string, method string) bool {
-	if !strings.EqualFold(r.Verb, method) {
-		return false
-	}
-	if r.Prefix != nil {
-		return strings.HasPrefix(path, *r.Prefix)
+func MapDbPresign(presign *dbc.Presign) ActivePresign {
+	var rules []PresignRule
+	// rules are always marshaled by us, they can't be invalid.
+	json.Unmarshal(presign.Rules, &rules)
+	return ActivePresign{
+		Id:            presign.Id,
+		For:           rules,
+		RemainingUses: presign.RemainingUses,
+		CreatedAt:     presign.CreatedAt,
+		ExpireAt:      presign.ExpireAt,
 	}
-	return r.Url != nil && path == *r.Url
-}
-
-type Presign struct {
-	PresignRequest
-	// The signature to append as a `x-presign` query parameter to any request allowed by `for`.
-	Signature string `json:"signature" example:"eyJhbGciOiJSUzI1NiIsImtpZCI6Ii4uLiJ9.eyJmb3IiOlt7InVybCI6Ii4uLiJ9XX0.KMUFsID..."`
-	// When this signature stops being valid.
-	ExpireAt time.Time `json:"expireAt" example:"2025-03-29T18:20:05.267Z"`
 }
 
 // @Summary      Presign a group of urls
-// @Description  Add the signature as a `x-presign` query parameter
+// @Description  Add the signature as a `x-presign` query parameter. Use `maxUses` or `singleUse` to limit the number
+// @Description  of requests the signature can be used for.
 // @Tags         jwt
 // @Accept       json
 // @Produce      json
@@ -61,21 +43,22 @@ type Presign struct {
 // @Success      200  {object}  Presign
 // @Failure      400  {object}  KError "Invalid parameters"
 // @Failure      401  {object}  KError "Not logged in"
-// @Failure      422  {object}  KError "Invalid body"
+// @Failure      422  {object}  KError "Invalid body or unknown permissions"
 // @Router       /presign [post]
 func (h *Handler) Presign(c *echo.Context) error {
+	ctx := c.Request().Context()
 	token, ok := c.Get("user").(*jwt.Token)
 	if !ok || token == nil {
-		return echo.NewHTTPError(http.StatusUnauthorized, "Not logged in")
+		return NewError(http.StatusUnauthorized, ErrNotLoggedIn, "Not logged in")
 	}
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok {
-		return echo.NewHTTPError(http.StatusForbidden, "Could not retrieve claims")
+		return NewError(http.StatusForbidden, ErrInvalidToken, "Could not retrieve claims")
 	}
 
 	var dto PresignRequest
 	if err := c.Bind(&dto); err != nil {
-		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+		return NewError(http.StatusUnprocessableEntity, ErrInvalidBody, err.Error())
 	}
 	if err := c.Validate(&dto); err != nil {
 		return err
@@ -86,34 +69,55 @@ func (h *Handler) Presign(c *echo.Context) error {
 		if rule.Prefix != nil {
 			path, err := urlPath(*rule.Prefix)
 			if err != nil {
-				return echo.NewHTTPError(http.StatusBadRequest, "Invalid `prefix` value: not an url")
+				return NewError(http.StatusBadRequest, ErrInvalidPresignRule, "Invalid `prefix` value: not an url")
 			}
 			rule.Prefix = &path
 			rule.Url = nil
 		} else if rule.Url != nil {
 			path, err := urlPath(*rule.Url)
 			if err != nil {
-				return echo.NewHTTPError(http.StatusBadRequest, "Invalid `url` value: not an url")
+				return NewError(http.StatusBadRequest, ErrInvalidPresignRule, "Invalid `url` value: not an url")
 			}
 			rule.Url = &path
 		} else {
-			return echo.NewHTTPError(http.StatusBadRequest, "A `for` rule must have either `url` or `prefix`")
+			return NewError(http.StatusBadRequest, ErrInvalidPresignRule, "A `for` rule must have either `url` or `prefix`")
 		}
 		rule.Verb = strings.ToUpper(rule.Verb)
 	}
 
 	for key := range dto.Claims {
 		if slices.Contains(h.config.ProtectedClaims, key) {
-			return echo.NewHTTPError(
+			return NewError(
 				http.StatusBadRequest,
+				ErrProtectedClaim,
 				fmt.Sprintf("Cannot set the protected claim `%s`", key),
 			)
 		}
 	}
 
+	if err := h.validatePermissions(dto.Claims); err != nil {
+		return err
+	}
+
 	duration, err := time.ParseDuration(dto.Duration)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "Invalid `duration` value: not a valid duration")
+		return NewError(http.StatusBadRequest, ErrInvalidDuration, "Invalid `duration` value: not a valid duration")
+	}
+
+	if dto.SingleUse {
+		if dto.MaxUses != nil && *dto.MaxUses != 1 {
+			return NewError(http.StatusBadRequest, ErrInvalidPresignRule, "`singleUse` can't be used with a `maxUses` other than 1")
+		}
+		dto.MaxUses = new(int32(1))
+	}
+
+	sub, err := token.Claims.GetSubject()
+	if err != nil {
+		return NewError(http.StatusForbidden, ErrInvalidToken, "Could not retrieve subject")
+	}
+	subId, err := uuid.Parse(sub)
+	if err != nil {
+		return NewError(http.StatusForbidden, ErrInvalidToken, "Invalid subject")
 	}
 
 	now := time.Now().UTC()
@@ -124,14 +128,26 @@ func (h *Handler) Presign(c *echo.Context) error {
 		return err
 	}
 
+	presign, err := h.db.CreatePresign(ctx, dbc.CreatePresignParams{
+		Sub:           subId,
+		Rules:         rules,
+		RemainingUses: dto.MaxUses,
+		ExpireAt:      expireAt,
+	})
+	if err != nil {
+		return err
+	}
+	go h.db.CleanupPresigns(context.WithoutCancel(ctx))
+
 	presignClaims := jwt.MapClaims{}
 	maps.Copy(presignClaims, dto.Claims)
 	maps.Copy(presignClaims, claims)
 	presignClaims["presign"] = string(rules)
+	presignClaims["pid"] = presign.Id.String()
 	presignClaims["iat"] = &jwt.NumericDate{Time: now}
 	presignClaims["exp"] = &jwt.NumericDate{Time: expireAt}
 
-	jwt := jwt.NewWithClaims(jwt.SigningMethodRS256, presignClaims)
+	jwt := jwt.NewWithClaims(h.config.JwtSigningMethod, presignClaims)
 	jwt.Header["kid"] = h.config.JwtKid
 	signed, err := jwt.SignedString(h.config.JwtPrivateKey)
 	if err != nil {
@@ -140,11 +156,90 @@ func (h *Handler) Presign(c *echo.Context) error {
 
 	return c.JSON(http.StatusOK, Presign{
 		PresignRequest: dto,
+		Id:             presign.Id,
 		Signature:      signed,
 		ExpireAt:       expireAt,
 	})
 }
 
+// @Summary      List presigns
+// @Description  List the presigned signatures you created that can still be used
+// @Tags         jwt
+// @Produce      json
+// @Security     Jwt
+// @Success      200  {array}   ActivePresign
+// @Failure      401  {object}  KError "Not logged in"
+// @Router       /presign [get]
+func (h *Handler) ListPresigns(c *echo.Context) error {
+	ctx := c.Request().Context()
+	sub, err := getPresignOwner(c)
+	if err != nil {
+		return err
+	}
+
+	presigns, err := h.db.ListActivePresigns(ctx, sub)
+	if err != nil {
+		return err
+	}
+	ret := make([]ActivePresign, 0, len(presigns))
+	for _, presign := range presigns {
+		ret = append(ret, MapDbPresign(&presign))
+	}
+	return c.JSON(http.StatusOK, ret)
+}
+
+// @Summary      Revoke presign
+// @Description  Revoke a presigned signature you created, it can't be used anymore.
+// @Tags         jwt
+// @Produce      json
+// @Security     Jwt
+// @Param        id   path      string    true  "The id of the presign to revoke"  Format(uuid)
+// @Success      200  {object}  ActivePresign
+// @Failure      401  {object}  KError "Not logged in"
+// @Failure      404  {object}  KError "No presign found with this id"
+// @Failure      422  {object}  KError "Invalid id format"
+// @Router       /presign/{id} [delete]
+func (h *Handler) RevokePresign(c *echo.Context) error {
+	ctx := c.Request().Context()
+	sub, err := getPresignOwner(c)
+	if err != nil {
+		return err
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return NewError(http.StatusUnprocessableEntity, ErrInvalidId, "Invalid id given: not an uuid")
+	}
+
+	presign, err := h.db.DeletePresign(ctx, dbc.DeletePresignParams{
+		Id:  id,
+		Sub: sub,
+	})
+	if err == pgx.ErrNoRows {
+		return NewError(http.StatusNotFound, ErrPresignNotFound, "No presign found with this id")
+	} else if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, MapDbPresign(&presign))
+}
+
+func getPresignOwner(c *echo.Context) (uuid.UUID, error) {
+	token, ok := c.Get("user").(*jwt.Token)
+	if !ok || token == nil {
+		return uuid.UUID{}, NewError(http.StatusUnauthorized, ErrNotLoggedIn, "Not logged in")
+	}
+	sub, err := token.Claims.GetSubject()
+	// guests share the same subject, they can't manage their presigns
+	if err != nil || sub == "00000000-0000-0000-0000-000000000000" {
+		return uuid.UUID{}, NewError(http.StatusUnauthorized, ErrNotLoggedIn, "Not logged in")
+	}
+	ret, err := uuid.Parse(sub)
+	if err != nil {
+		return uuid.UUID{}, NewError(http.StatusForbidden, ErrInvalidToken, "Invalid subject")
+	}
+	return ret, nil
+}
+
 func getQueryPresign(c *echo.Context) string {
 	if p := c.Request().URL.Query().Get("x-presign"); p != "" {
 		return p
@@ -169,49 +264,41 @@ func urlPath(raw string) (string, error) {
 }
 
 func (h *Handler) createPresignJwt(c *echo.Context, presign string) (string, error) {
-	token, err := jwt.ParseWithClaims(presign, jwt.MapClaims{}, func(t *jwt.Token) (any, error) {
-		if t.Method.Alg() != "RS256" {
-			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
-		}
-		return h.config.JwtPublicKey, nil
-	})
+	token, err := jwt.ParseWithClaims(presign, jwt.MapClaims{}, h.jwtKeyfunc)
 	if err != nil {
-		return "", echo.NewHTTPError(http.StatusForbidden, "Invalid presign signature")
+		return "", NewError(http.StatusForbidden, ErrInvalidPresign, "Invalid presign signature")
 	}
 
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok {
-		return "", echo.NewHTTPError(http.StatusForbidden, "Invalid presign claims")
+		return "", NewError(http.StatusForbidden, ErrInvalidPresign, "Invalid presign claims")
 	}
 
 	presignJson, _ := claims["presign"].(string)
 	var rules []PresignRule
 	if json.Unmarshal([]byte(presignJson), &rules) != nil || len(rules) == 0 {
-		return "", echo.NewHTTPError(http.StatusForbidden, "Not a presign signature")
+		return "", NewError(http.StatusForbidden, ErrInvalidPresign, "Not a presign signature")
 	}
 
 	sidStr, ok := claims["sid"].(string)
 	if !ok {
-		return "", echo.NewHTTPError(http.StatusForbidden, "Missing session id in presign signature")
+		return "", NewError(http.StatusForbidden, ErrInvalidPresign, "Missing session id in presign signature")
 	}
 	sid, err := uuid.Parse(sidStr)
 	if err != nil {
-		return "", echo.NewHTTPError(http.StatusForbidden, "Invalid session id in presign signature")
+		return "", NewError(http.StatusForbidden, ErrInvalidPresign, "Invalid session id in presign signature")
 	}
 	if sid.String() != "00000000-0000-0000-0000-000000000000" {
 		ctx := c.Request().Context()
-		session, err := h.db.GetUserFromSessionId(ctx, sid)
+		session, err := h.getSessionFromId(ctx, sid)
 		if err != nil {
-			return "", echo.NewHTTPError(http.StatusForbidden, "Session not found")
+			return "", NewError(http.StatusForbidden, ErrInvalidSession, "Session not found")
 		}
 		if session.LastUsed.Add(h.config.ExpirationDelay).Compare(time.Now().UTC()) < 0 {
-			return "", echo.NewHTTPError(http.StatusForbidden, "Session has expired")
+			return "", NewError(http.StatusForbidden, ErrSessionExpired, "Session has expired")
 		}
 
-		go func() {
-			h.db.TouchSession(ctx, session.Pk)
-			h.db.TouchUser(ctx, session.User.Pk)
-		}()
+		h.touchSession(session.Pk, session.User.Pk)
 	}
 
 	path := c.Request().URL.Path
@@ -226,20 +313,35 @@ func (h *Handler) createPresignJwt(c *echo.Context, presign string) (string, err
 	}
 
 	allowed := slices.ContainsFunc(rules, func(r PresignRule) bool {
-		return r.matches(path, method)
+		return r.Matches(path, method)
 	})
 	if !allowed {
-		return "", echo.NewHTTPError(http.StatusForbidden, "Presign signature is not valid for this request")
+		return "", NewError(http.StatusForbidden, ErrInvalidPresign, "Presign signature is not valid for this request")
+	}
+
+	// signatures created before presigns were stored don't have an id, they stay stateless.
+	if pidStr, ok := claims["pid"].(string); ok {
+		pid, err := uuid.Parse(pidStr)
+		if err != nil {
+			return "", NewError(http.StatusForbidden, ErrInvalidPresign, "Invalid presign id in presign signature")
+		}
+		_, err = h.db.UsePresign(c.Request().Context(), pid)
+		if err == pgx.ErrNoRows {
+			return "", NewError(http.StatusForbidden, ErrPresignRevoked, "Presign signature has been revoked or already used")
+		} else if err != nil {
+			return "", err
+		}
 	}
 
 	now := time.Now().UTC()
 	delete(claims, "presign")
+	delete(claims, "pid")
 	claims["jti"] = uuid.New().String()
 	claims["iss"] = h.config.PublicUrl
 	claims["iat"] = &jwt.NumericDate{Time: now}
 	claims["exp"] = &jwt.NumericDate{Time: now.Add(time.Hour)}
 
-	jwtTok := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	jwtTok := jwt.NewWithClaims(h.config.JwtSigningMethod, claims)
 	jwtTok.Header["kid"] = h.config.JwtKid
 	return jwtTok.SignedString(h.config.JwtPrivateKey)
 }
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/crewjam/saml"
+	"github.com/crewjam/saml/samlsp"
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v5"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/zoriya/kyoo/keibi/dbc"
+)
+
+// IdP metadata is refetched after this delay to pick up certificate rotations.
+const samlMetadataCacheDuration = 24 * time.Hour
+
+// Failures to load an IdP's metadata are cached for this delay, so an unreachable IdP isn't hit on every login.
+const samlMetadataRetryDelay = time.Minute
+
+var samlMetadataClient = &http.Client{Timeout: 10 * time.Second}
+
+type SamlProviderConfig struct {
+	Id   string
+	Name string
+	Logo string
+	// Url or path of the IdP's metadata.
+	Metadata string
+	// Attribute used as the user's id on the IdP. Defaults to the assertion's NameID.
+	IdAttribute       string
+	UsernameAttribute string
+	EmailAttribute    string
+	// Claims to set from attributes (claim name -> attribute name).
+	ClaimAttributes map[string]string
+	// Link to existing users with the same email instead of failing.
+	LinkByEmail bool
+}
+
+// Attributes tried when SAML_<ID>_ATTRIBUTE_USERNAME/EMAIL are not set (ADFS, then LDAP style names).
+var (
+	samlUsernameAttributes = []string{
+		"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/name",
+		"urn:oid:0.9.2342.19200300.100.1.1",
+		"uid",
+		"username",
+	}
+	samlEmailAttributes = []string{
+		"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/emailaddress",
+		"urn:oid:0.9.2342.19200300.100.1.3",
+		"mail",
+		"email",
+	}
+)
+
+type samlMetadata struct {
+	// Held while the metadata is fetched, so other providers are not blocked by a slow IdP.
+	lock     sync.Mutex
+	metadata *saml.EntityDescriptor
+	err      error
+	expireAt time.Time
+}
+
+type SamlMetadataCache struct {
+	lock     sync.Mutex
+	metadata map[string]*samlMetadata
+}
+
+func (c *SamlMetadataCache) get(provider string) *samlMetadata {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	ret, ok := c.metadata[provider]
+	if !ok {
+		ret = &samlMetadata{}
+		c.metadata[provider] = ret
+	}
+	return ret
+}
+
+func (h *Handler) getSamlProviderConfig(provider string) (SamlProviderConfig, error) {
+	p, ok := h.config.SamlProviders[strings.ToLower(provider)]
+	if !ok {
+		return SamlProviderConfig{}, NewError(http.StatusNotFound, ErrProviderNotFound, "Unknown SAML provider")
+	}
+	return p, nil
+}
+
+// samlServiceProvider returns keibi's service provider for the given IdP, without the IdP's metadata.
+func (h *Handler) samlServiceProvider(provider SamlProviderConfig) *saml.ServiceProvider {
+	base := strings.TrimSuffix(h.config.PublicUrl, "/")
+	metadataUrl, _ := url.Parse(fmt.Sprintf("%s/auth/saml/metadata/%s", base, provider.Id))
+	acsUrl, _ := url.Parse(fmt.Sprintf("%s/auth/saml/acs/%s", base, provider.Id))
+
+	ret := &saml.ServiceProvider{
+		EntityID:          metadataUrl.String(),
+		MetadataURL:       *metadataUrl,
+		AcsURL:            *acsUrl,
+		AuthnNameIDFormat: saml.PersistentNameIDFormat,
+	}
+	if h.config.SamlKey != nil {
+		ret.Key = h.config.SamlKey
+		ret.Certificate = h.config.SamlCertificate
+		ret.SignatureMethod = dsig.RSASHA256SignatureMethod
+	}
+	return ret
+}
+
+// getSamlProvider returns the service provider of an IdP with its (cached) metadata.
+func (h *Handler) getSamlProvider(ctx context.Context, providerId string) (SamlProviderConfig, *saml.ServiceProvider, error) {
+	provider, err := h.getSamlProviderConfig(providerId)
+	if err != nil {
+		return provider, nil, err
+	}
+	sp := h.samlServiceProvider(provider)
+
+	cached := h.samlCache.get(provider.Id)
+	cached.lock.Lock()
+	defer cached.lock.Unlock()
+	if time.Now().After(cached.expireAt) {
+		// shared by every waiting request, a canceled one shouldn't fail the others.
+		cached.metadata, cached.err = fetchSamlMetadata(context.WithoutCancel(ctx), provider.Metadata)
+		if cached.err != nil {
+			slog.Error("Could not load saml metadata", "provider", provider.Id, "err", cached.err)
+			cached.expireAt = time.Now().Add(samlMetadataRetryDelay)
+		} else {
+			cached.expireAt = time.Now().Add(samlMetadataCacheDuration)
+		}
+	}
+	if cached.err != nil {
+		return provider, nil, NewError(http.StatusBadGateway, ErrProviderUnavailable, "Could not load the SAML IdP metadata")
+	}
+	sp.IDPMetadata = cached.metadata
+	return provider, sp, nil
+}
+
+func fetchSamlMetadata(ctx context.Context, source string) (*saml.EntityDescriptor, error) {
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		u, err := url.Parse(source)
+		if err != nil {
+			return nil, err
+		}
+		return samlsp.FetchMetadata(ctx, samlMetadataClient, *u)
+	}
+	data, err := os.ReadFile(source)
+	if err != nil {
+		return nil, err
+	}
+	return samlsp.ParseMetadata(data)
+}
+
+// @Summary      SAML metadata
+// @Description  Service provider metadata to register keibi in your SAML IdP.
+// @Tags         oidc
+// @Produce      xml
+// @Param        provider  path  string  true  "SAML provider id"  Example(adfs)
+// @Success      200
+// @Failure      404  {object}  KError "Unknown SAML provider"
+// @Router /saml/metadata/{provider} [get]
+func (h *Handler) SamlMetadata(c *echo.Context) error {
+	provider, err := h.getSamlProviderConfig(c.Param("provider"))
+	if err != nil {
+		return err
+	}
+	ret, err := xml.MarshalIndent(h.samlServiceProvider(provider).Metadata(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return c.Blob(http.StatusOK, "application/samlmetadata+xml", ret)
+}
+
+// @Summary      SAML login
+// @Description  Start a SAML login with an IdP. This works like the OIDC login.
+// @Tags         oidc
+// @Produce      json
+// @Param        provider     path  string  true   "SAML provider id"  Example(adfs)
+// @Param        redirectUrl  query string  true   "URL to redirect the browser to after the IdP's response"
+// @Param        tenant       query string  false  "Optional tenant passthrough for federated setups"
+// @Success      302
+// @Failure      400  {object}  KError "Missing redirectUrl"
+// @Failure      404  {object}  KError "Unknown SAML provider"
+// @Failure      502  {object}  KError "Could not load the IdP metadata"
+// @Router /saml/login/{provider} [get]
+func (h *Handler) SamlLogin(c *echo.Context) error {
+	ctx := c.Request().Context()
+	provider, sp, err := h.getSamlProvider(ctx, c.Param("provider"))
+	if err != nil {
+		return err
+	}
+
+	redirectURL := c.QueryParam("redirectUrl")
+	if redirectURL == "" {
+		return NewError(http.StatusBadRequest, ErrMissingRedirectUrl, "Missing redirectUrl")
+	}
+	if !h.isAllowedRedirectUrl(redirectURL) {
+		return NewError(http.StatusBadRequest, ErrUnauthorizedRedirect, "Unauthorized redirectUrl, ask your server admin to whitelist it.")
+	}
+
+	ssoUrl := sp.GetSSOBindingLocation(saml.HTTPRedirectBinding)
+	if ssoUrl == "" {
+		return NewError(http.StatusBadGateway, ErrProviderUnavailable, "The SAML IdP does not support the redirect binding")
+	}
+	req, err := sp.MakeAuthenticationRequest(ssoUrl, saml.HTTPRedirectBinding, saml.HTTPPostBinding)
+	if err != nil {
+		return err
+	}
+
+	opaque := make([]byte, 64)
+	_, err = rand.Read(opaque)
+	if err != nil {
+		return err
+	}
+	_, err = h.db.CreateSamlLogin(ctx, dbc.CreateSamlLoginParams{
+		Provider:    provider.Id,
+		Opaque:      base64.RawURLEncoding.EncodeToString(opaque),
+		RedirectUrl: redirectURL,
+		Tenant:      c.QueryParam("tenant"),
+		RequestId:   &req.ID,
+	})
+	if err != nil {
+		return err
+	}
+
+	authURL, err := req.Redirect(base64.RawURLEncoding.EncodeToString(opaque), sp)
+	if err != nil {
+		return err
+	}
+
+	go h.db.CleanupOidcLogins(context.WithoutCancel(ctx))
+	return c.Redirect(http.StatusFound, authURL.String())
+}
+
+// @Summary      SAML assertion consumer
+// @Description  Endpoint the IdP posts its response to (don't call it manually). The response's signature,
+// @Description  audience, destination and validity are checked before redirecting to the app like /oidc/logged.
+// @Tags         oidc
+// @Accept       x-www-form-urlencoded
+// @Param        provider      path      string  true  "SAML provider id"  Example(adfs)
+// @Param        SAMLResponse  formData  string  true  "Base64 encoded SAML response"
+// @Param        RelayState    formData  string  true  "State sent with the authentication request"
+// @Success      302
+// @Failure      404  {object}  KError "Unknown SAML provider or login state"
+// @Router /saml/acs/{provider} [post]
+func (h *Handler) SamlAcs(c *echo.Context) error {
+	ctx := c.Request().Context()
+	provider, sp, err := h.getSamlProvider(ctx, c.Param("provider"))
+	if err != nil {
+		return err
+	}
+
+	login, err := h.db.GetOidcLoginByOpaque(ctx, dbc.GetOidcLoginByOpaqueParams{
+		Opaque:   c.FormValue("RelayState"),
+		Provider: provider.Id,
+	})
+	if err == pgx.ErrNoRows || (err == nil && login.RequestId == nil) {
+		return NewError(http.StatusNotFound, ErrOidcStateExpired, "Login state not found or expired.")
+	} else if err != nil {
+		return err
+	}
+	if login.CreatedAt.Add(time.Hour).Compare(time.Now().UTC()) < 0 {
+		return NewError(http.StatusGone, ErrOidcStateExpired, "Login state expired")
+	}
+
+	providerErr := ""
+	profile, err := h.parseSamlResponse(sp, provider, c.FormValue("SAMLResponse"), *login.RequestId)
+	if err == nil {
+		var data []byte
+		data, err = json.Marshal(profile)
+		if err == nil {
+			err = h.db.SaveSamlLoginProfile(ctx, dbc.SaveSamlLoginProfileParams{
+				Id:      login.Id,
+				Profile: data,
+			})
+		}
+	}
+	if err != nil {
+		var invalid *saml.InvalidResponseError
+		if errors.As(err, &invalid) {
+			err = invalid.PrivateErr
+		}
+		slog.Warn("Invalid saml response", "provider", provider.Id, "err", err)
+		h.db.DeleteOidcLoginById(ctx, login.Id)
+		providerErr = "Invalid SAML response"
+	}
+
+	ret, err := url.Parse(login.RedirectUrl)
+	if err != nil {
+		return NewError(http.StatusInternalServerError, ErrInternal, "Invalid SAML redirect URL")
+	}
+	params := ret.Query()
+	params.Set("provider", provider.Id)
+	params.Set("token", login.Opaque)
+	params.Set("error", providerErr)
+	ret.RawQuery = params.Encode()
+	return c.Redirect(http.StatusFound, ret.String())
+}
+
+func (h *Handler) parseSamlResponse(
+	sp *saml.ServiceProvider,
+	provider SamlProviderConfig,
+	response string,
+	requestId string,
+) (Profile, error) {
+	raw, err := base64.StdEncoding.DecodeString(response)
+	if err != nil {
+		return Profile{}, err
+	}
+	assertion, err := sp.ParseXMLResponse(raw, []string{requestId}, sp.AcsURL)
+	if err != nil {
+		return Profile{}, err
+	}
+
+	attributes := make(map[string][]string)
+	for _, statement := range assertion.AttributeStatements {
+		for _, attr := range statement.Attributes {
+			values := make([]string, 0, len(attr.Values))
+			for _, v := range attr.Values {
+				values = append(values, v.Value)
+			}
+			attributes[attr.Name] = values
+			if attr.FriendlyName != "" {
+				attributes[attr.FriendlyName] = values
+			}
+		}
+	}
+	first := func(names ...string) string {
+		for _, name := range names {
+			if v := attributes[name]; name != "" && len(v) > 0 && v[0] != "" {
+				return v[0]
+			}
+		}
+		return ""
+	}
+
+	sub := first(provider.IdAttribute)
+	if sub == "" && provider.IdAttribute == "" && assertion.Subject != nil && assertion.Subject.NameID != nil {
+		sub = assertion.Subject.NameID.Value
+	}
+	if sub == "" {
+		return Profile{}, errors.New("missing user id in saml assertion")
+	}
+
+	ret := Profile{
+		Sub:      sub,
+		Username: first(append([]string{provider.UsernameAttribute}, samlUsernameAttributes...)...),
+		Email:    first(append([]string{provider.EmailAttribute}, samlEmailAttributes...)...),
+		Claims:   make(map[string]any),
+	}
+	// the IdP is trusted to own its users' emails (linking is still opt-in via SAML_<ID>_LINKBYEMAIL).
+	ret.EmailVerified = ret.Email != ""
+	if ret.Username == "" {
+		ret.Username = fmt.Sprintf("%s-%s", provider.Id, sub)
+	}
+	if ret.Email == "" {
+		ret.Email = fmt.Sprintf("%s@%s.local", sub, provider.Id)
+	}
+	for claim, attr := range provider.ClaimAttributes {
+		// missing attributes clear the claim so the IdP stays the source of truth.
+		ret.Claims[claim] = append(make([]string, 0), attributes[attr]...)
+	}
+	return ret, nil
+}
+
+// @Summary      SAML callback
+// @Description  Exchange an opaque SAML token for a local session. This works like the OIDC callback.
+// @Tags         oidc
+// @Produce      json
+// @Param        provider      path   string  true   "SAML provider id"  Example(adfs)
+// @Param        token         query  string  true   "Opaque token returned by /saml/acs/:provider"
+// @Param        tenant        query  string  false  "Optional tenant passthrough for federated setups"
+// @Param        device        query  string  false  "The device the created session will be used on"  example(android tv)
+// @Param        rotate        query  bool    false  "Rotate the session token each time it's exchanged for a jwt"
+// @Param        Authorization header string  false  "Bearer token to link provider to current account"
+// @Success      201  {object}  models.SessionWToken
+// @Failure      404  {object}  KError "Unknown SAML provider"
+// @Failure      410  {object}  KError "Login token expired or already used"
+// @Router /saml/callback/{provider} [get]
+func (h *Handler) SamlCallback(c *echo.Context) error {
+	ctx := c.Request().Context()
+	provider, err := h.getSamlProviderConfig(c.Param("provider"))
+	if err != nil {
+		return err
+	}
+
+	login, err := h.db.ConsumeOidcLogin(ctx, dbc.ConsumeOidcLoginParams{
+		Opaque:   c.QueryParam("token"),
+		Provider: provider.Id,
+		Tenant:   c.QueryParam("tenant"),
+	})
+	if err == pgx.ErrNoRows {
+		return NewError(http.StatusGone, ErrOidcLoginExpired, "Login token expired or already used")
+	} else if err != nil {
+		return err
+	}
+	if login.Profile == nil {
+		return NewError(http.StatusBadRequest, ErrOidcMissingCode, "Missing SAML assertion")
+	}
+	var profile Profile
+	if err := json.Unmarshal(login.Profile, &profile); err != nil {
+		return err
+	}
+
+	// handles & account creation are shared with oidc providers.
+	oidcProvider := OidcProviderConfig{
+		Id:          provider.Id,
+		Name:        provider.Name,
+		Logo:        provider.Logo,
+		Enabled:     true,
+		LinkByEmail: provider.LinkByEmail,
+		ReadOnly:    true,
+	}
+	if uid, err := GetCurrentUserId(c); err == nil {
+		if getActor(c) != nil {
+			return NewError(http.StatusForbidden, ErrImpersonationReadOnly, "Impersonation tokens are read-only.")
+		}
+		return h.LinkOidcTo(c, oidcProvider, profile, Token{}, uid)
+	}
+	return h.CreateUserByOidc(c, oidcProvider, profile, Token{})
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"log/slog"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/zoriya/kyoo/keibi/dbc"
+)
+
+// Postgres channel used to invalidate cached sessions & users (see the cache_invalidation migration).
+const cacheInvalidationChannel = "keibi_cache"
+
+type lruEntry[K comparable, V any] struct {
+	key      K
+	value    V
+	expireAt time.Time
+}
+
+// lruCache is a size-bounded cache whose entries also expire after a ttl.
+type lruCache[K comparable, V any] struct {
+	lock  sync.Mutex
+	size  int
+	ttl   time.Duration
+	items map[K]*list.Element
+	order *list.List
+}
+
+func newLruCache[K comparable, V any](size int, ttl time.Duration) *lruCache[K, V] {
+	return &lruCache[K, V]{
+		size:  size,
+		ttl:   ttl,
+		items: make(map[K]*list.Element),
+		order: list.New(),
+	}
+}
+
+func (l *lruCache[K, V]) Get(key K) (V, bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	elem, ok := l.items[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	entry := elem.Value.(*lruEntry[K, V])
+	if time.Now().After(entry.expireAt) {
+		l.order.Remove(elem)
+		delete(l.items, key)
+		var zero V
+		return zero, false
+	}
+	l.order.MoveToFront(elem)
+	return entry.value, true
+}
+
+func (l *lruCache[K, V]) Set(key K, value V) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	entry := &lruEntry[K, V]{key: key, value: value, expireAt: time.Now().Add(l.ttl)}
+	if elem, ok := l.items[key]; ok {
+		elem.Value = entry
+		l.order.MoveToFront(elem)
+		return
+	}
+	l.items[key] = l.order.PushFront(entry)
+	for l.order.Len() > l.size {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.items, oldest.Value.(*lruEntry[K, V]).key)
+	}
+}
+
+// RemoveFunc removes every entry for which del returns true.
+func (l *lruCache[K, V]) RemoveFunc(del func(V) bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for elem := l.order.Front(); elem != nil; {
+		next := elem.Next()
+		entry := elem.Value.(*lruEntry[K, V])
+		if del(entry.value) {
+			l.order.Remove(elem)
+			delete(l.items, entry.key)
+		}
+		elem = next
+	}
+}
+
+func (l *lruCache[K, V]) Clear() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	clear(l.items)
+	l.order.Init()
+}
+
+// SessionCache caches session lookups (by token or by id) and batches last used updates.
+// Entries are invalidated via postgres notifications, the cache is bypassed while they can't be received.
+type SessionCache struct {
+	byToken *lruCache[string, dbc.GetUserFromTokenRow]
+	byId    *lruCache[uuid.UUID, dbc.GetUserFromSessionIdRow]
+	// true while we are listening for invalidations.
+	listening bool
+	lock      sync.RWMutex
+	// incremented on each invalidation, used to avoid caching a lookup that raced with one.
+	generation atomic.Uint64
+
+	touchLock sync.Mutex
+	sessions  map[int32]struct{}
+	users     map[int32]struct{}
+}
+
+func NewSessionCache(size int, ttl time.Duration) *SessionCache {
+	ret := &SessionCache{
+		sessions: make(map[int32]struct{}),
+		users:    make(map[int32]struct{}),
+	}
+	if size > 0 {
+		ret.byToken = newLruCache[string, dbc.GetUserFromTokenRow](size, ttl)
+		ret.byId = newLruCache[uuid.UUID, dbc.GetUserFromSessionIdRow](size, ttl)
+	}
+	return ret
+}
+
+func (s *SessionCache) enabled() bool {
+	if s.byToken == nil {
+		return false
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.listening
+}
+
+func (s *SessionCache) setListening(listening bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.listening = listening
+	s.generation.Add(1)
+	if s.byToken != nil {
+		s.byToken.Clear()
+		s.byId.Clear()
+	}
+}
+
+func (s *SessionCache) invalidate(payload string) {
+	kind, pkStr, _ := strings.Cut(payload, ":")
+	pk64, err := strconv.ParseInt(pkStr, 10, 32)
+	if err != nil || s.byToken == nil {
+		return
+	}
+	pk := int32(pk64)
+	s.generation.Add(1)
+	switch kind {
+	case "session":
+		s.byToken.RemoveFunc(func(v dbc.GetUserFromTokenRow) bool { return v.Pk == pk })
+		s.byId.RemoveFunc(func(v dbc.GetUserFromSessionIdRow) bool { return v.Pk == pk })
+	case "user":
+		s.byToken.RemoveFunc(func(v dbc.GetUserFromTokenRow) bool { return v.User.Pk == pk })
+		s.byId.RemoveFunc(func(v dbc.GetUserFromSessionIdRow) bool { return v.User.Pk == pk })
+	}
+}
+
+// getSessionFromToken is a cached GetUserFromToken. Rotating sessions are never cached
+// since their token changes on each use.
+func (h *Handler) getSessionFromToken(ctx context.Context, token string) (dbc.GetUserFromTokenRow, error) {
+	cache := h.sessionCache
+	if !cache.enabled() {
+		return h.db.GetUserFromToken(ctx, token)
+	}
+	if ret, ok := cache.byToken.Get(token); ok {
+		return ret, nil
+	}
+	gen := cache.generation.Load()
+	ret, err := h.db.GetUserFromToken(ctx, token)
+	if err == nil && !ret.Rotate && cache.generation.Load() == gen {
+		cache.byToken.Set(token, ret)
+	}
+	return ret, err
+}
+
+// getSessionFromId is a cached GetUserFromSessionId.
+func (h *Handler) getSessionFromId(ctx context.Context, id uuid.UUID) (dbc.GetUserFromSessionIdRow, error) {
+	cache := h.sessionCache
+	if !cache.enabled() {
+		return h.db.GetUserFromSessionId(ctx, id)
+	}
+	if ret, ok := cache.byId.Get(id); ok {
+		return ret, nil
+	}
+	gen := cache.generation.Load()
+	ret, err := h.db.GetUserFromSessionId(ctx, id)
+	if err == nil && cache.generation.Load() == gen {
+		cache.byId.Set(id, ret)
+	}
+	return ret, err
+}
+
+// touchSession marks a session (and its user) as used, the update is written on the next flush.
+func (h *Handler) touchSession(sessionPk int32, userPk int32) {
+	cache := h.sessionCache
+	cache.touchLock.Lock()
+	defer cache.touchLock.Unlock()
+	cache.sessions[sessionPk] = struct{}{}
+	cache.users[userPk] = struct{}{}
+}
+
+func (h *Handler) flushTouches(ctx context.Context) {
+	cache := h.sessionCache
+	cache.touchLock.Lock()
+	sessions := slices.Collect(maps.Keys(cache.sessions))
+	users := slices.Collect(maps.Keys(cache.users))
+	clear(cache.sessions)
+	clear(cache.users)
+	cache.touchLock.Unlock()
+
+	if len(sessions) > 0 {
+		if err := h.db.TouchSessions(ctx, sessions); err != nil {
+			slog.Warn("Could not update sessions last used date", "err", err)
+		}
+	}
+	if len(users) > 0 {
+		if err := h.db.TouchUsers(ctx, users); err != nil {
+			slog.Warn("Could not update users last seen date", "err", err)
+		}
+	}
+}
+
+// FlushTouches periodically writes the last used dates of sessions & users.
+func (h *Handler) FlushTouches(ctx context.Context) {
+	ticker := time.NewTicker(h.config.TouchFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			h.flushTouches(context.WithoutCancel(ctx))
+			return
+		case <-ticker.C:
+			h.flushTouches(ctx)
+		}
+	}
+}
+
+// ListenCacheInvalidations receives invalidations sent by every keibi instance (via postgres triggers).
+// If the connection is lost, the cache is disabled until we listen again since we could miss invalidations.
+func (h *Handler) ListenCacheInvalidations(ctx context.Context) {
+	if h.sessionCache.byToken == nil {
+		return
+	}
+	for {
+		err := h.listenCacheInvalidations(ctx)
+		h.sessionCache.setListening(false)
+		if ctx.Err() != nil {
+			return
+		}
+		slog.Warn("Lost cache invalidation listener, retrying in 5s", "err", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+func (h *Handler) listenCacheInvalidations(ctx context.Context) error {
+	pooled, err := h.rawDb.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	// the connection stays in LISTEN mode, don't give it back to the pool.
+	conn := pooled.Hijack()
+	defer conn.Close(context.WithoutCancel(ctx))
+
+	_, err = conn.Exec(ctx, "listen "+cacheInvalidationChannel)
+	if err != nil {
+		return err
+	}
+	h.sessionCache.setListening(true)
+
+	for {
+		notif, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		h.sessionCache.invalidate(notif.Payload)
+	}
+}
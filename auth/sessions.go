@@ -2,11 +2,12 @@ package main
 
 import (
 	"cmp"
+	"context"
 	"crypto/rand"
 	"encoding/base64"
 	"net/http"
+	"strconv"
 	"strings"
-	"time"
 
 	"github.com/alexedwards/argon2id"
 	"github.com/golang-jwt/jwt/v5"
@@ -18,45 +19,40 @@ import (
 	. "github.com/zoriya/kyoo/keibi/models"
 )
 
-type Session struct {
-	// Unique id of this session. Can be used for calls to DELETE
-	Id uuid.UUID `json:"id" example:"e05089d6-9179-4b5b-a63e-94dd5fc2a397"`
-	// When was the session first opened
-	CreatedDate time.Time `json:"createdDate" example:"2025-03-29T18:20:05.267Z"`
-	// Last date this session was used to access a service.
-	LastUsed time.Time `json:"lastUsed" example:"2025-03-29T18:20:05.267Z"`
-	// Device that created the session.
-	Device *string `json:"device" example:"Web - Firefox"`
-}
-
-type SessionWToken struct {
-	Session
-	Token string `json:"token" example:"lyHzTYm9yi+pkEv3m2tamAeeK7Dj7N3QRP7xv7dPU5q9MAe8tU4ySwYczE0RaMr4fijsA=="`
-}
-
-type SessionWCurrent struct {
-	Session
-	Current bool `json:"current"`
+// formatDevice converts a user agent to a readable name (without versions, like `Firefox - Linux`).
+func formatDevice(device string) string {
+	ua := useragent.Parse(device)
+	uae := ([]string{ua.Name})
+	if ua.Device != "" {
+		uae = append(uae, ua.Device)
+	}
+	if ua.OS != "" {
+		uae = append(uae, ua.OS)
+	}
+	return strings.Join(uae, " - ")
 }
 
 func MapSession(ses *dbc.Session) Session {
 	dev := ses.Device
 	if ses.Device != nil {
-		ua := useragent.Parse(*ses.Device)
-		uae := ([]string{ua.Name})
-		if ua.Device != "" {
-			uae = append(uae, ua.Device)
-		}
-		if ua.OS != "" {
-			uae = append(uae, ua.OS)
+		dev = new(formatDevice(*ses.Device))
+	}
+	var impersonator *Actor
+	if ses.ImpersonatorId != nil {
+		impersonator = &Actor{
+			Id:       *ses.ImpersonatorId,
+			Username: *cmp.Or(ses.ImpersonatorUsername, new("")),
 		}
-		dev = new(strings.Join(uae, " - "))
+		dev = ses.Device
 	}
 	return Session{
 		Id:          ses.Id,
 		CreatedDate: ses.CreatedDate,
 		LastUsed:    ses.LastUsed,
 		Device:      dev,
+		Rotate:      ses.Rotate,
+
+		ImpersonatedBy: impersonator,
 	}
 }
 
@@ -67,31 +63,26 @@ func MapSessionToken(ses *dbc.Session) SessionWToken {
 	}
 }
 
-type LoginDto struct {
-	// Either the email or the username.
-	Login string `json:"login" validate:"required" example:"zoriya"`
-	// Password of the account.
-	Password string `json:"password" validate:"required" example:"password1234"`
-}
-
 // @Summary      Login
 // @Description  Login to your account and open a session
 // @Tags         sessions
 // @Accept       json
 // @Produce      json
 // @Param        device  query   string    false  "The device the created session will be used on"  example(android tv)
+// @Param        rotate  query   bool      false  "Rotate the session token each time it's exchanged for a jwt"
 // @Param        login   body    LoginDto  false  "Account informations"
 // @Success      201  {object}   SessionWToken
-// @Failure      403  {object}   KError "Invalid password"
+// @Failure      403  {object}   KError "Invalid password or challenge solution (or a reported login requires a password change)"
 // @Failure      404  {object}   KError "Account does not exists"
 // @Failure      422  {object}   KError "User does not have a password (registered via oidc, please login via oidc)"
+// @Failure      428  {object}   KError "Too many failed logins, a challenge must be solved (see /info)"
 // @Router /sessions [post]
 func (h *Handler) Login(c *echo.Context) error {
 	ctx := c.Request().Context()
 	var req LoginDto
 	err := c.Bind(&req)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+		return NewError(http.StatusUnprocessableEntity, ErrInvalidBody, err.Error())
 	}
 	if err = c.Validate(&req); err != nil {
 		return err
@@ -99,18 +90,52 @@ func (h *Handler) Login(c *echo.Context) error {
 
 	dbuser, err := h.db.GetUserByLogin(ctx, req.Login)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusNotFound, "No account exists with the specified email or username.")
+		return NewError(http.StatusNotFound, ErrUserNotFound, "No account exists with the specified email or username.")
 	}
 	if dbuser.Password == nil {
-		return echo.NewHTTPError(http.StatusUnprocessableEntity, "Can't login with password, this account was created with OIDC.")
+		return NewError(http.StatusUnprocessableEntity, ErrNoPassword, "Can't login with password, this account was created with OIDC.")
+	}
+	if dbuser.PasswordResetRequired {
+		return NewError(http.StatusForbidden, ErrPasswordResetRequired, "A login to this account was reported, use the link sent by email to choose a new password.")
+	}
+
+	threshold := h.config.Challenge.AfterFailedLogins
+	if threshold > 0 && dbuser.FailedLogins >= threshold {
+		if err = h.verifyChallenge(c, req.Challenge); err != nil {
+			return err
+		}
 	}
 
-	match, err := argon2id.ComparePasswordAndHash(req.Password, *dbuser.Password)
+	match, needsRehash, err := verifyPassword(req.Password, *dbuser.Password)
 	if err != nil {
 		return err
 	}
 	if !match {
-		return echo.NewHTTPError(http.StatusForbidden, "Invalid password")
+		if threshold > 0 {
+			if _, err = h.db.IncrementFailedLogins(ctx, dbuser.Pk); err != nil {
+				return err
+			}
+		}
+		return NewError(http.StatusForbidden, ErrInvalidPassword, "Invalid password")
+	}
+	if dbuser.FailedLogins > 0 {
+		if err = h.db.ResetFailedLogins(ctx, dbuser.Pk); err != nil {
+			return err
+		}
+	}
+	if needsRehash {
+		// imported users keep their legacy hash until their first login.
+		pass, err := argon2id.CreateHash(req.Password, argon2id.DefaultParams)
+		if err != nil {
+			return err
+		}
+		_, err = h.db.UpdateUser(ctx, dbc.UpdateUserParams{
+			Id:       dbuser.Id,
+			Password: &pass,
+		})
+		if err != nil {
+			return err
+		}
 	}
 
 	user := MapDbUser(&dbuser)
@@ -118,12 +143,20 @@ func (h *Handler) Login(c *echo.Context) error {
 }
 
 func (h *Handler) createSession(c *echo.Context, user *User) error {
+	session, err := h.newSession(c, user)
+	if err != nil {
+		return err
+	}
+	return c.JSON(201, MapSessionToken(&session))
+}
+
+func (h *Handler) newSession(c *echo.Context, user *User) (dbc.Session, error) {
 	ctx := c.Request().Context()
 
 	id := make([]byte, 64)
 	_, err := rand.Read(id)
 	if err != nil {
-		return err
+		return dbc.Session{}, err
 	}
 
 	dev := cmp.Or(c.QueryParam("device"), c.Request().Header.Get("User-Agent"))
@@ -132,15 +165,29 @@ func (h *Handler) createSession(c *echo.Context, user *User) error {
 		device = nil
 	}
 
+	// rotating sessions return a new token (and invalidate the previous one) on each call to /jwt.
+	rotate, _ := strconv.ParseBool(c.QueryParam("rotate"))
+
 	session, err := h.db.CreateSession(ctx, dbc.CreateSessionParams{
 		Token:  base64.RawURLEncoding.EncodeToString(id),
 		UserPk: user.Pk,
 		Device: device,
+		Rotate: rotate,
 	})
 	if err != nil {
-		return err
+		return dbc.Session{}, err
 	}
-	return c.JSON(201, MapSessionToken(&session))
+	h.audit(ctx, user.Pk, "session.created", map[string]any{
+		"session": session.Id,
+		"device":  session.Device,
+	})
+	h.emit(ctx, EventSessionCreated, map[string]any{
+		"userId":  user.Id,
+		"session": MapSession(&session),
+	})
+	// in the background since it can send an email.
+	go h.checkNewDevice(context.WithoutCancel(ctx), *user, session, c.RealIP())
+	return session, nil
 }
 
 // @Summary      List my sessions
@@ -210,7 +257,7 @@ func (h *Handler) ListUserSessions(c *echo.Context) error {
 		Username: id,
 	})
 	if err == pgx.ErrNoRows {
-		return echo.NewHTTPError(http.StatusNotFound, "No user found with id or username")
+		return NewError(http.StatusNotFound, ErrUserNotFound, "No user found with id or username")
 	} else if err != nil {
 		return err
 	}
@@ -229,11 +276,14 @@ func (h *Handler) ListUserSessions(c *echo.Context) error {
 }
 
 // @Summary      Logout
-// @Description  Delete a session and logout
+// @Description  Delete a session and logout. If the session was created via an OIDC provider that supports it,
+// @Description  `logoutUrl` can be opened to also end the session on the provider.
 // @Tags         sessions
 // @Produce      json
 // @Security     Jwt
-// @Success      200  {object}  Session
+// @Param        redirectUrl  query  string  false  "Url the provider redirects to after its logout"  example(https://kyoo.zoriya.dev/login)
+// @Success      200  {object}  SessionWLogout
+// @Failure      400  {object}  KError "Unauthorized redirectUrl"
 // @Failure      401  {object}  KError "Missing jwt token"
 // @Failure      403  {object}  KError "Invalid jwt token (or expired)"
 // @Router /sessions/current [delete]
@@ -248,13 +298,17 @@ func (h *Handler) Logout(c *echo.Context) error {
 	if session == "current" {
 		sid, ok := c.Get("user").(*jwt.Token).Claims.(jwt.MapClaims)["sid"]
 		if !ok {
-			return echo.NewHTTPError(http.StatusInternalServerError, "Missing session id")
+			return NewError(http.StatusInternalServerError, ErrInternal, "Missing session id")
 		}
 		session = sid.(string)
 	}
 	sid, err := uuid.Parse(session)
 	if err != nil {
-		return echo.NewHTTPError(422, "Invalid session id")
+		return NewError(422, ErrInvalidId, "Invalid session id")
+	}
+	redirectUrl := c.QueryParam("redirectUrl")
+	if redirectUrl != "" && !h.isAllowedRedirectUrl(redirectUrl) {
+		return NewError(http.StatusBadRequest, ErrUnauthorizedRedirect, "Unauthorized redirectUrl, ask your server admin to whitelist it.")
 	}
 
 	ret, err := h.db.DeleteSession(ctx, dbc.DeleteSessionParams{
@@ -262,11 +316,18 @@ func (h *Handler) Logout(c *echo.Context) error {
 		UserId: uid,
 	})
 	if err == pgx.ErrNoRows {
-		return echo.NewHTTPError(404, "Session not found with specified id")
+		return NewError(404, ErrSessionNotFound, "Session not found with specified id")
 	} else if err != nil {
 		return err
 	}
-	return c.JSON(200, MapSession(&ret))
+	h.emit(ctx, EventSessionDeleted, map[string]any{
+		"userId":  uid,
+		"session": MapSession(&ret),
+	})
+	return c.JSON(200, SessionWLogout{
+		Session:   MapSession(&ret),
+		LogoutUrl: h.oidcLogoutUrl(ctx, &ret, redirectUrl),
+	})
 }
 
 // @Summary      Delete other session
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"net/http"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v5"
+	"github.com/zoriya/kyoo/keibi/dbc"
+)
+
+//go:embed settings.schema.json
+var defaultSettingsSchema []byte
+
+// SettingsSchema is the subset of json schema used to validate user settings.
+type SettingsSchema struct {
+	Type        SchemaTypes `json:"type,omitempty" swaggertype:"string" example:"string"`
+	Description string      `json:"description,omitempty"`
+	Enum        []any       `json:"enum,omitempty"`
+	// Raw to differentiate a null default from a missing one.
+	Default              json.RawMessage            `json:"default,omitempty" swaggertype:"object"`
+	Minimum              *float64                   `json:"minimum,omitempty"`
+	Maximum              *float64                   `json:"maximum,omitempty"`
+	Properties           map[string]*SettingsSchema `json:"properties,omitempty"`
+	AdditionalProperties bool                       `json:"additionalProperties,omitempty"`
+	Items                *SettingsSchema            `json:"items,omitempty"`
+}
+
+// SchemaTypes is either a single type (`"string"`) or a list of types (`["string", "null"]`).
+type SchemaTypes []string
+
+var schemaTypeNames = []string{"null", "boolean", "string", "number", "integer", "array", "object"}
+
+func (t *SchemaTypes) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*t = SchemaTypes{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+	*t = multiple
+	return nil
+}
+
+func (t SchemaTypes) MarshalJSON() ([]byte, error) {
+	if len(t) == 1 {
+		return json.Marshal(t[0])
+	}
+	return json.Marshal([]string(t))
+}
+
+func loadSettingsSchema(path string) (*SettingsSchema, error) {
+	data := defaultSettingsSchema
+	if path != "" {
+		var err error
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var ret SettingsSchema
+	if err := json.Unmarshal(data, &ret); err != nil {
+		return nil, fmt.Errorf("invalid USER_SETTINGS_SCHEMA_PATH: %w", err)
+	}
+	if !slices.Equal(ret.Type, SchemaTypes{"object"}) {
+		return nil, errors.New("invalid USER_SETTINGS_SCHEMA_PATH: the root must be an object")
+	}
+	if err := ret.check("settings"); err != nil {
+		return nil, fmt.Errorf("invalid USER_SETTINGS_SCHEMA_PATH: %w", err)
+	}
+	if defaults, ok := ret.defaults(); ok {
+		if err := ret.validate("settings", defaults); err != nil {
+			return nil, fmt.Errorf("invalid USER_SETTINGS_SCHEMA_PATH default: %w", err)
+		}
+	}
+	return &ret, nil
+}
+
+func (s *SettingsSchema) check(path string) error {
+	for _, t := range s.Type {
+		if !slices.Contains(schemaTypeNames, t) {
+			return fmt.Errorf("%s: unknown type %s", path, t)
+		}
+	}
+	for key, prop := range s.Properties {
+		if err := prop.check(path + "." + key); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		return s.Items.check(path + "[]")
+	}
+	return nil
+}
+
+func (s *SettingsSchema) hasType(value any) bool {
+	if len(s.Type) == 0 {
+		return true
+	}
+	for _, t := range s.Type {
+		ok := false
+		switch t {
+		case "null":
+			ok = value == nil
+		case "boolean":
+			_, ok = value.(bool)
+		case "string":
+			_, ok = value.(string)
+		case "number":
+			_, ok = value.(float64)
+		case "integer":
+			f, isNumber := value.(float64)
+			ok = isNumber && f == math.Trunc(f)
+		case "array":
+			_, ok = value.([]any)
+		case "object":
+			_, ok = value.(map[string]any)
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *SettingsSchema) validate(path string, value any) error {
+	if !s.hasType(value) {
+		return fmt.Errorf("%s: expected %s", path, strings.Join(s.Type, " or "))
+	}
+	if len(s.Enum) > 0 && !slices.ContainsFunc(s.Enum, func(e any) bool { return reflect.DeepEqual(e, value) }) {
+		return fmt.Errorf("%s: must be one of %v", path, s.Enum)
+	}
+	if f, ok := value.(float64); ok {
+		if s.Minimum != nil && f < *s.Minimum {
+			return fmt.Errorf("%s: must be at least %v", path, *s.Minimum)
+		}
+		if s.Maximum != nil && f > *s.Maximum {
+			return fmt.Errorf("%s: must be at most %v", path, *s.Maximum)
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		for _, key := range slices.Sorted(maps.Keys(v)) {
+			item := v[key]
+			prop, ok := s.Properties[key]
+			if !ok {
+				if s.AdditionalProperties {
+					continue
+				}
+				return fmt.Errorf("%s.%s: unknown setting", path, key)
+			}
+			if err := prop.validate(path+"."+key, item); err != nil {
+				return err
+			}
+		}
+	case []any:
+		if s.Items == nil {
+			return nil
+		}
+		for i, item := range v {
+			if err := s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// prune removes unknown or invalid values (stored before a schema change for example).
+func (s *SettingsSchema) prune(value any) (any, bool) {
+	obj, ok := value.(map[string]any)
+	if !ok || !s.hasType(value) {
+		return value, s.validate("", value) == nil
+	}
+	ret := make(map[string]any, len(obj))
+	for key, item := range obj {
+		prop, ok := s.Properties[key]
+		if !ok {
+			if s.AdditionalProperties {
+				ret[key] = item
+			}
+			continue
+		}
+		if v, ok := prop.prune(item); ok {
+			ret[key] = v
+		}
+	}
+	return ret, true
+}
+
+func (s *SettingsSchema) defaults() (any, bool) {
+	if s.Default != nil {
+		var ret any
+		if err := json.Unmarshal(s.Default, &ret); err != nil {
+			return nil, false
+		}
+		return ret, true
+	}
+	if len(s.Properties) == 0 {
+		return nil, false
+	}
+	ret := make(map[string]any)
+	for key, prop := range s.Properties {
+		if v, ok := prop.defaults(); ok {
+			ret[key] = v
+		}
+	}
+	return ret, true
+}
+
+// mergePatch applies a json merge patch (RFC 7396): objects are merged recursively and null removes a key.
+func mergePatch(target any, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	ret := make(map[string]any)
+	if t, ok := target.(map[string]any); ok {
+		maps.Copy(ret, t)
+	}
+	for key, value := range p {
+		if value == nil {
+			delete(ret, key)
+		} else {
+			ret[key] = mergePatch(ret[key], value)
+		}
+	}
+	return ret
+}
+
+type UserSettings struct {
+	// Settings to use: the schema's defaults overridden by the account's settings then the device's.
+	Settings map[string]any `json:"settings" example:"preferOriginal: true"`
+	// Settings saved for the account.
+	Account map[string]any `json:"account" example:"preferOriginal: true"`
+	// Overrides saved for the current device (session).
+	Device map[string]any `json:"device" example:"preferOriginal: false"`
+}
+
+func (h *Handler) decodeSettings(raw json.RawMessage, err error) (map[string]any, error) {
+	if err == pgx.ErrNoRows {
+		return make(map[string]any), nil
+	} else if err != nil {
+		return nil, err
+	}
+	var value any
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil, err
+	}
+	pruned, ok := h.config.SettingsSchema.prune(value)
+	ret, isObj := pruned.(map[string]any)
+	if !ok || !isObj {
+		return make(map[string]any), nil
+	}
+	return ret, nil
+}
+
+func (h *Handler) getUserSettings(ctx context.Context, uid uuid.UUID, sid *uuid.UUID) (UserSettings, error) {
+	account, err := h.decodeSettings(h.db.GetAccountSettings(ctx, uid))
+	if err != nil {
+		return UserSettings{}, err
+	}
+	device := make(map[string]any)
+	if sid != nil {
+		device, err = h.decodeSettings(h.db.GetSessionSettings(ctx, *sid))
+		if err != nil {
+			return UserSettings{}, err
+		}
+	}
+
+	defaults, _ := h.config.SettingsSchema.defaults()
+	settings, _ := mergePatch(mergePatch(defaults, account), device).(map[string]any)
+	return UserSettings{
+		Settings: settings,
+		Account:  account,
+		Device:   device,
+	}, nil
+}
+
+// @Summary      Settings schema
+// @Description  Json schema of user settings (types, allowed values & defaults).
+// @Tags         settings
+// @Produce      json
+// @Success      200  {object}  SettingsSchema
+// @Router /settings/schema [get]
+func (h *Handler) GetSettingsSchema(c *echo.Context) error {
+	return c.JSON(http.StatusOK, h.config.SettingsSchema)
+}
+
+// @Summary      Get my settings
+// @Description  Get your settings. Unlike claims, settings are not stored in jwts.
+// @Tags         settings
+// @Produce      json
+// @Security     Jwt
+// @Success      200  {object}  UserSettings
+// @Router /users/me/settings [get]
+func (h *Handler) GetMySettings(c *echo.Context) error {
+	uid, err := GetCurrentUserId(c)
+	if err != nil {
+		return err
+	}
+	var sid *uuid.UUID
+	if id, err := GetCurrentSessionId(c); err == nil {
+		sid = &id
+	}
+
+	ret, err := h.getUserSettings(c.Request().Context(), uid, sid)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, ret)
+}
+
+// @Summary      Get user settings
+// @Description  Get the settings of a user (without device overrides).
+// @Tags         settings
+// @Produce      json
+// @Security     Jwt[users.read]
+// @Param        id   path      string  true  "The id of the user" Format(uuid)
+// @Success      200  {object}  UserSettings
+// @Failure      404  {object}  KError "No user with the given id found"
+// @Router /users/{id}/settings [get]
+func (h *Handler) GetUserSettings(c *echo.Context) error {
+	ctx := c.Request().Context()
+	err := CheckPermissions(c, []string{"users.read"})
+	if err != nil {
+		return err
+	}
+
+	uid, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return NewError(http.StatusBadRequest, ErrInvalidId, "Invalid id given: not an uuid")
+	}
+	_, err = h.db.GetUser(ctx, dbc.GetUserParams{
+		UseId: true,
+		Id:    uid,
+	})
+	if err == pgx.ErrNoRows {
+		return NewError(http.StatusNotFound, ErrUserNotFound, fmt.Sprintf("No user found with id %s", uid))
+	} else if err != nil {
+		return err
+	}
+
+	ret, err := h.getUserSettings(ctx, uid, nil)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, ret)
+}
+
+// @Summary      Edit my settings
+// @Description  Edit your settings with a json merge patch (RFC 7396): objects are merged and null resets a
+// @Description  setting to its default. Use `device=true` to override settings only for the current session.
+// @Tags         settings
+// @Accept       json
+// @Produce      json
+// @Security     Jwt
+// @Param        device    query  bool    false  "Edit the current device's overrides instead of the account's settings"
+// @Param        settings  body   object  true   "Settings to change" example(preferOriginal: false)
+// @Success      200  {object}  UserSettings
+// @Failure      403  {object}  KError "Settings can only be edited by users (or device settings without a session)"
+// @Failure      422  {object}  KError "Unknown setting or invalid value"
+// @Router /users/me/settings [patch]
+func (h *Handler) EditMySettings(c *echo.Context) error {
+	ctx := c.Request().Context()
+	var patch map[string]any
+	if err := c.Bind(&patch); err != nil {
+		return NewError(http.StatusUnprocessableEntity, ErrInvalidBody, err.Error())
+	}
+
+	uid, err := GetCurrentUserId(c)
+	if err != nil {
+		return err
+	}
+	device := c.QueryParam("device") == "true"
+	var sid *uuid.UUID
+	if id, err := GetCurrentSessionId(c); err == nil {
+		sid = &id
+	} else if device {
+		return NewError(http.StatusForbidden, ErrSessionRequired, "Device settings require a session.")
+	}
+
+	var current map[string]any
+	if device {
+		current, err = h.decodeSettings(h.db.GetSessionSettings(ctx, *sid))
+	} else {
+		current, err = h.decodeSettings(h.db.GetAccountSettings(ctx, uid))
+	}
+	if err != nil {
+		return err
+	}
+	merged := mergePatch(current, patch)
+	if err := h.config.SettingsSchema.validate("settings", merged); err != nil {
+		return NewError(http.StatusUnprocessableEntity, ErrInvalidSettings, err.Error())
+	}
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return err
+	}
+
+	var rows int64
+	if device {
+		rows, err = h.db.SaveSessionSettings(ctx, dbc.SaveSessionSettingsParams{
+			SessionId: *sid,
+			UserId:    uid,
+			Settings:  data,
+		})
+	} else {
+		rows, err = h.db.SaveAccountSettings(ctx, dbc.SaveAccountSettingsParams{
+			Id:       uid,
+			Settings: data,
+		})
+	}
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return NewError(http.StatusForbidden, ErrUsersOnly, "Only users can have settings (not api keys or guests).")
+	}
+
+	ret, err := h.getUserSettings(ctx, uid, sid)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, ret)
+}
@@ -0,0 +1,5 @@
+begin;
+
+drop table keibi.audit_logs;
+
+commit;
@@ -0,0 +1,14 @@
+begin;
+
+create table keibi.audit_logs(
+	pk serial primary key,
+	id uuid not null default gen_random_uuid(),
+	user_pk integer not null references keibi.users(pk) on delete cascade,
+	action varchar(256) not null,
+	details jsonb not null default '{}'::jsonb,
+	created_at timestamptz not null default now()::timestamptz
+);
+
+create index audit_logs_user_pk on keibi.audit_logs(user_pk);
+
+commit;
@@ -0,0 +1,5 @@
+begin;
+
+alter table keibi.users drop column delete_at;
+
+commit;
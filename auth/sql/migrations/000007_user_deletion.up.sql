@@ -0,0 +1,5 @@
+begin;
+
+alter table keibi.users add column delete_at timestamptz;
+
+commit;
@@ -0,0 +1,5 @@
+begin;
+
+drop table keibi.oidc_providers;
+
+commit;
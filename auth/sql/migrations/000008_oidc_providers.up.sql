@@ -0,0 +1,22 @@
+begin;
+
+create table keibi.oidc_providers(
+	pk serial primary key,
+	id varchar(256) not null unique,
+	name varchar(256) not null,
+	logo text,
+	client_id text not null,
+	-- encrypted with the SECRET_ENCRYPTION_KEY env var
+	secret text not null,
+	authorization_url text not null,
+	token_url text not null,
+	profile_url text not null,
+	scope text not null,
+	auth_method varchar(256) not null,
+	enabled boolean not null default true,
+
+	created_at timestamptz not null default now()::timestamptz,
+	updated_at timestamptz not null default now()::timestamptz
+);
+
+commit;
@@ -0,0 +1,17 @@
+begin;
+
+create table keibi.presigns(
+	pk serial primary key,
+	id uuid not null unique default gen_random_uuid(),
+	-- subject of the jwt that created this presign (user or apikey id)
+	sub uuid not null,
+	rules jsonb not null,
+	-- null means unlimited
+	remaining_uses integer,
+	created_at timestamptz not null default now()::timestamptz,
+	expire_at timestamptz not null
+);
+
+create index presigns_sub on keibi.presigns(sub);
+
+commit;
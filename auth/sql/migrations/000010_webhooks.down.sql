@@ -0,0 +1,7 @@
+begin;
+
+drop table keibi.webhook_deliveries;
+drop type keibi.webhook_status;
+drop table keibi.webhooks;
+
+commit;
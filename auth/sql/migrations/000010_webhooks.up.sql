@@ -0,0 +1,40 @@
+begin;
+
+create table keibi.webhooks(
+	pk serial primary key,
+	id uuid not null unique default gen_random_uuid(),
+	url text not null,
+	-- encrypted with the SECRET_ENCRYPTION_KEY env var
+	secret text not null,
+	-- empty means every events
+	events text[] not null,
+	enabled boolean not null default true,
+	created_at timestamptz not null default now()::timestamptz
+);
+
+create type keibi.webhook_status as enum(
+	'pending',
+	'delivered',
+	'failed'
+);
+
+create table keibi.webhook_deliveries(
+	pk serial primary key,
+	id uuid not null unique default gen_random_uuid(),
+	webhook_pk integer not null references keibi.webhooks(pk) on delete cascade,
+	event varchar(256) not null,
+	payload jsonb not null,
+	status keibi.webhook_status not null default 'pending',
+	attempts integer not null default 0,
+	next_attempt_at timestamptz not null default now()::timestamptz,
+	last_status_code integer,
+	last_error text,
+	created_at timestamptz not null default now()::timestamptz,
+	delivered_at timestamptz
+);
+
+create index webhook_deliveries_pending on keibi.webhook_deliveries(next_attempt_at)
+where
+	status = 'pending';
+
+commit;
@@ -0,0 +1,5 @@
+begin;
+
+alter table keibi.oidc_providers drop column link_by_email;
+
+commit;
@@ -0,0 +1,5 @@
+begin;
+
+alter table keibi.oidc_providers add column link_by_email boolean not null default false;
+
+commit;
@@ -0,0 +1,6 @@
+begin;
+
+drop table keibi.spent_session_tokens;
+alter table keibi.sessions drop column rotate;
+
+commit;
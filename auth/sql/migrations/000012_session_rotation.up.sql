@@ -0,0 +1,11 @@
+begin;
+
+alter table keibi.sessions add column rotate boolean not null default false;
+
+create table keibi.spent_session_tokens(
+	token varchar(128) primary key,
+	session_pk integer not null references keibi.sessions(pk) on delete cascade,
+	spent_at timestamptz not null default now()::timestamptz
+);
+
+commit;
@@ -0,0 +1,6 @@
+begin;
+
+alter table keibi.sessions drop column impersonator_username;
+alter table keibi.sessions drop column impersonator_id;
+
+commit;
@@ -0,0 +1,8 @@
+begin;
+
+-- sessions created by an admin impersonating the user. Stored as a snapshot (no fk) to keep the
+-- history visible to the user even if the admin account is deleted.
+alter table keibi.sessions add column impersonator_id uuid;
+alter table keibi.sessions add column impersonator_username varchar(256);
+
+commit;
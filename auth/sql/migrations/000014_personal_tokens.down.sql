@@ -0,0 +1,9 @@
+begin;
+
+delete from keibi.apikeys where personal;
+drop index keibi.apikeys_personal_name_key;
+drop index keibi.apikeys_name_key;
+alter table keibi.apikeys drop column personal;
+alter table keibi.apikeys add constraint apikeys_name_key unique (name);
+
+commit;
@@ -0,0 +1,10 @@
+begin;
+
+-- personal tokens are api keys bound to the user that created them (created_by).
+-- their names only need to be unique per user.
+alter table keibi.apikeys add column personal boolean not null default false;
+alter table keibi.apikeys drop constraint apikeys_name_key;
+create unique index apikeys_name_key on keibi.apikeys(name) where not personal;
+create unique index apikeys_personal_name_key on keibi.apikeys(created_by, name) where personal;
+
+commit;
@@ -0,0 +1,6 @@
+begin;
+
+alter table keibi.users drop column failed_logins;
+drop table keibi.challenges;
+
+commit;
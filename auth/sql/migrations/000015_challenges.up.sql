@@ -0,0 +1,14 @@
+begin;
+
+-- proof-of-work challenges issued to clients, deleted once solved (or after they expire).
+create table keibi.challenges(
+	pk serial primary key,
+	id uuid not null unique default gen_random_uuid(),
+	nonce varchar(64) not null,
+	difficulty integer not null,
+	expire_at timestamptz not null
+);
+
+alter table keibi.users add column failed_logins integer not null default 0;
+
+commit;
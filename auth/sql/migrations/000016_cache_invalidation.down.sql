@@ -0,0 +1,7 @@
+begin;
+
+drop trigger users_cache_invalidation on keibi.users;
+drop trigger sessions_cache_invalidation on keibi.sessions;
+drop function keibi.notify_cache_invalidation();
+
+commit;
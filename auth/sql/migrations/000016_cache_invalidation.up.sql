@@ -0,0 +1,26 @@
+begin;
+
+-- notify keibi instances when a cached session or user changes so they can invalidate it.
+-- last_used/last_seen updates are not listed since they don't invalidate anything.
+create function keibi.notify_cache_invalidation()
+	returns trigger
+	language plpgsql
+	as $$
+begin
+	perform
+		pg_notify('keibi_cache', tg_argv[0] || ':' || old.pk);
+	return null;
+end;
+$$;
+
+create trigger sessions_cache_invalidation
+	after update of token, user_pk, rotate or delete on keibi.sessions
+	for each row
+	execute function keibi.notify_cache_invalidation('session');
+
+create trigger users_cache_invalidation
+	after update of username, email, password, claims, delete_at or delete on keibi.users
+	for each row
+	execute function keibi.notify_cache_invalidation('user');
+
+commit;
@@ -0,0 +1,6 @@
+begin;
+
+alter table keibi.oidc_login drop column profile;
+alter table keibi.oidc_login drop column request_id;
+
+commit;
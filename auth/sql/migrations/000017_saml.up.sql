@@ -0,0 +1,8 @@
+begin;
+
+-- saml logins reuse the oidc login states: the AuthnRequest id is checked against the response's
+-- InResponseTo and the validated profile is kept until the app exchanges its opaque token.
+alter table keibi.oidc_login add column request_id text;
+alter table keibi.oidc_login add column profile jsonb;
+
+commit;
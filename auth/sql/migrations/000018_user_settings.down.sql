@@ -0,0 +1,5 @@
+begin;
+
+drop table keibi.user_settings;
+
+commit;
@@ -0,0 +1,24 @@
+begin;
+
+create table keibi.user_settings(
+	user_pk integer not null references keibi.users(pk) on delete cascade,
+	-- null for account wide settings, else overrides for a single device.
+	session_pk integer references keibi.sessions(pk) on delete cascade,
+	settings jsonb not null default '{}'::jsonb,
+	updated_at timestamptz not null default now()::timestamptz
+);
+
+create unique index user_settings_account on keibi.user_settings(user_pk) where session_pk is null;
+create unique index user_settings_session on keibi.user_settings(session_pk) where session_pk is not null;
+
+-- settings used to be stored in the claims, unknown keys are ignored when read.
+insert into keibi.user_settings(user_pk, settings)
+select
+	pk,
+	claims->'settings'
+from
+	keibi.users
+where
+	jsonb_typeof(claims->'settings') = 'object';
+
+commit;
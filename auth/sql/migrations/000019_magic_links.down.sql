@@ -0,0 +1,5 @@
+begin;
+
+drop table keibi.magic_links;
+
+commit;
@@ -0,0 +1,21 @@
+begin;
+
+create table keibi.magic_links(
+	pk serial primary key,
+	id uuid not null unique default gen_random_uuid(),
+	token text not null unique,
+	email varchar(320) not null,
+	-- null if no account uses this email, the link is still stored to rate limit the address.
+	user_pk integer references keibi.users(pk) on delete cascade,
+	redirect_url text,
+	-- shown when the link is opened without redirect url, to type on the device that asked for the link.
+	code varchar(16),
+	code_attempts integer not null default 0,
+	used_at timestamptz,
+	expire_at timestamptz not null,
+	created_at timestamptz not null default now()::timestamptz
+);
+
+create index magic_links_email on keibi.magic_links(lower(email), created_at);
+
+commit;
@@ -0,0 +1,14 @@
+begin;
+
+drop index keibi.sessions_oidc;
+
+alter table keibi.sessions drop column oidc_provider;
+alter table keibi.sessions drop column oidc_sub;
+alter table keibi.sessions drop column oidc_sid;
+alter table keibi.sessions drop column oidc_id_token;
+
+alter table keibi.oidc_providers drop column issuer;
+alter table keibi.oidc_providers drop column jwks_url;
+alter table keibi.oidc_providers drop column end_session_url;
+
+commit;
@@ -0,0 +1,15 @@
+begin;
+
+alter table keibi.oidc_providers add column issuer text;
+alter table keibi.oidc_providers add column jwks_url text;
+alter table keibi.oidc_providers add column end_session_url text;
+
+-- provider login that created the session, used for back-channel & rp-initiated logouts.
+alter table keibi.sessions add column oidc_provider varchar(256);
+alter table keibi.sessions add column oidc_sub text;
+alter table keibi.sessions add column oidc_sid text;
+alter table keibi.sessions add column oidc_id_token text;
+
+create index sessions_oidc on keibi.sessions(oidc_provider, oidc_sub) where oidc_provider is not null;
+
+commit;
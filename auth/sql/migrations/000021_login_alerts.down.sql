@@ -0,0 +1,8 @@
+begin;
+
+alter table keibi.users drop column password_reset_required;
+
+drop table keibi.login_alerts;
+drop table keibi.known_devices;
+
+commit;
@@ -0,0 +1,34 @@
+begin;
+
+-- devices & networks each user logged in from. Kept after logouts to detect logins from somewhere new.
+create table keibi.known_devices(
+	pk serial primary key,
+	user_pk integer not null references keibi.users(pk) on delete cascade,
+	device varchar(1024) not null,
+	-- ip prefix (/24 for ipv4, /64 for ipv6) of the login.
+	network varchar(64) not null,
+	first_seen timestamptz not null default now()::timestamptz,
+	last_seen timestamptz not null default now()::timestamptz,
+	unique (user_pk, device, network)
+);
+
+create table keibi.login_alerts(
+	pk serial primary key,
+	id uuid not null unique default gen_random_uuid(),
+	token text not null unique,
+	user_pk integer not null references keibi.users(pk) on delete cascade,
+	session_id uuid not null,
+	device varchar(1024),
+	ip varchar(64),
+	location text,
+	-- set when the user reports the login ("this wasn't me").
+	reported_at timestamptz,
+	password_reset_at timestamptz,
+	expire_at timestamptz not null,
+	created_at timestamptz not null default now()::timestamptz
+);
+
+-- set when a login is reported, password logins are refused until the password is changed.
+alter table keibi.users add column password_reset_required boolean not null default false;
+
+commit;
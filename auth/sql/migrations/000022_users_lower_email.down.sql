@@ -0,0 +1,5 @@
+begin;
+
+drop index if exists keibi.users_lower_email;
+
+commit;
@@ -0,0 +1,21 @@
+begin;
+
+-- emails are matched case-insensitively (magic links, oidc link by email). Instances that already have
+-- case-variant duplicates keep working without the index, those emails are refused by the lookups instead.
+do $$
+begin
+	if not exists (
+		select
+			1
+		from
+			keibi.users
+		group by
+			lower(email)
+		having
+			count(*) > 1) then
+	create unique index users_lower_email on keibi.users(lower(email));
+end if;
+end
+$$;
+
+commit;
@@ -0,0 +1,7 @@
+begin;
+
+update keibi.users as u set claims = u.claims || jsonb_build_object('settings', s.settings)
+from keibi.user_settings as s
+where s.user_pk = u.pk and s.session_pk is null;
+
+commit;
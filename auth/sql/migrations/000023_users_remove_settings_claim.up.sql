@@ -0,0 +1,18 @@
+begin;
+
+-- settings written to the claims after 000018 (users could still edit them via `PATCH /users/me`),
+-- the account's settings take precedence.
+insert into keibi.user_settings(user_pk, settings)
+select
+	pk,
+	claims->'settings'
+from
+	keibi.users
+where
+	jsonb_typeof(claims->'settings') = 'object'
+on conflict (user_pk) where session_pk is null
+	do update set settings = excluded.settings || keibi.user_settings.settings;
+
+update keibi.users set claims = claims - 'settings' where claims ? 'settings';
+
+commit;
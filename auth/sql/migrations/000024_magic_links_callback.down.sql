@@ -0,0 +1,5 @@
+begin;
+
+alter table keibi.magic_links drop column callback_token;
+
+commit;
@@ -0,0 +1,6 @@
+begin;
+
+-- opaque token given to the redirect url, exchanged for a session via /sessions/magic-link/callback.
+alter table keibi.magic_links add column callback_token text unique;
+
+commit;
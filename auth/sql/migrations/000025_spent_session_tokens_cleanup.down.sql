@@ -0,0 +1,5 @@
+begin;
+
+drop index keibi.spent_session_tokens_spent_at;
+
+commit;
@@ -0,0 +1,6 @@
+begin;
+
+-- spent tokens are pruned once they are older than the session expiration delay.
+create index spent_session_tokens_spent_at on keibi.spent_session_tokens(spent_at);
+
+commit;
@@ -6,6 +6,14 @@ from
 where
 	token = $1;
 
+-- name: GetApiKeyById :one
+select
+	*
+from
+	keibi.apikeys
+where
+	id = $1;
+
 -- name: TouchApiKey :exec
 update
 	keibi.apikeys
@@ -19,12 +27,14 @@ select
 	*
 from
 	keibi.apikeys
+where
+	not personal
 order by
 	last_used;
 
 -- name: CreateApiKey :one
-insert into keibi.apikeys(name, token, claims, created_by)
-	values ($1, $2, $3, $4)
+insert into keibi.apikeys(name, token, claims, created_by, personal)
+	values ($1, $2, $3, $4, $5)
 returning
 	*;
 
@@ -34,3 +44,47 @@ where id = $1
 returning
 	*;
 
+
+-- name: ListApiKeysCreatedBy :many
+select
+	*
+from
+	keibi.apikeys
+where
+	created_by = $1
+order by
+	created_at;
+
+-- name: DeleteApiKeyByToken :one
+delete from keibi.apikeys
+where token = $1
+returning
+	*;
+
+-- name: ListPersonalTokens :many
+select
+	*
+from
+	keibi.apikeys
+where
+	created_by = $1
+	and personal
+order by
+	created_at;
+
+-- name: DeletePersonalToken :one
+delete from keibi.apikeys
+where id = $1
+	and created_by = $2
+	and personal
+returning
+	*;
+
+-- name: GetApiKeyOwner :one
+select
+	sqlc.embed(u)
+from
+	keibi.apikeys as k
+	inner join keibi.users as u on u.pk = k.created_by
+where
+	k.pk = $1;
@@ -0,0 +1,13 @@
+-- name: CreateAuditLog :exec
+insert into keibi.audit_logs(user_pk, action, details)
+	values ($1, $2, $3);
+
+-- name: GetUserAuditLogs :many
+select
+	*
+from
+	keibi.audit_logs
+where
+	user_pk = $1
+order by
+	created_at desc;
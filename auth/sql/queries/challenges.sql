@@ -0,0 +1,16 @@
+-- name: CreateChallenge :one
+insert into keibi.challenges(nonce, difficulty, expire_at)
+	values ($1, $2, $3)
+returning
+	*;
+
+-- name: SpendChallenge :one
+delete from keibi.challenges
+where id = $1
+	and expire_at > now()::timestamptz
+returning
+	*;
+
+-- name: DeleteExpiredChallenges :exec
+delete from keibi.challenges
+where expire_at < now()::timestamptz;
@@ -0,0 +1,102 @@
+-- name: GetKnownDevice :one
+select
+	exists (
+		select
+			1
+		from
+			keibi.known_devices as kd
+		where
+			kd.user_pk = $1) as has_history,
+	exists (
+		select
+			1
+		from
+			keibi.known_devices as kd
+		where
+			kd.user_pk = $1
+			and kd.device = $2) as known_device,
+	exists (
+		select
+			1
+		from
+			keibi.known_devices as kd
+		where
+			kd.user_pk = $1
+			and kd.network = $3) as known_network;
+
+-- name: TouchKnownDevice :exec
+insert into keibi.known_devices(user_pk, device, network)
+	values ($1, $2, $3)
+on conflict (user_pk, device, network)
+	do update set
+		last_seen = now()::timestamptz;
+
+-- name: DeleteStaleKnownDevices :exec
+delete from keibi.known_devices
+where last_seen < now()::timestamptz - interval '1 year';
+
+-- name: CreateLoginAlert :one
+insert into keibi.login_alerts(token, user_pk, session_id, device, ip, location, expire_at)
+	values ($1, $2, $3, $4, $5, $6, $7)
+returning
+	*;
+
+-- name: ReportLoginAlert :one
+update
+	keibi.login_alerts
+set
+	reported_at = coalesce(reported_at, now()::timestamptz)
+where
+	token = $1
+	and password_reset_at is null
+	and expire_at > now()::timestamptz
+returning
+	*;
+
+-- name: ResetPasswordFromLoginAlert :one
+update
+	keibi.login_alerts
+set
+	password_reset_at = now()::timestamptz
+where
+	token = $1
+	and reported_at is not null
+	and password_reset_at is null
+	and expire_at > now()::timestamptz
+returning
+	*;
+
+-- name: DeleteExpiredLoginAlerts :exec
+delete from keibi.login_alerts
+where expire_at < now()::timestamptz - interval '1 day';
+
+-- name: SetPasswordResetRequired :exec
+update
+	keibi.users
+set
+	password_reset_required = $2
+where
+	pk = $1;
+
+-- name: GetLoginAlert :one
+select
+	*
+from
+	keibi.login_alerts
+where
+	token = $1
+	and reported_at is not null
+	and password_reset_at is null
+	and expire_at > now()::timestamptz
+limit 1;
+
+-- name: GetActiveLoginAlert :one
+select
+	*
+from
+	keibi.login_alerts
+where
+	token = $1
+	and password_reset_at is null
+	and expire_at > now()::timestamptz
+limit 1;
@@ -0,0 +1,91 @@
+-- name: CountRecentMagicLinks :one
+select
+	count(*)
+from
+	keibi.magic_links
+where
+	lower(email) = lower($1)
+	and created_at > now()::timestamptz - interval '1 hour';
+
+-- name: CreateMagicLink :one
+insert into keibi.magic_links(token, email, user_pk, redirect_url, expire_at)
+	values ($1, $2, $3, $4, $5)
+returning
+	*;
+
+-- name: GetActiveMagicLink :one
+select
+	*
+from
+	keibi.magic_links
+where
+	token = $1
+	and used_at is null
+	and user_pk is not null
+	and expire_at > now()::timestamptz;
+
+-- name: UseMagicLink :one
+update
+	keibi.magic_links
+set
+	used_at = now()::timestamptz
+where
+	token = $1
+	and used_at is null
+	and user_pk is not null
+	and expire_at > now()::timestamptz
+returning
+	*;
+
+-- name: SetMagicLinkCode :exec
+update
+	keibi.magic_links
+set
+	code = $2
+where
+	pk = $1;
+
+-- name: SetMagicLinkCallbackToken :exec
+update
+	keibi.magic_links
+set
+	callback_token = $2
+where
+	pk = $1;
+
+-- name: ConsumeMagicLinkCallback :one
+update
+	keibi.magic_links
+set
+	callback_token = null
+where
+	callback_token = $1
+	-- the app exchanges it right after the redirect.
+	and used_at > now()::timestamptz - interval '5 min'
+returning
+	*;
+
+-- name: ConsumeMagicLinkCode :one
+update
+	keibi.magic_links
+set
+	code = null
+where
+	id = $1
+	and code = $2
+	and code_attempts < sqlc.arg(max_attempts)
+	and expire_at > now()::timestamptz
+returning
+	*;
+
+-- name: FailMagicLinkCode :exec
+update
+	keibi.magic_links
+set
+	code_attempts = code_attempts + 1
+where
+	id = $1;
+
+-- name: DeleteExpiredMagicLinks :exec
+delete from keibi.magic_links
+where created_at < now()::timestamptz - interval '1 day';
@@ -4,6 +4,20 @@ insert into keibi.oidc_login(provider, opaque, redirect_url, tenant)
 returning
 	*;
 
+-- name: CreateSamlLogin :one
+insert into keibi.oidc_login(provider, opaque, redirect_url, tenant, request_id)
+	values ($1, $2, $3, $4, $5)
+returning
+	*;
+
+-- name: SaveSamlLoginProfile :exec
+update
+	keibi.oidc_login
+set
+	profile = $2
+where
+	id = $1;
+
 -- name: GetOidcLoginByOpaque :one
 select
 	*
@@ -40,3 +54,58 @@ where
 -- name: CleanupOidcLogins :exec
 delete from keibi.oidc_login
 where created_at + interval '10 min' < now()::timestamptz;
+
+-- name: GetUserOidcHandles :many
+select
+	*
+from
+	keibi.oidc_handle
+where
+	user_pk = $1
+order by
+	provider;
+
+-- name: ListOidcProviders :many
+select
+	*
+from
+	keibi.oidc_providers
+order by
+	id;
+
+-- name: CreateOidcProvider :one
+insert into keibi.oidc_providers(id, name, logo, client_id, secret, authorization_url, token_url,
+	profile_url, scope, auth_method, enabled, link_by_email, issuer, jwks_url, end_session_url)
+	values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+returning
+	*;
+
+-- name: UpdateOidcProvider :one
+update
+	keibi.oidc_providers
+set
+	name = coalesce(sqlc.narg(name), name),
+	logo = coalesce(sqlc.narg(logo), logo),
+	client_id = coalesce(sqlc.narg(client_id), client_id),
+	secret = coalesce(sqlc.narg(secret), secret),
+	authorization_url = coalesce(sqlc.narg(authorization_url), authorization_url),
+	token_url = coalesce(sqlc.narg(token_url), token_url),
+	profile_url = coalesce(sqlc.narg(profile_url), profile_url),
+	scope = coalesce(sqlc.narg(scope), scope),
+	auth_method = coalesce(sqlc.narg(auth_method), auth_method),
+	enabled = coalesce(sqlc.narg(enabled), enabled),
+	link_by_email = coalesce(sqlc.narg(link_by_email), link_by_email),
+	issuer = coalesce(sqlc.narg(issuer), issuer),
+	jwks_url = coalesce(sqlc.narg(jwks_url), jwks_url),
+	end_session_url = coalesce(sqlc.narg(end_session_url), end_session_url),
+	updated_at = now()::timestamptz
+where
+	id = $1
+returning
+	*;
+
+-- name: DeleteOidcProvider :one
+delete from keibi.oidc_providers
+where id = $1
+returning
+	*;
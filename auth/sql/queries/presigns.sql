@@ -0,0 +1,43 @@
+-- name: CreatePresign :one
+insert into keibi.presigns(sub, rules, remaining_uses, expire_at)
+	values ($1, $2, $3, $4)
+returning
+	*;
+
+-- name: UsePresign :one
+update
+	keibi.presigns
+set
+	remaining_uses = remaining_uses - 1
+where
+	id = $1
+	and expire_at > now()::timestamptz
+	and (remaining_uses is null
+		or remaining_uses > 0)
+returning
+	*;
+
+-- name: ListActivePresigns :many
+select
+	*
+from
+	keibi.presigns
+where
+	sub = $1
+	and expire_at > now()::timestamptz
+	and (remaining_uses is null
+		or remaining_uses > 0)
+order by
+	created_at desc;
+
+-- name: DeletePresign :one
+delete from keibi.presigns
+where id = $1
+	and sub = $2
+returning
+	*;
+
+-- name: CleanupPresigns :exec
+delete from keibi.presigns
+where expire_at < now()::timestamptz
+	or remaining_uses = 0;
@@ -2,7 +2,9 @@
 select
 	s.pk,
 	s.id,
+	s.created_date,
 	s.last_used,
+	s.rotate,
 	sqlc.embed(u)
 from
 	keibi.users as u
@@ -11,13 +13,13 @@ where
 	s.token = $1
 limit 1;
 
--- name: TouchSession :exec
+-- name: TouchSessions :exec
 update
 	keibi.sessions
 set
 	last_used = now()::timestamptz
 where
-	pk = $1;
+	pk = any(sqlc.arg(pks)::int[]);
 
 -- name: GetUserSessions :many
 select
@@ -31,8 +33,14 @@ order by
 	last_used;
 
 -- name: CreateSession :one
-insert into keibi.sessions(token, user_pk, device)
-	values ($1, $2, $3)
+insert into keibi.sessions(token, user_pk, device, rotate)
+	values ($1, $2, $3, $4)
+returning
+	*;
+
+-- name: CreateImpersonationSession :one
+insert into keibi.sessions(token, user_pk, device, impersonator_id, impersonator_username)
+	values ($1, $2, $3, $4, $5)
 returning
 	*;
 
@@ -49,6 +57,7 @@ select
 	s.pk,
 	s.id,
 	s.last_used,
+	s.rotate,
 	sqlc.embed(u)
 from
 	keibi.users as u
@@ -57,8 +66,90 @@ where
 	s.id = $1
 limit 1;
 
--- name: ClearOtherSessions :exec
+-- name: ClearOtherSessions :many
 delete from keibi.sessions as s using keibi.users as u
 where s.user_pk = u.pk
 	and s.id != @session_id
-	and u.id = @user_id;
+	and u.id = @user_id
+returning
+	s.*;
+
+-- name: ClearUserSessions :many
+delete from keibi.sessions
+where user_pk = $1
+returning
+	*;
+
+-- name: DeleteSessionByToken :one
+delete from keibi.sessions
+where token = $1
+returning
+	*;
+
+-- name: RotateSessionToken :one
+update
+	keibi.sessions
+set
+	token = sqlc.arg(new_token),
+	last_used = now()::timestamptz
+where
+	pk = $1
+	and token = sqlc.arg(token)
+returning
+	*;
+
+-- name: SpendSessionToken :exec
+insert into keibi.spent_session_tokens(token, session_pk)
+	values ($1, $2)
+on conflict (token)
+	do nothing;
+
+-- name: DeleteOldSpentSessionTokens :exec
+delete from keibi.spent_session_tokens
+where spent_at < $1;
+
+-- name: GetSessionFromSpentToken :one
+select
+	s.pk,
+	s.id,
+	s.user_pk,
+	u.id as user_id,
+	st.spent_at
+from
+	keibi.spent_session_tokens as st
+	inner join keibi.sessions as s on s.pk = st.session_pk
+	inner join keibi.users as u on u.pk = s.user_pk
+where
+	st.token = $1
+limit 1;
+
+-- name: DeleteSessionByPk :one
+delete from keibi.sessions
+where pk = $1
+returning
+	*;
+
+-- name: SetSessionOidc :exec
+update
+	keibi.sessions
+set
+	oidc_provider = $2,
+	oidc_sub = $3,
+	oidc_sid = $4,
+	oidc_id_token = $5
+where
+	pk = $1;
+
+-- name: GetOidcSessions :many
+select
+	sqlc.embed(s),
+	u.id as user_id
+from
+	keibi.sessions as s
+	inner join keibi.users as u on u.pk = s.user_pk
+where
+	s.oidc_provider = sqlc.arg(provider)::text
+	and (sqlc.narg(sub)::text is null
+		or s.oidc_sub = sqlc.narg(sub))
+	and (sqlc.narg(sid)::text is null
+		or s.oidc_sid = sqlc.narg(sid));
@@ -0,0 +1,51 @@
+-- name: GetAccountSettings :one
+select
+	s.settings
+from
+	keibi.user_settings as s
+	inner join keibi.users as u on u.pk = s.user_pk
+where
+	u.id = $1
+	and s.session_pk is null;
+
+-- name: GetSessionSettings :one
+select
+	us.settings
+from
+	keibi.user_settings as us
+	inner join keibi.sessions as s on s.pk = us.session_pk
+where
+	s.id = $1;
+
+-- name: SaveAccountSettings :execrows
+insert into keibi.user_settings(user_pk, settings)
+select
+	pk,
+	@settings::jsonb
+from
+	keibi.users
+where
+	id = @id
+on conflict (user_pk)
+	where session_pk is null
+	do update set
+		settings = excluded.settings,
+		updated_at = now()::timestamptz;
+
+-- name: SaveSessionSettings :execrows
+insert into keibi.user_settings(user_pk, session_pk, settings)
+select
+	s.user_pk,
+	s.pk,
+	@settings::jsonb
+from
+	keibi.sessions as s
+	inner join keibi.users as u on u.pk = s.user_pk
+where
+	s.id = @session_id
+	and u.id = @user_id
+on conflict (session_pk)
+	where session_pk is not null
+	do update set
+		settings = excluded.settings,
+		updated_at = now()::timestamptz;
@@ -89,11 +89,29 @@ where
 	or username = sqlc.arg(login)
 limit 1;
 
--- name: TouchUser :exec
+-- name: TouchUsers :exec
 update
 	keibi.users
 set
 	last_seen = now()::timestamptz
+where
+	pk = any(sqlc.arg(pks)::int[]);
+
+-- name: IncrementFailedLogins :one
+update
+	keibi.users
+set
+	failed_logins = failed_logins + 1
+where
+	pk = $1
+returning
+	failed_logins;
+
+-- name: ResetFailedLogins :exec
+update
+	keibi.users
+set
+	failed_logins = 0
 where
 	pk = $1;
 
@@ -118,6 +136,9 @@ set
 	username = coalesce(sqlc.narg(username), username),
 	email = coalesce(sqlc.narg(email), email),
 	password = coalesce(sqlc.narg(password), password),
+	-- setting a new password fulfills a required password reset.
+	password_reset_required = password_reset_required
+	and sqlc.narg(password)::text is null,
 	claims = claims || coalesce(sqlc.narg(claims), '{}'::jsonb)
 where
 	id = $1
@@ -130,14 +151,14 @@ where id = $1
 returning
 	*;
 
--- name: GetUserByEmail :one
+-- name: GetUsersByEmail :many
 select
 	*
 from
 	keibi.users
 where
-	email = $1
-limit 1;
+	lower(email) = lower(sqlc.arg(email))
+limit 2;
 
 -- name: GetUserByOidc :one
 select
@@ -167,3 +188,38 @@ delete from keibi.oidc_handle
 where
 	user_pk = $1
 	and provider = $2;
+
+-- name: ScheduleUserDeletion :one
+update
+	keibi.users
+set
+	delete_at = $2
+where
+	id = $1
+returning
+	*;
+
+-- name: CancelUserDeletion :one
+update
+	keibi.users
+set
+	delete_at = null
+where
+	id = $1
+	and delete_at is not null
+returning
+	*;
+
+-- name: DeleteScheduledUsers :many
+delete from keibi.users
+where delete_at < now()::timestamptz
+returning
+	*;
+
+-- name: GetUserByPk :one
+select
+	*
+from
+	keibi.users
+where
+	pk = $1;
@@ -0,0 +1,120 @@
+-- name: ListWebhooks :many
+select
+	*
+from
+	keibi.webhooks
+order by
+	created_at;
+
+-- name: GetWebhook :one
+select
+	*
+from
+	keibi.webhooks
+where
+	id = $1;
+
+-- name: CreateWebhook :one
+insert into keibi.webhooks(url, secret, events, enabled)
+	values ($1, $2, $3, $4)
+returning
+	*;
+
+-- name: UpdateWebhook :one
+update
+	keibi.webhooks
+set
+	url = coalesce(sqlc.narg(url), url),
+	secret = coalesce(sqlc.narg(secret), secret),
+	events = coalesce(sqlc.narg(events), events),
+	enabled = coalesce(sqlc.narg(enabled), enabled)
+where
+	id = $1
+returning
+	*;
+
+-- name: DeleteWebhook :one
+delete from keibi.webhooks
+where id = $1
+returning
+	*;
+
+-- name: EnqueueWebhookDeliveries :exec
+insert into keibi.webhook_deliveries(webhook_pk, event, payload)
+select
+	pk,
+	sqlc.arg(event),
+	sqlc.arg(payload)
+from
+	keibi.webhooks
+where
+	enabled
+	and (cardinality(events) = 0
+		or sqlc.arg(event)::text = any (events));
+
+-- name: ClaimWebhookDeliveries :many
+update
+	keibi.webhook_deliveries as d
+set
+	-- lease the delivery so other instances don't send it at the same time.
+	-- a batch is sent concurrently, the lease only needs to outlast the http client's timeout (10s).
+	next_attempt_at = now()::timestamptz + interval '1 min'
+from
+	keibi.webhooks as w
+where
+	w.pk = d.webhook_pk
+	and d.pk in (
+		select
+			pk
+		from
+			keibi.webhook_deliveries
+		where
+			status = 'pending'
+			and next_attempt_at <= now()::timestamptz
+		order by
+			next_attempt_at
+		limit $1
+		for update
+			skip locked)
+returning
+	d.pk,
+	d.id,
+	d.event,
+	d.payload,
+	d.attempts,
+	w.url,
+	w.secret;
+
+-- name: SaveWebhookAttempt :exec
+update
+	keibi.webhook_deliveries
+set
+	status = $2,
+	attempts = attempts + 1,
+	next_attempt_at = $3,
+	last_status_code = $4,
+	last_error = $5,
+	delivered_at = case when $2 = 'delivered'::keibi.webhook_status then
+		now()::timestamptz
+	else
+		null
+	end
+where
+	pk = $1;
+
+-- name: ListWebhookDeliveries :many
+select
+	d.*
+from
+	keibi.webhook_deliveries as d
+	inner join keibi.webhooks as w on w.pk = d.webhook_pk
+where
+	w.id = $1
+order by
+	d.created_at desc
+limit $2;
+
+-- name: CleanupWebhookDeliveries :exec
+delete from keibi.webhook_deliveries
+where status != 'pending'
+	and created_at + interval '30 days' < now()::timestamptz;
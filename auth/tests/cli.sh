@@ -0,0 +1,83 @@
+#!/usr/bin/env bash
+# Tests of the admin cli (see `keibi help`), run by the gh workflow while keibi is running with the hurl env.
+#
+#	tests/cli.sh ./keibi http://localhost:4568/auth
+set -euo pipefail
+
+keibi=$1
+host=$2
+
+fail() {
+	echo "FAIL: $*" >&2
+	exit 1
+}
+
+# expect <status> <pattern> <args...>: runs the cli and checks its exit code & that its output contains pattern.
+expect() {
+	local status=$1 pattern=$2
+	shift 2
+	local out code=0
+	out=$("$keibi" "$@" 2>&1 </dev/null) || code=$?
+	[[ $code == "$status" ]] || fail "keibi $*: exited with $code instead of $status: $out"
+	[[ $out == *"$pattern"* ]] || fail "keibi $*: expected '$pattern' in: $out"
+	echo "$out"
+}
+
+# http <method> <path> [curl args...]: prints the status code of a request to keibi.
+http() {
+	local method=$1 path=$2
+	shift 2
+	curl -s -o /dev/null -w '%{http_code}' -X "$method" "$host$path" "$@"
+}
+
+# eventually <status> <method> <path> [curl args...]: the server learns about changes made by the cli via
+# postgres notifications (see session_cache.go), give it a few seconds.
+eventually() {
+	local status=$1
+	shift
+	for _ in $(seq 20); do
+		[[ $(http "$@") == "$status" ]] && return 0
+		sleep 0.25
+	done
+	return 1
+}
+
+login() {
+	curl -sf -X POST "$host/sessions" -H 'Content-Type: application/json' \
+		-d "{\"login\": \"cli-user\", \"password\": \"$1\"}" | jq -r .token
+}
+
+expect 2 "Usage: keibi" user
+expect 0 "Created user cli-user" user create cli-user cli-user@zoriya.dev password-cli-user
+expect 1 "already taken" user create cli-user cli-user@zoriya.dev password-cli-user
+expect 1 "no user found" user grant cli-nobody users.read
+
+# permissions
+expect 1 'unknown permission "users.explode"' user grant cli-user users.explode
+expect 0 "Permissions of cli-user: users.read, users.*" user grant cli-user users.read users.*
+# the wildcard would still grant the permission
+expect 1 '"users.write" is still granted by users.*' user revoke cli-user users.write
+expect 0 "Permissions of cli-user: users.read" user revoke cli-user users.*
+expect 0 "Permissions of cli-user: users.read" user revoke cli-user users.write
+
+# sessions
+token=$(login password-cli-user)
+[[ $(http GET /jwt -H "Authorization: Bearer $token") == 200 ]] || fail "could not use the session"
+expect 0 "Revoked 1 session(s) of cli-user" sessions revoke cli-user
+eventually 403 GET /jwt -H "Authorization: Bearer $token" || fail "revoked session still usable"
+
+# passwords
+expect 1 "Password does not match the password policy." user set-password cli-user short
+token=$(login password-cli-user)
+expect 0 "all its sessions have been revoked" user set-password cli-user new-password-cli-user
+eventually 403 GET /jwt -H "Authorization: Bearer $token" || fail "session still usable after set-password"
+[[ $(login new-password-cli-user) != "" ]] || fail "could not login with the new password"
+
+# api keys
+expect 1 'unknown permission "users.explode"' apikey create cli-key users.explode
+apikey=$("$keibi" apikey create cli-key users.read)
+[[ $(http GET /users -H "X-Api-Key: $apikey") == 200 ]] || fail "could not use the created api key"
+[[ $(http GET /apikeys -H "X-Api-Key: $apikey") == 403 ]] || fail "the created api key has too many permissions"
+expect 1 "already exists" apikey create cli-key
+
+echo "cli tests passed"
@@ -0,0 +1,113 @@
+#!/usr/bin/env python3
+"""Minimal oidc provider used by the hurl tests.
+
+Hurl never follows the provider's authorization redirect, it calls /oidc/logged with a code it chose.
+The code is returned as the access token and the profile is derived from it:
+`email:<address>` returns a profile with this (verified) email.
+
+It also acts as a gravatar server (GRAVATAR_URL): only the emails of `AVATARS` have an image and
+`/avatar-hits/<email>` returns how many times keibi fetched an email's avatar (to check its cache).
+
+Hurl can't hash, `/pow?nonce=&difficulty=` solves keibi's proof-of-work challenges for it. With `valid=false`,
+it returns a response that is not a solution instead.
+"""
+
+import hashlib
+import json
+import os
+import sys
+from collections import Counter
+from http.server import BaseHTTPRequestHandler, HTTPServer
+from urllib.parse import parse_qs, urlparse
+
+AVATARS = {"logo@zoriya.dev"}
+AVATAR_PATH = os.path.join(os.path.dirname(__file__), "assets", "gravatar.png")
+avatar_hits = Counter()
+
+
+def email_hash(email):
+    return hashlib.md5(email.strip().lower().encode()).hexdigest()
+
+
+def leading_zero_bits(data):
+    ret = 0
+    for b in data:
+        if b != 0:
+            return ret + 8 - b.bit_length()
+        ret += 8
+    return ret
+
+
+def solve_pow(nonce, difficulty, valid):
+    i = 0
+    while True:
+        hash = hashlib.sha256(f"{nonce}{i}".encode()).digest()
+        if (leading_zero_bits(hash) >= difficulty) == valid:
+            return str(i)
+        i += 1
+
+
+class Handler(BaseHTTPRequestHandler):
+    def send_json(self, status, body):
+        data = json.dumps(body).encode()
+        self.send_response(status)
+        self.send_header("Content-Type", "application/json")
+        self.send_header("Content-Length", str(len(data)))
+        self.end_headers()
+        self.wfile.write(data)
+
+    def do_POST(self):
+        if self.path != "/token":
+            return self.send_json(404, {"error": "not_found"})
+        length = int(self.headers.get("Content-Length", 0))
+        form = parse_qs(self.rfile.read(length).decode())
+        code = form.get("code", [""])[0]
+        self.send_json(200, {"access_token": code, "token_type": "Bearer", "expires_in": 3600})
+
+    def do_GET(self):
+        url = urlparse(self.path)
+        path = url.path
+        if path == "/pow":
+            query = parse_qs(url.query)
+            response = solve_pow(
+                query["nonce"][0],
+                int(query["difficulty"][0]),
+                query.get("valid", ["true"])[0] != "false",
+            )
+            return self.send_json(200, {"response": response})
+        if path.startswith("/avatar/"):
+            return self.send_avatar(path.removeprefix("/avatar/"))
+        if path.startswith("/avatar-hits/"):
+            return self.send_json(200, {"hits": avatar_hits[email_hash(path.removeprefix("/avatar-hits/"))]})
+        if path != "/profile":
+            return self.send_json(404, {"error": "not_found"})
+        code = self.headers.get("Authorization", "").removeprefix("Bearer ")
+        kind, _, value = code.partition(":")
+        if kind != "email":
+            return self.send_json(401, {"error": "invalid_token"})
+        self.send_json(200, {
+            "sub": f"mock-{value.lower()}",
+            "preferred_username": value.split("@")[0],
+            "email": value,
+            "email_verified": True,
+        })
+
+    def send_avatar(self, hash):
+        avatar_hits[hash] += 1
+        if hash not in {email_hash(x) for x in AVATARS}:
+            return self.send_json(404, {"error": "not_found"})
+        with open(AVATAR_PATH, "rb") as f:
+            data = f.read()
+        self.send_response(200)
+        self.send_header("Content-Type", "image/png")
+        self.send_header("Content-Length", str(len(data)))
+        self.end_headers()
+        self.wfile.write(data)
+
+    def log_message(self, format, *args):
+        pass
+
+
+if __name__ == "__main__":
+    port = int(sys.argv[1]) if len(sys.argv) > 1 else 4569
+    HTTPServer(("127.0.0.1", port), Handler).serve_forever()
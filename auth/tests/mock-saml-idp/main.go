@@ -0,0 +1,129 @@
+// Minimal saml IdP used by the hurl tests (the saml counterpart of tests/mock-idp.py).
+//
+//	go run ./tests/mock-saml-idp 4570 http://localhost:4568/auth
+//
+// Hurl can't submit the auto-posting form of a real IdP, so /sso answers the authentication request with
+// json ({ url, SAMLResponse, RelayState }) to post to keibi's acs. Responses are signed with idp.key for a
+// fixed user (saml-user). With `tamper=true`, the user's email is changed after the response is signed.
+//
+// metadata.xml is what /metadata returns for the port 4570, keibi reads it via SAML_HURL_METADATA.
+package main
+
+import (
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	_ "embed"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/crewjam/saml"
+	"github.com/crewjam/saml/logger"
+)
+
+//go:embed idp.crt
+var idpCertificate []byte
+
+//go:embed idp.key
+var idpKey []byte
+
+const (
+	email         = "saml-user@zoriya.dev"
+	tamperedEmail = "saml-admin@zoriya.dev"
+)
+
+// keibiSps fetches the metadata of keibi's service providers: their entity id is
+// `$PUBLIC_URL/auth/saml/metadata/$provider`, which may not be reachable from here.
+type keibiSps struct {
+	keibiUrl string
+}
+
+func (k keibiSps) GetServiceProvider(r *http.Request, id string) (*saml.EntityDescriptor, error) {
+	resp, err := http.Get(fmt.Sprintf("%s/saml/metadata/%s", k.keibiUrl, path.Base(id)))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, os.ErrNotExist
+	}
+	var ret saml.EntityDescriptor
+	if err := xml.NewDecoder(resp.Body).Decode(&ret); err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func main() {
+	if len(os.Args) != 3 {
+		log.Fatalf("usage: %s <port> <keibi url>", os.Args[0])
+	}
+	port, keibiUrl := os.Args[1], strings.TrimSuffix(os.Args[2], "/")
+
+	pair, err := tls.X509KeyPair(idpCertificate, idpKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	base := fmt.Sprintf("http://localhost:%s", port)
+	metadataUrl, _ := url.Parse(base + "/metadata")
+	ssoUrl, _ := url.Parse(base + "/sso")
+	idp := &saml.IdentityProvider{
+		Key:                     pair.PrivateKey.(*rsa.PrivateKey),
+		Certificate:             cert,
+		Logger:                  logger.DefaultLogger,
+		MetadataURL:             *metadataUrl,
+		SSOURL:                  *ssoUrl,
+		ServiceProviderProvider: keibiSps{keibiUrl: keibiUrl},
+	}
+
+	http.HandleFunc("/metadata", idp.ServeMetadata)
+	http.HandleFunc("/sso", func(w http.ResponseWriter, r *http.Request) {
+		req, err := saml.NewIdpAuthnRequest(idp, r)
+		if err == nil {
+			err = req.Validate()
+		}
+		if err == nil {
+			err = saml.DefaultAssertionMaker{}.MakeAssertion(req, &saml.Session{
+				NameID:       "saml-user",
+				NameIDFormat: string(saml.PersistentNameIDFormat),
+				UserName:     "saml-user",
+				UserEmail:    email,
+			})
+		}
+		var form saml.IdpAuthnRequestForm
+		if err == nil {
+			form, err = req.PostBinding()
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if r.URL.Query().Get("tamper") == "true" {
+			raw, _ := base64.StdEncoding.DecodeString(form.SAMLResponse)
+			raw = []byte(strings.ReplaceAll(string(raw), email, tamperedEmail))
+			form.SAMLResponse = base64.StdEncoding.EncodeToString(raw)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"url":          form.URL,
+			"SAMLResponse": form.SAMLResponse,
+			"RelayState":   form.RelayState,
+		})
+	})
+	log.Fatal(http.ListenAndServe(":"+port, nil))
+}
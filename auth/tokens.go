@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v5"
+	"github.com/zoriya/kyoo/keibi/dbc"
+
+	. "github.com/zoriya/kyoo/keibi/models"
+)
+
+type TokenDto struct {
+	// A session token, an api key or a jwt.
+	Token string `json:"token" form:"token" validate:"required" example:"lyHzTYm9yi+pkEv3m2tamAeeK7Dj7N3QRP7xv7dPU5q9MAe8tU4ySwYczE0RaMr4fijsA=="`
+	// Ignored, the type of the token is always detected from its value.
+	TokenTypeHint string `json:"token_type_hint" form:"token_type_hint" example:"refresh_token"`
+}
+
+type Introspection struct {
+	// False if the token is invalid, expired or revoked. Other fields are omitted in this case.
+	Active bool `json:"active" example:"true"`
+	// Kind of the introspected token.
+	TokenType string `json:"token_type,omitempty" example:"session" enums:"session,apikey,jwt"`
+	// Id of the user (or of the api key).
+	Sub string `json:"sub,omitempty" example:"e05089d6-9179-4b5b-a63e-94dd5fc2a397"`
+	// Username of the user (or name of the api key).
+	Username string `json:"username,omitempty" example:"zoriya"`
+	// Id of the session (or of the api key).
+	Sid string `json:"sid,omitempty" example:"e05089d6-9179-4b5b-a63e-94dd5fc2a397"`
+	Iss string `json:"iss,omitempty" example:"https://kyoo.zoriya.dev"`
+	// Unix timestamp of the creation of the token.
+	Iat int64 `json:"iat,omitempty" example:"1743272405"`
+	// Unix timestamp after which the token won't be valid anymore. Omitted for api keys (they never expire).
+	Exp int64 `json:"exp,omitempty" example:"1745864405"`
+	// Claims a jwt created from this token would contain.
+	Claims jwt.MapClaims `json:"claims,omitempty"`
+}
+
+// isOpaqueToken returns true for session tokens & api keys, false for jwts (`header.payload.signature`).
+// Api keys from env vars can contain any character, only the jwt's shape is reliable.
+func isOpaqueToken(token string) bool {
+	return strings.Count(token, ".") != 2
+}
+
+func (h *Handler) introspect(ctx context.Context, token string) (Introspection, error) {
+	inactive := Introspection{Active: false}
+
+	if !isOpaqueToken(token) {
+		parsed, err := jwt.ParseWithClaims(token, jwt.MapClaims{}, h.jwtKeyfunc)
+		if err != nil {
+			return inactive, nil
+		}
+		claims := parsed.Claims.(jwt.MapClaims)
+		active, err := h.isJwtSessionActive(ctx, claims)
+		if err != nil || !active {
+			return inactive, err
+		}
+		ret := Introspection{
+			Active:    true,
+			TokenType: "jwt",
+			Claims:    claims,
+		}
+		ret.Sub, _ = claims["sub"].(string)
+		ret.Username, _ = claims["username"].(string)
+		ret.Sid, _ = claims["sid"].(string)
+		ret.Iss, _ = claims["iss"].(string)
+		if iat, err := claims.GetIssuedAt(); err == nil && iat != nil {
+			ret.Iat = iat.Unix()
+		}
+		if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+			ret.Exp = exp.Unix()
+		}
+		return ret, nil
+	}
+
+	idx := slices.IndexFunc(h.config.EnvApiKeys, func(k ApiKeyWToken) bool {
+		return k.Token == token
+	})
+	if idx != -1 {
+		key := h.config.EnvApiKeys[idx]
+		return Introspection{
+			Active:    true,
+			TokenType: "apikey",
+			Sub:       key.Id.String(),
+			Username:  key.Name,
+			Sid:       key.Id.String(),
+			Iss:       h.config.PublicUrl,
+			Claims:    key.Claims,
+		}, nil
+	}
+
+	dbKey, err := h.db.GetApiKey(ctx, token)
+	if err == nil {
+		ret := Introspection{
+			Active:    true,
+			TokenType: "apikey",
+			Sub:       dbKey.Id.String(),
+			Username:  dbKey.Name,
+			Sid:       dbKey.Id.String(),
+			Iss:       h.config.PublicUrl,
+			Iat:       dbKey.CreatedAt.Unix(),
+			Claims:    dbKey.Claims,
+		}
+		if dbKey.Personal {
+			owner, err := h.db.GetApiKeyOwner(ctx, dbKey.Pk)
+			if err == pgx.ErrNoRows {
+				return inactive, nil
+			} else if err != nil {
+				return inactive, err
+			}
+			ret.Sub = owner.User.Id.String()
+			ret.Username = owner.User.Username
+			ret.Claims = personalTokenClaims(&owner.User, dbKey.Claims)
+		}
+		return ret, nil
+	} else if err != pgx.ErrNoRows {
+		return inactive, err
+	}
+
+	session, err := h.db.GetUserFromToken(ctx, token)
+	if err == pgx.ErrNoRows {
+		return inactive, nil
+	} else if err != nil {
+		return inactive, err
+	}
+	exp := session.LastUsed.Add(h.config.ExpirationDelay)
+	if exp.Before(time.Now().UTC()) {
+		return inactive, nil
+	}
+	return Introspection{
+		Active:    true,
+		TokenType: "session",
+		Sub:       session.User.Id.String(),
+		Username:  session.User.Username,
+		Sid:       session.Id.String(),
+		Iss:       h.config.PublicUrl,
+		Iat:       session.CreatedDate.Unix(),
+		Exp:       exp.Unix(),
+		Claims:    session.User.Claims,
+	}, nil
+}
+
+// isJwtSessionActive checks that the session (or api key, certificate identity...) a jwt was created from was not
+// revoked since: jwts stay valid until they expire but introspection should reflect revocations.
+func (h *Handler) isJwtSessionActive(ctx context.Context, claims jwt.MapClaims) (bool, error) {
+	sidStr, _ := claims["sid"].(string)
+	sid, err := uuid.Parse(sidStr)
+	if err != nil {
+		return false, nil
+	}
+	if sid == uuid.Nil {
+		return h.config.GuestClaims != nil, nil
+	}
+	if slices.ContainsFunc(h.config.EnvApiKeys, func(k ApiKeyWToken) bool { return k.Id == sid }) ||
+		slices.ContainsFunc(h.config.CertIdentities, func(i CertIdentity) bool { return i.Id == sid }) {
+		return true, nil
+	}
+
+	key, err := h.db.GetApiKeyById(ctx, sid)
+	if err == nil {
+		if !key.Personal {
+			return true, nil
+		}
+		_, err = h.db.GetApiKeyOwner(ctx, key.Pk)
+		if err == pgx.ErrNoRows {
+			return false, nil
+		}
+		return err == nil, err
+	} else if err != pgx.ErrNoRows {
+		return false, err
+	}
+
+	session, err := h.getSessionFromId(ctx, sid)
+	if err == pgx.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return session.LastUsed.Add(h.config.ExpirationDelay).After(time.Now().UTC()), nil
+}
+
+// checkServiceCaller only allows api keys (not personal tokens) & client certificates to introspect or
+// revoke tokens, those routes are meant for services: users (even admins) or impersonation jwts can't use them.
+func (h *Handler) checkServiceCaller(c *echo.Context, perm string) error {
+	if err := CheckPermissions(c, []string{perm}); err != nil {
+		return err
+	}
+	uid, err := GetCurrentUserId(c)
+	if err != nil {
+		return err
+	}
+	_, err = h.db.GetUser(c.Request().Context(), dbc.GetUserParams{
+		UseId: true,
+		Id:    uid,
+	})
+	if err == nil {
+		return NewError(http.StatusForbidden, ErrServicesOnly, "Only api keys can introspect or revoke tokens (not users).")
+	} else if err != pgx.ErrNoRows {
+		return err
+	}
+	return nil
+}
+
+// @Summary      Introspect token
+// @Description  Check if a session token, an api key or a jwt is valid and retrieve its subject, claims & expiry
+// @Description  (see [RFC 7662](https://www.rfc-editor.org/rfc/rfc7662)). Invalid tokens return `{ active: false }`.
+// @Description  Jwts are only active while the session (or api key) they were created from is not revoked.
+// @Description  Only api keys & client certificates can call this route, users can't (even with the permission).
+// @Tags         jwt
+// @Accept       x-www-form-urlencoded
+// @Accept       json
+// @Produce      json
+// @Security     Jwt[tokens.introspect]
+// @Param        token  body      TokenDto  true  "Token to introspect"
+// @Success      200  {object}  Introspection
+// @Failure      403  {object}  KError "Missing permissions: tokens.introspect. Or the caller is an user."
+// @Failure      422  {object}  KError "Missing token"
+// @Router /introspect [post]
+func (h *Handler) Introspect(c *echo.Context) error {
+	ctx := c.Request().Context()
+	err := h.checkServiceCaller(c, "tokens.introspect")
+	if err != nil {
+		return err
+	}
+
+	var req TokenDto
+	if err := c.Bind(&req); err != nil {
+		return NewError(http.StatusUnprocessableEntity, ErrInvalidBody, err.Error())
+	}
+	if err = c.Validate(&req); err != nil {
+		return err
+	}
+
+	ret, err := h.introspect(ctx, req.Token)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, ret)
+}
+
+// @Summary      Revoke token
+// @Description  Revoke a session token or an api key by value (see [RFC 7009](https://www.rfc-editor.org/rfc/rfc7009)).
+// @Description  Unknown or already revoked tokens are ignored. Jwts are short lived and can't be revoked,
+// @Description  revoke the session token (or api key) used to create them instead.
+// @Description  Only api keys & client certificates can call this route, users can't (even with the permission).
+// @Tags         jwt
+// @Accept       x-www-form-urlencoded
+// @Accept       json
+// @Produce      json
+// @Security     Jwt[tokens.revoke]
+// @Param        token  body      TokenDto  true  "Token to revoke"
+// @Success      200
+// @Failure      400  {object}  KError "Jwts or api keys defined in the environment can't be revoked"
+// @Failure      403  {object}  KError "Missing permissions: tokens.revoke. Or the caller is an user."
+// @Failure      422  {object}  KError "Missing token"
+// @Router /revoke [post]
+func (h *Handler) RevokeToken(c *echo.Context) error {
+	ctx := c.Request().Context()
+	err := h.checkServiceCaller(c, "tokens.revoke")
+	if err != nil {
+		return err
+	}
+
+	var req TokenDto
+	if err := c.Bind(&req); err != nil {
+		return NewError(http.StatusUnprocessableEntity, ErrInvalidBody, err.Error())
+	}
+	if err = c.Validate(&req); err != nil {
+		return err
+	}
+
+	if !isOpaqueToken(req.Token) {
+		return NewError(
+			http.StatusBadRequest,
+			ErrJwtNotRevocable,
+			"Jwts can't be revoked, revoke the session token or api key used to create it instead.",
+		)
+	}
+	isEnvKey := slices.ContainsFunc(h.config.EnvApiKeys, func(k ApiKeyWToken) bool {
+		return k.Token == req.Token
+	})
+	if isEnvKey {
+		return NewError(http.StatusBadRequest, ErrApiKeyReadOnly, "Api keys defined in the environment can't be revoked.")
+	}
+
+	_, err = h.db.DeleteApiKeyByToken(ctx, req.Token)
+	if err == nil {
+		return c.NoContent(http.StatusOK)
+	} else if err != pgx.ErrNoRows {
+		return err
+	}
+
+	session, err := h.db.GetUserFromToken(ctx, req.Token)
+	if err == pgx.ErrNoRows {
+		return c.NoContent(http.StatusOK)
+	} else if err != nil {
+		return err
+	}
+	ret, err := h.db.DeleteSessionByToken(ctx, req.Token)
+	if err == pgx.ErrNoRows {
+		return c.NoContent(http.StatusOK)
+	} else if err != nil {
+		return err
+	}
+	h.audit(ctx, session.User.Pk, "session.revoked", map[string]any{
+		"session": ret.Id,
+	})
+	h.emit(ctx, EventSessionDeleted, map[string]any{
+		"userId":  session.User.Id,
+		"session": MapSession(&ret),
+	})
+	return c.NoContent(http.StatusOK)
+}
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"os"
 	"strconv"
+	"time"
 
 	"github.com/alexedwards/argon2id"
 	"github.com/google/uuid"
@@ -23,6 +28,7 @@ func MapDbUser(user *dbc.User) User {
 		HasPassword: user.Password != nil,
 		CreatedDate: user.CreatedDate,
 		LastSeen:    user.LastSeen,
+		DeleteAt:    user.DeleteAt,
 		Claims:      user.Claims,
 		Oidc:        nil,
 	}
@@ -65,7 +71,7 @@ func (h *Handler) ListUsers(c *echo.Context) error {
 	} else {
 		pk, err := strconv.Atoi(id)
 		if err != nil {
-			return echo.NewHTTPError(http.StatusUnprocessableEntity, "Invalid `after` parameter")
+			return NewError(http.StatusUnprocessableEntity, ErrInvalidCursor, "Invalid `after` parameter")
 		}
 		users, err := h.db.GetAllUsersAfter(ctx, dbc.GetAllUsersAfterParams{
 			Limit:   limit,
@@ -110,7 +116,7 @@ func (h *Handler) GetUser(c *echo.Context) error {
 		Username: id,
 	})
 	if err == pgx.ErrNoRows {
-		return echo.NewHTTPError(404, fmt.Sprintf("No user found with id or username: '%s'.", id))
+		return NewError(404, ErrUserNotFound, fmt.Sprintf("No user found with id or username: '%s'.", id))
 	} else if err != nil {
 		return err
 	}
@@ -125,7 +131,7 @@ func (h *Handler) GetUser(c *echo.Context) error {
 // @Tags         users
 // @Produce      json
 // @Security     Jwt
-// @Success      200  {object}  User
+// @Success      200  {object}  Me
 // @Failure      401  {object}  KError "Missing jwt token"
 // @Failure      403  {object}  KError "Invalid jwt token (or expired)"
 // @Router /users/me [get]
@@ -147,7 +153,10 @@ func (h *Handler) GetMe(c *echo.Context) error {
 
 	ret := MapDbUser(&dbuser.User)
 	ret.Oidc = dbuser.Oidc
-	return c.JSON(200, ret)
+	return c.JSON(200, Me{
+		User:           ret,
+		ImpersonatedBy: getActor(c),
+	})
 }
 
 // @Summary      Register
@@ -156,26 +165,36 @@ func (h *Handler) GetMe(c *echo.Context) error {
 // @Accept       json
 // @Produce      json
 // @Param        device   query   string         false  "The device the created session will be used on"  Example(android)
+// @Param        rotate   query   bool           false  "Rotate the session token each time it's exchanged for a jwt"
 // @Param        user     body    RegisterDto  false  "Registration informations"
 // @Success      201  {object}  SessionWToken
 // @Success      409  {object}  KError "Duplicated email or username"
-// @Failure      403  {object}  KError "Registrations are disabled"
+// @Failure      403  {object}  KError "Registrations are disabled or invalid challenge solution"
 // @Failure      422  {object}  KError "Invalid register body"
+// @Failure      428  {object}  KError "A challenge must be solved (see /info)"
 // @Router /users [post]
 func (h *Handler) Register(c *echo.Context) error {
 	if h.config.DisableRegistration {
-		return echo.NewHTTPError(http.StatusForbidden, "Registrations are disabled")
+		return NewError(http.StatusForbidden, ErrRegistrationsDisabled, "Registrations are disabled")
 	}
 
 	ctx := c.Request().Context()
 	var req RegisterDto
 	err := c.Bind(&req)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+		return NewError(http.StatusUnprocessableEntity, ErrInvalidBody, err.Error())
 	}
 	if err = c.Validate(&req); err != nil {
 		return err
 	}
+	if h.config.Challenge.OnRegister {
+		if err = h.verifyChallenge(c, req.Challenge); err != nil {
+			return err
+		}
+	}
+	if err = h.checkPassword(ctx, req.Password, req.Username, req.Email); err != nil {
+		return err
+	}
 
 	pass, err := argon2id.CreateHash(req.Password, argon2id.DefaultParams)
 	if err != nil {
@@ -190,11 +209,12 @@ func (h *Handler) Register(c *echo.Context) error {
 		FirstClaims: h.config.FirstUserClaims,
 	})
 	if ErrIs(err, pgerrcode.UniqueViolation) {
-		return echo.NewHTTPError(409, "Email or username already taken")
+		return NewError(409, ErrUserExists, "Email or username already taken")
 	} else if err != nil {
 		return err
 	}
 	user := MapDbUser(&duser)
+	h.emit(ctx, EventUserCreated, user)
 	return h.createSession(c, &user)
 }
 
@@ -218,25 +238,29 @@ func (h *Handler) DeleteUser(c *echo.Context) error {
 
 	uid, err := uuid.Parse(c.Param("id"))
 	if err != nil {
-		return echo.NewHTTPError(422, "Invalid id given: not an uuid")
+		return NewError(422, ErrInvalidId, "Invalid id given: not an uuid")
 	}
 
 	ret, err := h.db.DeleteUser(ctx, uid)
 	if err == pgx.ErrNoRows {
-		return echo.NewHTTPError(404, "No user found with given id")
+		return NewError(404, ErrUserNotFound, "No user found with given id")
 	} else if err != nil {
 		return err
 	}
-	return c.JSON(200, MapDbUser(&ret))
+	user := MapDbUser(&ret)
+	h.emit(ctx, EventUserDeleted, user)
+	return c.JSON(200, user)
 }
 
 // @Summary      Delete self
-// @Description  Delete your account and all your sessions
+// @Description  Delete your account and all your sessions. If the instance has a deletion delay, the account is
+// @Description  only scheduled for deletion and can be restored until `deleteAt` via DELETE /users/me/deletion.
 // @Tags         users
 // @Accept       json
 // @Produce      json
 // @Security     Jwt
-// @Success      200  {object}  User
+// @Success      200  {object}  User "Account deleted"
+// @Success      202  {object}  User "Account scheduled for deletion"
 // @Router /users/me [delete]
 func (h *Handler) DeleteSelf(c *echo.Context) error {
 	ctx := c.Request().Context()
@@ -245,15 +269,84 @@ func (h *Handler) DeleteSelf(c *echo.Context) error {
 		return err
 	}
 
+	if h.config.DeletionDelay > 0 {
+		ret, err := h.db.ScheduleUserDeletion(ctx, dbc.ScheduleUserDeletionParams{
+			Id:       uid,
+			DeleteAt: new(time.Now().UTC().Add(h.config.DeletionDelay)),
+		})
+		if err == pgx.ErrNoRows {
+			return NewError(403, ErrInvalidToken, "Invalid token, user already deleted.")
+		} else if err != nil {
+			return err
+		}
+		h.audit(ctx, ret.Pk, "user.deletion_requested", map[string]any{
+			"deleteAt": ret.DeleteAt,
+		})
+		return c.JSON(http.StatusAccepted, MapDbUser(&ret))
+	}
+
 	ret, err := h.db.DeleteUser(ctx, uid)
 	if err == pgx.ErrNoRows {
-		return echo.NewHTTPError(403, "Invalid token, user already deleted.")
+		return NewError(403, ErrInvalidToken, "Invalid token, user already deleted.")
+	} else if err != nil {
+		return err
+	}
+	user := MapDbUser(&ret)
+	h.emit(ctx, EventUserDeleted, user)
+	return c.JSON(200, user)
+}
+
+// @Summary      Cancel self deletion
+// @Description  Cancel a pending deletion of your account
+// @Tags         users
+// @Produce      json
+// @Security     Jwt
+// @Success      200  {object}  User
+// @Failure      404  {object}  KError "No deletion pending for this account"
+// @Router /users/me/deletion [delete]
+func (h *Handler) CancelSelfDeletion(c *echo.Context) error {
+	ctx := c.Request().Context()
+	uid, err := GetCurrentUserId(c)
+	if err != nil {
+		return err
+	}
+
+	ret, err := h.db.CancelUserDeletion(ctx, uid)
+	if err == pgx.ErrNoRows {
+		return NewError(http.StatusNotFound, ErrNoPendingDeletion, "No deletion pending for this account.")
 	} else if err != nil {
 		return err
 	}
+	h.audit(ctx, ret.Pk, "user.deletion_cancelled", nil)
 	return c.JSON(200, MapDbUser(&ret))
 }
 
+// DeleteScheduledUsers periodically removes accounts whose deletion grace period is over.
+func (h *Handler) DeleteScheduledUsers(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		users, err := h.db.DeleteScheduledUsers(ctx)
+		if err != nil {
+			slog.Error("Could not delete scheduled users", "err", err)
+		}
+		for _, user := range users {
+			slog.Info("Deleted user after grace period", "id", user.Id)
+			h.emit(ctx, EventUserDeleted, MapDbUser(&user))
+			err := h.removeLogo(user.Id)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				slog.Warn("Could not delete logo of deleted user", "id", user.Id, "err", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
 // @Summary      Edit self
 // @Description  Edit your account's info
 // @Tags         users
@@ -262,7 +355,7 @@ func (h *Handler) DeleteSelf(c *echo.Context) error {
 // @Security     Jwt
 // @Param        user     body  EditUserDto  false  "Edited user info"
 // @Success      200  {object}  User
-// @Success      403  {object}  KError  "You can't edit a protected claim"
+// @Success      403  {object}  KError  "Claims can't be edited on yourself"
 // @Success      422  {object}  KError  "Invalid body"
 // @Router /users/me [patch]
 func (h *Handler) EditSelf(c *echo.Context) error {
@@ -270,16 +363,16 @@ func (h *Handler) EditSelf(c *echo.Context) error {
 	var req EditUserDto
 	err := c.Bind(&req)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+		return NewError(http.StatusUnprocessableEntity, ErrInvalidBody, err.Error())
 	}
 	if err = c.Validate(&req); err != nil {
 		return err
 	}
 
-	for _, key := range h.config.ProtectedClaims {
-		if _, contains := req.Claims[key]; contains {
-			return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("Can't edit protected claim: '%s'.", key))
-		}
+	// Claims end up in jwts that other services trust, users can't set them on themselves
+	// (client preferences belong in `/users/me/settings`).
+	if len(req.Claims) != 0 {
+		return NewError(http.StatusForbidden, ErrProtectedClaim, "Claims can only be edited by admins, use /users/me/settings for preferences.")
 	}
 
 	uid, err := GetCurrentUserId(c)
@@ -291,15 +384,16 @@ func (h *Handler) EditSelf(c *echo.Context) error {
 		Id:       uid,
 		Username: req.Username,
 		Email:    req.Email,
-		Claims:   req.Claims,
 	})
 	if err == pgx.ErrNoRows {
-		return echo.NewHTTPError(http.StatusNotFound, "Invalid token, user not found.")
+		return NewError(http.StatusNotFound, ErrInvalidSession, "Invalid token, user not found.")
 	} else if err != nil {
 		return err
 	}
 
-	return c.JSON(200, MapDbUser(&ret))
+	user := MapDbUser(&ret)
+	h.emit(ctx, EventUserUpdated, user)
+	return c.JSON(200, user)
 }
 
 // @Summary      Edit user
@@ -312,7 +406,7 @@ func (h *Handler) EditSelf(c *echo.Context) error {
 // @Param        user     body  EditUserDto  false  "Edited user info"
 // @Success      200  {object}  User
 // @Success      403  {object}  KError  "You don't have permissions to edit another account"
-// @Success      422  {object}  KError  "Invalid body"
+// @Success      422  {object}  KError  "Invalid body or unknown permissions"
 // @Router /users/{id} [patch]
 func (h *Handler) EditUser(c *echo.Context) error {
 	ctx := c.Request().Context()
@@ -323,17 +417,20 @@ func (h *Handler) EditUser(c *echo.Context) error {
 
 	uid, err := uuid.Parse(c.Param("id"))
 	if err != nil {
-		return echo.NewHTTPError(400, "Invalid id given: not an uuid")
+		return NewError(400, ErrInvalidId, "Invalid id given: not an uuid")
 	}
 
 	var req EditUserDto
 	err = c.Bind(&req)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+		return NewError(http.StatusUnprocessableEntity, ErrInvalidBody, err.Error())
 	}
 	if err = c.Validate(&req); err != nil {
 		return err
 	}
+	if err = h.validatePermissions(req.Claims); err != nil {
+		return err
+	}
 
 	ret, err := h.db.UpdateUser(ctx, dbc.UpdateUserParams{
 		Id:       uid,
@@ -342,12 +439,14 @@ func (h *Handler) EditUser(c *echo.Context) error {
 		Claims:   req.Claims,
 	})
 	if err == pgx.ErrNoRows {
-		return echo.NewHTTPError(http.StatusNotFound, "Invalid user id, user not found")
+		return NewError(http.StatusNotFound, ErrUserNotFound, "Invalid user id, user not found")
 	} else if err != nil {
 		return err
 	}
 
-	return c.JSON(200, MapDbUser(&ret))
+	user := MapDbUser(&ret)
+	h.emit(ctx, EventUserUpdated, user)
+	return c.JSON(200, user)
 }
 
 // @Summary      Edit password
@@ -380,7 +479,7 @@ func (h *Handler) ChangePassword(c *echo.Context) error {
 	var req EditPasswordDto
 	err = c.Bind(&req)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+		return NewError(http.StatusUnprocessableEntity, ErrInvalidBody, err.Error())
 	}
 	if err = c.Validate(&req); err != nil {
 		return err
@@ -388,20 +487,22 @@ func (h *Handler) ChangePassword(c *echo.Context) error {
 
 	if user.User.Password != nil {
 		if req.OldPassword == nil {
-			return echo.NewHTTPError(http.StatusUnprocessableEntity, "Missing old password")
+			return NewError(http.StatusUnprocessableEntity, ErrMissingOldPassword, "Missing old password")
 		}
-		match, err := argon2id.ComparePasswordAndHash(
-			*req.OldPassword,
-			*user.User.Password,
-		)
+		match, _, err := verifyPassword(*req.OldPassword, *user.User.Password)
 		if err != nil {
 			return err
 		}
 		if !match {
-			return echo.NewHTTPError(http.StatusForbidden, "Invalid password")
+			return NewError(http.StatusForbidden, ErrInvalidPassword, "Invalid password")
 		}
 	}
 
+	err = h.checkPassword(ctx, req.NewPassword, user.User.Username, user.User.Email)
+	if err != nil {
+		return err
+	}
+
 	pass, err := argon2id.CreateHash(req.NewPassword, argon2id.DefaultParams)
 	if err != nil {
 		return err
@@ -414,13 +515,38 @@ func (h *Handler) ChangePassword(c *echo.Context) error {
 		return err
 	}
 
-	err = h.db.ClearOtherSessions(ctx, dbc.ClearOtherSessionsParams{
+	sessions, err := h.db.ClearOtherSessions(ctx, dbc.ClearOtherSessionsParams{
 		SessionId: sid,
 		UserId:    uid,
 	})
 	if err != nil {
 		return err
 	}
+	h.audit(ctx, user.User.Pk, "user.password_changed", nil)
+	h.emit(ctx, EventUserUpdated, MapDbUser(&user.User))
+	h.emitSessionsDeleted(ctx, uid, sessions)
 
 	return c.NoContent(http.StatusNoContent)
 }
+
+// getUserByEmail returns the user with this email (case-insensitive), or pgx.ErrNoRows if there is none.
+// Emails used by more than one account (only differing by case) are refused instead of picking one of them.
+func (h *Handler) getUserByEmail(ctx context.Context, email string) (dbc.User, error) {
+	users, err := h.db.GetUsersByEmail(ctx, email)
+	if err != nil {
+		return dbc.User{}, err
+	}
+	switch len(users) {
+	case 0:
+		return dbc.User{}, pgx.ErrNoRows
+	case 1:
+		return users[0], nil
+	default:
+		slog.Warn("Multiple users share the same email (case-insensitive)", "email", email)
+		return dbc.User{}, NewError(
+			http.StatusConflict,
+			ErrAmbiguousEmail,
+			"Multiple accounts use this email, ask your server admin to change one of them.",
+		)
+	}
+}
@@ -0,0 +1,483 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v5"
+	"github.com/zoriya/kyoo/keibi/dbc"
+)
+
+const (
+	EventUserCreated      = "user.created"
+	EventUserUpdated      = "user.updated"
+	EventUserDeleted      = "user.deleted"
+	EventSessionCreated   = "session.created"
+	EventSessionDeleted   = "session.deleted"
+	EventSessionNewDevice = "session.new_device"
+	EventApiKeyCreated    = "apikey.created"
+	EventOidcLinked       = "oidc.linked"
+)
+
+// After this many failed attempts, a delivery is marked as failed and never retried.
+const maxWebhookAttempts = 8
+
+// Must stay well under the lease of ClaimWebhookDeliveries (1 min).
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+type Webhook struct {
+	Id uuid.UUID `json:"id" example:"e05089d6-9179-4b5b-a63e-94dd5fc2a397"`
+	// Url that will receive a POST request for each event.
+	Url string `json:"url" format:"url" example:"https://example.com/hooks/kyoo"`
+	// Events this webhook is subscribed to. Empty means every events.
+	Events []string `json:"events" example:"user.created,user.deleted"`
+	// Disabled webhooks don't receive events.
+	Enabled   bool      `json:"enabled"`
+	CreatedAt time.Time `json:"createdAt" example:"2025-03-29T18:20:05.267Z"`
+}
+
+type WebhookWSecret struct {
+	Webhook
+	// Secret used to sign payloads (`X-Keibi-Signature` header). Only returned on creation.
+	Secret string `json:"secret" example:"lyHzTYm9yi-pkEv3m2tamAeeK7Dj7N3QRP7xv7dPU5q"`
+}
+
+type WebhookDto struct {
+	Url string `json:"url" validate:"required,url" example:"https://example.com/hooks/kyoo"`
+	// Secret used to sign payloads. A random one is generated if unspecified.
+	Secret *string `json:"secret,omitempty" validate:"omitnil,min=16" example:"lyHzTYm9yi-pkEv3m2tamAeeK7Dj7N3QRP7xv7dPU5q"`
+	// Events to subscribe to. Empty means every events.
+	Events  []string `json:"events" validate:"dive,oneof=user.created user.updated user.deleted session.created session.deleted session.new_device apikey.created oidc.linked" example:"user.created,user.deleted"`
+	Enabled *bool    `json:"enabled,omitempty"`
+}
+
+type EditWebhookDto struct {
+	Url     *string  `json:"url,omitempty" validate:"omitnil,url" example:"https://example.com/hooks/kyoo"`
+	Secret  *string  `json:"secret,omitempty" validate:"omitnil,min=16" example:"lyHzTYm9yi-pkEv3m2tamAeeK7Dj7N3QRP7xv7dPU5q"`
+	Events  []string `json:"events,omitempty" validate:"omitnil,dive,oneof=user.created user.updated user.deleted session.created session.deleted session.new_device apikey.created oidc.linked" example:"user.created,user.deleted"`
+	Enabled *bool    `json:"enabled,omitempty"`
+}
+
+type WebhookDelivery struct {
+	Id    uuid.UUID `json:"id" example:"e05089d6-9179-4b5b-a63e-94dd5fc2a397"`
+	Event string    `json:"event" example:"user.created"`
+	// The body sent to the webhook.
+	Payload json.RawMessage `json:"payload" swaggertype:"object"`
+	// `pending` deliveries will be retried, `failed` ones won't.
+	Status   dbc.WebhookStatus `json:"status" swaggertype:"string" enums:"pending,delivered,failed" example:"delivered"`
+	Attempts int32             `json:"attempts" example:"1"`
+	// When will this delivery be retried (only relevant for pending deliveries).
+	NextAttemptAt time.Time `json:"nextAttemptAt" example:"2025-03-29T18:20:05.267Z"`
+	// Http status returned by the webhook on the last attempt.
+	LastStatusCode *int32 `json:"lastStatusCode" example:"200"`
+	// Error of the last attempt.
+	LastError   *string    `json:"lastError" example:"webhook returned status 500"`
+	CreatedAt   time.Time  `json:"createdAt" example:"2025-03-29T18:20:05.267Z"`
+	DeliveredAt *time.Time `json:"deliveredAt" example:"2025-03-29T18:20:05.267Z"`
+}
+
+type WebhookPayload struct {
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"createdAt"`
+	Data      any       `json:"data"`
+}
+
+func MapWebhook(hook *dbc.Webhook) Webhook {
+	return Webhook{
+		Id:        hook.Id,
+		Url:       hook.Url,
+		Events:    hook.Events,
+		Enabled:   hook.Enabled,
+		CreatedAt: hook.CreatedAt,
+	}
+}
+
+func MapWebhookDelivery(delivery *dbc.WebhookDelivery) WebhookDelivery {
+	return WebhookDelivery{
+		Id:             delivery.Id,
+		Event:          delivery.Event,
+		Payload:        delivery.Payload,
+		Status:         delivery.Status,
+		Attempts:       delivery.Attempts,
+		NextAttemptAt:  delivery.NextAttemptAt,
+		LastStatusCode: delivery.LastStatusCode,
+		LastError:      delivery.LastError,
+		CreatedAt:      delivery.CreatedAt,
+		DeliveredAt:    delivery.DeliveredAt,
+	}
+}
+
+// emit queues an event for every webhook subscribed to it. Failures are only
+// logged since webhooks should never prevent the action itself.
+func (h *Handler) emit(ctx context.Context, event string, data any) {
+	payload, err := json.Marshal(WebhookPayload{
+		Event:     event,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	})
+	if err == nil {
+		err = h.db.EnqueueWebhookDeliveries(ctx, dbc.EnqueueWebhookDeliveriesParams{
+			Event:   event,
+			Payload: payload,
+		})
+	}
+	if err != nil {
+		slog.Warn("Could not queue webhook event", "event", event, "err", err)
+	}
+}
+
+func (h *Handler) emitOidcLinked(ctx context.Context, uid uuid.UUID, provider OidcProviderConfig, profile Profile) {
+	h.emit(ctx, EventOidcLinked, map[string]any{
+		"userId": uid,
+		"link": OidcLink{
+			Provider: provider.Id,
+			Id:       profile.Sub,
+			Username: profile.Username,
+		},
+	})
+}
+
+// emitSessionsDeleted sends a session.deleted event for each session deleted in bulk (password changes, revocations...).
+func (h *Handler) emitSessionsDeleted(ctx context.Context, uid uuid.UUID, sessions []dbc.Session) {
+	for _, session := range sessions {
+		h.emit(ctx, EventSessionDeleted, map[string]any{
+			"userId":  uid,
+			"session": MapSession(&session),
+		})
+	}
+}
+
+// DeliverWebhooks sends queued events, retrying failed deliveries with an exponential backoff.
+func (h *Handler) DeliverWebhooks(ctx context.Context) {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	cleanup := time.NewTicker(time.Hour)
+	defer cleanup.Stop()
+	for {
+		deliveries, err := h.db.ClaimWebhookDeliveries(ctx, 20)
+		if err != nil {
+			slog.Error("Could not retrieve webhook deliveries", "err", err)
+		}
+		// sent concurrently so the whole batch finishes within webhookClient's timeout,
+		// well before the lease taken by ClaimWebhookDeliveries expires.
+		var wg sync.WaitGroup
+		for _, delivery := range deliveries {
+			wg.Go(func() {
+				h.deliverWebhook(ctx, &delivery)
+			})
+		}
+		wg.Wait()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-cleanup.C:
+			if err := h.db.CleanupWebhookDeliveries(ctx); err != nil {
+				slog.Warn("Could not cleanup webhook deliveries", "err", err)
+			}
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *Handler) deliverWebhook(ctx context.Context, delivery *dbc.ClaimWebhookDeliveriesRow) {
+	status, err := h.sendWebhook(ctx, delivery)
+
+	attempt := dbc.SaveWebhookAttemptParams{
+		Pk:            delivery.Pk,
+		Status:        dbc.KeibiWebhookStatusDelivered,
+		NextAttemptAt: time.Now().UTC(),
+	}
+	if status != 0 {
+		attempt.LastStatusCode = new(int32(status))
+	}
+	if err != nil {
+		slog.Warn("Webhook delivery failed", "delivery", delivery.Id, "url", delivery.Url, "err", err)
+		attempt.LastError = new(err.Error())
+		if delivery.Attempts+1 >= maxWebhookAttempts {
+			attempt.Status = dbc.KeibiWebhookStatusFailed
+		} else {
+			attempt.Status = dbc.KeibiWebhookStatusPending
+			// 30s, 1m, 2m, 4m... up to ~1h between the last attempts
+			backoff := time.Duration(math.Pow(2, float64(delivery.Attempts))) * 30 * time.Second
+			attempt.NextAttemptAt = time.Now().UTC().Add(backoff)
+		}
+	}
+
+	if err := h.db.SaveWebhookAttempt(ctx, attempt); err != nil {
+		slog.Error("Could not save webhook delivery attempt", "delivery", delivery.Id, "err", err)
+	}
+}
+
+func (h *Handler) sendWebhook(ctx context.Context, delivery *dbc.ClaimWebhookDeliveriesRow) (int, error) {
+	secret, err := decryptSecret(h.config.SecretKey, delivery.Secret)
+	if err != nil {
+		return 0, fmt.Errorf("could not decrypt webhook secret: %w", err)
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(delivery.Payload)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.Url, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "keibi-webhooks")
+	req.Header.Set("X-Keibi-Event", delivery.Event)
+	req.Header.Set("X-Keibi-Delivery", delivery.Id.String())
+	req.Header.Set("X-Keibi-Timestamp", timestamp)
+	req.Header.Set("X-Keibi-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// @Summary      List webhooks
+// @Description  List every webhook configured on this instance
+// @Tags         webhooks
+// @Produce      json
+// @Security     Jwt[webhooks.read]
+// @Success      200  {array}   Webhook
+// @Failure      403  {object}  KError "Missing permissions: webhooks.read."
+// @Router       /webhooks [get]
+func (h *Handler) ListWebhooks(c *echo.Context) error {
+	ctx := c.Request().Context()
+	if err := CheckPermissions(c, []string{"webhooks.read"}); err != nil {
+		return err
+	}
+
+	hooks, err := h.db.ListWebhooks(ctx)
+	if err != nil {
+		return err
+	}
+	ret := make([]Webhook, 0, len(hooks))
+	for _, hook := range hooks {
+		ret = append(ret, MapWebhook(&hook))
+	}
+	return c.JSON(http.StatusOK, ret)
+}
+
+// @Summary      Create webhook
+// @Description  Register a new webhook. Each event is sent as a POST request signed with an HMAC-SHA256 of
+// @Description  `{X-Keibi-Timestamp}.{body}` in the `X-Keibi-Signature` header.
+// @Tags         webhooks
+// @Accept       json
+// @Produce      json
+// @Security     Jwt[webhooks.write]
+// @Param        webhook  body  WebhookDto  true  "Webhook settings"
+// @Success      201  {object}  WebhookWSecret
+// @Failure      403  {object}  KError "Missing permissions: webhooks.write."
+// @Failure      422  {object}  KError "Invalid body"
+// @Router       /webhooks [post]
+func (h *Handler) CreateWebhook(c *echo.Context) error {
+	ctx := c.Request().Context()
+	if err := CheckPermissions(c, []string{"webhooks.write"}); err != nil {
+		return err
+	}
+
+	var req WebhookDto
+	if err := c.Bind(&req); err != nil {
+		return NewError(http.StatusUnprocessableEntity, ErrInvalidBody, err.Error())
+	}
+	if err := c.Validate(&req); err != nil {
+		return err
+	}
+
+	secret := ""
+	if req.Secret != nil {
+		secret = *req.Secret
+	} else {
+		raw := make([]byte, 32)
+		if _, err := rand.Read(raw); err != nil {
+			return err
+		}
+		secret = base64.RawURLEncoding.EncodeToString(raw)
+	}
+	encrypted, err := encryptSecret(h.config.SecretKey, secret)
+	if err == ErrNoSecretKey {
+		return NewError(http.StatusInternalServerError, ErrMissingSecretKey, "SECRET_ENCRYPTION_KEY must be set to create webhooks.")
+	} else if err != nil {
+		return err
+	}
+
+	events := req.Events
+	if events == nil {
+		events = make([]string, 0)
+	}
+	enabled := true
+	if req.Enabled != nil {
+		enabled = *req.Enabled
+	}
+
+	hook, err := h.db.CreateWebhook(ctx, dbc.CreateWebhookParams{
+		Url:     req.Url,
+		Secret:  encrypted,
+		Events:  events,
+		Enabled: enabled,
+	})
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusCreated, WebhookWSecret{
+		Webhook: MapWebhook(&hook),
+		Secret:  secret,
+	})
+}
+
+// @Summary      Edit webhook
+// @Description  Edit, disable or rotate the secret of a webhook
+// @Tags         webhooks
+// @Accept       json
+// @Produce      json
+// @Security     Jwt[webhooks.write]
+// @Param        id       path  string          true  "Id of the webhook"  Format(uuid)
+// @Param        webhook  body  EditWebhookDto  true  "Edited settings"
+// @Success      200  {object}  Webhook
+// @Failure      404  {object}  KError "No webhook found with this id"
+// @Failure      422  {object}  KError "Invalid body"
+// @Router       /webhooks/{id} [patch]
+func (h *Handler) EditWebhook(c *echo.Context) error {
+	ctx := c.Request().Context()
+	if err := CheckPermissions(c, []string{"webhooks.write"}); err != nil {
+		return err
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return NewError(http.StatusUnprocessableEntity, ErrInvalidId, "Invalid id given: not an uuid")
+	}
+
+	var req EditWebhookDto
+	if err := c.Bind(&req); err != nil {
+		return NewError(http.StatusUnprocessableEntity, ErrInvalidBody, err.Error())
+	}
+	if err := c.Validate(&req); err != nil {
+		return err
+	}
+
+	var secret *string
+	if req.Secret != nil {
+		encrypted, err := encryptSecret(h.config.SecretKey, *req.Secret)
+		if err == ErrNoSecretKey {
+			return NewError(http.StatusInternalServerError, ErrMissingSecretKey, "SECRET_ENCRYPTION_KEY must be set to edit webhooks.")
+		} else if err != nil {
+			return err
+		}
+		secret = &encrypted
+	}
+
+	hook, err := h.db.UpdateWebhook(ctx, dbc.UpdateWebhookParams{
+		Id:      id,
+		Url:     req.Url,
+		Secret:  secret,
+		Events:  req.Events,
+		Enabled: req.Enabled,
+	})
+	if err == pgx.ErrNoRows {
+		return NewError(http.StatusNotFound, ErrWebhookNotFound, "No webhook found with this id")
+	} else if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, MapWebhook(&hook))
+}
+
+// @Summary      Delete webhook
+// @Description  Delete a webhook and its delivery log
+// @Tags         webhooks
+// @Produce      json
+// @Security     Jwt[webhooks.write]
+// @Param        id  path  string  true  "Id of the webhook"  Format(uuid)
+// @Success      200  {object}  Webhook
+// @Failure      404  {object}  KError "No webhook found with this id"
+// @Failure      422  {object}  KError "Invalid id format"
+// @Router       /webhooks/{id} [delete]
+func (h *Handler) DeleteWebhook(c *echo.Context) error {
+	ctx := c.Request().Context()
+	if err := CheckPermissions(c, []string{"webhooks.write"}); err != nil {
+		return err
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return NewError(http.StatusUnprocessableEntity, ErrInvalidId, "Invalid id given: not an uuid")
+	}
+
+	hook, err := h.db.DeleteWebhook(ctx, id)
+	if err == pgx.ErrNoRows {
+		return NewError(http.StatusNotFound, ErrWebhookNotFound, "No webhook found with this id")
+	} else if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, MapWebhook(&hook))
+}
+
+// @Summary      List webhook deliveries
+// @Description  List the last deliveries (and their status) of a webhook
+// @Tags         webhooks
+// @Produce      json
+// @Security     Jwt[webhooks.read]
+// @Param        id  path  string  true  "Id of the webhook"  Format(uuid)
+// @Success      200  {array}   WebhookDelivery
+// @Failure      404  {object}  KError "No webhook with the given id"
+// @Failure      422  {object}  KError "Invalid id format"
+// @Router       /webhooks/{id}/deliveries [get]
+func (h *Handler) ListWebhookDeliveries(c *echo.Context) error {
+	ctx := c.Request().Context()
+	if err := CheckPermissions(c, []string{"webhooks.read"}); err != nil {
+		return err
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return NewError(http.StatusUnprocessableEntity, ErrInvalidId, "Invalid id given: not an uuid")
+	}
+
+	_, err = h.db.GetWebhook(ctx, id)
+	if err == pgx.ErrNoRows {
+		return NewError(http.StatusNotFound, ErrWebhookNotFound, "No webhook found with this id")
+	} else if err != nil {
+		return err
+	}
+
+	deliveries, err := h.db.ListWebhookDeliveries(ctx, dbc.ListWebhookDeliveriesParams{
+		Id:    id,
+		Limit: 100,
+	})
+	if err != nil {
+		return err
+	}
+	ret := make([]WebhookDelivery, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		ret = append(ret, MapWebhookDelivery(&delivery))
+	}
+	return c.JSON(http.StatusOK, ret)
+}
@@ -9,6 +9,45 @@ const ChapterSkipBehavior = z
 	])
 	.catch("showSkipButton");
 
+// Stored by keibi in `/users/me/settings`, not in the jwt's claims.
+export const Settings = z.object({
+	downloadQuality: z
+		.union([
+			z.literal("original"),
+			z.literal("8k"),
+			z.literal("4k"),
+			z.literal("1440p"),
+			z.literal("1080p"),
+			z.literal("720p"),
+			z.literal("480p"),
+			z.literal("360p"),
+			z.literal("240p"),
+		])
+		.catch("original"),
+	audioLanguage: z.string().catch("original"),
+	subtitleLanguage: z.string().nullable().catch(null),
+	chapterSkip: z
+		.object({
+			recap: ChapterSkipBehavior,
+			intro: ChapterSkipBehavior,
+			credits: ChapterSkipBehavior,
+			preview: ChapterSkipBehavior,
+		})
+		.catch({
+			recap: "showSkipButton",
+			intro: "showSkipButton",
+			credits: "showSkipButton",
+			preview: "showSkipButton",
+		}),
+});
+export type Settings = z.infer<typeof Settings>;
+
+export const UserSettings = z.object({
+	// account settings overridden by the device's, with the schema's defaults
+	settings: Settings,
+});
+export type UserSettings = z.infer<typeof UserSettings>;
+
 export const User = z
 	.object({
 		id: z.string(),
@@ -20,48 +59,6 @@ export const User = z
 		claims: z.object({
 			verified: z.boolean().default(true),
 			permissions: z.array(z.string()),
-			settings: z
-				.object({
-					downloadQuality: z
-						.union([
-							z.literal("original"),
-							z.literal("8k"),
-							z.literal("4k"),
-							z.literal("1440p"),
-							z.literal("1080p"),
-							z.literal("720p"),
-							z.literal("480p"),
-							z.literal("360p"),
-							z.literal("240p"),
-						])
-						.catch("original"),
-					audioLanguage: z.string().catch("original"),
-					subtitleLanguage: z.string().nullable().catch(null),
-					chapterSkip: z
-						.object({
-							recap: ChapterSkipBehavior,
-							intro: ChapterSkipBehavior,
-							credits: ChapterSkipBehavior,
-							preview: ChapterSkipBehavior,
-						})
-						.catch({
-							recap: "showSkipButton",
-							intro: "showSkipButton",
-							credits: "showSkipButton",
-							preview: "showSkipButton",
-						}),
-				})
-				.default({
-					downloadQuality: "original",
-					audioLanguage: "default",
-					subtitleLanguage: null,
-					chapterSkip: {
-						recap: "showSkipButton",
-						intro: "showSkipButton",
-						credits: "showSkipButton",
-						preview: "showSkipButton",
-					},
-				}),
 		}),
 		oidc: z
 			.record(
@@ -77,7 +74,9 @@ export const User = z
 	.transform((x) => ({
 		...x,
 		logo: `/auth/users/${x.id}/logo`,
-		isAdmin: x.claims.permissions.includes("users.write"),
+		isAdmin: x.claims.permissions.some((p) =>
+			["users.write", "users.*", "*"].includes(p),
+		),
 	}));
 export type User = z.infer<typeof User>;
 
@@ -62,20 +62,8 @@ const UserRow = ({
 					action === "verify"
 						? { verified: true }
 						: {
-								permissions: [
-									"users.read",
-									"users.write",
-									"users.delete",
-									"apikeys.read",
-									"apikeys.write",
-									"core.read",
-									"core.write",
-									"core.play",
-									"scanner.trigger",
-									"scanner.guess",
-									"scanner.search",
-									"scanner.add",
-								],
+								// same as the FIRST_USER_CLAIMS of the .env.example
+								permissions: ["*"],
 							},
 			},
 		}),
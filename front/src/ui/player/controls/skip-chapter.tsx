@@ -3,9 +3,9 @@ import { useTranslation } from "react-i18next";
 import { usePlayer, usePlayerState } from "react-native-omni";
 import type { Chapter } from "~/models";
 import { Button, P } from "~/primitives";
-import { useAccount } from "~/providers/account-context";
 import { useFetch } from "~/query";
 import { Info } from "~/ui/info";
+import { useSettings } from "~/ui/settings/base";
 import { cn, useQueryState } from "~/utils";
 import { seekPlayerTo } from "../imperative";
 
@@ -19,7 +19,7 @@ export const SkipChapterButton = ({
 	isVisible: boolean;
 }) => {
 	const { t } = useTranslation();
-	const account = useAccount();
+	const settings = useSettings();
 	const [slug] = useQueryState<string>("slug", undefined!);
 	const { data } = useFetch(Info.infoQuery(slug));
 	const lastAutoSkippedChapter = useRef<number | null>(null);
@@ -34,7 +34,7 @@ export const SkipChapterButton = ({
 	const behavior =
 		(chapter &&
 			chapter.type !== "content" &&
-			account?.claims.settings.chapterSkip[chapter.type]) ||
+			settings.chapterSkip[chapter.type]) ||
 		"showSkipButton";
 	const shouldAutoSkip =
 		behavior === "autoSkip" ||
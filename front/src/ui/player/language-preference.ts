@@ -1,8 +1,8 @@
 import { useEffect, useRef } from "react";
 import { useEvent, usePlayer } from "react-native-omni";
-import { useAccount } from "~/providers/account-context";
 import { useFetch } from "~/query";
 import { Info } from "../info";
+import { useSettings } from "../settings/base";
 
 // Delay before selecting a track: the player needs a moment to initialise its
 // track list after a new episode loads.
@@ -32,12 +32,16 @@ export const useLanguagePreference = (
 ) => {
 	const player = usePlayer();
 	const { data } = useFetch(Info.infoQuery(slug));
-	const account = useAccount();
+	const settings = useSettings();
 
 	const audios = data?.audios;
-	const audioPref = useRef(account?.claims.settings.audioLanguage ?? "default");
+	const audioPref = useRef(settings.audioLanguage);
 	const audioIdx = useRef(-1);
 	const restoringAudio = useRef(false);
+	// settings are fetched separately, pick them up once they've loaded.
+	useEffect(() => {
+		audioPref.current = settings.audioLanguage;
+	}, [settings.audioLanguage]);
 
 	useEvent("audioTrackChange", (selected) => {
 		if (restoringAudio.current || !audios?.length) return;
@@ -72,11 +76,18 @@ export const useLanguagePreference = (
 
 	const subtitles = data?.subtitles;
 	const subPref = useRef({
-		idx: account?.claims.settings.subtitleLanguage === null ? null : -1,
-		lang: account?.claims.settings.subtitleLanguage,
+		idx: settings.subtitleLanguage === null ? null : -1,
+		lang: settings.subtitleLanguage,
 		forced: false,
 	});
 	const restoringSub = useRef(false);
+	useEffect(() => {
+		subPref.current = {
+			idx: settings.subtitleLanguage === null ? null : -1,
+			lang: settings.subtitleLanguage,
+			forced: false,
+		};
+	}, [settings.subtitleLanguage]);
 	useEvent("subtitleChange", (selected) => {
 		if (restoringSub.current || !subtitles?.length) return;
 		if (!selected) {
@@ -1,9 +1,9 @@
 import { Children, Fragment, type ReactElement, type ReactNode } from "react";
 import { type Falsy, View } from "react-native";
-import type { User } from "~/models";
+import { Settings, UserSettings } from "~/models";
 import { Container, H1, HR, Icon, P, SubP } from "~/primitives";
 import { useAccount } from "~/providers/account-context";
-import { useMutation } from "~/query";
+import { type QueryIdentifier, useFetch, useMutation } from "~/query";
 
 export const Preference = ({
 	customIcon,
@@ -69,34 +69,43 @@ export const SettingsContainer = ({
 	);
 };
 
-export const useSetting = <Setting extends keyof User["claims"]["settings"]>(
+export const settingsQuery = (): QueryIdentifier<UserSettings> => ({
+	path: ["auth", "users", "me", "settings"],
+	parser: UserSettings,
+});
+
+// Settings with their defaults while loading (or without an account).
+export const useSettings = (): Settings => {
+	const account = useAccount();
+	const { data } = useFetch({
+		...settingsQuery(),
+		enabled: !!account,
+		options: { returnError: true },
+	});
+	return data?.settings ?? Settings.parse({});
+};
+
+export const useSetting = <Setting extends keyof Settings>(
 	setting: Setting,
 ) => {
 	const account = useAccount();
+	const settings = useSettings();
 	const { mutateAsync } = useMutation({
 		method: "PATCH",
-		path: ["auth", "users", "me"],
-		compute: (update: Partial<User["claims"]["settings"]>) => ({
-			body: {
-				claims: {
-					settings: { ...account!.claims.settings, ...update },
-				},
-			},
-		}),
-		optimistic: (update) => ({
-			...account,
-			claims: {
-				...account!.claims,
-				settings: { ...account!.claims.settings, ...update },
-			},
+		path: settingsQuery().path,
+		// json merge patch, only send what changed
+		compute: (update: Partial<Settings>) => ({ body: update }),
+		optimistic: (update, previous?: UserSettings) => ({
+			...previous,
+			settings: { ...(previous?.settings ?? settings), ...update },
 		}),
-		invalidate: ["auth", "users", "me"],
+		invalidate: settingsQuery().path as string[],
 	});
 
 	if (!account) return null;
 	return [
-		account.claims.settings[setting],
-		async (value: User["claims"]["settings"][Setting]) => {
+		settings[setting],
+		async (value: Settings[Setting]) => {
 			await mutateAsync({ [setting]: value });
 		},
 	] as const;
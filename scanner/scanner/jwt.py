@@ -16,6 +16,14 @@ jwks_client = PyJWKClient(
 security = HTTPBearer(scheme_name="Bearer")
 
 
+def has_permission(granted: list[str], perm: str) -> bool:
+	# mirrors keibi's matching: `*` grants everything and `scanner.*` grants every `scanner.` permission.
+	return any(
+		x == perm or x == "*" or (x.endswith(".*") and perm.startswith(x[:-1]))
+		for x in granted
+	)
+
+
 def validate_bearer(
 	token: Annotated[HTTPAuthorizationCredentials, Depends(security)],
 	perms: SecurityScopes,
@@ -24,11 +32,11 @@ def validate_bearer(
 		payload = jwt.decode(
 			token.credentials,
 			jwks_client.get_signing_key_from_jwt(token.credentials).key,
-			algorithms=["RS256"],
+			algorithms=["RS256", "ES256", "EdDSA"],
 			issuer=os.environ.get("JWT_ISSUER"),
 		)
 		for scope in perms.scopes:
-			if scope not in payload["permissions"]:
+			if not has_permission(payload["permissions"], scope):
 				raise HTTPException(
 					status_code=403,
 					detail=f"Missing permissions {', '.join(perms.scopes)}",
@@ -48,6 +48,23 @@ func ErrorHandler(c *echo.Context, err error) {
 	}{Errors: []string{message}})
 }
 
+// hasPermission mirrors keibi's matching: `*` grants everything and `core.*` grants every `core.` permission.
+func hasPermission(granted []any, perm string) bool {
+	for _, g := range granted {
+		p, ok := g.(string)
+		if !ok {
+			continue
+		}
+		if p == perm || p == "*" {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(p, "*"); ok && strings.HasSuffix(prefix, ".") && strings.HasPrefix(perm, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
 func RequireCorePlayPermission(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c *echo.Context) error {
 		user := c.Get("user")
@@ -70,7 +87,7 @@ func RequireCorePlayPermission(next echo.HandlerFunc) echo.HandlerFunc {
 		if !ok {
 			return echo.NewHTTPError(http.StatusForbidden, "permissions claim is not an array")
 		}
-		if !slices.Contains(perms, "core.play") {
+		if !hasPermission(perms, "core.play") {
 			return echo.NewHTTPError(http.StatusForbidden, "missing core.play permission")
 		}
 		return next(c)
@@ -220,7 +237,12 @@ func main() {
 					return echo.NewHTTPError(http.StatusUnauthorized, "Missing or invalid token")
 				}
 
-				token, err := jwt.Parse(strings.TrimPrefix(authHeader, "Bearer "), k.Keyfunc)
+				// keibi can sign jwts with RSA, ECDSA or Ed25519 keys (see JWT_SIGNING_ALGORITHM).
+				token, err := jwt.Parse(
+					strings.TrimPrefix(authHeader, "Bearer "),
+					k.Keyfunc,
+					jwt.WithValidMethods([]string{"RS256", "ES256", "EdDSA"}),
+				)
 				if err != nil {
 					return echo.NewHTTPError(http.StatusForbidden, "Invalid token: "+err.Error())
 				}